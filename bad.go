@@ -1,37 +1,167 @@
 package eventdb
 
 import (
+	"fmt"
 	"regexp"
+	"sort"
+	"strings"
 )
 
 // IsBadEvent applies some heuristics to remove spammy events or expensive ones
-// that aren't practical to show up at without previous notice.
+// that aren't practical to show up at without previous notice. It uses
+// DefaultBadEventFilter.
 //
 // Not sure if I want to keep this since it makes things less random. Perhaps
 // there's some machine learning magic I can do to filter events while
 // minimizing bias?
 func IsBadEvent(event Event) bool {
-	for _, filt := range nameFilters {
-		if filt.MatchString(event.Name) {
-			return true
+	return DefaultBadEventFilter.IsBad(event)
+}
+
+// BadEventReason says why IsBadEvent flags the event, or returns "" if it
+// doesn't.
+func BadEventReason(event Event) string {
+	return DefaultBadEventFilter.Reason(event)
+}
+
+// A FilterGroup is a set of bad event rules written for one language.
+type FilterGroup struct {
+	// Name rules are matched against the event's name.
+	Name []*regexp.Regexp
+	// Description rules are matched against the event's description.
+	Description []*regexp.Regexp
+}
+
+// BadEventFilter flags bad events using rules grouped by language, so that a
+// word that means "sign up" in German doesn't trip up an event in Slovenia.
+type BadEventFilter struct {
+	// Groups holds the rules for each language, keyed by language code.
+	Groups map[string]FilterGroup
+
+	// Countries maps the country names Facebook uses in event locations to the
+	// languages whose rules apply there.
+	Countries map[string][]string
+
+	// DefaultLanguages are the languages used for events in countries that
+	// aren't in Countries. Events without a country are checked against every
+	// group.
+	DefaultLanguages []string
+
+	// Categories lists Facebook event categories, like "FUNDRAISER", that are
+	// always bad no matter what the event says. They're matched ignoring
+	// case.
+	Categories []string
+}
+
+// IsBad reports whether the event is in a disallowed category or any of the
+// rules for the event's languages match.
+func (f BadEventFilter) IsBad(event Event) bool {
+	return f.Reason(event) != ""
+}
+
+// Reason says why the event is bad, naming the category or the rule that
+// matched. It's empty if the event isn't bad.
+func (f BadEventFilter) Reason(event Event) string {
+	for _, category := range f.Categories {
+		if event.Category != "" && strings.EqualFold(event.Category, category) {
+			return fmt.Sprintf("category %s", category)
 		}
 	}
-	for _, filt := range descFilters {
-		if filt.MatchString(event.Description) {
-			return true
+
+	for _, lang := range f.languages(event) {
+		group := f.Groups[lang]
+
+		for _, filt := range group.Name {
+			if filt.MatchString(event.Name) {
+				return fmt.Sprintf("name matches %s rule %s", lang, filt)
+			}
+		}
+		for _, filt := range group.Description {
+			if filt.MatchString(event.Description) {
+				return fmt.Sprintf("description matches %s rule %s", lang, filt)
+			}
 		}
 	}
 
-	return false
+	return ""
+}
+
+// A BadRule is one of a BadEventFilter's rules, for showing admins.
+type BadRule struct {
+	// Field is what the rule is matched against: "name", "description", or
+	// "category".
+	Field string `json:"field"`
+	// Language is the rule group's language code. It's empty for categories,
+	// which apply everywhere.
+	Language string `json:"language,omitempty"`
+	// Pattern is the regular expression source, or the category name.
+	Pattern string `json:"pattern"`
 }
 
-var nameFilters = []*regexp.Regexp{
+// Rules lists the filter's rules: categories first, then each language's
+// name and description rules in language order.
+func (f BadEventFilter) Rules() []BadRule {
+	rules := []BadRule{}
+	for _, category := range f.Categories {
+		rules = append(rules, BadRule{Field: "category", Pattern: category})
+	}
+
+	var langs []string
+	for lang := range f.Groups {
+		langs = append(langs, lang)
+	}
+	sort.Strings(langs)
+
+	for _, lang := range langs {
+		group := f.Groups[lang]
+		for _, filt := range group.Name {
+			rules = append(rules, BadRule{Field: "name", Language: lang, Pattern: filt.String()})
+		}
+		for _, filt := range group.Description {
+			rules = append(rules, BadRule{Field: "description", Language: lang, Pattern: filt.String()})
+		}
+	}
+	return rules
+}
+
+// languages picks the rule groups to check an event against.
+func (f BadEventFilter) languages(event Event) []string {
+	if event.Country == "" {
+		var all []string
+		for lang := range f.Groups {
+			all = append(all, lang)
+		}
+		return all
+	}
+	if langs, ok := f.Countries[event.Country]; ok {
+		return langs
+	}
+	return f.DefaultLanguages
+}
+
+// DefaultBadEventFilter is the filter used by IsBadEvent. English rules apply
+// everywhere, German ones only in German-speaking countries.
+var DefaultBadEventFilter = BadEventFilter{
+	Groups: map[string]FilterGroup{
+		"en": {Name: enNameFilters, Description: enDescFilters},
+		"de": {Name: deNameFilters, Description: deDescFilters},
+	},
+	Countries: map[string][]string{
+		"Germany":       {"en", "de"},
+		"Austria":       {"en", "de"},
+		"Switzerland":   {"en", "de"},
+		"Liechtenstein": {"en", "de"},
+	},
+	DefaultLanguages: []string{"en"},
+
+	// No Categories by default. Deployments that want them, like FUNDRAISER
+	// or RELIGION, opt in with their own filter.
+}
+
+var enNameFilters = []*regexp.Regexp{
 	// If it's sold out or canceled you'll be turned away.
 	regexp.MustCompile(`(?i)\bSold Out\b`),
 	regexp.MustCompile(`(?i)\bCancel\b`),
-	regexp.MustCompile(`(?i)\bgeschlossene\b`), // German
-	regexp.MustCompile(`(?i)\babgesagte\b`),    // German
-	regexp.MustCompile(`(?i)\bannulliert\b`),   // German
 
 	// Don't go to Facebook funerals.
 	regexp.MustCompile(`(?i)\bFuneral\b`),
@@ -44,7 +174,13 @@ var nameFilters = []*regexp.Regexp{
 	regexp.MustCompile(`(?i)\bpub\b`),
 }
 
-var descFilters = []*regexp.Regexp{
+var deNameFilters = []*regexp.Regexp{
+	regexp.MustCompile(`(?i)\bgeschlossene\b`),
+	regexp.MustCompile(`(?i)\babgesagte\b`),
+	regexp.MustCompile(`(?i)\bannulliert\b`),
+}
+
+var enDescFilters = []*regexp.Regexp{
 	// Facebook events should be free.
 	//
 	// At some point it might be nice to add some price parsing and allow people
@@ -68,6 +204,9 @@ var descFilters = []*regexp.Regexp{
 	// If an RSVP is required then you might be turned away.
 	regexp.MustCompile(`(?i)regist`),
 	regexp.MustCompile(`(?i)RSVP`),
-	regexp.MustCompile(`(?i)anmelden`),  // German
-	regexp.MustCompile(`(?i)anmeldung`), // German
+}
+
+var deDescFilters = []*regexp.Regexp{
+	regexp.MustCompile(`(?i)anmelden`),
+	regexp.MustCompile(`(?i)anmeldung`),
 }
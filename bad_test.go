@@ -0,0 +1,75 @@
+package eventdb
+
+import (
+	"testing"
+)
+
+func TestIsBadEventLanguage(t *testing.T) {
+	for _, test := range []struct {
+		Name  string
+		Event Event
+		Want  bool
+	}{
+		{
+			Name: "german word in slovenian event",
+			Event: Event{
+				Name:        "Večer za dušo",
+				Description: "Vprašanja na anmeldung@cajnica.si",
+				Country:     "Slovenia",
+			},
+			Want: false,
+		},
+		{
+			Name: "canceled german event",
+			Event: Event{
+				Name:    "Abgesagte Lesung",
+				Country: "Germany",
+			},
+			Want: true,
+		},
+		{
+			Name: "english rules apply everywhere",
+			Event: Event{
+				Name:    "Sold Out: Koncert",
+				Country: "Slovenia",
+			},
+			Want: true,
+		},
+		{
+			Name: "no country uses every group",
+			Event: Event{
+				Description: "Bitte anmelden",
+			},
+			Want: true,
+		},
+	} {
+		if got := IsBadEvent(test.Event); got != test.Want {
+			t.Fatalf("IsBadEvent (%s) = %v, want %v", test.Name, got, test.Want)
+		}
+	}
+}
+
+func TestIsBadEventCategory(t *testing.T) {
+	filter := BadEventFilter{
+		Groups:           DefaultBadEventFilter.Groups,
+		DefaultLanguages: DefaultBadEventFilter.DefaultLanguages,
+		Categories:       []string{"FUNDRAISER"},
+	}
+
+	clean := Event{
+		Name:        "Park cleanup",
+		Description: "Come help clean up the park",
+		Country:     "United States",
+	}
+	if filter.IsBad(clean) {
+		t.Fatalf("IsBad(%q) = true, want false", clean.Name)
+	}
+
+	clean.Category = "fundraiser"
+	if !filter.IsBad(clean) {
+		t.Fatalf("IsBad(%q) in category %q = false, want true", clean.Name, clean.Category)
+	}
+	if got, want := filter.Reason(clean), "category FUNDRAISER"; got != want {
+		t.Fatalf("Reason(%q) = %q, want %q", clean.Name, got, want)
+	}
+}
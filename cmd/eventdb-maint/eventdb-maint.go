@@ -0,0 +1,55 @@
+// package main provides maintenance commands for the eventdb database.
+package main
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	_ "github.com/lib/pq"
+
+	"github.com/findrandomevents/eventdb/pg"
+)
+
+const usage = `usage: eventdb-maint [flags] <command>
+
+commands:
+  rebuild-geoms    recompute the location columns from each event's JSON data
+`
+
+func main() {
+	var (
+		dbURL     = flag.String("db", os.Getenv("DB"), "a database connection URL for the PostgreSQL database")
+		noPostGIS = flag.Bool("no-postgis", false, "the database stores event locations without the PostGIS extension")
+	)
+	flag.Usage = func() {
+		fmt.Fprint(os.Stderr, usage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	ctx := context.Background()
+
+	db, err := sql.Open("postgres", *dbURL)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+
+	switch cmd := flag.Arg(0); cmd {
+	case "rebuild-geoms":
+		eventStore := &pg.EventStore{DB: db, NoPostGIS: *noPostGIS}
+		count, err := eventStore.RebuildGeoms(ctx)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("rebuilt geoms for %d events\n", count)
+
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+}
@@ -6,18 +6,23 @@ import (
 	"database/sql"
 	"flag"
 	"fmt"
+	"io/ioutil"
 	"net/http"
 	"os"
+	"os/signal"
 	"strings"
+	"syscall"
+	"time"
+	"unicode"
 
 	"go.uber.org/zap"
 	"golang.org/x/oauth2"
 
 	firebase "firebase.google.com/go"
-	"github.com/gorilla/handlers"
 	_ "github.com/lib/pq"
 	oauthFB "golang.org/x/oauth2/facebook"
 
+	"github.com/findrandomevents/eventdb"
 	"github.com/findrandomevents/eventdb/auth"
 	"github.com/findrandomevents/eventdb/facebook"
 	"github.com/findrandomevents/eventdb/log"
@@ -30,13 +35,34 @@ import (
 func main() {
 	var (
 		adminUIDs         = flag.String("admin-uids", os.Getenv("ADMIN_UIDS"), "comma-separated list of firebase uids that have admin privileges")
+		attendedStatuses  = flag.String("attended-statuses", "", "comma-separated list of dest statuses the client sets when a user went to an event, which is then never suggested to them again")
+		badCategories     = flag.String("bad-categories", "", "comma-separated list of Facebook event categories, like FUNDRAISER or RELIGION, whose events are always flagged bad; none by default")
+		countInterval     = flag.Duration("count-interval", 5*time.Minute, "how often the stored event, user, and dest count metrics are refreshed")
 		corsOrigins       = flag.String("cors-origins", "", "comma-seaprated list of request origins where CORS requests are allowed")
+		corsOriginsFile   = flag.String("cors-origins-file", "", "if set, a file listing allowed CORS origins, separated by commas or newlines, used instead of -cors-origins and reread on SIGHUP")
+		corsCredentials   = flag.Bool("cors-credentials", false, "allow cookies on CORS requests from the -cors-origins (needed for cookie auth)")
+		destRepeatAfter   = flag.Duration("dest-repeat-after", 0, "how long before an event suggested to a user can be suggested to them again, or 0 for never")
+		destWebhook       = flag.String("dest-webhook", os.Getenv("DEST_WEBHOOK"), "if set, the JSON for each new dest is POSTed to this URL (e.g. to send a push notification)")
 		dbURL             = flag.String("db", os.Getenv("DB"), "a database connection URL for the PostgreSQL database")
+		dbWait            = flag.Duration("db-wait", 30*time.Second, "how long to wait at startup for the database to accept connections")
+		dbWaitBackoff     = flag.Duration("db-wait-backoff", 500*time.Millisecond, "how long to wait before the first retry while waiting for the database, doubling after each failure")
+		dbReadURL         = flag.String("db-read", os.Getenv("DB_READ"), "if set, a connection URL for a read replica of the database used for searches")
 		environment       = flag.String("environment", os.Getenv("ENV"), "development or production, controls log verbosity")
 		firebaseProjectID = flag.String("project-id", "the-third-party", "The firebase project-id used for auth")
+		maxDestsPerDay    = flag.Int("max-dests-per-day", 0, "how many dests a user can generate per day, or 0 for no limit")
+		maxSubmitIDs      = flag.Int("max-submit-ids", 50, "how many events can be submitted in one request; more than 50 are fetched from Facebook in several batches")
+		minAreaEvents     = flag.Int("min-area-events", 0, "how many upcoming events must be near a user before a dest can be generated there, or 0 for no minimum")
+		noBadFilter       = flag.Bool("no-bad-filter", false, "don't flag bad events when they're submitted")
+		noPostGIS         = flag.Bool("no-postgis", false, "store event locations without the PostGIS extension (slow, for small databases)")
+		generate          = flag.Bool("generate", false, "enable dest generate; while it's off, generate returns a notice that the Facebook Events API is gone")
+		generateMaxRadius = flag.Float64("generate-max-radius", 0, "how far out in meters dest generate may widen its search when there's nothing nearby, or 0 to never widen it")
+		generateStep      = flag.Float64("generate-radius-step", 8000, "how many meters dest generate widens its search by at a time")
+		generateTimeout   = flag.Duration("generate-timeout", 15*time.Second, "how long a dest generate request may run before it's canceled")
 		oauthID           = flag.String("oauth-id", os.Getenv("OAUTH_ID"), "ID token used to authenticate with Facebook OAuth")
 		oauthSecret       = flag.String("oauth-secret", os.Getenv("OAUTH_SECRET"), "Secret token used to authenticate with Facebook OAuth")
 		port              = flag.Int("port", 8080, "the port where the REST API listens for connections")
+		searchTimeout     = flag.Duration("search-timeout", 60*time.Second, "how long an event search request may run before it's canceled")
+		submitTimeout     = flag.Duration("submit-timeout", 30*time.Second, "how long an event submit request may run before it's canceled")
 	)
 	flag.Parse()
 
@@ -59,6 +85,12 @@ func main() {
 	if *oauthSecret == "" {
 		logger.Fatal("missing oauth-secret")
 	}
+	if *dbWaitBackoff <= 0 {
+		logger.Fatal("db-wait-backoff must be positive")
+	}
+	if *countInterval <= 0 {
+		logger.Fatal("count-interval must be positive")
+	}
 
 	db, err := sql.Open("postgres", *dbURL)
 	if err != nil {
@@ -66,17 +98,36 @@ func main() {
 	}
 	db.SetMaxOpenConns(5)
 
-	eventStore := &pg.EventStore{DB: db}
+	readDB := db
+	if *dbReadURL != "" {
+		readDB, err = sql.Open("postgres", *dbReadURL)
+		if err != nil {
+			logger.Fatal("open postgres read replica failed", zap.Error(err))
+		}
+		readDB.SetMaxOpenConns(5)
+	}
+
+	// In containers the app often starts before the database is up
+	if err = pg.WaitForDB(ctx, db, *dbWait, *dbWaitBackoff); err != nil {
+		logger.Fatal("postgres not ready", zap.Error(err))
+	}
+	if readDB != db {
+		if err = pg.WaitForDB(ctx, readDB, *dbWait, *dbWaitBackoff); err != nil {
+			logger.Fatal("postgres read replica not ready", zap.Error(err))
+		}
+	}
+
+	eventStore := &pg.EventStore{DB: db, ReadDB: readDB, NoPostGIS: *noPostGIS}
 	if err = eventStore.Init(ctx); err != nil {
 		logger.Fatal("init event store failed", zap.Error(err))
 	}
 
-	userStore := &pg.UserStore{DB: db}
+	userStore := &pg.UserStore{DB: db, ReadDB: readDB}
 	if err = userStore.Init(ctx); err != nil {
 		logger.Fatal("init user store failed", zap.Error(err))
 	}
 
-	destStore := &pg.DestStore{DB: db}
+	destStore := &pg.DestStore{DB: db, ReadDB: readDB, EventStore: eventStore}
 	if err = destStore.Init(ctx); err != nil {
 		logger.Fatal("init dest store failed", zap.Error(err))
 	}
@@ -106,6 +157,17 @@ func main() {
 		AdminUIDs:  strings.Split(*adminUIDs, ","),
 	}
 
+	var notifier service.Notifier
+	if *destWebhook != "" {
+		notifier = &service.WebhookNotifier{
+			URL:  *destWebhook,
+			HTTP: &http.Client{Timeout: 10 * time.Second},
+		}
+	}
+
+	badFilter := eventdb.DefaultBadEventFilter
+	badFilter.Categories = splitList(*badCategories)
+
 	service := &service.Service{
 		DestStore:  destStore,
 		EventStore: eventStore,
@@ -114,19 +176,53 @@ func main() {
 		FacebookClient: fbClientFactory,
 
 		Auth: jwtProvider,
+
+		Notifier: notifier,
+
+		GenerateEnabled:          *generate,
+		MaxDestsPerDay:           *maxDestsPerDay,
+		MinAreaEvents:            *minAreaEvents,
+		MaxSubmitIDs:             *maxSubmitIDs,
+		DestRepeatAfter:          *destRepeatAfter,
+		AttendedStatuses:         splitList(*attendedStatuses),
+		GenerateMaxRadius:        *generateMaxRadius,
+		GenerateRadiusStep:       *generateStep,
+		DisableBadFilterOnIngest: *noBadFilter,
+		BadFilter:                &badFilter,
+
+		GenerateTimeout: *generateTimeout,
+		SubmitTimeout:   *submitTimeout,
+		SearchTimeout:   *searchTimeout,
 	}
 
 	var handler http.Handler
 	handler = rest.New(service)
+	handler = rest.Recover{}.Wrap(handler)
+	handler = rest.Compress{}.Wrap(handler)
 	handler = log.WrapHandler(handler, logger)
-	handler = handlers.CORS(
-		handlers.AllowedHeaders([]string{"Authorization"}),
-		handlers.AllowedMethods([]string{"GET", "POST", "PUT", "PATCH", "OPTIONS", "HEAD"}),
-		handlers.AllowedOrigins(strings.Split(*corsOrigins, ",")),
-	)(handler)
+	origins := rest.NewOriginList(strings.Split(*corsOrigins, ","))
+	if *corsOriginsFile != "" {
+		if err := loadOrigins(origins, *corsOriginsFile); err != nil {
+			logger.Fatal("load cors origins failed", zap.Error(err))
+		}
+		go reloadOriginsOnHUP(logger, origins, *corsOriginsFile)
+	}
+	handler = rest.CORS{
+		Origins:          origins,
+		AllowCredentials: *corsCredentials,
+	}.Wrap(handler)
 	http.Handle("/", handler)
 
 	http.Handle("/metrics", prom.Handler())
+	go prom.WatchCounts(log.ToContext(ctx, logger), *countInterval, map[string]prom.CountFunc{
+		"events": eventStore.Count,
+		"users":  userStore.Count,
+		"dests":  destStore.Count,
+	})
+	go prom.WatchGauge(log.ToContext(ctx, logger), *countInterval, prom.FacebookTokensAvailable, func(ctx context.Context) (int64, error) {
+		n, err := userStore.CountFBTokens(ctx)
+		return int64(n), err
+	})
 
 	addr := fmt.Sprint(":", *port)
 	logger.Info("listening", zap.String("addr", addr))
@@ -134,3 +230,42 @@ func main() {
 		logger.Fatal("http server failed", zap.Error(err))
 	}
 }
+
+// loadOrigins replaces the allowed CORS origins with the ones listed in the
+// file at path.
+func loadOrigins(origins *rest.OriginList, path string) error {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	origins.Set(strings.FieldsFunc(string(b), func(r rune) bool {
+		return r == ',' || unicode.IsSpace(r)
+	}))
+	return nil
+}
+
+// reloadOriginsOnHUP rereads the CORS origins file each time the process gets
+// a SIGHUP. A file that can't be read leaves the old origins in place.
+func reloadOriginsOnHUP(logger *zap.Logger, origins *rest.OriginList, path string) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	for range hup {
+		if err := loadOrigins(origins, path); err != nil {
+			logger.Error("reload cors origins failed", zap.Error(err))
+			continue
+		}
+		logger.Info("reloaded cors origins", zap.Strings("origins", origins.Get()))
+	}
+}
+
+// splitList splits a comma-separated flag value, dropping blank entries.
+func splitList(s string) []string {
+	var list []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
@@ -18,6 +18,10 @@ type Dest struct {
 
 	// Used to side-load event data when sending dest list to the client
 	Event *Event `json:"event,omitempty"`
+	// EventUnavailable is set when the dest's event was deleted or marked bad
+	// after the dest was created. The client should show that the event is no
+	// longer available.
+	EventUnavailable bool `json:"eventUnavailable,omitempty"`
 
 	Status   string `json:"status"`
 	Feedback string `json:"feedback"`
@@ -25,6 +29,20 @@ type Dest struct {
 	CreatedAt time.Time `json:"createdAt"`
 }
 
+// FeedbackSummary totals up the feedback users have left on the dests for an
+// event.
+type FeedbackSummary struct {
+	EventID EventID `json:"eventID"`
+
+	// Dests is the number of times the event was chosen for a user.
+	Dests int `json:"dests"`
+	// Statuses counts the dests by status. Dests without a status aren't
+	// counted.
+	Statuses map[string]int `json:"statuses"`
+	// WithFeedback is the number of dests with written feedback.
+	WithFeedback int `json:"withFeedback"`
+}
+
 // A DestUpdate allows a user to update a Dest with feedback.
 type DestUpdate struct {
 	Feedback string `json:"feedback"`
@@ -42,9 +60,35 @@ type DestUpdate struct {
 //
 // It's sent by the client to get their next random event.
 type DestGenerateRequest struct {
-	UserID UserID  `json:"userID"`
-	Lat    float64 `json:"lat"`
-	Lng    float64 `json:"lng"`
+	UserID UserID `json:"userID"`
+
+	// Lat and Lng are where to look for events. If both are zero, the
+	// location of the user's last generate is used, and the request is
+	// invalid if they haven't generated before.
+	Lat float64 `json:"lat"`
+	Lng float64 `json:"lng"`
+
+	// RadiusM is how far from the location to look for events, in meters.
+	// It must be between 100m and 50km, and defaults to 8km if it's zero.
+	RadiusM float64 `json:"radiusM"`
+
+	// Location, if set, is the label of one of the user's saved locations to
+	// look for events at instead of Lat and Lng.
+	Location string `json:"location"`
+
+	// MinNoticeMinutes is how far in the future an event must start for the
+	// user to have time to get there. It defaults to 10 minutes.
+	MinNoticeMinutes int `json:"minNoticeMinutes"`
+	// WindowMinutes is the size of the time windows events are searched in,
+	// soonest first. It defaults to 90 minutes and must be at least 15.
+	WindowMinutes int `json:"windowMinutes"`
+	// CooldownDisabled lets the user generate again before the event they
+	// were last sent to has started. Normally they get GenerateWait.
+	CooldownDisabled bool `json:"cooldownDisabled"`
+
+	// AllowStarted includes events that start before the minimum notice,
+	// including ones that are already going on. Normally they're excluded.
+	AllowStarted bool `json:"allowStarted"`
 }
 
 // DestGenerateResult describes whether or not a DestGenerate request was
@@ -62,6 +106,12 @@ const (
 	GenerateNoResults DestGenerateResult = "no-results"
 	// GenerateError means there was a problem generating the event, try again later
 	GenerateError DestGenerateResult = "error"
+	// GenerateLimit means the user has generated as many destinations as they
+	// can today. DestGenerateReply.RetryAfter says when they can try again.
+	GenerateLimit DestGenerateResult = "limit"
+	// GenerateUnderserved means there are too few upcoming events in the area
+	// for the choice to feel random, so no destination was generated.
+	GenerateUnderserved DestGenerateResult = "area-underserved"
 )
 
 // DestGenerateReply is returned in response to a DestGenerateRequest. It reports
@@ -71,9 +121,28 @@ type DestGenerateReply struct {
 	Result DestGenerateResult `json:"result"`
 	Dests  []Dest             `json:"dests"`
 	Events []Event            `json:"events"`
+
+	// RetryAfter is set with GenerateLimit. It's the next midnight in the
+	// user's time zone, when the daily limit resets. Otherwise it's nil.
+	RetryAfter *time.Time `json:"retryAfter,omitempty"`
+}
+
+// A DestCreateMultiRequest asks to create dests for a user directly, without
+// going through generate. It's for admins seeding accounts or reconstructing
+// a user's history.
+type DestCreateMultiRequest struct {
+	UserID   UserID    `json:"userID"`
+	EventIDs []EventID `json:"eventIDs"`
 }
 
 // A DestListRequest requests a piece of the user's dest list.
 type DestListRequest struct {
 	Page int `json:"page"`
+
+	// PageSize is the number of dests per page. It defaults to 10 and is
+	// capped at 100.
+	PageSize int `json:"pageSize"`
+
+	// OnlyUpcoming lists just the dests whose events haven't ended yet.
+	OnlyUpcoming bool `json:"onlyUpcoming"`
 }
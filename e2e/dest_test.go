@@ -2,11 +2,19 @@ package e2e
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/findrandomevents/eventdb"
+	"github.com/findrandomevents/eventdb/auth"
 	"github.com/findrandomevents/eventdb/errors"
 	"github.com/findrandomevents/eventdb/rest/client"
+	"github.com/findrandomevents/eventdb/service"
 )
 
 func TestGenerateDest(t *testing.T) {
@@ -24,7 +32,7 @@ func TestGenerateDest(t *testing.T) {
 	savedEventIDs := []eventdb.EventID{
 		"1", "2", "3", "4", "5",
 	}
-	err := client.Events.Submit(ctx, eventdb.EventSubmitRequest{
+	_, err := client.Events.Submit(ctx, eventdb.EventSubmitRequest{
 		EventIDs: savedEventIDs,
 	})
 	if err != nil {
@@ -87,7 +95,7 @@ func TestGenerateDestTooFast(t *testing.T) {
 	savedEventIDs := []eventdb.EventID{
 		"1", "2", "3", "4", "5",
 	}
-	err := client.Events.Submit(ctx, eventdb.EventSubmitRequest{
+	_, err := client.Events.Submit(ctx, eventdb.EventSubmitRequest{
 		EventIDs: savedEventIDs,
 	})
 	if err != nil {
@@ -134,9 +142,10 @@ func TestNoNewEvents(t *testing.T) {
 
 	ctx := context.Background()
 
+	// Somewhere in the ocean, far from any events
 	resp, err := client.Dests.Generate(ctx, eventdb.DestGenerateRequest{
 		Lat: 0,
-		Lng: 0,
+		Lng: -30,
 	})
 	if err != nil {
 		t.Fatalf("Dests.Generate: %v", err)
@@ -159,7 +168,7 @@ func TestUpdateStrangerEvent(t *testing.T) {
 	strangerClient := client.New("stranger")
 	strangerClient.BaseURL = srv.URL
 
-	err := strangerClient.Events.Submit(ctx, eventdb.EventSubmitRequest{
+	_, err := strangerClient.Events.Submit(ctx, eventdb.EventSubmitRequest{
 		EventIDs: []eventdb.EventID{"dummyevent"},
 	})
 	if err != nil {
@@ -187,7 +196,7 @@ func TestUpdateStrangerEvent(t *testing.T) {
 	client.BaseURL = srv.URL
 
 	_, err = client.Dests.Get(ctx, dest.ID)
-	if got, kind := err, errors.Permission; !errors.Is(kind, err) {
+	if got, kind := err, errors.NotExist; !errors.Is(kind, err) {
 		t.Fatalf("get stranger's dest returned %v, want %v", got, kind)
 	}
 
@@ -195,7 +204,1159 @@ func TestUpdateStrangerEvent(t *testing.T) {
 		Status: "pwned",
 		Mask:   "status",
 	})
-	if got, kind := err, errors.Permission; !errors.Is(kind, err) {
-		t.Fatalf("get stranger's dest returned %v, want %v", got, kind)
+	if got, kind := err, errors.NotExist; !errors.Is(kind, err) {
+		t.Fatalf("update stranger's dest returned %v, want %v", got, kind)
+	}
+
+	// The same as for a dest that doesn't exist
+	_, err = client.Dests.Update(ctx, "no-such-dest", eventdb.DestUpdate{
+		Status: "pwned",
+		Mask:   "status",
+	})
+	if got, kind := err, errors.NotExist; !errors.Is(kind, err) {
+		t.Fatalf("update missing dest returned %v, want %v", got, kind)
+	}
+}
+
+func TestDestGetEventStatus(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	srv := stubService(ctx, t)
+	srv.FacebookClient = func(string) service.FacebookClient {
+		return eventGetterFunc(func(ctx context.Context, ids []string) ([]json.RawMessage, error) {
+			js := strings.Replace(string(stubEvent(ids[0])), `"is_canceled": false`, `"is_canceled": true`, 1)
+			return []json.RawMessage{json.RawMessage(js)}, nil
+		})
+	}
+
+	userCtx := auth.Context(ctx, auth.ID("user"))
+
+	if _, err := srv.EventSubmit(userCtx, eventdb.EventSubmitRequest{
+		EventIDs: []eventdb.EventID{"canceled"},
+	}); err != nil {
+		t.Fatalf("EventSubmit: %v", err)
+	}
+
+	for _, test := range []struct {
+		Name            string
+		EventID         eventdb.EventID
+		WantStatus      eventdb.EventStatus
+		WantUnavailable bool
+	}{
+		{
+			Name:       "canceled",
+			EventID:    "canceled",
+			WantStatus: eventdb.EventCanceled,
+		},
+		{
+			Name:            "deleted",
+			EventID:         "deleted",
+			WantUnavailable: true,
+		},
+	} {
+		created, err := srv.DestStore.Create(ctx, eventdb.Dest{
+			UserID:  "user",
+			EventID: test.EventID,
+		})
+		if err != nil {
+			t.Fatalf("DestStore.Create (%s): %v", test.Name, err)
+		}
+
+		dest, err := srv.DestGet(userCtx, created.ID)
+		if err != nil {
+			t.Fatalf("DestGet (%s): %v", test.Name, err)
+		}
+
+		if got, want := dest.EventUnavailable, test.WantUnavailable; got != want {
+			t.Fatalf("DestGet (%s): eventUnavailable = %v, want %v", test.Name, got, want)
+		}
+
+		var status eventdb.EventStatus
+		if dest.Event != nil {
+			status = dest.Event.Status
+		}
+		if got, want := status, test.WantStatus; got != want {
+			t.Fatalf("DestGet (%s): event status = %q, want %q", test.Name, got, want)
+		}
+	}
+}
+
+func TestGenerateDestDisabled(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	srv := stubService(ctx, t)
+	srv.GenerateEnabled = false
+
+	userCtx := auth.Context(ctx, auth.ID("user"))
+	if _, err := srv.EventSubmit(userCtx, eventdb.EventSubmitRequest{
+		EventIDs: []eventdb.EventID{"1"},
+	}); err != nil {
+		t.Fatalf("EventSubmit: %v", err)
+	}
+
+	reply, err := srv.DestGenerate(userCtx, eventdb.DestGenerateRequest{
+		Lat: 45.962815043539,
+		Lng: 15.485937595367,
+	})
+	if err != nil {
+		t.Fatalf("DestGenerate: %v", err)
+	}
+	if got, want := reply.Result, eventdb.GenerateWait; got != want {
+		t.Fatalf("disabled generate got result %q, want %q", got, want)
+	}
+	if len(reply.Events) != 1 || reply.Events[0].ID != "findrandomevents" {
+		t.Fatalf("disabled generate got events %v, want the notice", reply.Events)
+	}
+
+	// Nothing was actually chosen
+	dests, err := srv.DestStore.ListForUser(ctx, "user", eventdb.DestListRequest{})
+	if err != nil {
+		t.Fatalf("ListForUser: %v", err)
+	}
+	if got, want := len(dests), 0; got != want {
+		t.Fatalf("disabled generate created %d dests, want %d", got, want)
+	}
+}
+
+func TestGenerateDestConcurrent(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	srv := stubService(ctx, t)
+
+	userCtx := auth.Context(ctx, auth.ID("user"))
+
+	_, err := srv.EventSubmit(userCtx, eventdb.EventSubmitRequest{
+		EventIDs: []eventdb.EventID{"1", "2", "3", "4", "5"},
+	})
+	if err != nil {
+		t.Fatalf("EventSubmit: %v", err)
+	}
+
+	// Generate from two "devices" at the same time
+	const devices = 2
+	results := make(chan eventdb.DestGenerateResult, devices)
+	errs := make(chan error, devices)
+	for i := 0; i < devices; i++ {
+		go func() {
+			reply, err := srv.DestGenerate(userCtx, eventdb.DestGenerateRequest{
+				Lat: 45.962815043539,
+				Lng: 15.485937595367,
+			})
+			results <- reply.Result
+			errs <- err
+		}()
+	}
+
+	var okCount int
+	for i := 0; i < devices; i++ {
+		if err := <-errs; err != nil {
+			t.Fatalf("DestGenerate: %v", err)
+		}
+		if <-results == eventdb.GenerateOK {
+			okCount++
+		}
+	}
+	if got, want := okCount, 1; got != want {
+		t.Fatalf("concurrent generates returned %d ok results, want %d", got, want)
+	}
+
+	dests, err := srv.DestStore.ListForUser(ctx, "user", eventdb.DestListRequest{})
+	if err != nil {
+		t.Fatalf("ListForUser: %v", err)
+	}
+	if got, want := len(dests), 1; got != want {
+		t.Fatalf("concurrent generates created %d dests, want %d", got, want)
+	}
+}
+
+func TestGenerateDestMoreThanPool(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	srv := stubService(ctx, t)
+
+	_, err := srv.EventSubmit(auth.Context(ctx, auth.ID("user")), eventdb.EventSubmitRequest{
+		EventIDs: []eventdb.EventID{"1", "2", "3", "4", "5"},
+	})
+	if err != nil {
+		t.Fatalf("EventSubmit: %v", err)
+	}
+
+	// Far more generates than connections. Holding the user lock mustn't tie
+	// up a connection the generates need for their own queries.
+	const pool, generates = 2, 8
+	srv.DestStore.DB.SetMaxOpenConns(pool)
+
+	errs := make(chan error, generates)
+	for i := 0; i < generates; i++ {
+		userCtx := auth.Context(ctx, auth.ID(fmt.Sprintf("user%d", i%4)))
+		go func() {
+			_, err := srv.DestGenerate(userCtx, eventdb.DestGenerateRequest{
+				Lat: 45.962815043539,
+				Lng: 15.485937595367,
+			})
+			errs <- err
+		}()
+	}
+	for i := 0; i < generates; i++ {
+		if err := <-errs; err != nil {
+			t.Fatalf("DestGenerate with %d connections: %v", pool, err)
+		}
+	}
+
+	// Each of the four users got one dest, the rest waited on it
+	dests, err := srv.DestStore.ListForUser(ctx, "user0", eventdb.DestListRequest{})
+	if err != nil {
+		t.Fatalf("ListForUser: %v", err)
+	}
+	if got, want := len(dests), 1; got != want {
+		t.Fatalf("concurrent generates created %d dests, want %d", got, want)
+	}
+}
+
+func TestGenerateDestMinNotice(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2017, 8, 17, 14, 0, 0, 0, time.UTC)
+
+	for _, test := range []struct {
+		Name       string
+		StartsIn   time.Duration
+		Notice     int
+		WantResult eventdb.DestGenerateResult
+	}{
+		{
+			Name:       "starts too soon",
+			StartsIn:   2 * time.Minute,
+			Notice:     15,
+			WantResult: eventdb.GenerateNoResults,
+		},
+		{
+			Name:       "already started",
+			StartsIn:   -time.Hour,
+			WantResult: eventdb.GenerateNoResults,
+		},
+		{
+			Name:       "enough notice",
+			StartsIn:   20 * time.Minute,
+			Notice:     15,
+			WantResult: eventdb.GenerateOK,
+		},
+	} {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		srv := stubService(ctx, t)
+		srv.Time = stubTime(now)
+		srv.FacebookClient = func(string) service.FacebookClient {
+			return eventGetterFunc(func(ctx context.Context, ids []string) ([]json.RawMessage, error) {
+				start := now.Add(test.StartsIn)
+				return []json.RawMessage{stubEventAt(ids[0], start, start.Add(2*time.Hour))}, nil
+			})
+		}
+
+		userCtx := auth.Context(ctx, auth.ID("user"))
+
+		_, err := srv.EventSubmit(userCtx, eventdb.EventSubmitRequest{
+			EventIDs: []eventdb.EventID{"1"},
+		})
+		if err != nil {
+			t.Fatalf("EventSubmit (%s): %v", test.Name, err)
+		}
+
+		reply, err := srv.DestGenerate(userCtx, eventdb.DestGenerateRequest{
+			Lat:              45.962815043539,
+			Lng:              15.485937595367,
+			MinNoticeMinutes: test.Notice,
+		})
+		if err != nil {
+			t.Fatalf("DestGenerate (%s): %v", test.Name, err)
+		}
+		if got, want := reply.Result, test.WantResult; got != want {
+			t.Fatalf("DestGenerate (%s): result = %q, want %q", test.Name, got, want)
+		}
+	}
+}
+
+// recordingNotifier is a service.Notifier that remembers the dests it's told
+// about.
+type recordingNotifier struct {
+	Err   error
+	Dests []eventdb.Dest
+}
+
+func (r *recordingNotifier) NotifyDest(ctx context.Context, dest eventdb.Dest) error {
+	r.Dests = append(r.Dests, dest)
+	return r.Err
+}
+
+func TestGenerateDestNotify(t *testing.T) {
+	t.Parallel()
+
+	for _, notifyErr := range []error{nil, fmt.Errorf("push service is down")} {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		notifier := &recordingNotifier{Err: notifyErr}
+
+		srv := stubService(ctx, t)
+		srv.Notifier = notifier
+
+		userCtx := auth.Context(ctx, auth.ID("user"))
+
+		_, err := srv.EventSubmit(userCtx, eventdb.EventSubmitRequest{
+			EventIDs: []eventdb.EventID{"1"},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// A failing notifier shouldn't fail the generate
+		reply, err := srv.DestGenerate(userCtx, eventdb.DestGenerateRequest{
+			Lat: 45.962815043539,
+			Lng: 15.485937595367,
+		})
+		if err != nil {
+			t.Fatalf("DestGenerate (notify err %v): %v", notifyErr, err)
+		}
+		if got, want := reply.Result, eventdb.GenerateOK; got != want {
+			t.Fatalf("DestGenerate (notify err %v): result = %q, want %q", notifyErr, got, want)
+		}
+
+		if got, want := len(notifier.Dests), 1; got != want {
+			t.Fatalf("notifier called %d times, want %d", got, want)
+		}
+		notified := notifier.Dests[0]
+		if got, want := notified.ID, reply.Dests[0].ID; got != want {
+			t.Errorf("notified dest id = %q, want %q", got, want)
+		}
+		if got, want := notified.EventID, eventdb.EventID("1"); got != want {
+			t.Errorf("notified dest event id = %q, want %q", got, want)
+		}
+	}
+}
+
+func TestUpdateDestUnknownMask(t *testing.T) {
+	t.Parallel()
+
+	srv := stubServer(t)
+	defer srv.Close()
+
+	ctx := context.Background()
+
+	client := client.New("user")
+	client.BaseURL = srv.URL
+
+	_, err := client.Events.Submit(ctx, eventdb.EventSubmitRequest{
+		EventIDs: []eventdb.EventID{"1"},
+	})
+	if err != nil {
+		t.Fatal("submit events: ", err)
+	}
+
+	reply, err := client.Dests.Generate(ctx, eventdb.DestGenerateRequest{
+		Lat: 45.962815043539,
+		Lng: 15.485937595367,
+	})
+	if err != nil {
+		t.Fatal("generate dest: ", err)
+	}
+	if got, want := len(reply.Dests), 1; got != want {
+		t.Fatalf("generate created %d dests, want %d", got, want)
+	}
+	dest := reply.Dests[0]
+
+	_, err = client.Dests.Update(ctx, dest.ID, eventdb.DestUpdate{
+		Status: "liked",
+		Mask:   "staus",
+	})
+	if !errors.Is(errors.Invalid, err) {
+		t.Fatalf("update with unknown mask field returned %v, want %v", err, errors.Invalid)
+	}
+	if !strings.Contains(err.Error(), "staus") {
+		t.Fatalf("update error %q doesn't name the unknown field", err)
+	}
+
+	updated, err := client.Dests.Update(ctx, dest.ID, eventdb.DestUpdate{
+		Status: "liked",
+		Mask:   "status",
+	})
+	if err != nil {
+		t.Fatal("update dest: ", err)
+	}
+	if got, want := updated.Status, "liked"; got != want {
+		t.Fatalf("updated dest status = %q, want %q", got, want)
+	}
+}
+
+func TestDestCreateMulti(t *testing.T) {
+	t.Parallel()
+
+	srv := stubServer(t)
+	defer srv.Close()
+
+	ctx := context.Background()
+
+	admin := client.New("admin")
+	admin.BaseURL = srv.URL
+	user := client.New("user")
+	user.BaseURL = srv.URL
+
+	eventIDs := []eventdb.EventID{"1", "2", "3"}
+	_, err := admin.Events.Submit(ctx, eventdb.EventSubmitRequest{EventIDs: eventIDs})
+	if err != nil {
+		t.Fatal("submit events: ", err)
+	}
+
+	req := eventdb.DestCreateMultiRequest{UserID: "user", EventIDs: eventIDs}
+
+	if _, err := user.Dests.CreateMulti(ctx, req); !errors.Is(errors.Permission, err) {
+		t.Fatalf("non-admin CreateMulti got %v, want %v", err, errors.Permission)
+	}
+
+	_, err = admin.Dests.CreateMulti(ctx, eventdb.DestCreateMultiRequest{
+		UserID:   "user",
+		EventIDs: []eventdb.EventID{"1", "missing"},
+	})
+	if !errors.Is(errors.Invalid, err) {
+		t.Fatalf("CreateMulti with a missing event got %v, want %v", err, errors.Invalid)
+	}
+
+	created, err := admin.Dests.CreateMulti(ctx, req)
+	if err != nil {
+		t.Fatal("create dests: ", err)
+	}
+	var createdIDs []eventdb.EventID
+	for _, dest := range created {
+		if dest.UserID != "user" {
+			t.Fatalf("created dest for %q, want %q", dest.UserID, "user")
+		}
+		createdIDs = append(createdIDs, dest.EventID)
+	}
+	if got, want := fmt.Sprint(createdIDs), fmt.Sprint(eventIDs); got != want {
+		t.Fatalf("created dests for events %v, want %v", got, want)
+	}
+
+	// The list is newest first
+	dests, err := user.Dests.List(ctx, "", eventdb.DestUpdate{})
+	if err != nil {
+		t.Fatal("list dests: ", err)
+	}
+	var listedIDs []eventdb.EventID
+	for _, dest := range dests {
+		listedIDs = append(listedIDs, dest.EventID)
+	}
+	if got, want := fmt.Sprint(listedIDs), fmt.Sprint([]eventdb.EventID{"3", "2", "1"}); got != want {
+		t.Fatalf("listed dests for events %v, want %v", got, want)
+	}
+}
+
+func TestGenerateDestDailyLimit(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	srv := stubService(ctx, t)
+	srv.MaxDestsPerDay = 1
+
+	userCtx := auth.Context(ctx, auth.ID("user"))
+
+	_, err := srv.UserUpdate(userCtx, "user", eventdb.UserUpdate{
+		TimeZone: "Europe/Ljubljana",
+		Mask:     "timeZone",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = srv.EventSubmit(userCtx, eventdb.EventSubmitRequest{
+		EventIDs: []eventdb.EventID{"1", "2"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := eventdb.DestGenerateRequest{
+		Lat: 45.962815043539,
+		Lng: 15.485937595367,
+	}
+
+	reply, err := srv.DestGenerate(userCtx, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := reply.Result, eventdb.GenerateOK; got != want {
+		t.Fatalf("first DestGenerate result = %q, want %q", got, want)
+	}
+	if reply.RetryAfter != nil {
+		t.Fatalf("first DestGenerate RetryAfter = %v, want nil", reply.RetryAfter)
+	}
+
+	reply, err = srv.DestGenerate(userCtx, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := reply.Result, eventdb.GenerateLimit; got != want {
+		t.Fatalf("second DestGenerate result = %q, want %q", got, want)
+	}
+	if got, want := len(reply.Dests), 1; got != want {
+		t.Fatalf("DestGenerate at the limit has %d dests, want %d", got, want)
+	}
+
+	// The stub clock is 16:00 in Ljubljana, so the limit resets at midnight
+	// there.
+	loc, err := time.LoadLocation("Europe/Ljubljana")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reply.RetryAfter == nil {
+		t.Fatal("DestGenerate at the limit has no RetryAfter")
+	}
+	if got, want := *reply.RetryAfter, time.Date(2017, 8, 18, 0, 0, 0, 0, loc); !got.Equal(want) {
+		t.Fatalf("RetryAfter = %v, want %v", got, want)
+	}
+}
+
+func TestGenerateDestTimesChosen(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	srv := stubService(ctx, t)
+
+	adminCtx := auth.Context(ctx, auth.ID("admin"), auth.Admin(true))
+
+	// Only one event is in the database, so every dest has to pick it.
+	_, err := srv.EventSubmit(adminCtx, eventdb.EventSubmitRequest{
+		EventIDs: []eventdb.EventID{"2"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, userID := range []string{"user1", "user2", "user3"} {
+		userCtx := auth.Context(ctx, auth.ID(userID))
+		reply, err := srv.DestGenerate(userCtx, eventdb.DestGenerateRequest{
+			Lat: 45.962815043539,
+			Lng: 15.485937595367,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := reply.Result, eventdb.GenerateOK; got != want {
+			t.Fatalf("DestGenerate for %s result = %q, want %q", userID, got, want)
+		}
+	}
+
+	event, err := srv.EventGet(adminCtx, "2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := event.TimesChosen, 3; got != want {
+		t.Fatalf("TimesChosen = %d, want %d", got, want)
+	}
+
+	// Event 1 sorts first by start time, but it's never been chosen.
+	_, err = srv.EventSubmit(adminCtx, eventdb.EventSubmitRequest{
+		EventIDs: []eventdb.EventID{"1"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	events, err := srv.EventSearch(adminCtx, eventdb.EventSearchRequest{
+		Start:   time.Date(2017, 8, 17, 0, 0, 0, 0, time.UTC),
+		End:     time.Date(2017, 8, 18, 0, 0, 0, 0, time.UTC),
+		OrderBy: eventdb.OrderByTimesChosen,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var gotIDs []eventdb.EventID
+	for _, event := range events {
+		gotIDs = append(gotIDs, event.ID)
+	}
+	if want := []eventdb.EventID{"2", "1"}; !reflect.DeepEqual(gotIDs, want) {
+		t.Fatalf("search ordered by times chosen = %v, want %v", gotIDs, want)
+	}
+}
+
+func TestDestGetNotFound(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	srv := stubService(ctx, t)
+
+	ownerCtx := auth.Context(ctx, auth.ID("owner"))
+	strangerCtx := auth.Context(ctx, auth.ID("stranger"))
+	adminCtx := auth.Context(ctx, auth.ID("admin"), auth.Admin(true))
+
+	dest, err := srv.DestStore.Create(ctx, eventdb.Dest{
+		UserID:  "owner",
+		EventID: "1",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		Name     string
+		Ctx      context.Context
+		ID       eventdb.DestID
+		WantKind errors.Kind // or Other for no error
+	}{
+		{Name: "owner, owned", Ctx: ownerCtx, ID: dest.ID, WantKind: errors.Other},
+		{Name: "owner, missing", Ctx: ownerCtx, ID: "missing", WantKind: errors.NotExist},
+		{Name: "stranger, not owned", Ctx: strangerCtx, ID: dest.ID, WantKind: errors.NotExist},
+		{Name: "stranger, missing", Ctx: strangerCtx, ID: "missing", WantKind: errors.NotExist},
+		{Name: "admin, not owned", Ctx: adminCtx, ID: dest.ID, WantKind: errors.Other},
+		{Name: "admin, missing", Ctx: adminCtx, ID: "missing", WantKind: errors.NotExist},
+	} {
+		got, err := srv.DestGet(test.Ctx, test.ID)
+		if test.WantKind == errors.Other {
+			if err != nil {
+				t.Errorf("%s: DestGet: %v", test.Name, err)
+			} else if got.ID != dest.ID {
+				t.Errorf("%s: DestGet returned dest %q, want %q", test.Name, got.ID, dest.ID)
+			}
+			continue
+		}
+		if !errors.Is(test.WantKind, err) {
+			t.Errorf("%s: DestGet got %v, want %v", test.Name, err, test.WantKind)
+		}
+		if got.ID != "" {
+			t.Errorf("%s: DestGet leaked dest %q with the error", test.Name, got.ID)
+		}
+	}
+}
+
+func TestGenerateDestUnderserved(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	srv := stubService(ctx, t)
+	srv.MinAreaEvents = 3
+
+	userCtx := auth.Context(ctx, auth.ID("user"))
+
+	_, err := srv.EventSubmit(userCtx, eventdb.EventSubmitRequest{
+		EventIDs: []eventdb.EventID{"1"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reply, err := srv.DestGenerate(userCtx, eventdb.DestGenerateRequest{
+		Lat: 45.962815043539,
+		Lng: 15.485937595367,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := reply.Result, eventdb.GenerateUnderserved; got != want {
+		t.Fatalf("DestGenerate result = %q, want %q", got, want)
+	}
+	if got := len(reply.Dests); got != 0 {
+		t.Fatalf("underserved DestGenerate created %d dests, want none", got)
+	}
+
+	// With enough events it picks one as usual
+	_, err = srv.EventSubmit(userCtx, eventdb.EventSubmitRequest{
+		EventIDs: []eventdb.EventID{"2", "3"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reply, err = srv.DestGenerate(userCtx, eventdb.DestGenerateRequest{
+		Lat: 45.962815043539,
+		Lng: 15.485937595367,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := reply.Result, eventdb.GenerateOK; got != want {
+		t.Fatalf("DestGenerate with 3 events result = %q, want %q", got, want)
+	}
+}
+
+func TestGenerateDestSeeded(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	srv := stubService(ctx, t)
+	expected := pinService(srv, 42, time.Date(2017, 8, 17, 14, 0, 0, 0, time.UTC))
+
+	adminCtx := auth.Context(ctx, auth.ID("admin"), auth.Admin(true))
+
+	// The candidates all start at the same time, so they're searched in ID
+	// order.
+	candidates := []eventdb.EventID{"1", "2", "3", "4", "5"}
+	_, err := srv.EventSubmit(adminCtx, eventdb.EventSubmitRequest{EventIDs: candidates})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Every candidate is equally likely, so each pick is the candidate at
+	// the seeded random number's position in the list.
+	for _, userID := range []string{"user1", "user2", "user3", "user4"} {
+		want := candidates[int(expected.Float64()*float64(len(candidates)))]
+
+		reply, err := srv.DestGenerate(auth.Context(ctx, auth.ID(userID)), eventdb.DestGenerateRequest{
+			Lat: 45.962815043539,
+			Lng: 15.485937595367,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := reply.Result, eventdb.GenerateOK; got != want {
+			t.Fatalf("DestGenerate for %s result = %q, want %q", userID, got, want)
+		}
+		if got := reply.Dests[0].EventID; got != want {
+			t.Fatalf("DestGenerate for %s chose %q, want %q", userID, got, want)
+		}
+	}
+}
+
+func TestGenerateDestDeterministic(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	srv := stubService(ctx, t)
+	now := time.Date(2017, 8, 17, 14, 0, 0, 0, time.UTC)
+	expected := pinService(srv, 7, now)
+
+	// The events all start at the same time, so they're searched in ID order
+	start := time.Date(2017, 8, 17, 18, 0, 0, 0, time.UTC)
+	end := time.Date(2017, 8, 17, 23, 0, 0, 0, time.UTC)
+	remaining := []eventdb.EventID{"1", "2", "3", "4", "5"}
+
+	var events []json.RawMessage
+	for _, id := range remaining {
+		events = append(events, stubEventAt(string(id), start, end))
+	}
+	adminCtx := auth.Context(ctx, auth.ID("admin"), auth.Admin(true))
+	if err := srv.EventImport(adminCtx, events); err != nil {
+		t.Fatal(err)
+	}
+
+	// The same user generates over and over. Events they've already been sent
+	// to drop out, so each pick is from the ones that are left.
+	userCtx := auth.Context(ctx, auth.ID("user"))
+	for i := 0; i < 4; i++ {
+		pick := int(expected.Float64() * float64(len(remaining)))
+		want := remaining[pick]
+		remaining = append(remaining[:pick], remaining[pick+1:]...)
+
+		reply, err := srv.DestGenerate(userCtx, eventdb.DestGenerateRequest{
+			Lat:          45.962815043539,
+			Lng:          15.485937595367,
+			AllowStarted: true,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := reply.Result, eventdb.GenerateOK; got != want {
+			t.Fatalf("generate at %v result = %q, want %q", now, got, want)
+		}
+		if got := reply.Dests[0].EventID; got != want {
+			t.Fatalf("generate at %v chose %q, want %q", now, got, want)
+		}
+
+		// Wait for the chosen event to start so the user can generate again
+		now = start.Add(time.Duration(i+1) * time.Minute)
+		srv.Time = stubTime(now)
+	}
+}
+
+func TestDestListOnlyUpcoming(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	srv := stubService(ctx, t)
+
+	// The stub clock is 2017-08-17 14:00 UTC
+	adminCtx := auth.Context(ctx, auth.ID("admin"), auth.Admin(true))
+	err := srv.EventImport(adminCtx, []json.RawMessage{
+		stubEventAt("past",
+			time.Date(2017, 8, 16, 18, 0, 0, 0, time.UTC),
+			time.Date(2017, 8, 16, 20, 0, 0, 0, time.UTC)),
+		stubEventAt("future",
+			time.Date(2017, 8, 18, 18, 0, 0, 0, time.UTC),
+			time.Date(2017, 8, 18, 20, 0, 0, 0, time.UTC)),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, eventID := range []eventdb.EventID{"past", "future"} {
+		if _, err := srv.DestStore.Create(ctx, eventdb.Dest{UserID: "user", EventID: eventID}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	userCtx := auth.Context(ctx, auth.ID("user"))
+	for _, test := range []struct {
+		OnlyUpcoming bool
+		Want         map[eventdb.EventID]eventdb.EventStatus
+	}{
+		{
+			OnlyUpcoming: false,
+			Want:         map[eventdb.EventID]eventdb.EventStatus{"past": eventdb.EventEnded, "future": eventdb.EventUpcoming},
+		},
+		{
+			OnlyUpcoming: true,
+			Want:         map[eventdb.EventID]eventdb.EventStatus{"future": eventdb.EventUpcoming},
+		},
+	} {
+		dests, err := srv.DestList(userCtx, eventdb.DestListRequest{OnlyUpcoming: test.OnlyUpcoming})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		got := map[eventdb.EventID]eventdb.EventStatus{}
+		for _, dest := range dests {
+			if dest.Event == nil {
+				t.Fatalf("OnlyUpcoming=%v: dest %s has no event", test.OnlyUpcoming, dest.ID)
+			}
+			got[dest.EventID] = dest.Event.Status
+		}
+		if !reflect.DeepEqual(got, test.Want) {
+			t.Fatalf("OnlyUpcoming=%v: got event statuses %v, want %v", test.OnlyUpcoming, got, test.Want)
+		}
+	}
+}
+
+func TestGenerateDestLastLocation(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	srv := stubService(ctx, t)
+	userCtx := auth.Context(ctx, auth.ID("user"))
+
+	_, err := srv.UserStore.Update(ctx, "user", eventdb.UserUpdate{TimeZone: "UTC", Mask: "timeZone"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Nothing's there yet, but the location is still remembered
+	reply, err := srv.DestGenerate(userCtx, eventdb.DestGenerateRequest{
+		Lat: 45.962815043539,
+		Lng: 15.485937595367,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := reply.Result, eventdb.GenerateNoResults; got != want {
+		t.Fatalf("first generate got result %q, want %q", got, want)
+	}
+
+	// A generate doesn't create a user who doesn't exist
+	_, err = srv.DestGenerate(auth.Context(ctx, auth.ID("newuser")), eventdb.DestGenerateRequest{
+		Lat: 45.962815043539,
+		Lng: 15.485937595367,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := srv.UserStore.GetByID(ctx, "newuser"); !errors.Is(errors.NotExist, err) {
+		t.Fatalf("GetByID(newuser) after generate got %v, want %v", err, errors.NotExist)
+	}
+
+	user, err := srv.UserStore.GetByID(ctx, "user")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.LastLat != 45.962815043539 || user.LastLng != 15.485937595367 {
+		t.Fatalf("user's last location = %v,%v, want the generate's location", user.LastLat, user.LastLng)
+	}
+
+	adminCtx := auth.Context(ctx, auth.ID("admin"), auth.Admin(true))
+	if err := srv.EventImport(adminCtx, []json.RawMessage{stubEvent("1")}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Without a location, the generate searches where the last one did
+	reply, err = srv.DestGenerate(userCtx, eventdb.DestGenerateRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := reply.Result, eventdb.GenerateOK; got != want {
+		t.Fatalf("location-less generate got result %q, want %q", got, want)
+	}
+}
+
+func TestGenerateDestWidensRadius(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	srv := stubService(ctx, t)
+	userCtx := auth.Context(ctx, auth.ID("user"))
+	adminCtx := auth.Context(ctx, auth.ID("admin"), auth.Admin(true))
+
+	if err := srv.EventImport(adminCtx, []json.RawMessage{stubEvent("1")}); err != nil {
+		t.Fatal(err)
+	}
+
+	// About 11km north of the event, just outside the usual radius
+	req := eventdb.DestGenerateRequest{
+		Lat: 45.962815043539 + 0.1,
+		Lng: 15.485937595367,
+	}
+
+	reply, err := srv.DestGenerate(userCtx, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := reply.Result, eventdb.GenerateNoResults; got != want {
+		t.Fatalf("generate without widening got result %q, want %q", got, want)
+	}
+
+	srv.GenerateMaxRadius = 20000
+	srv.GenerateRadiusStep = 4000
+
+	reply, err = srv.DestGenerate(userCtx, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := reply.Result, eventdb.GenerateOK; got != want {
+		t.Fatalf("generate with widening got result %q, want %q", got, want)
+	}
+	if got, want := reply.Dests[0].EventID, eventdb.EventID("1"); got != want {
+		t.Fatalf("generate with widening chose event %q, want %q", got, want)
+	}
+}
+
+func TestGenerateDestCoordinates(t *testing.T) {
+	t.Parallel()
+
+	srv := stubServer(t)
+	defer srv.Close()
+
+	// They run in order. The valid one comes last since it could save the
+	// user's last location, which lets later requests leave the location out.
+	for _, test := range []struct {
+		Name   string
+		Query  string
+		Body   string
+		Status int
+	}{
+		{"missing", "", "", http.StatusBadRequest},
+		{"missing lng", "?lat=45.96", "", http.StatusBadRequest},
+		{"unparseable", "?lat=north&lng=15.48", "", http.StatusBadRequest},
+		{"lat out of range", "?lat=91&lng=15.48", "", http.StatusBadRequest},
+		{"lng out of range", "?lat=45.96&lng=-181", "", http.StatusBadRequest},
+		{"json out of range", "", `{"lat": -100, "lng": 15.48}`, http.StatusBadRequest},
+		{"json unparseable", "", `{"lat": "north", "lng": 15.48}`, http.StatusBadRequest},
+		{"unparseable notice", "?lat=45.96&lng=15.48&notice=soon", "", http.StatusBadRequest},
+		{"unparseable window", "?lat=45.96&lng=15.48&window=1h", "", http.StatusBadRequest},
+		{"valid", "?lat=45.96&lng=15.48", "", http.StatusOK},
+	} {
+		req, err := http.NewRequest("POST", srv.URL+"/dests/generate"+test.Query, strings.NewReader(test.Body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Authorization", "Bearer user")
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+
+		if got, want := resp.StatusCode, test.Status; got != want {
+			t.Errorf("%s: got status %d, want %d", test.Name, got, want)
+		}
+	}
+}
+
+func TestGenerateDestRadius(t *testing.T) {
+	t.Parallel()
+
+	srv := stubServer(t)
+	defer srv.Close()
+
+	client := client.New("user")
+	client.BaseURL = srv.URL
+
+	ctx := context.Background()
+
+	if _, err := client.Events.Submit(ctx, eventdb.EventSubmitRequest{EventIDs: []eventdb.EventID{"1"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, radius := range []float64{50, 60000} {
+		_, err := client.Dests.Generate(ctx, eventdb.DestGenerateRequest{
+			Lat:     45.962815043539,
+			Lng:     15.485937595367,
+			RadiusM: radius,
+		})
+		if !errors.Is(errors.Invalid, err) {
+			t.Fatalf("generate with radius %v got error %v, want Invalid", radius, err)
+		}
+	}
+
+	// About 11km north of the event, outside the default radius
+	lat := 45.962815043539 + 0.1
+	lng := 15.485937595367
+
+	reply, err := client.Dests.Generate(ctx, eventdb.DestGenerateRequest{Lat: lat, Lng: lng, RadiusM: 10000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := reply.Result, eventdb.GenerateNoResults; got != want {
+		t.Fatalf("generate with a 10km radius got result %q, want %q", got, want)
+	}
+
+	reply, err = client.Dests.Generate(ctx, eventdb.DestGenerateRequest{Lat: lat, Lng: lng, RadiusM: 15000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := reply.Result, eventdb.GenerateOK; got != want {
+		t.Fatalf("generate with a 15km radius got result %q, want %q", got, want)
+	}
+}
+
+func TestGenerateDestWindowAndCooldown(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	srv := stubService(ctx, t)
+	// The seed's first pick is the second of two candidates
+	pinService(srv, 1, time.Date(2017, 8, 17, 14, 0, 0, 0, time.UTC))
+
+	adminCtx := auth.Context(ctx, auth.ID("admin"), auth.Admin(true))
+	err := srv.EventImport(adminCtx, []json.RawMessage{
+		stubEventAt("soon",
+			time.Date(2017, 8, 17, 15, 0, 0, 0, time.UTC),
+			time.Date(2017, 8, 17, 17, 0, 0, 0, time.UTC)),
+		stubEventAt("later",
+			time.Date(2017, 8, 17, 19, 0, 0, 0, time.UTC),
+			time.Date(2017, 8, 17, 21, 0, 0, 0, time.UTC)),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	userCtx := auth.Context(ctx, auth.ID("user"))
+	generate := func(req eventdb.DestGenerateRequest) (eventdb.DestGenerateReply, error) {
+		req.Lat, req.Lng = 45.962815043539, 15.485937595367
+		return srv.DestGenerate(userCtx, req)
+	}
+
+	if _, err := generate(eventdb.DestGenerateRequest{WindowMinutes: -1}); !errors.Is(errors.Invalid, err) {
+		t.Fatalf("generate with a negative window got error %v, want Invalid", err)
+	}
+	if _, err := generate(eventdb.DestGenerateRequest{WindowMinutes: 1}); !errors.Is(errors.Invalid, err) {
+		t.Fatalf("generate with a 1 minute window got error %v, want Invalid", err)
+	}
+
+	// A 90 minute window would only have the soon event
+	reply, err := generate(eventdb.DestGenerateRequest{WindowMinutes: 8 * 60})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := reply.Result, eventdb.GenerateOK; got != want {
+		t.Fatalf("generate with a long window got result %q, want %q", got, want)
+	}
+	if got, want := reply.Dests[0].EventID, eventdb.EventID("later"); got != want {
+		t.Fatalf("generate with a long window chose %q, want %q", got, want)
+	}
+
+	reply, err = generate(eventdb.DestGenerateRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := reply.Result, eventdb.GenerateWait; got != want {
+		t.Fatalf("generate before the last event started got result %q, want %q", got, want)
+	}
+
+	reply, err = generate(eventdb.DestGenerateRequest{CooldownDisabled: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := reply.Result, eventdb.GenerateOK; got != want {
+		t.Fatalf("generate without the cooldown got result %q, want %q", got, want)
+	}
+	if got, want := reply.Dests[0].EventID, eventdb.EventID("soon"); got != want {
+		t.Fatalf("generate without the cooldown chose %q, want %q", got, want)
+	}
+}
+
+func TestGenerateDestAfterEventDeleted(t *testing.T) {
+	t.Parallel()
+
+	srv := stubServer(t)
+	defer srv.Close()
+
+	admin := client.New("admin")
+	admin.BaseURL = srv.URL
+	user := client.New("user")
+	user.BaseURL = srv.URL
+
+	ctx := context.Background()
+
+	if err := admin.Events.Import(ctx, []json.RawMessage{stubEvent("1"), stubEvent("2")}); err != nil {
+		t.Fatal(err)
+	}
+
+	req := eventdb.DestGenerateRequest{
+		Lat: 45.962815043539,
+		Lng: 15.485937595367,
+	}
+	reply, err := user.Dests.Generate(ctx, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := reply.Result, eventdb.GenerateOK; got != want {
+		t.Fatalf("first generate got result %q, want %q", got, want)
+	}
+	deleted := reply.Dests[0].EventID
+
+	// Without the delete the user would have to wait for the event to start
+	if err := admin.Events.Delete(ctx, deleted); err != nil {
+		t.Fatal(err)
+	}
+
+	reply, err = user.Dests.Generate(ctx, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := reply.Result, eventdb.GenerateOK; got != want {
+		t.Fatalf("generate after deleting the last event got result %q, want %q", got, want)
+	}
+	if got, want := len(reply.Dests), 2; got != want {
+		t.Fatalf("generate after deleting the last event got %d dests, want %d", got, want)
+	}
+	if len(reply.Events) != 1 || reply.Events[0].ID == deleted {
+		t.Fatalf("generate after deleting %s got events %v, want just the other event", deleted, reply.Events)
 	}
 }
@@ -7,6 +7,7 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"math/rand"
 	"net/http"
 	"net/http/httptest"
 	"strings"
@@ -58,7 +59,7 @@ func stubService(ctx context.Context, t *testing.T) *service.Service {
 		t.Fatal(err)
 	}
 
-	destStore := &pg.DestStore{DB: db}
+	destStore := &pg.DestStore{DB: db, EventStore: eventStore}
 	if err := destStore.Init(ctx); err != nil {
 		t.Fatal(err)
 	}
@@ -73,6 +74,8 @@ func stubService(ctx context.Context, t *testing.T) *service.Service {
 		Time: stubTime(time.Date(2017, 8, 17, 14, 0, 0, 0, time.UTC)),
 
 		Auth: stubAuth{},
+
+		GenerateEnabled: true,
 	}
 
 	return srv
@@ -82,6 +85,9 @@ func stubService(ctx context.Context, t *testing.T) *service.Service {
 // in Slovenia is returned regardless of the event id requested.
 type stubFacebookClient struct {
 	StubError error
+
+	// PageEvents maps page IDs to the event IDs GetPageEvents returns.
+	PageEvents map[string][]string
 }
 
 func (s stubFacebookClient) GetEventInfo(ctx context.Context, ids []string) ([]json.RawMessage, error) {
@@ -92,10 +98,22 @@ func (s stubFacebookClient) GetEventInfo(ctx context.Context, ids []string) ([]j
 	return events, s.StubError
 }
 
+func (s stubFacebookClient) GetPageEvents(ctx context.Context, pageID string) ([]string, error) {
+	return s.PageEvents[pageID], s.StubError
+}
+
 func stubEvent(id string) json.RawMessage {
 	return json.RawMessage(fmt.Sprintf(stubEventTmpl, id))
 }
 
+// stubEventAt is like stubEvent, but the event happens at the given time.
+func stubEventAt(id string, start, end time.Time) json.RawMessage {
+	js := string(stubEvent(id))
+	js = strings.Replace(js, "2017-08-17T17:00:00+0200", start.Format(time.RFC3339), 1)
+	js = strings.Replace(js, "2017-08-17T20:00:00+0200", end.Format(time.RFC3339), 1)
+	return json.RawMessage(js)
+}
+
 const stubEventTmpl = `{
 	"attending_count": 8,
 	"can_guests_invite": true,
@@ -149,6 +167,11 @@ func (f eventGetterFunc) GetEventInfo(ctx context.Context, ids []string) ([]json
 	return f(ctx, ids)
 }
 
+// GetPageEvents returns no events.
+func (f eventGetterFunc) GetPageEvents(ctx context.Context, pageID string) ([]string, error) {
+	return nil, nil
+}
+
 // StubTime mocks out the time with a fixed time.
 type stubTime time.Time
 
@@ -156,6 +179,15 @@ func (s stubTime) Now() time.Time {
 	return time.Time(s)
 }
 
+// pinService makes srv deterministic: its clock is stopped at now and its
+// random numbers come from seed. It returns a generator with the same seed,
+// which the test can use to work out which events DestGenerate will pick.
+func pinService(srv *service.Service, seed int64, now time.Time) *rand.Rand {
+	srv.Time = stubTime(now)
+	srv.Rand = rand.New(rand.NewSource(seed))
+	return rand.New(rand.NewSource(seed))
+}
+
 // StubAuth is a fake auth.Provider that takes the Authorization header and
 // sets it as the current user's id. If the header equals "admin", it also sets
 // the IsAdmin flag.
@@ -2,11 +2,27 @@ package e2e
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/findrandomevents/eventdb"
+	"github.com/findrandomevents/eventdb/auth"
 	"github.com/findrandomevents/eventdb/errors"
+	"github.com/findrandomevents/eventdb/facebook"
+	"github.com/findrandomevents/eventdb/geojson"
+	"github.com/findrandomevents/eventdb/prom"
+	"github.com/findrandomevents/eventdb/rest"
 	"github.com/findrandomevents/eventdb/rest/client"
+	"github.com/findrandomevents/eventdb/service"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
 )
 
 func TestEventSubmitAnonymous(t *testing.T) {
@@ -20,8 +36,1429 @@ func TestEventSubmitAnonymous(t *testing.T) {
 	client := client.New("") // anonymous
 	client.BaseURL = srv.URL
 
-	err := client.Events.Submit(ctx, eventdb.EventSubmitRequest{EventIDs: []eventdb.EventID{"1"}})
+	_, err := client.Events.Submit(ctx, eventdb.EventSubmitRequest{EventIDs: []eventdb.EventID{"1"}})
 	if !errors.Is(errors.Permission, err) {
 		t.Fatalf("anon user Events.Submit got %v, want %v", err, errors.Permission)
 	}
 }
+
+func TestEventSubmitTimeout(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	srv := stubService(ctx, t)
+	srv.SubmitTimeout = 100 * time.Millisecond
+	srv.FacebookClient = func(string) service.FacebookClient {
+		return eventGetterFunc(func(ctx context.Context, ids []string) ([]json.RawMessage, error) {
+			// Hang like a slow Graph API call
+			<-ctx.Done()
+			return nil, ctx.Err()
+		})
+	}
+
+	userCtx := auth.Context(ctx, auth.ID("user"))
+
+	start := time.Now()
+	_, err := srv.EventSubmit(userCtx, eventdb.EventSubmitRequest{
+		EventIDs: []eventdb.EventID{"1"},
+	})
+	if err == nil {
+		t.Fatalf("EventSubmit succeeded, want deadline error")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("EventSubmit took %v, want it to abort at its %v deadline", elapsed, srv.SubmitTimeout)
+	}
+}
+
+func TestEventSearchAnonymous(t *testing.T) {
+	t.Parallel()
+
+	srv := stubServer(t)
+	defer srv.Close()
+
+	ctx := context.Background()
+
+	userClient := client.New("user")
+	userClient.BaseURL = srv.URL
+
+	_, err := userClient.Events.Submit(ctx, eventdb.EventSubmitRequest{
+		EventIDs: []eventdb.EventID{"1"},
+	})
+	if err != nil {
+		t.Fatalf("Events.Submit: %v", err)
+	}
+
+	search := eventdb.EventSearchRequest{
+		Bounds: geojson.CircleGeom(45.962815043539, 15.485937595367, 1000),
+		Start:  time.Date(2017, 8, 17, 0, 0, 0, 0, time.UTC),
+		End:    time.Date(2017, 8, 18, 0, 0, 0, 0, time.UTC),
+	}
+
+	anonClient := client.New("")
+	anonClient.BaseURL = srv.URL
+
+	events, err := anonClient.Events.Search(ctx, search)
+	if err != nil {
+		t.Fatalf("anonymous Events.Search: %v", err)
+	}
+	if got, want := len(events), 1; got != want {
+		t.Fatalf("anonymous Events.Search returned %d events, want %d", got, want)
+	}
+
+	_, err = anonClient.Events.Search(ctx, eventdb.EventSearchRequest{
+		Bounds: geojson.CircleGeom(45.962815043539, 15.485937595367, 100*1000),
+		Start:  search.Start,
+		End:    search.End,
+	})
+	if !errors.Is(errors.Invalid, err) {
+		t.Fatalf("anonymous Events.Search with huge bounds got %v, want %v", err, errors.Invalid)
+	}
+
+	// Logged-in users hitting the same endpoint get the full search, which is
+	// admin-only.
+	_, err = userClient.Events.Search(ctx, search)
+	if !errors.Is(errors.Permission, err) {
+		t.Fatalf("user Events.Search got %v, want %v", err, errors.Permission)
+	}
+
+	adminClient := client.New("admin")
+	adminClient.BaseURL = srv.URL
+
+	events, err = adminClient.Events.Search(ctx, eventdb.EventSearchRequest{
+		Bounds: geojson.CircleGeom(45.962815043539, 15.485937595367, 100*1000),
+		Start:  search.Start,
+		End:    search.End,
+	})
+	if err != nil {
+		t.Fatalf("admin Events.Search: %v", err)
+	}
+	if got, want := len(events), 1; got != want {
+		t.Fatalf("admin Events.Search returned %d events, want %d", got, want)
+	}
+}
+
+func TestEventSubmitFailedDetails(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	svc := stubService(ctx, t)
+	svc.FacebookClient = func(string) service.FacebookClient {
+		return eventGetterFunc(func(ctx context.Context, ids []string) ([]json.RawMessage, error) {
+			return []json.RawMessage{stubEvent("1")}, facebook.BatchError{
+				"2": facebook.Error{Code: 100, Message: "Unsupported get request"},
+				"3": facebook.Error{Code: 100, Message: "Unsupported get request"},
+			}
+		})
+	}
+	srv := httptest.NewServer(rest.New(svc))
+	defer srv.Close()
+
+	body := strings.NewReader(`{"event_ids": ["1", "2", "3"]}`)
+	req, err := http.NewRequest("POST", srv.URL+"/events/", body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer user")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if got, want := resp.StatusCode, http.StatusBadRequest; got != want {
+		t.Fatalf("POST /events/ got status %d, want %d", got, want)
+	}
+
+	var errResp struct {
+		Details struct {
+			Failed map[string]string           `json:"failed"`
+			Events []eventdb.EventSubmitResult `json:"events"`
+		} `json:"details"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&errResp); err != nil {
+		t.Fatal(err)
+	}
+
+	failed := errResp.Details.Failed
+	if got, want := len(failed), 2; got != want {
+		t.Fatalf("details listed %d failed events, want %d: %v", got, want, failed)
+	}
+	for _, id := range []string{"2", "3"} {
+		if _, ok := failed[id]; !ok {
+			t.Errorf("details missing failed event %q: %v", id, failed)
+		}
+	}
+
+	// The saved event's result isn't lost with the error
+	if got, want := errResp.Details.Events, []eventdb.EventSubmitResult{{ID: "1"}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("details listed saved events %v, want %v", got, want)
+	}
+
+	// The event that was fetched should still be saved
+	if _, err := svc.EventGet(ctx, "1"); err != nil {
+		t.Fatalf("EventGet(1) got %v, want event saved", err)
+	}
+}
+
+func TestNextEvent(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	now := time.Date(2017, 8, 17, 14, 0, 0, 0, time.UTC)
+	startsIn := map[string]time.Duration{
+		"1": 40 * time.Minute,
+		"2": 20 * time.Minute,
+		"3": 60 * time.Minute,
+		"4": -30 * time.Minute, // already started
+	}
+
+	svc := stubService(ctx, t)
+	svc.Time = stubTime(now)
+	svc.FacebookClient = func(string) service.FacebookClient {
+		return eventGetterFunc(func(ctx context.Context, ids []string) ([]json.RawMessage, error) {
+			var events []json.RawMessage
+			for _, id := range ids {
+				start := now.Add(startsIn[id])
+				events = append(events, stubEventAt(id, start, start.Add(2*time.Hour)))
+			}
+			return events, nil
+		})
+	}
+	srv := httptest.NewServer(rest.New(svc))
+	defer srv.Close()
+
+	client := client.New("") // anonymous
+	client.BaseURL = srv.URL
+
+	// Nothing has been submitted yet
+	_, err := client.Events.Next(ctx, 45.962815043539, 15.485937595367)
+	if !errors.Is(errors.NotExist, err) {
+		t.Fatalf("Events.Next with no events got %v, want %v", err, errors.NotExist)
+	}
+
+	for _, loc := range [][2]float64{{math.NaN(), 15.48}, {45.96, math.Inf(1)}, {91, 15.48}, {45.96, -181}} {
+		if _, err := svc.NextEvent(ctx, loc[0], loc[1]); !errors.Is(errors.Invalid, err) {
+			t.Fatalf("NextEvent(%v, %v) got %v, want %v", loc[0], loc[1], err, errors.Invalid)
+		}
+	}
+
+	userCtx := auth.Context(ctx, auth.ID("user"))
+	_, err = svc.EventSubmit(userCtx, eventdb.EventSubmitRequest{
+		EventIDs: []eventdb.EventID{"1", "2", "3", "4"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 5; i++ {
+		event, err := client.Events.Next(ctx, 45.962815043539, 15.485937595367)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := event.ID, eventdb.EventID("2"); got != want {
+			t.Fatalf("Events.Next returned event %q, want soonest event %q", got, want)
+		}
+	}
+}
+
+func TestEventSearchNoBounds(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	srv := stubServer(t)
+	defer srv.Close()
+
+	admin := client.New("admin")
+	admin.BaseURL = srv.URL
+
+	_, err := admin.Events.Submit(ctx, eventdb.EventSubmitRequest{
+		EventIDs: []eventdb.EventID{"1", "2", "3"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The stub events are all at 15:00 UTC
+	events, err := admin.Events.Search(ctx, eventdb.EventSearchRequest{
+		Start: time.Date(2017, 8, 17, 0, 0, 0, 0, time.UTC),
+		End:   time.Date(2017, 8, 18, 0, 0, 0, 0, time.UTC),
+		Limit: 2,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(events), 2; got != want {
+		t.Fatalf("Events.Search with limit returned %d events, want %d", got, want)
+	}
+
+	events, err = admin.Events.Search(ctx, eventdb.EventSearchRequest{
+		Start: time.Date(2017, 8, 18, 0, 0, 0, 0, time.UTC),
+		End:   time.Date(2017, 8, 19, 0, 0, 0, 0, time.UTC),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(events), 0; got != want {
+		t.Fatalf("Events.Search the next day returned %d events, want %d", got, want)
+	}
+}
+
+func TestEventSubmitDeleted(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	deleted := false
+
+	srv := stubService(ctx, t)
+	srv.FacebookClient = func(string) service.FacebookClient {
+		return eventGetterFunc(func(ctx context.Context, ids []string) ([]json.RawMessage, error) {
+			if deleted {
+				return nil, facebook.BatchError{
+					"1": facebook.Error{
+						Message: "Unsupported get request. Object with ID '1' does not exist",
+						Type:    "GraphMethodException",
+						Code:    100,
+						Subcode: 33,
+					},
+				}
+			}
+			return []json.RawMessage{stubEvent("1")}, nil
+		})
+	}
+
+	userCtx := auth.Context(ctx, auth.ID("user"))
+	adminCtx := auth.Context(ctx, auth.ID("admin"), auth.Admin(true))
+
+	search := eventdb.EventSearchRequest{
+		Start: time.Date(2017, 8, 17, 0, 0, 0, 0, time.UTC),
+		End:   time.Date(2017, 8, 18, 0, 0, 0, 0, time.UTC),
+	}
+
+	_, err := srv.EventSubmit(userCtx, eventdb.EventSubmitRequest{EventIDs: []eventdb.EventID{"1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	events, err := srv.EventSearch(adminCtx, search)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(events), 1; got != want {
+		t.Fatalf("EventSearch before delete returned %d events, want %d", got, want)
+	}
+
+	// The event is deleted on Facebook, and a refresh gets a 404
+	deleted = true
+	_, err = srv.EventSubmit(userCtx, eventdb.EventSubmitRequest{EventIDs: []eventdb.EventID{"1"}})
+	if !errors.Is(errors.Invalid, err) {
+		t.Fatalf("EventSubmit of deleted event got %v, want %v", err, errors.Invalid)
+	}
+
+	events, err = srv.EventSearch(adminCtx, search)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(events), 0; got != want {
+		t.Fatalf("EventSearch after delete returned %d events, want %d", got, want)
+	}
+
+	event, err := srv.EventGet(ctx, "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !event.IsDeleted {
+		t.Errorf("EventGet after delete got IsDeleted=false, want true")
+	}
+}
+
+func TestEventSubmitTokenExpired(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	srv := stubService(ctx, t)
+	srv.FacebookClient = func(string) service.FacebookClient {
+		return stubFacebookClient{StubError: facebook.Error{
+			Message: "Error validating access token: Session has expired",
+			Type:    "OAuthException",
+			Code:    190,
+		}}
+	}
+
+	before := counterValue(t, prom.FacebookTokenExpired)
+
+	userCtx := auth.Context(ctx, auth.ID("user"))
+	_, err := srv.EventSubmit(userCtx, eventdb.EventSubmitRequest{EventIDs: []eventdb.EventID{"1"}})
+	if err == nil {
+		t.Fatalf("EventSubmit with an expired token succeeded, want error")
+	}
+
+	if got, want := counterValue(t, prom.FacebookTokenExpired)-before, 1.0; got != want {
+		t.Fatalf("token expired counter went up by %v, want %v", got, want)
+	}
+}
+
+func counterValue(t *testing.T, c prometheus.Counter) float64 {
+	t.Helper()
+
+	var m dto.Metric
+	if err := c.Write(&m); err != nil {
+		t.Fatal(err)
+	}
+	return m.GetCounter().GetValue()
+}
+
+func TestEventSubmitBadFilterDisabled(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	srv := stubService(ctx, t)
+	srv.DisableBadFilterOnIngest = true
+	srv.FacebookClient = func(string) service.FacebookClient {
+		return eventGetterFunc(func(ctx context.Context, ids []string) ([]json.RawMessage, error) {
+			js := strings.Replace(string(stubEvent("1")), "VEČER ZA DUŠO", "Sold Out", 1)
+			return []json.RawMessage{json.RawMessage(js)}, nil
+		})
+	}
+
+	userCtx := auth.Context(ctx, auth.ID("user"))
+	_, err := srv.EventSubmit(userCtx, eventdb.EventSubmitRequest{EventIDs: []eventdb.EventID{"1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	event, err := srv.EventGet(ctx, "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !eventdb.IsBadEvent(event) {
+		t.Fatalf("stub event %q isn't bad, the test is broken", event.Name)
+	}
+	if event.IsBad {
+		t.Fatalf("event flagged bad at ingest with the bad filter disabled")
+	}
+}
+
+func TestEventSubmitReportsBad(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	names := map[string]string{
+		"1": "Poetry reading",
+		"2": "Sold Out: Koncert",
+		"3": "Funeral for a friend",
+	}
+
+	srv := stubService(ctx, t)
+	srv.FacebookClient = func(string) service.FacebookClient {
+		return eventGetterFunc(func(ctx context.Context, ids []string) ([]json.RawMessage, error) {
+			var events []json.RawMessage
+			for _, id := range ids {
+				js := strings.Replace(string(stubEvent(id)), "VEČER ZA DUŠO", names[id], 1)
+				events = append(events, json.RawMessage(js))
+			}
+			return events, nil
+		})
+	}
+
+	userCtx := auth.Context(ctx, auth.ID("user"))
+	reply, err := srv.EventSubmit(userCtx, eventdb.EventSubmitRequest{EventIDs: []eventdb.EventID{"1", "2", "3"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := len(reply.Events), 3; got != want {
+		t.Fatalf("reply has %d events, want %d", got, want)
+	}
+	for _, result := range reply.Events {
+		wantBad := result.ID != "1"
+		if result.IsBad != wantBad {
+			t.Fatalf("event %s (%q) IsBad = %v, want %v", result.ID, names[string(result.ID)], result.IsBad, wantBad)
+		}
+		if wantBad && result.BadReason == "" {
+			t.Fatalf("event %s was flagged bad without a reason", result.ID)
+		}
+		if !wantBad && result.BadReason != "" {
+			t.Fatalf("good event %s has bad reason %q", result.ID, result.BadReason)
+		}
+	}
+	if reason := reply.Events[1].BadReason; !strings.Contains(reason, "Sold Out") {
+		t.Fatalf("bad reason %q doesn't name the matching rule", reason)
+	}
+}
+
+func TestRefreshStale(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	srv := stubService(ctx, t)
+
+	userCtx := auth.Context(ctx, auth.ID("user"))
+	adminCtx := auth.Context(ctx, auth.ID("admin"), auth.Admin(true))
+
+	_, err := srv.EventSubmit(userCtx, eventdb.EventSubmitRequest{EventIDs: []eventdb.EventID{"1", "2"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Event 1 was last fetched well before the stub clock's time
+	_, err = srv.EventStore.DB.ExecContext(ctx, `UPDATE events SET fetched_at = '2017-08-01T00:00:00Z' WHERE id = '1'`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var fetched []string
+	srv.FacebookClient = func(string) service.FacebookClient {
+		return eventGetterFunc(func(ctx context.Context, ids []string) ([]json.RawMessage, error) {
+			fetched = append(fetched, ids...)
+			return stubFacebookClient{}.GetEventInfo(ctx, ids)
+		})
+	}
+
+	if _, err := srv.RefreshStale(userCtx, time.Hour, 10); !errors.Is(errors.Permission, err) {
+		t.Fatalf("non-admin RefreshStale got %v, want %v", err, errors.Permission)
+	}
+
+	refreshed, err := srv.RefreshStale(adminCtx, 24*time.Hour, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := refreshed, 1; got != want {
+		t.Fatalf("RefreshStale refreshed %d events, want %d", got, want)
+	}
+	if got, want := strings.Join(fetched, ","), "1"; got != want {
+		t.Fatalf("RefreshStale fetched events %q, want %q", got, want)
+	}
+
+	// Now that it's been refetched it isn't stale anymore
+	refreshed, err = srv.RefreshStale(adminCtx, 24*time.Hour, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := refreshed, 0; got != want {
+		t.Fatalf("second RefreshStale refreshed %d events, want %d", got, want)
+	}
+}
+
+func TestRefreshStaleFailed(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	srv := stubService(ctx, t)
+
+	userCtx := auth.Context(ctx, auth.ID("user"))
+	adminCtx := auth.Context(ctx, auth.ID("admin"), auth.Admin(true))
+
+	_, err := srv.EventSubmit(userCtx, eventdb.EventSubmitRequest{EventIDs: []eventdb.EventID{"1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = srv.EventStore.DB.ExecContext(ctx, `UPDATE events SET fetched_at = '2017-08-01T00:00:00Z' WHERE id = '1'`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var fetched []string
+	srv.FacebookClient = func(string) service.FacebookClient {
+		return eventGetterFunc(func(ctx context.Context, ids []string) ([]json.RawMessage, error) {
+			fetched = append(fetched, ids...)
+			return nil, facebook.BatchError{
+				"1": facebook.Error{Code: 100, Message: "Unsupported get request"},
+			}
+		})
+	}
+
+	refreshed, err := srv.RefreshStale(adminCtx, 24*time.Hour, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := refreshed, 0; got != want {
+		t.Fatalf("RefreshStale refreshed %d events, want %d", got, want)
+	}
+
+	// The failed event goes to the back of the line instead of being
+	// retried every time
+	if _, err := srv.RefreshStale(adminCtx, 24*time.Hour, 10); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := strings.Join(fetched, ","), "1"; got != want {
+		t.Fatalf("RefreshStale fetched events %q, want %q", got, want)
+	}
+}
+
+func TestValidateBounds(t *testing.T) {
+	t.Parallel()
+
+	srv := stubServer(t)
+	defer srv.Close()
+
+	ctx := context.Background()
+
+	anonClient := client.New("")
+	anonClient.BaseURL = srv.URL
+
+	for _, test := range []struct {
+		Name       string
+		Bounds     string
+		WantValid  bool
+		WantReason string
+	}{
+		{
+			Name:      "circle",
+			Bounds:    geojson.CircleGeom(45.962815043539, 15.485937595367, 1000),
+			WantValid: true,
+		},
+		{
+			Name:       "oversized polygon",
+			Bounds:     geojson.CircleGeom(45.962815043539, 15.485937595367, 100*1000),
+			WantReason: "too large",
+		},
+		{
+			Name:       "self-intersecting",
+			Bounds:     `{"type":"Polygon","coordinates":[[[15.4,45.9],[15.5,46.0],[15.5,45.9],[15.4,46.0],[15.4,45.9]]]}`,
+			WantReason: "intersects itself",
+		},
+		{
+			Name:       "not geojson",
+			Bounds:     `{"type":"Point","coordinates":[15.4,45.9]}`,
+			WantReason: "GeoJSON",
+		},
+	} {
+		reply, err := anonClient.Events.ValidateBounds(ctx, test.Bounds)
+		if err != nil {
+			t.Fatalf("%s: Events.ValidateBounds: %v", test.Name, err)
+		}
+		if reply.Valid != test.WantValid {
+			t.Errorf("%s: valid = %v (%q), want %v", test.Name, reply.Valid, reply.Reason, test.WantValid)
+		}
+		if !strings.Contains(reply.Reason, test.WantReason) {
+			t.Errorf("%s: reason = %q, want it to mention %q", test.Name, reply.Reason, test.WantReason)
+		}
+		if test.WantValid && reply.AreaM2 <= 0 {
+			t.Errorf("%s: area = %v, want > 0", test.Name, reply.AreaM2)
+		}
+	}
+}
+
+func TestEventImport(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	srv := stubService(ctx, t)
+
+	// Imports shouldn't touch Facebook at all
+	srv.FacebookClient = func(string) service.FacebookClient {
+		return eventGetterFunc(func(context.Context, []string) ([]json.RawMessage, error) {
+			t.Fatal("EventImport called Facebook")
+			return nil, nil
+		})
+	}
+
+	userCtx := auth.Context(ctx, auth.ID("user"))
+	adminCtx := auth.Context(ctx, auth.ID("admin"), auth.Admin(true))
+
+	events := []json.RawMessage{stubEvent("100"), stubEvent("101")}
+
+	if err := srv.EventImport(userCtx, events); !errors.Is(errors.Permission, err) {
+		t.Fatalf("non-admin EventImport got %v, want %v", err, errors.Permission)
+	}
+
+	for _, bad := range []string{
+		`{"start_time": "2017-08-17T17:00:00+0200"}`,
+		`{"id": "102"}`,
+		`{"id": "102", "start_time": "tomorrow"}`,
+		`not json`,
+	} {
+		err := srv.EventImport(adminCtx, []json.RawMessage{stubEvent("103"), json.RawMessage(bad)})
+		if !errors.Is(errors.Invalid, err) {
+			t.Fatalf("EventImport(%s) got %v, want %v", bad, err, errors.Invalid)
+		}
+		// The error says which event was bad
+		if !strings.Contains(err.Error(), "event 1: ") {
+			t.Fatalf("EventImport(%s) error %q doesn't name event 1", bad, err)
+		}
+	}
+
+	if err := srv.EventImport(adminCtx, events); err != nil {
+		t.Fatal(err)
+	}
+
+	found, err := srv.EventSearch(adminCtx, eventdb.EventSearchRequest{
+		Bounds: geojson.CircleGeom(45.962815043539, 15.485937595367, 1000),
+		Start:  time.Date(2017, 8, 17, 0, 0, 0, 0, time.UTC),
+		End:    time.Date(2017, 8, 18, 0, 0, 0, 0, time.UTC),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var gotIDs []eventdb.EventID
+	for _, event := range found {
+		gotIDs = append(gotIDs, event.ID)
+	}
+	if want := []eventdb.EventID{"100", "101"}; !reflect.DeepEqual(gotIDs, want) {
+		t.Fatalf("search found %v, want %v", gotIDs, want)
+	}
+}
+
+func TestEventSearchAddressless(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	srv := stubService(ctx, t)
+
+	userCtx := auth.Context(ctx, auth.ID("user"))
+	adminCtx := auth.Context(ctx, auth.ID("admin"), auth.Admin(true))
+
+	addressless := strings.Replace(string(stubEvent("2")), `"street": "Cesta Krških Žrtev 53",`, "", 1)
+	err := srv.EventImport(adminCtx, []json.RawMessage{
+		stubEvent("1"),
+		json.RawMessage(addressless),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	search := eventdb.EventSearchRequest{
+		Bounds: geojson.CircleGeom(45.962815043539, 15.485937595367, 1000),
+		Start:  time.Date(2017, 8, 17, 0, 0, 0, 0, time.UTC),
+		End:    time.Date(2017, 8, 18, 0, 0, 0, 0, time.UTC),
+	}
+
+	ids := func(events []eventdb.Event) []eventdb.EventID {
+		var ids []eventdb.EventID
+		for _, event := range events {
+			ids = append(ids, event.ID)
+		}
+		return ids
+	}
+
+	events, err := srv.EventSearch(adminCtx, search)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := ids(events), []eventdb.EventID{"1"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("EventSearch found %v, want %v", got, want)
+	}
+
+	if _, err := srv.EventSearchAddressless(userCtx, search); !errors.Is(errors.Permission, err) {
+		t.Fatalf("non-admin EventSearchAddressless got %v, want %v", err, errors.Permission)
+	}
+
+	events, err = srv.EventSearchAddressless(adminCtx, search)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := ids(events), []eventdb.EventID{"2"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("EventSearchAddressless found %v, want %v", got, want)
+	}
+}
+
+func TestEventReport(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	srv := stubService(ctx, t)
+	srv.ReportThreshold = 3
+
+	adminCtx := auth.Context(ctx, auth.ID("admin"), auth.Admin(true))
+	if err := srv.EventImport(adminCtx, []json.RawMessage{stubEvent("100")}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := srv.EventReport(ctx, "100", "spam"); !errors.Is(errors.NotLoggedIn, err) {
+		t.Fatalf("logged out EventReport got %v, want %v", err, errors.NotLoggedIn)
+	}
+	user1Ctx := auth.Context(ctx, auth.ID("user1"))
+	if err := srv.EventReport(user1Ctx, "100", " "); !errors.Is(errors.Invalid, err) {
+		t.Fatalf("EventReport with no reason got %v, want %v", err, errors.Invalid)
+	}
+	if err := srv.EventReport(user1Ctx, "999", "spam"); !errors.Is(errors.NotExist, err) {
+		t.Fatalf("EventReport of missing event got %v, want %v", err, errors.NotExist)
+	}
+
+	report := func(userID string) {
+		t.Helper()
+		userCtx := auth.Context(ctx, auth.ID(userID))
+		if err := srv.EventReport(userCtx, "100", "not a real event"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	isBad := func() bool {
+		t.Helper()
+		event, err := srv.EventGet(ctx, "100")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return event.IsBad
+	}
+
+	// The same user reporting twice only counts once
+	report("user1")
+	report("user1")
+	report("user2")
+	if isBad() {
+		t.Fatal("event marked bad before the report threshold")
+	}
+
+	report("user3")
+	if !isBad() {
+		t.Fatal("event not marked bad after the report threshold")
+	}
+
+	// Refetching the event shouldn't clear the flag
+	if err := srv.EventImport(adminCtx, []json.RawMessage{stubEvent("100")}); err != nil {
+		t.Fatal(err)
+	}
+	if !isBad() {
+		t.Fatal("reimporting the event cleared its bad flag")
+	}
+
+	if _, err := srv.EventReportList(user1Ctx); !errors.Is(errors.Permission, err) {
+		t.Fatalf("non-admin EventReportList got %v, want %v", err, errors.Permission)
+	}
+	reports, err := srv.EventReportList(adminCtx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(reports), 3; got != want {
+		t.Fatalf("got %d reports, want %d", got, want)
+	}
+	for _, r := range reports {
+		if r.EventID != "100" || r.Reason != "not a real event" {
+			t.Fatalf("unexpected report %+v", r)
+		}
+	}
+}
+
+func TestEventsAtVenue(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	srv := stubService(ctx, t)
+
+	moved := func(id, placeID, lat string) json.RawMessage {
+		js := string(stubEvent(id))
+		js = strings.Replace(js, `"id": "1199667026764073"`, `"id": "`+placeID+`"`, 1)
+		js = strings.Replace(js, "45.962815043539", lat, 1)
+		return json.RawMessage(js)
+	}
+
+	adminCtx := auth.Context(ctx, auth.ID("admin"), auth.Admin(true))
+	err := srv.EventImport(adminCtx, []json.RawMessage{
+		stubEvent("100"),
+		stubEvent("101"),                     // Same place
+		moved("102", "2", "45.962915043539"), // Next door, about 11m away
+		moved("103", "3", "46.062815043539"), // Another town
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := srv.EventsAtVenue(ctx, "999"); !errors.Is(errors.NotExist, err) {
+		t.Fatalf("EventsAtVenue of missing event got %v, want %v", err, errors.NotExist)
+	}
+
+	events, err := srv.EventsAtVenue(ctx, "100")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var gotIDs []eventdb.EventID
+	for _, event := range events {
+		gotIDs = append(gotIDs, event.ID)
+	}
+	if want := []eventdb.EventID{"101", "102"}; !reflect.DeepEqual(gotIDs, want) {
+		t.Fatalf("got events %v, want %v", gotIDs, want)
+	}
+}
+
+func TestBadFilterRules(t *testing.T) {
+	t.Parallel()
+
+	srv := stubServer(t)
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	userClient := client.New("user")
+	userClient.BaseURL = srv.URL
+	if _, err := userClient.Events.BadFilterRules(ctx); !errors.Is(errors.Permission, err) {
+		t.Fatalf("non-admin BadFilterRules got %v, want %v", err, errors.Permission)
+	}
+
+	adminClient := client.New("admin")
+	adminClient.BaseURL = srv.URL
+	rules, err := adminClient.Events.BadFilterRules(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := rules, eventdb.DefaultBadEventFilter.Rules(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("got rules %+v, want the default rules %+v", got, want)
+	}
+	// Categories are opt-in
+	for _, rule := range rules {
+		if rule.Field == "category" {
+			t.Fatalf("default rules flag category %q", rule.Pattern)
+		}
+	}
+	for _, want := range []eventdb.BadRule{
+		{Field: "name", Language: "en", Pattern: `(?i)\bSold Out\b`},
+	} {
+		var found bool
+		for _, rule := range rules {
+			found = found || rule == want
+		}
+		if !found {
+			t.Fatalf("rules are missing %+v", want)
+		}
+	}
+}
+
+func TestBadFilterRulesCustom(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	srv := stubService(ctx, t)
+	filter := eventdb.DefaultBadEventFilter
+	filter.Categories = []string{"CONCERT"}
+	srv.BadFilter = &filter
+
+	adminCtx := auth.Context(ctx, auth.ID("admin"), auth.Admin(true))
+	rules, err := srv.BadFilterRules(adminCtx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := rules, filter.Rules(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("got rules %+v, want the configured rules %+v", got, want)
+	}
+}
+
+func TestEventSubmitMaxIDs(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	srv := stubService(ctx, t)
+	srv.MaxSubmitIDs = 60
+	srv.FacebookClient = func(string) service.FacebookClient {
+		return eventGetterFunc(func(ctx context.Context, ids []string) ([]json.RawMessage, error) {
+			if len(ids) > 50 {
+				t.Errorf("fetched %d events from Facebook at once, want at most 50", len(ids))
+			}
+			return stubFacebookClient{}.GetEventInfo(ctx, ids)
+		})
+	}
+
+	ids := func(n int) []eventdb.EventID {
+		var ids []eventdb.EventID
+		for i := 0; i < n; i++ {
+			ids = append(ids, eventdb.EventID(fmt.Sprint(1000+i)))
+		}
+		return ids
+	}
+
+	userCtx := auth.Context(ctx, auth.ID("user"))
+	for _, n := range []int{59, 60} {
+		reply, err := srv.EventSubmit(userCtx, eventdb.EventSubmitRequest{EventIDs: ids(n)})
+		if err != nil {
+			t.Fatalf("submit %d events: %v", n, err)
+		}
+		if got := len(reply.Events); got != n {
+			t.Fatalf("submit %d events: saved %d", n, got)
+		}
+	}
+
+	_, err := srv.EventSubmit(userCtx, eventdb.EventSubmitRequest{EventIDs: ids(61)})
+	if !errors.Is(errors.Invalid, err) {
+		t.Fatalf("submit 61 events got %v, want %v", err, errors.Invalid)
+	}
+	if !strings.Contains(err.Error(), "max (60)") {
+		t.Fatalf("error %q doesn't give the limit", err)
+	}
+}
+
+func TestEventSearchStartingSoon(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	srv := stubService(ctx, t)
+	adminCtx := auth.Context(ctx, auth.ID("admin"), auth.Admin(true))
+
+	// The stub clock is 2017-08-17 14:00 UTC
+	at := func(hour int) time.Time {
+		return time.Date(2017, 8, 17, hour, 0, 0, 0, time.UTC)
+	}
+	err := srv.EventImport(adminCtx, []json.RawMessage{
+		stubEventAt("ongoing", at(13), at(16)),
+		stubEventAt("later", at(16), at(18)),
+		stubEventAt("sooner", at(15), at(17)),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		OrderBy eventdb.EventOrder
+		Want    []eventdb.EventID
+	}{
+		{eventdb.OrderByStartTime, []eventdb.EventID{"ongoing", "sooner", "later"}},
+		{eventdb.OrderByStartingSoon, []eventdb.EventID{"sooner", "later"}},
+	} {
+		events, err := srv.EventSearch(adminCtx, eventdb.EventSearchRequest{
+			Bounds:  geojson.CircleGeom(45.962815043539, 15.485937595367, 1000),
+			Start:   at(12),
+			End:     at(20),
+			OrderBy: test.OrderBy,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var ids []eventdb.EventID
+		for _, event := range events {
+			ids = append(ids, event.ID)
+		}
+		if !reflect.DeepEqual(ids, test.Want) {
+			t.Errorf("search ordered by %q got %v, want %v", test.OrderBy, ids, test.Want)
+		}
+	}
+}
+
+func TestEventExtent(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	srv := stubService(ctx, t)
+	adminCtx := auth.Context(ctx, auth.ID("admin"), auth.Admin(true))
+
+	// One event in each corner of the box
+	at := func(id string, lat, lng float64) json.RawMessage {
+		js := string(stubEvent(id))
+		js = strings.Replace(js, "45.962815043539", fmt.Sprint(lat), 1)
+		js = strings.Replace(js, "15.485937595367", fmt.Sprint(lng), 1)
+		return json.RawMessage(js)
+	}
+	err := srv.EventImport(adminCtx, []json.RawMessage{
+		at("southwest", 10, 20),
+		at("northeast", 30, 40),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	search := eventdb.EventSearchRequest{
+		Start: time.Date(2017, 8, 17, 0, 0, 0, 0, time.UTC),
+		End:   time.Date(2017, 8, 18, 0, 0, 0, 0, time.UTC),
+	}
+	extent, err := srv.EventExtent(adminCtx, search)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := eventdb.EventExtent{MinLat: 10, MinLng: 20, MaxLat: 30, MaxLng: 40}
+	if extent != want {
+		t.Fatalf("extent = %+v, want %+v", extent, want)
+	}
+
+	// No events the next day
+	search.Start, search.End = search.Start.AddDate(0, 0, 1), search.End.AddDate(0, 0, 1)
+	if _, err := srv.EventExtent(adminCtx, search); !errors.Is(errors.NotExist, err) {
+		t.Fatalf("extent with no matching events got error %v, want NotExist", err)
+	}
+
+	userCtx := auth.Context(ctx, auth.ID("user"))
+	if _, err := srv.EventExtent(userCtx, search); !errors.Is(errors.Permission, err) {
+		t.Fatalf("extent as a non-admin got error %v, want Permission", err)
+	}
+}
+
+func TestEventSubmitFacebookBreaker(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	srv := stubService(ctx, t)
+	srv.FacebookFailureThreshold = 1
+
+	// Facebook is down
+	calls := 0
+	srv.FacebookClient = func(string) service.FacebookClient {
+		return eventGetterFunc(func(ctx context.Context, ids []string) ([]json.RawMessage, error) {
+			calls++
+			return nil, facebook.Error{Code: 2, Message: "Service temporarily unavailable"}
+		})
+	}
+
+	userCtx := auth.Context(ctx, auth.ID("user"))
+	for i := 0; i < 3; i++ {
+		_, err := srv.EventSubmit(userCtx, eventdb.EventSubmitRequest{
+			EventIDs: []eventdb.EventID{"1"},
+		})
+		if !errors.Is(errors.Unavailable, err) {
+			t.Fatalf("submit %d got error %v, want Unavailable", i, err)
+		}
+	}
+
+	// The first failure tripped the breaker, so Facebook was only called once
+	if got, want := calls, 1; got != want {
+		t.Fatalf("called Facebook %d times, want %d", got, want)
+	}
+
+	// An admin pretending it's later doesn't get around the cooldown
+	adminCtx := auth.Context(ctx, auth.ID("admin"), auth.Admin(true))
+	adminCtx = service.WithNow(adminCtx, time.Date(2017, 8, 18, 14, 0, 0, 0, time.UTC))
+	_, err := srv.EventSubmit(adminCtx, eventdb.EventSubmitRequest{
+		EventIDs: []eventdb.EventID{"1"},
+	})
+	if !errors.Is(errors.Unavailable, err) {
+		t.Fatalf("submit with a later X-Now got error %v, want Unavailable", err)
+	}
+	if got, want := calls, 1; got != want {
+		t.Fatalf("called Facebook %d times with a later X-Now, want %d", got, want)
+	}
+}
+
+func TestEventSubmitFacebookBreakerCanceled(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	srv := stubService(ctx, t)
+	srv.FacebookFailureThreshold = 1
+
+	// The client gives up while Facebook is working on it
+	userCtx := auth.Context(ctx, auth.ID("user"))
+	submitCtx, cancelSubmit := context.WithCancel(userCtx)
+	srv.FacebookClient = func(string) service.FacebookClient {
+		return eventGetterFunc(func(ctx context.Context, ids []string) ([]json.RawMessage, error) {
+			cancelSubmit()
+			return nil, ctx.Err()
+		})
+	}
+	if _, err := srv.EventSubmit(submitCtx, eventdb.EventSubmitRequest{
+		EventIDs: []eventdb.EventID{"1"},
+	}); err == nil {
+		t.Fatal("canceled submit succeeded")
+	}
+
+	// That wasn't Facebook's fault, so the next submit still calls it
+	calls := 0
+	srv.FacebookClient = func(string) service.FacebookClient {
+		return eventGetterFunc(func(ctx context.Context, ids []string) ([]json.RawMessage, error) {
+			calls++
+			return nil, facebook.Error{Code: 2, Message: "Service temporarily unavailable"}
+		})
+	}
+	srv.EventSubmit(userCtx, eventdb.EventSubmitRequest{
+		EventIDs: []eventdb.EventID{"1"},
+	})
+	if calls == 0 {
+		t.Fatal("a canceled submit stopped calls to Facebook")
+	}
+}
+
+func TestEventSearchExplain(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	srv := stubServer(t)
+	defer srv.Close()
+
+	search := eventdb.EventSearchRequest{
+		Bounds: geojson.CircleGeom(45.962815043539, 15.485937595367, 1000),
+		Start:  time.Date(2017, 8, 17, 0, 0, 0, 0, time.UTC),
+		End:    time.Date(2017, 8, 18, 0, 0, 0, 0, time.UTC),
+	}
+
+	admin := client.New("admin")
+	admin.BaseURL = srv.URL
+
+	plan, err := admin.Events.Explain(ctx, search)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var plans []struct {
+		Plan map[string]interface{} `json:"Plan"`
+	}
+	if err := json.Unmarshal(plan, &plans); err != nil {
+		t.Fatalf("explain returned %s, not a JSON plan: %v", plan, err)
+	}
+	if len(plans) == 0 || plans[0].Plan["Node Type"] == nil {
+		t.Fatalf("explain returned %s, want a query plan", plan)
+	}
+
+	user := client.New("user")
+	user.BaseURL = srv.URL
+
+	if _, err := user.Events.Explain(ctx, search); !errors.Is(errors.Permission, err) {
+		t.Fatalf("explain as a non-admin got error %v, want Permission", err)
+	}
+}
+
+func TestEventSearchByDay(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	srv := stubServer(t)
+	defer srv.Close()
+
+	admin := client.New("admin")
+	admin.BaseURL = srv.URL
+
+	at := func(id string, start time.Time) json.RawMessage {
+		return stubEventAt(id, start, start.Add(time.Hour))
+	}
+	err := admin.Events.Import(ctx, []json.RawMessage{
+		// The same UTC day, but either side of midnight in New York
+		at("evening", time.Date(2017, 8, 18, 2, 0, 0, 0, time.UTC)),
+		at("late-night", time.Date(2017, 8, 18, 5, 0, 0, 0, time.UTC)),
+
+		// Daylight saving time ends in New York on November 5th, so both of
+		// these are on the 5th there even though they're 24 hours apart
+		at("before-dst-end", time.Date(2017, 11, 5, 4, 30, 0, 0, time.UTC)),
+		at("after-dst-end", time.Date(2017, 11, 6, 4, 30, 0, 0, time.UTC)),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	search := eventdb.EventSearchRequest{
+		Start: time.Date(2017, 8, 1, 0, 0, 0, 0, time.UTC),
+		End:   time.Date(2017, 12, 1, 0, 0, 0, 0, time.UTC),
+	}
+
+	for _, test := range []struct {
+		TZ   string
+		Want map[string][]eventdb.EventID
+	}{
+		{
+			TZ: "America/New_York",
+			Want: map[string][]eventdb.EventID{
+				"2017-08-17": {"evening"},
+				"2017-08-18": {"late-night"},
+				"2017-11-05": {"before-dst-end", "after-dst-end"},
+			},
+		},
+		{
+			TZ: "",
+			Want: map[string][]eventdb.EventID{
+				"2017-08-18": {"evening", "late-night"},
+				"2017-11-05": {"before-dst-end"},
+				"2017-11-06": {"after-dst-end"},
+			},
+		},
+	} {
+		days, err := admin.Events.SearchByDay(ctx, search, test.TZ)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		got := map[string][]eventdb.EventID{}
+		for i, day := range days {
+			if i > 0 && days[i-1].Date >= day.Date {
+				t.Fatalf("tz %q: days out of order: %s before %s", test.TZ, days[i-1].Date, day.Date)
+			}
+			for _, event := range day.Events {
+				got[day.Date] = append(got[day.Date], event.ID)
+			}
+		}
+		if !reflect.DeepEqual(got, test.Want) {
+			t.Fatalf("tz %q: got days %v, want %v", test.TZ, got, test.Want)
+		}
+	}
+
+	if _, err := admin.Events.SearchByDay(ctx, search, "Mars/Olympus_Mons"); !errors.Is(errors.Invalid, err) {
+		t.Fatalf("search by day with a bad time zone got error %v, want Invalid", err)
+	}
+}
+
+func TestEventDelete(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	svc := stubService(ctx, t)
+	srv := httptest.NewServer(rest.New(svc))
+	defer srv.Close()
+
+	admin := client.New("admin")
+	admin.BaseURL = srv.URL
+	user := client.New("user")
+	user.BaseURL = srv.URL
+
+	if err := admin.Events.Import(ctx, []json.RawMessage{stubEvent("spam")}); err != nil {
+		t.Fatal(err)
+	}
+	created, err := svc.DestStore.Create(ctx, eventdb.Dest{
+		UserID:  "user",
+		EventID: "spam",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := user.Events.Delete(ctx, "spam"); !errors.Is(errors.Permission, err) {
+		t.Fatalf("non-admin Delete got %v, want %v", err, errors.Permission)
+	}
+	if err := admin.Events.Delete(ctx, "spam"); err != nil {
+		t.Fatal(err)
+	}
+	if err := admin.Events.Delete(ctx, "spam"); !errors.Is(errors.NotExist, err) {
+		t.Fatalf("deleting twice got %v, want %v", err, errors.NotExist)
+	}
+
+	if exists, err := svc.EventStore.Exists(ctx, "spam"); err != nil || exists {
+		t.Fatalf("Exists after Delete = %v, %v, want false", exists, err)
+	}
+
+	// Clients syncing the database are told it's gone
+	sync, err := user.Events.Sync(ctx, time.Time{}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sync.Events) != 0 || !reflect.DeepEqual(sync.Tombstones, []eventdb.EventID{"spam"}) {
+		t.Fatalf("Sync after Delete got events %v, tombstones %v, want just a tombstone for spam", sync.Events, sync.Tombstones)
+	}
+
+	// The user's dest is still there, without its event
+	dest, err := user.Dests.Get(ctx, created.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dest.Event != nil || !dest.EventUnavailable {
+		t.Fatalf("dest for deleted event got event %v, eventUnavailable %v, want no event and unavailable", dest.Event, dest.EventUnavailable)
+	}
+
+	userCtx := auth.Context(ctx, auth.ID("user"))
+	dests, err := svc.DestList(userCtx, eventdb.DestListRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dests) != 1 || dests[0].ID != created.ID || dests[0].Event != nil || !dests[0].EventUnavailable {
+		t.Fatalf("DestList after Delete got %+v, want the dest with its event unavailable", dests)
+	}
+}
+
+func TestBlocklist(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	srv := stubServer(t)
+	defer srv.Close()
+
+	admin := client.New("admin")
+	admin.BaseURL = srv.URL
+	user := client.New("user")
+	user.BaseURL = srv.URL
+
+	if _, err := user.Events.Block(ctx, eventdb.Block{Kind: eventdb.BlockOwner, ID: "spammer"}); !errors.Is(errors.Permission, err) {
+		t.Fatalf("non-admin Block got %v, want %v", err, errors.Permission)
+	}
+	if _, err := admin.Events.Block(ctx, eventdb.Block{Kind: "city", ID: "spammer"}); !errors.Is(errors.Invalid, err) {
+		t.Fatalf("Block with an unknown kind got %v, want %v", err, errors.Invalid)
+	}
+
+	// The ID is trimmed so it matches the owner's events
+	block, err := admin.Events.Block(ctx, eventdb.Block{Kind: eventdb.BlockOwner, ID: " spammer ", Reason: "fake events"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if block.CreatedAt.IsZero() {
+		t.Fatal("Block didn't set createdAt")
+	}
+	blocks, err := admin.Events.Blocklist(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(blocks) != 1 || blocks[0].Kind != eventdb.BlockOwner || blocks[0].ID != "spammer" || blocks[0].Reason != "fake events" {
+		t.Fatalf("Blocklist got %+v, want the spammer", blocks)
+	}
+
+	// Events from the owner are ingested after the block, and there's
+	// nothing else nearby
+	spam := strings.Replace(string(stubEvent("spam")), `"id": "356511867809316"`, `"id": "spammer"`, 1)
+	if err := admin.Events.Import(ctx, []json.RawMessage{json.RawMessage(spam)}); err != nil {
+		t.Fatal(err)
+	}
+
+	search := eventdb.EventSearchRequest{
+		Bounds: geojson.CircleGeom(45.962815043539, 15.485937595367, 1000),
+		Start:  time.Date(2017, 8, 17, 0, 0, 0, 0, time.UTC),
+		End:    time.Date(2017, 8, 18, 0, 0, 0, 0, time.UTC),
+	}
+	events, err := admin.Events.Search(ctx, search)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 0 {
+		t.Fatalf("search returned %d events from a blocked owner", len(events))
+	}
+
+	reply, err := user.Dests.Generate(ctx, eventdb.DestGenerateRequest{
+		Lat: 45.962815043539,
+		Lng: 15.485937595367,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := reply.Result, eventdb.GenerateNoResults; got != want {
+		t.Fatalf("generate next to a blocked owner's event got result %q, want %q", got, want)
+	}
+
+	// Unblocking brings the events back
+	if err := admin.Events.Unblock(ctx, eventdb.BlockOwner, "spammer"); err != nil {
+		t.Fatal(err)
+	}
+	if err := admin.Events.Unblock(ctx, eventdb.BlockOwner, "spammer"); !errors.Is(errors.NotExist, err) {
+		t.Fatalf("unblocking twice got %v, want %v", err, errors.NotExist)
+	}
+	events, err = admin.Events.Search(ctx, search)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 || events[0].ID != "spam" {
+		t.Fatalf("search after Unblock got %v, want the spam event", events)
+	}
+}
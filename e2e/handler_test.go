@@ -0,0 +1,504 @@
+package e2e
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+
+	"github.com/findrandomevents/eventdb"
+	"github.com/findrandomevents/eventdb/errors"
+	"github.com/findrandomevents/eventdb/log"
+	"github.com/findrandomevents/eventdb/rest"
+	"github.com/findrandomevents/eventdb/rest/client"
+)
+
+func TestHandlerContentType(t *testing.T) {
+	t.Parallel()
+
+	srv := stubServer(t)
+	defer srv.Close()
+
+	httpClient := &http.Client{
+		// Look at the redirect itself, not where it goes
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	for _, test := range []struct {
+		Path       string
+		Accept     string
+		WantStatus int
+		WantJSON   bool
+	}{
+		{Path: "/healthz", Accept: "", WantStatus: http.StatusOK, WantJSON: false},
+		{Path: "/healthz", Accept: "application/json", WantStatus: http.StatusOK, WantJSON: true},
+		{Path: "/", Accept: "text/html", WantStatus: http.StatusTemporaryRedirect, WantJSON: false},
+		{Path: "/", Accept: "application/json, text/plain;q=0.9", WantStatus: http.StatusTemporaryRedirect, WantJSON: true},
+		{Path: "/nope", Accept: "application/json", WantStatus: http.StatusNotFound, WantJSON: true},
+	} {
+		req, err := http.NewRequest("GET", srv.URL+test.Path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if test.Accept != "" {
+			req.Header.Set("Accept", test.Accept)
+		}
+
+		resp, err := httpClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+
+		if got, want := resp.StatusCode, test.WantStatus; got != want {
+			t.Fatalf("GET %s (Accept: %q) status = %d, want %d", test.Path, test.Accept, got, want)
+		}
+		isJSON := strings.HasPrefix(resp.Header.Get("Content-Type"), "application/json")
+		if isJSON != test.WantJSON {
+			t.Fatalf("GET %s (Accept: %q) Content-Type = %q, want JSON: %v", test.Path, test.Accept, resp.Header.Get("Content-Type"), test.WantJSON)
+		}
+		if test.WantStatus == http.StatusTemporaryRedirect {
+			if got, want := resp.Header.Get("Location"), "https://findrandomevents.com"; got != want {
+				t.Fatalf("GET %s redirected to %q, want %q", test.Path, got, want)
+			}
+		}
+	}
+}
+
+func TestHandlerCORS(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	handler := rest.New(stubService(ctx, t))
+
+	for _, test := range []struct {
+		Name   string
+		CORS   rest.CORS
+		Method string
+		Origin string
+
+		WantStatus      int
+		WantOrigin      string
+		WantCredentials string
+	}{
+		{
+			Name:       "allowed origin",
+			CORS:       rest.CORS{AllowedOrigins: []string{"https://findrandomevents.com"}},
+			Method:     "GET",
+			Origin:     "https://findrandomevents.com",
+			WantStatus: http.StatusOK,
+			WantOrigin: "https://findrandomevents.com",
+		},
+		{
+			Name:       "disallowed origin",
+			CORS:       rest.CORS{AllowedOrigins: []string{"https://findrandomevents.com"}},
+			Method:     "GET",
+			Origin:     "https://evil.example.com",
+			WantStatus: http.StatusOK,
+		},
+		{
+			Name:       "disallowed preflight",
+			CORS:       rest.CORS{AllowedOrigins: []string{"https://findrandomevents.com"}},
+			Method:     "OPTIONS",
+			Origin:     "https://evil.example.com",
+			WantStatus: http.StatusForbidden,
+		},
+		{
+			Name:       "wildcard",
+			CORS:       rest.CORS{AllowedOrigins: []string{"*"}},
+			Method:     "GET",
+			Origin:     "https://example.com",
+			WantStatus: http.StatusOK,
+			WantOrigin: "*",
+		},
+		{
+			Name:            "credentialed origin",
+			CORS:            rest.CORS{AllowedOrigins: []string{"https://findrandomevents.com"}, AllowCredentials: true},
+			Method:          "GET",
+			Origin:          "https://findrandomevents.com",
+			WantStatus:      http.StatusOK,
+			WantOrigin:      "https://findrandomevents.com",
+			WantCredentials: "true",
+		},
+		{
+			Name:            "credentialed preflight",
+			CORS:            rest.CORS{AllowedOrigins: []string{"https://findrandomevents.com"}, AllowCredentials: true},
+			Method:          "OPTIONS",
+			Origin:          "https://findrandomevents.com",
+			WantStatus:      http.StatusNoContent,
+			WantOrigin:      "https://findrandomevents.com",
+			WantCredentials: "true",
+		},
+		{
+			Name:       "credentialed wildcard",
+			CORS:       rest.CORS{AllowedOrigins: []string{"*"}, AllowCredentials: true},
+			Method:     "GET",
+			Origin:     "https://evil.example.com",
+			WantStatus: http.StatusOK,
+		},
+	} {
+		srv := httptest.NewServer(test.CORS.Wrap(handler))
+
+		req, err := http.NewRequest(test.Method, srv.URL+"/healthz", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Origin", test.Origin)
+		if test.Method == "OPTIONS" {
+			req.Header.Set("Access-Control-Request-Method", "POST")
+			req.Header.Set("Access-Control-Request-Headers", "Authorization")
+		}
+
+		resp, err := http.DefaultClient.Do(req)
+		srv.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+
+		if got, want := resp.StatusCode, test.WantStatus; got != want {
+			t.Errorf("%s: status = %d, want %d", test.Name, got, want)
+		}
+		if got, want := resp.Header.Get("Access-Control-Allow-Origin"), test.WantOrigin; got != want {
+			t.Errorf("%s: Access-Control-Allow-Origin = %q, want %q", test.Name, got, want)
+		}
+		if got, want := resp.Header.Get("Access-Control-Allow-Credentials"), test.WantCredentials; got != want {
+			t.Errorf("%s: Access-Control-Allow-Credentials = %q, want %q", test.Name, got, want)
+		}
+	}
+}
+
+func TestHandlerCORSReload(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	origins := rest.NewOriginList([]string{"https://old.example.com"})
+	handler := rest.CORS{Origins: origins}.Wrap(rest.New(stubService(ctx, t)))
+
+	srv := httptest.NewServer(handler)
+	defer srv.Close()
+
+	allowedOrigin := func(origin string) string {
+		req, err := http.NewRequest("GET", srv.URL+"/healthz", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Origin", origin)
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.Header.Get("Access-Control-Allow-Origin")
+	}
+
+	if got, want := allowedOrigin("https://old.example.com"), "https://old.example.com"; got != want {
+		t.Fatalf("before reload: Access-Control-Allow-Origin = %q, want %q", got, want)
+	}
+
+	origins.Set([]string{"https://new.example.com"})
+
+	if got, want := allowedOrigin("https://new.example.com"), "https://new.example.com"; got != want {
+		t.Errorf("newly allowed origin: Access-Control-Allow-Origin = %q, want %q", got, want)
+	}
+	if got := allowedOrigin("https://old.example.com"); got != "" {
+		t.Errorf("removed origin: Access-Control-Allow-Origin = %q, want none", got)
+	}
+}
+
+func TestHandlerIndent(t *testing.T) {
+	t.Parallel()
+
+	srv := stubServer(t)
+	defer srv.Close()
+
+	ctx := context.Background()
+
+	userClient := client.New("user")
+	userClient.BaseURL = srv.URL
+
+	_, err := userClient.Events.Submit(ctx, eventdb.EventSubmitRequest{
+		EventIDs: []eventdb.EventID{"1"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		Name       string
+		Path       string
+		Query      string
+		Accept     string
+		WantStatus int
+		WantIndent bool
+	}{
+		{Name: "browser", Accept: "text/html,application/xhtml+xml,*/*;q=0.8", WantIndent: true},
+		{Name: "api client", Accept: "application/json", WantIndent: false},
+		{Name: "no accept", WantIndent: false},
+		{Name: "browser asking for compact", Query: "?compact=1", Accept: "text/html", WantIndent: false},
+		{Name: "api client asking for pretty", Query: "?pretty=1", Accept: "application/json", WantIndent: true},
+		{Name: "error for api client", Path: "/missing", Accept: "application/json", WantStatus: http.StatusNotFound, WantIndent: false},
+		{Name: "error asking for pretty", Path: "/missing", Query: "?pretty=1", Accept: "application/json", WantStatus: http.StatusNotFound, WantIndent: true},
+	} {
+		if test.Path == "" {
+			test.Path = "/events/1"
+		}
+		if test.WantStatus == 0 {
+			test.WantStatus = http.StatusOK
+		}
+
+		req, err := http.NewRequest("GET", srv.URL+test.Path+test.Query, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Authorization", "Bearer user")
+		if test.Accept != "" {
+			req.Header.Set("Accept", test.Accept)
+		}
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got, want := resp.StatusCode, test.WantStatus; got != want {
+			t.Fatalf("%s: status = %d, want %d", test.Name, got, want)
+		}
+		if got := strings.Contains(string(body), "\n\t"); got != test.WantIndent {
+			t.Errorf("%s: indented = %v, want %v:\n%s", test.Name, got, test.WantIndent, body)
+		}
+	}
+}
+
+func TestHandlerCompress(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	service := stubService(ctx, t)
+	srv := httptest.NewServer(rest.Compress{MinSize: 100}.Wrap(rest.New(service)))
+	defer srv.Close()
+
+	userClient := client.New("user")
+	userClient.BaseURL = srv.URL
+
+	_, err := userClient.Events.Submit(ctx, eventdb.EventSubmitRequest{
+		EventIDs: []eventdb.EventID{"1"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		Name           string
+		Path           string
+		AcceptEncoding string
+		WantGzip       bool
+	}{
+		{Name: "gzip", Path: "/events/1", AcceptEncoding: "gzip, deflate", WantGzip: true},
+		{Name: "no gzip", Path: "/events/1", AcceptEncoding: "", WantGzip: false},
+		{Name: "gzip refused", Path: "/events/1", AcceptEncoding: "gzip;q=0", WantGzip: false},
+		{Name: "small response", Path: "/healthz", AcceptEncoding: "gzip", WantGzip: false},
+	} {
+		req, err := http.NewRequest("GET", srv.URL+test.Path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Authorization", "Bearer user")
+		if test.AcceptEncoding != "" {
+			// Setting it by hand stops the transport from decompressing
+			req.Header.Set("Accept-Encoding", test.AcceptEncoding)
+		}
+
+		resp, err := http.DefaultTransport.RoundTrip(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got, want := resp.StatusCode, http.StatusOK; got != want {
+			t.Fatalf("%s: status = %d, want %d", test.Name, got, want)
+		}
+		isGzip := resp.Header.Get("Content-Encoding") == "gzip"
+		if isGzip != test.WantGzip {
+			t.Fatalf("%s: Content-Encoding = %q, want gzip: %v", test.Name, resp.Header.Get("Content-Encoding"), test.WantGzip)
+		}
+		if !isGzip || test.Path != "/events/1" {
+			continue
+		}
+
+		gz, err := gzip.NewReader(bytes.NewReader(body))
+		if err != nil {
+			t.Fatalf("%s: %v", test.Name, err)
+		}
+		var event eventdb.Event
+		if err := json.NewDecoder(gz).Decode(&event); err != nil {
+			t.Fatalf("%s: decode gzipped event: %v", test.Name, err)
+		}
+		if got, want := event.ID, eventdb.EventID("1"); got != want {
+			t.Fatalf("%s: got event %q, want %q", test.Name, got, want)
+		}
+	}
+}
+
+func TestHandlerNowOverride(t *testing.T) {
+	t.Parallel()
+
+	srv := stubServer(t)
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	userClient := client.New("user")
+	userClient.BaseURL = srv.URL
+
+	_, err := userClient.Events.Submit(ctx, eventdb.EventSubmitRequest{
+		EventIDs: []eventdb.EventID{"1"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The stub clock is 2017-08-17 14:00 UTC and the event starts an hour
+	// later, so it's the next event unless X-Now moves past it.
+	for _, test := range []struct {
+		Name       string
+		User       string
+		Now        string
+		WantStatus int
+	}{
+		{Name: "no override", User: "admin", WantStatus: http.StatusOK},
+		{Name: "before event", User: "admin", Now: "2017-08-17T12:00:00Z", WantStatus: http.StatusOK},
+		{Name: "after event", User: "admin", Now: "2017-08-20T12:00:00Z", WantStatus: http.StatusNotFound},
+		{Name: "bad time", User: "admin", Now: "friday", WantStatus: http.StatusBadRequest},
+		{Name: "not admin", User: "user", Now: "2017-08-20T12:00:00Z", WantStatus: http.StatusForbidden},
+	} {
+		req, err := http.NewRequest("GET", srv.URL+"/events/next?lat=45.962815043539&lng=15.485937595367", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Authorization", "Bearer "+test.User)
+		if test.Now != "" {
+			req.Header.Set("X-Now", test.Now)
+		}
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+
+		if got, want := resp.StatusCode, test.WantStatus; got != want {
+			t.Fatalf("%s: status = %d, want %d", test.Name, got, want)
+		}
+	}
+}
+
+func TestHandlerReady(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	svc := stubService(ctx, t)
+	srv := httptest.NewServer(rest.New(svc))
+	defer srv.Close()
+
+	ready := func() rest.ReadyReply {
+		t.Helper()
+
+		resp, err := http.Get(srv.URL + "/readyz")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+
+		if got, want := resp.StatusCode, http.StatusOK; got != want {
+			t.Fatalf("status = %d, want %d", got, want)
+		}
+		var reply rest.ReadyReply
+		if err := json.NewDecoder(resp.Body).Decode(&reply); err != nil {
+			t.Fatal(err)
+		}
+		return reply
+	}
+
+	// stubService has one user with a token
+	if got, want := ready(), (rest.ReadyReply{Status: "ok", FacebookTokens: 1}); got != want {
+		t.Fatalf("readyz = %+v, want %+v", got, want)
+	}
+
+	_, err := svc.UserStore.Update(ctx, "dummy", eventdb.UserUpdate{Mask: "facebookToken"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := ready(), (rest.ReadyReply{Status: "degraded", FacebookTokens: 0}); got != want {
+		t.Fatalf("readyz without tokens = %+v, want %+v", got, want)
+	}
+}
+
+func TestHandlerRecover(t *testing.T) {
+	t.Parallel()
+
+	var logs bytes.Buffer
+	logger := zap.New(zapcore.NewCore(
+		zapcore.NewJSONEncoder(zap.NewProductionEncoderConfig()),
+		zapcore.AddSync(&logs),
+		zap.DebugLevel,
+	))
+
+	panicky := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var event *eventdb.Event
+		fmt.Fprint(w, event.Name) // nil dereference
+	})
+	handler := log.WrapHandler(rest.Recover{}.Wrap(panicky), logger)
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/events/1", nil))
+
+	if got, want := w.Code, http.StatusInternalServerError; got != want {
+		t.Fatalf("status = %d, want %d", got, want)
+	}
+	var resp errors.Response
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("decode error response: %v", err)
+	}
+	if got, want := resp.Status, http.StatusInternalServerError; got != want {
+		t.Fatalf("response status = %d, want %d", got, want)
+	}
+
+	if !strings.Contains(logs.String(), "handler panicked") {
+		t.Fatalf("panic wasn't logged: %s", logs.String())
+	}
+	if !strings.Contains(logs.String(), "TestHandlerRecover") {
+		t.Fatalf("logged panic has no stack trace: %s", logs.String())
+	}
+}
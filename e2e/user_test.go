@@ -1,7 +1,17 @@
 package e2e
 
 import (
+	"context"
+	"net/http/httptest"
+	"reflect"
+	"strings"
 	"testing"
+
+	"github.com/findrandomevents/eventdb"
+	"github.com/findrandomevents/eventdb/auth"
+	"github.com/findrandomevents/eventdb/errors"
+	"github.com/findrandomevents/eventdb/rest"
+	"github.com/findrandomevents/eventdb/rest/client"
 )
 
 func TestUserRegistration(t *testing.T) {
@@ -56,3 +66,217 @@ func TestUserRegistration(t *testing.T) {
 	// 	t.Fatalf("updated user TimeZone = %q, want %q", got, want)
 	// }
 }
+
+func TestUserUpdateUnknownMask(t *testing.T) {
+	t.Parallel()
+
+	srv := stubServer(t)
+	defer srv.Close()
+
+	ctx := context.Background()
+
+	client := client.New("user")
+	client.BaseURL = srv.URL
+
+	_, err := client.Users.Update(ctx, "me", eventdb.UserUpdate{
+		TimeZone: "America/New_York",
+		Mask:     "timezone",
+	})
+	if !errors.Is(errors.Invalid, err) {
+		t.Fatalf("update with unknown mask field returned %v, want %v", err, errors.Invalid)
+	}
+	if !strings.Contains(err.Error(), "timezone") {
+		t.Fatalf("update error %q doesn't name the unknown field", err)
+	}
+}
+
+func TestUserUpdateEmptyMask(t *testing.T) {
+	t.Parallel()
+
+	srv := stubServer(t)
+	defer srv.Close()
+
+	ctx := context.Background()
+
+	client := client.New("user")
+	client.BaseURL = srv.URL
+
+	_, err := client.Users.Update(ctx, "me", eventdb.UserUpdate{
+		TimeZone: "America/New_York",
+	})
+	if !errors.Is(errors.Invalid, err) {
+		t.Fatalf("update with empty mask returned %v, want %v", err, errors.Invalid)
+	}
+}
+
+func TestUserActivity(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	svc := stubService(ctx, t)
+	srv := httptest.NewServer(rest.New(svc))
+	defer srv.Close()
+
+	if _, err := svc.UserStore.GetByID(ctx, "user"); !errors.Is(errors.NotExist, err) {
+		t.Fatalf("user exists before their first request: %v", err)
+	}
+
+	client := client.New("user")
+	client.BaseURL = srv.URL
+
+	// Any authenticated request counts
+	if _, err := client.Events.Search(ctx, eventdb.EventSearchRequest{}); err == nil {
+		t.Fatalf("non-admin search succeeded, want error")
+	}
+
+	user, err := svc.UserStore.GetByID(ctx, "user")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.LastActiveAt.IsZero() {
+		t.Fatalf("LastActiveAt wasn't set by an authenticated request")
+	}
+}
+
+func TestUserSearch(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	svc := stubService(ctx, t)
+
+	// stubService already has "dummy", who has a Facebook token but was never
+	// active.
+	for _, id := range []eventdb.UserID{"u1", "u2", "u3", "u4"} {
+		_, err := svc.UserStore.Update(ctx, id, eventdb.UserUpdate{TimeZone: "UTC", Mask: "timeZone"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := svc.UserStore.TouchActivity(ctx, id); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	userCtx := auth.Context(ctx, auth.ID("u1"))
+	if _, err := svc.UserSearch(userCtx, eventdb.UserSearchRequest{}); !errors.Is(errors.Permission, err) {
+		t.Fatalf("non-admin UserSearch got %v, want %v", err, errors.Permission)
+	}
+
+	adminCtx := auth.Context(ctx, auth.ID("admin"), auth.Admin(true))
+	searchAll := func(order eventdb.UserOrder) []eventdb.UserID {
+		t.Helper()
+
+		var ids []eventdb.UserID
+		req := eventdb.UserSearchRequest{OrderBy: order, Limit: 2}
+		for pages := 0; ; pages++ {
+			if pages > 5 {
+				t.Fatalf("%q: search didn't end", order)
+			}
+			reply, err := svc.UserSearch(adminCtx, req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(reply.Users) > req.Limit {
+				t.Fatalf("%q: got %d users, want at most %d", order, len(reply.Users), req.Limit)
+			}
+			for _, user := range reply.Users {
+				if user.FacebookToken != "" {
+					t.Fatalf("%q: user %s's Facebook token wasn't redacted", order, user.ID)
+				}
+				ids = append(ids, user.ID)
+			}
+			if reply.Next == "" {
+				return ids
+			}
+			req.Cursor = reply.Next
+		}
+	}
+
+	if got, want := searchAll(eventdb.UserOrderBySequence), []eventdb.UserID{"dummy", "u1", "u2", "u3", "u4"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("by sequence got %v, want %v", got, want)
+	}
+
+	// Activity moves a user to the end
+	if err := svc.UserStore.TouchActivity(ctx, "u1"); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := searchAll(eventdb.UserOrderByLastActive), []eventdb.UserID{"dummy", "u2", "u3", "u4", "u1"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("by last active got %v, want %v", got, want)
+	}
+
+	_, err := svc.UserSearch(adminCtx, eventdb.UserSearchRequest{Cursor: "garbage!"})
+	if !errors.Is(errors.Invalid, err) {
+		t.Fatalf("UserSearch with bad cursor got %v, want %v", err, errors.Invalid)
+	}
+}
+
+func TestUserLocations(t *testing.T) {
+	t.Parallel()
+
+	srv := stubServer(t)
+	defer srv.Close()
+
+	ctx := context.Background()
+
+	client := client.New("user")
+	client.BaseURL = srv.URL
+
+	for _, loc := range []eventdb.SavedLocation{
+		{Label: "work", Lat: 46.056946, Lng: 14.505751},
+		{Label: "home", Lat: 45.962815043539, Lng: 15.485937595367},
+	} {
+		if _, err := client.Users.SaveLocation(ctx, "me", loc); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := client.Users.SaveLocation(ctx, "me", eventdb.SavedLocation{Lat: 1, Lng: 1}); !errors.Is(errors.Invalid, err) {
+		t.Fatalf("saving a location without a label got error %v, want Invalid", err)
+	}
+
+	locs, err := client.Users.Locations(ctx, "me")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var labels []string
+	for _, loc := range locs {
+		labels = append(labels, loc.Label)
+	}
+	if got, want := labels, []string{"home", "work"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("saved location labels = %v, want %v", got, want)
+	}
+
+	if err := client.Users.DeleteLocation(ctx, "me", "work"); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Users.DeleteLocation(ctx, "me", "work"); !errors.Is(errors.NotExist, err) {
+		t.Fatalf("deleting a missing location got error %v, want NotExist", err)
+	}
+	locs, err = client.Users.Locations(ctx, "me")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(locs), 1; got != want {
+		t.Fatalf("after delete got %d saved locations, want %d", got, want)
+	}
+
+	// The stub events are at "home"
+	_, err = client.Events.Submit(ctx, eventdb.EventSubmitRequest{
+		EventIDs: []eventdb.EventID{"1"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Dests.Generate(ctx, eventdb.DestGenerateRequest{Location: "work"}); !errors.Is(errors.Invalid, err) {
+		t.Fatalf("generating at a deleted location got error %v, want Invalid", err)
+	}
+	reply, err := client.Dests.Generate(ctx, eventdb.DestGenerateRequest{Location: "home"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := reply.Result, eventdb.GenerateOK; got != want {
+		t.Fatalf("generating at a saved location got result %q, want %q", got, want)
+	}
+}
@@ -153,6 +153,8 @@ const (
 	NotExist                // Item does not exist.
 	Exist                   // Item already exists.
 	Internal                // Internal error or inconsistency.
+	Unavailable             // A dependency is temporarily unavailable, try again.
+	RateLimited             // Too many requests, try again later.
 )
 
 func (k Kind) String() string {
@@ -171,6 +173,10 @@ func (k Kind) String() string {
 		return "invalid request"
 	case Internal:
 		return "internal error"
+	case Unavailable:
+		return "temporarily unavailable"
+	case RateLimited:
+		return "rate limited"
 	}
 	return "unknown error kind"
 }
@@ -215,6 +221,22 @@ func Is(kind Kind, err error) bool {
 	return false
 }
 
+// KindOf returns the Kind of err, or Other if err isn't an *Error or has no
+// Kind. It lets a wrapper that adds its own message keep the Kind of the error
+// it wraps, as in
+//
+//	errors.E(op, errors.KindOf(err), errors.Errorf("get user: %v", err))
+func KindOf(err error) Kind {
+	e, ok := err.(*Error)
+	if !ok {
+		return Other
+	}
+	if e.Kind != Other || e.Err == nil {
+		return e.Kind
+	}
+	return KindOf(e.Err)
+}
+
 // Match compares its two error arguments. It can be used to check
 // for expected errors in tests. Both arguments must have underlying
 // type *Error or Match will return false. Otherwise it returns true
@@ -0,0 +1,36 @@
+package errors
+
+import "testing"
+
+func TestKindOf(t *testing.T) {
+	for _, test := range []struct {
+		Name string
+		Err  error
+		Want Kind
+	}{
+		{
+			Name: "not an *Error",
+			Err:  Str("oops"),
+			Want: Other,
+		},
+		{
+			Name: "kind",
+			Err:  E(Unavailable, "database connection lost"),
+			Want: Unavailable,
+		},
+		{
+			Name: "wrapped",
+			Err:  E(Op("Service.EventSearch"), E(Op("EventStore.Search"), Unavailable, "database connection lost")),
+			Want: Unavailable,
+		},
+		{
+			Name: "outer kind wins",
+			Err:  E(Internal, E(Unavailable, "database connection lost")),
+			Want: Internal,
+		},
+	} {
+		if got, want := KindOf(test.Err), test.Want; got != want {
+			t.Errorf("%s: KindOf(%v) = %v, want %v", test.Name, test.Err, got, want)
+		}
+	}
+}
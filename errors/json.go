@@ -28,6 +28,10 @@ func (e Response) ToError() error {
 		return E(Exist, e.Error)
 	case http.StatusNotFound:
 		return E(NotExist, e.Error)
+	case http.StatusServiceUnavailable:
+		return E(Unavailable, e.Error)
+	case http.StatusTooManyRequests:
+		return E(RateLimited, e.Error)
 	}
 	return Errorf("status %d: %s", e.Status, e.Error)
 }
@@ -53,13 +57,33 @@ func errText(err error) string {
 			return "not logged in: please authenticate with firebase and send the token as an Authorization header"
 		case Invalid:
 			return e.Error()
+		case RateLimited:
+			return "too many requests: please wait a minute and try again"
 		}
 	}
 
 	return http.StatusText(errStatus(err))
 }
 
+// A Detailer is an error that carries structured information for the client.
+// If an error or one of the errors it wraps is a Detailer, its details are sent
+// in the Response's Details field.
+type Detailer interface {
+	Details() interface{}
+}
+
 func errDetails(err error) interface{} {
+	for err != nil {
+		if d, ok := err.(Detailer); ok {
+			return d.Details()
+		}
+
+		e, ok := err.(*Error)
+		if !ok {
+			return nil
+		}
+		err = e.Err
+	}
 	return nil
 }
 
@@ -85,6 +109,10 @@ func errStatus(err error) int {
 			return http.StatusConflict
 		case Internal:
 			return http.StatusInternalServerError
+		case Unavailable:
+			return http.StatusServiceUnavailable
+		case RateLimited:
+			return http.StatusTooManyRequests
 		default:
 			return http.StatusInternalServerError
 		}
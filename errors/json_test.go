@@ -0,0 +1,34 @@
+package errors
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+func TestResponseRateLimited(t *testing.T) {
+	err := E(Op("Service.EventSearchPublic"), RateLimited, "rate limit exceeded, try again later")
+
+	resp := ResponseForError(err)
+	if got, want := resp.Status, http.StatusTooManyRequests; got != want {
+		t.Fatalf("response status = %d, want %d", got, want)
+	}
+
+	// Send it through JSON like the REST API does
+	js, jsonErr := json.Marshal(resp)
+	if jsonErr != nil {
+		t.Fatal(jsonErr)
+	}
+	var decoded Response
+	if jsonErr := json.Unmarshal(js, &decoded); jsonErr != nil {
+		t.Fatal(jsonErr)
+	}
+
+	roundTripped := decoded.ToError()
+	if !Is(RateLimited, roundTripped) {
+		t.Fatalf("round-tripped error %v isn't RateLimited", roundTripped)
+	}
+	if Is(Invalid, roundTripped) || Is(Unavailable, roundTripped) {
+		t.Fatalf("round-tripped error %v has the wrong kind", roundTripped)
+	}
+}
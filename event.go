@@ -1,6 +1,10 @@
 package eventdb
 
 import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
 	"time"
 )
 
@@ -8,6 +12,64 @@ import (
 // You can access the event it references at https://facebook.com/<event id>.
 type EventID string
 
+var (
+	bareEventID    = regexp.MustCompile(`^\w+$`)
+	numericEventID = regexp.MustCompile(`^\d+$`)
+)
+
+// ParseEventID extracts an EventID from user input. It accepts bare IDs as well
+// as Facebook event URLs in their various forms, like:
+//
+//	123456
+//	https://www.facebook.com/events/123456/
+//	https://m.facebook.com/events/123456?ref=share
+//	facebook.com/events/123456/permalink/789/
+//	https://www.facebook.com/event.php?eid=123456
+func ParseEventID(input string) (EventID, error) {
+	input = strings.TrimSpace(input)
+
+	if bareEventID.MatchString(input) {
+		return EventID(input), nil
+	}
+
+	invalid := fmt.Errorf("invalid event id or url %q", input)
+
+	if !strings.Contains(input, "://") {
+		input = "https://" + input
+	}
+	u, err := url.Parse(input)
+	if err != nil {
+		return "", invalid
+	}
+
+	host := strings.ToLower(u.Hostname())
+	if host != "facebook.com" && !strings.HasSuffix(host, ".facebook.com") {
+		return "", invalid
+	}
+
+	// eg. /event.php?eid=123456
+	for _, key := range []string{"eid", "event_id"} {
+		if id := u.Query().Get(key); numericEventID.MatchString(id) {
+			return EventID(id), nil
+		}
+	}
+
+	// eg. /events/123456/ or /events/some-slug/123456/
+	parts := strings.Split(strings.Trim(u.Path, "/"), "/")
+	for i, part := range parts {
+		if part != "events" {
+			continue
+		}
+		for _, p := range parts[i+1:] {
+			if numericEventID.MatchString(p) {
+				return EventID(p), nil
+			}
+		}
+	}
+
+	return "", invalid
+}
+
 // Event describes a (random) Facebook event.
 type Event struct {
 	// These fields are extracted from the Facebook Graph API response
@@ -22,6 +84,19 @@ type Event struct {
 	Cover       string    `json:"cover"`
 	Place       string    `json:"place"`
 	Address     string    `json:"address"`
+	Country     string    `json:"country,omitempty"`
+	Category    string    `json:"category,omitempty"`
+
+	// Tags are guessed from the name and description when the event is
+	// saved, see ExtractTags.
+	Tags []string `json:"tags,omitempty"`
+
+	// CoverID is the Facebook photo ID of the Cover image. CoverOffsetX and
+	// CoverOffsetY say where to crop it, as percentages of the space left
+	// over when it's scaled to fill a frame.
+	CoverID      string  `json:"cover_id,omitempty"`
+	CoverOffsetX float64 `json:"cover_offset_x"`
+	CoverOffsetY float64 `json:"cover_offset_y"`
 
 	// IsBad is a flag used to filter events that don't work well on the service.
 	//
@@ -29,15 +104,287 @@ type Event struct {
 	// replacing it with something more thoroughly thought out. See the discussion
 	// at IsBadEvent().
 	IsBad bool `json:"is_bad"`
+
+	// IsDeleted is set when Facebook reports that the event no longer exists.
+	// Deleted events are left out of search results.
+	IsDeleted bool `json:"is_deleted,omitempty"`
+
+	// TimesChosen counts how many times the event has been picked for a dest.
+	TimesChosen int `json:"times_chosen"`
+
+	// Status is computed at read time by the service. It's left empty by the
+	// EventStore.
+	Status EventStatus `json:"status,omitempty"`
+
+	// DistanceM is how far the event is from the center of the search that
+	// returned it, in meters. It's zero if the search had no center.
+	DistanceM float64 `json:"distance_m,omitempty"`
+}
+
+// EventStatus describes where an Event is in its lifecycle at a given time.
+type EventStatus string
+
+const (
+	// EventUpcoming means the event hasn't started yet.
+	EventUpcoming EventStatus = "upcoming"
+	// EventOngoing means the event has started but hasn't ended.
+	EventOngoing EventStatus = "ongoing"
+	// EventEnded means the event is over.
+	EventEnded EventStatus = "ended"
+	// EventCanceled means the event was canceled by its organizer.
+	EventCanceled EventStatus = "canceled"
+)
+
+// StatusAt returns the Event's status at the time now. Canceled events are
+// always EventCanceled, regardless of the time.
+func (e Event) StatusAt(now time.Time) EventStatus {
+	switch {
+	case e.IsCanceled:
+		return EventCanceled
+	case now.Before(e.StartTime):
+		return EventUpcoming
+	case now.Before(e.EndTime):
+		return EventOngoing
+	default:
+		return EventEnded
+	}
+}
+
+// FieldChange describes a field that differs between two versions of an
+// Event. Old and New are formatted for display.
+type FieldChange struct {
+	Field string `json:"field"`
+	Old   string `json:"old"`
+	New   string `json:"new"`
+}
+
+// DiffEvents lists the fields that changed between two versions of the same
+// event, like before and after it's refetched from Facebook. Fields are named
+// by their JSON keys. Status, IsBad, and TimesChosen aren't compared since
+// they aren't from Facebook.
+func DiffEvents(old, new Event) []FieldChange {
+	var changes []FieldChange
+	diff := func(field string, o, n interface{}) {
+		if ot, ok := o.(time.Time); ok {
+			nt := n.(time.Time)
+			if ot.Equal(nt) {
+				return
+			}
+			o, n = ot.Format(time.RFC3339), nt.Format(time.RFC3339)
+		}
+		if o == n {
+			return
+		}
+		changes = append(changes, FieldChange{
+			Field: field,
+			Old:   fmt.Sprint(o),
+			New:   fmt.Sprint(n),
+		})
+	}
+
+	diff("name", old.Name, new.Name)
+	diff("description", old.Description, new.Description)
+	diff("latitude", old.Latitude, new.Latitude)
+	diff("longitude", old.Longitude, new.Longitude)
+	diff("start_time", old.StartTime, new.StartTime)
+	diff("end_time", old.EndTime, new.EndTime)
+	diff("is_canceled", old.IsCanceled, new.IsCanceled)
+	diff("cover", old.Cover, new.Cover)
+	diff("place", old.Place, new.Place)
+	diff("address", old.Address, new.Address)
+	diff("country", old.Country, new.Country)
+	diff("category", old.Category, new.Category)
+	diff("is_deleted", old.IsDeleted, new.IsDeleted)
+
+	return changes
 }
 
 // EventSearchRequest is passed to EventStore.Search to find events at a certain time
 // and place.
 type EventSearchRequest struct {
+	// Bounds is a GeoJSON geometry that the events must be inside. Only admins
+	// can leave it empty to search by time alone, which also includes events
+	// without an address.
 	Bounds     string    `json:"bounds"`
 	Start      time.Time `json:"start"`
 	End        time.Time `json:"end"`
 	IncludeBad bool      `json:"includeBad"`
+
+	// AllowLongEvents includes events that last 10 hours or more, like
+	// multi-day festivals. They're excluded by default.
+	//
+	// The search index only covers events shorter than 10 hours, so setting
+	// this flag falls back to a much slower scan of the events table.
+	AllowLongEvents bool `json:"allowLongEvents"`
+
+	// TimesInUTC returns start and end times in UTC instead of the event's
+	// own time zone, which is faster for big result sets.
+	TimesInUTC bool `json:"timesInUTC"`
+
+	// MaxElapsedMinutes, if set, excludes events that started more than this
+	// many minutes before Start. Events that have been going for a while are
+	// still in the time window, but they're often too late to join.
+	MaxElapsedMinutes int `json:"maxElapsedMinutes"`
+
+	// FreshnessWindow, if set, excludes events that haven't been fetched from
+	// Facebook within this long, since their details may have changed. It's
+	// in nanoseconds in JSON.
+	FreshnessWindow time.Duration `json:"freshnessWindow"`
+
+	// FreeOnly excludes events that list a price above zero in their
+	// description or have a ticket link.
+	FreeOnly bool `json:"freeOnly"`
+
+	// MaxPrice excludes events with a price above this amount in Currency, an
+	// ISO 4217 code like "EUR". Events with no listed price are included but
+	// events that only list prices in other currencies aren't. Currency is
+	// required if MaxPrice is set.
+	Currency string  `json:"currency"`
+	MaxPrice float64 `json:"maxPrice"`
+
+	// MaxPriceCents is like MaxPrice, but in cents, or whatever Currency's
+	// minor unit is (see MinorUnits). Currency is required with it too.
+	MaxPriceCents int `json:"maxPriceCents"`
+
+	// Tags, if set, restricts the results to events with at least one of
+	// these tags, see ExtractTags.
+	Tags []string `json:"tags"`
+
+	// PlaceName restricts the results to events whose place name or street
+	// address contains this text, ignoring case. It's a plain text match over
+	// the stored places, not geocoding, and is combined with Bounds.
+	PlaceName string `json:"placeName"`
+
+	// Query restricts the results to events with these keywords in their
+	// name or description.
+	Query string `json:"query"`
+	// Fuzzy allows approximate Query matches, for misspellings like "jaz" for
+	// "jazz". They're only used if there are no exact matches, and are ordered
+	// by how similar they are.
+	Fuzzy bool `json:"fuzzy"`
+
+	// CenterLat and CenterLng are the center of the search, like where the
+	// map is pointed. If either is set, each result's DistanceM is measured
+	// from here and the results can be sorted by OrderByDistance.
+	CenterLat float64 `json:"centerLat"`
+	CenterLng float64 `json:"centerLng"`
+
+	// RadiusM, if set, restricts the results to events within this many
+	// meters of the center, measured along the earth's surface. It needs a
+	// center and is combined with Bounds.
+	RadiusM float64 `json:"radiusM"`
+
+	// OrderBy sets the order of the results. The default, OrderByStartTime,
+	// sorts by start time. Only admins can sort by anything else.
+	OrderBy EventOrder `json:"orderBy"`
+
+	// DedupeByVenue returns at most one event per venue, the first in
+	// OrderBy, so one busy place doesn't crowd out everything else. Events
+	// are at the same venue if they share a Facebook place ID.
+	DedupeByVenue bool `json:"dedupeByVenue"`
+
+	// UserID, if set, excludes events the user has already attended: those
+	// with one of the user's dests in one of AttendedStatuses. Dest statuses
+	// are up to the client, so none count as attended unless they're listed.
+	UserID           UserID   `json:"userID"`
+	AttendedStatuses []string `json:"attendedStatuses"`
+
+	// ExcludeChosen, along with UserID, also excludes events that were
+	// already suggested to the user: those with one of the user's dests
+	// created at or after ChosenSince. A zero ChosenSince counts every dest,
+	// so nothing is ever suggested twice.
+	ExcludeChosen bool      `json:"excludeChosen"`
+	ChosenSince   time.Time `json:"chosenSince"`
+
+	// Limit and Offset page through the results, which are sorted by
+	// OrderBy. A zero Limit returns all the results.
+	Limit  int `json:"limit"`
+	Offset int `json:"offset"`
+}
+
+// HasCenter reports whether the search has a center set.
+func (r EventSearchRequest) HasCenter() bool {
+	return r.CenterLat != 0 || r.CenterLng != 0
+}
+
+// BoundsValidateRequest is the body of the /events/validate-bounds endpoint.
+type BoundsValidateRequest struct {
+	// Bounds is a GeoJSON Polygon or MultiPolygon, as in EventSearchRequest.
+	Bounds string `json:"bounds"`
+}
+
+// BoundsValidateReply is returned by the /events/validate-bounds endpoint.
+type BoundsValidateReply struct {
+	// AreaM2 is the area covered by the bounds in square meters.
+	AreaM2 float64 `json:"areaM2"`
+	// Valid is true if the bounds can be used to search.
+	Valid bool `json:"valid"`
+	// Reason explains why the bounds aren't valid.
+	Reason string `json:"reason,omitempty"`
+}
+
+// EventExtent is the bounding box of a set of events, returned by the
+// /events/extent endpoint. Map clients can use it as their initial viewport.
+type EventExtent struct {
+	MinLat float64 `json:"minLat"`
+	MinLng float64 `json:"minLng"`
+	MaxLat float64 `json:"maxLat"`
+	MaxLng float64 `json:"maxLng"`
+}
+
+// EventDay is a day's worth of search results, for calendar views. It's
+// returned by the /events/search endpoint with format=by-day.
+type EventDay struct {
+	// Date is the day in the requested time zone, like "2017-08-17".
+	Date   string  `json:"date"`
+	Events []Event `json:"events"`
+}
+
+// EventOrder is a sort order for event search results.
+type EventOrder string
+
+const (
+	// OrderByStartTime sorts events by start time, earliest first.
+	OrderByStartTime EventOrder = ""
+	// OrderByTimesChosen sorts the events picked for the most dests first.
+	OrderByTimesChosen EventOrder = "timesChosen"
+	// OrderByStartingSoon sorts events by start time, like OrderByStartTime,
+	// but leaves out events that already started, for a "starting next" feed.
+	// Events starting before the search's Start or the current time, whichever
+	// is later, are excluded.
+	OrderByStartingSoon EventOrder = "startingSoon"
+	// OrderByDistance sorts the events nearest the search's center first,
+	// then by start time. The search must have a center.
+	OrderByDistance EventOrder = "distance"
+)
+
+// EventRefreshReply is returned by the /events/refresh endpoint.
+type EventRefreshReply struct {
+	// Refreshed is the number of stale events that were refetched.
+	Refreshed int `json:"refreshed"`
+}
+
+// EventSyncReply is a page of changes to the event database since a cursor,
+// returned by the /events/sync endpoint.
+type EventSyncReply struct {
+	// Events were added or changed.
+	Events []Event `json:"events"`
+	// Tombstones are the IDs of events that were deleted or hidden. Clients
+	// should drop them from their caches.
+	Tombstones []EventID `json:"tombstones"`
+	// Next and NextID are the cursor for the next sync. Many events can
+	// change at the same time, so both are needed to pick up where this page
+	// left off.
+	Next   time.Time `json:"next"`
+	NextID EventID   `json:"nextID,omitempty"`
+}
+
+// SyncCursor marks where a page of sync changes ended: the last change's
+// time, and its event ID to break ties between events that changed at the
+// same time. The zero SyncCursor starts from the beginning.
+type SyncCursor struct {
+	UpdatedAt time.Time
+	ID        EventID
 }
 
 // An EventSubmitRequest is a request to add a facebook event to the event database.
@@ -45,5 +392,60 @@ type EventSubmitRequest struct {
 	// EventIDs are the Facebook Event IDs.
 	//
 	// Submissions can be batched for efficiency. Up to 50 ids may be submitted at a time.
+	//
+	// Facebook event URLs are also accepted. See ParseEventID.
 	EventIDs []EventID `json:"event_ids"`
 }
+
+// EventSubmitReply says what happened to each event saved by EventSubmit.
+type EventSubmitReply struct {
+	Events []EventSubmitResult `json:"events"`
+}
+
+// An EventSubmitResult is the outcome of saving one submitted event.
+type EventSubmitResult struct {
+	ID EventID `json:"id"`
+
+	// IsBad is set if the event was flagged bad, so it's hidden from search.
+	IsBad bool `json:"isBad"`
+	// BadReason says why the event was flagged, e.g. which filter rule
+	// matched.
+	BadReason string `json:"badReason,omitempty"`
+}
+
+// An EventReport is a user's complaint that an event is broken or wrong, like
+// spam or in the wrong place.
+type EventReport struct {
+	EventID   EventID   `json:"eventID"`
+	UserID    UserID    `json:"userID"`
+	Reason    string    `json:"reason"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// BlockKind says what a Block matches: a Facebook place, or the page or user
+// that owns events.
+type BlockKind string
+
+const (
+	// BlockPlace blocks events whose place has the ID.
+	BlockPlace BlockKind = "place"
+	// BlockOwner blocks events whose owner has the ID.
+	BlockOwner BlockKind = "owner"
+)
+
+// A Block keeps every event at a venue, or from an owner, out of search and
+// DestGenerate, including events saved after it was added. It's for known
+// spam sources, where flagging each event bad as it arrives doesn't keep up.
+type Block struct {
+	Kind BlockKind `json:"kind"`
+	// ID is the Facebook ID of the place or owner.
+	ID        string    `json:"id"`
+	Reason    string    `json:"reason"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// EventReportRequest is the body of the /events/{id}/report endpoint.
+type EventReportRequest struct {
+	// Reason says what's wrong with the event.
+	Reason string `json:"reason"`
+}
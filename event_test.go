@@ -0,0 +1,90 @@
+package eventdb
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseEventID(t *testing.T) {
+	for _, test := range []struct {
+		Input   string
+		Want    EventID
+		WantErr bool
+	}{
+		{Input: "123456", Want: "123456"},
+		{Input: " 123456\n", Want: "123456"},
+		{Input: "https://facebook.com/events/123456", Want: "123456"},
+		{Input: "https://www.facebook.com/events/123456/", Want: "123456"},
+		{Input: "http://m.facebook.com/events/123456?ref=share&acontext=%7B%7D", Want: "123456"},
+		{Input: "www.facebook.com/events/123456/permalink/789/", Want: "123456"},
+		{Input: "https://www.facebook.com/events/some-party/123456/", Want: "123456"},
+		{Input: "https://www.facebook.com/event.php?eid=123456", Want: "123456"},
+		{Input: "", WantErr: true},
+		{Input: "not an id", WantErr: true},
+		{Input: "https://example.com/events/123456", WantErr: true},
+		{Input: "https://facebook.com.evil.com/events/123456", WantErr: true},
+		{Input: "https://www.facebook.com/events/", WantErr: true},
+		{Input: "https://www.facebook.com/some-page", WantErr: true},
+	} {
+		got, err := ParseEventID(test.Input)
+		if test.WantErr {
+			if err == nil {
+				t.Fatalf("ParseEventID(%q) = %q, want error", test.Input, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("ParseEventID(%q): %v", test.Input, err)
+		}
+		if got != test.Want {
+			t.Fatalf("ParseEventID(%q) = %q, want %q", test.Input, got, test.Want)
+		}
+	}
+}
+
+func TestDiffEvents(t *testing.T) {
+	old := Event{
+		ID:        "1",
+		Name:      "Jazz Night",
+		StartTime: time.Date(2017, 8, 17, 17, 0, 0, 0, time.UTC),
+		EndTime:   time.Date(2017, 8, 17, 20, 0, 0, 0, time.UTC),
+		Place:     "Josipina",
+	}
+
+	moved := old
+	moved.StartTime = old.StartTime.Add(time.Hour)
+
+	canceled := old
+	canceled.IsCanceled = true
+
+	// The same time in a different zone isn't a change
+	sameTime := old
+	sameTime.StartTime = old.StartTime.In(time.FixedZone("CEST", 2*60*60))
+
+	for _, test := range []struct {
+		Name string
+		New  Event
+		Want []FieldChange
+	}{
+		{
+			Name: "time change",
+			New:  moved,
+			Want: []FieldChange{{Field: "start_time", Old: "2017-08-17T17:00:00Z", New: "2017-08-17T18:00:00Z"}},
+		},
+		{
+			Name: "canceled",
+			New:  canceled,
+			Want: []FieldChange{{Field: "is_canceled", Old: "false", New: "true"}},
+		},
+		{
+			Name: "no change",
+			New:  sameTime,
+			Want: nil,
+		},
+	} {
+		if got := DiffEvents(old, test.New); !reflect.DeepEqual(got, test.Want) {
+			t.Errorf("%s: DiffEvents = %+v, want %+v", test.Name, got, test.Want)
+		}
+	}
+}
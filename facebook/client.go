@@ -6,6 +6,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"net/url"
 	"strings"
 
 	"github.com/findrandomevents/eventdb/log"
@@ -14,18 +15,67 @@ import (
 
 const apiVersion = "v2.9"
 
+// DefaultUserAgent identifies eventdb to the Graph API when Client.UserAgent
+// isn't set.
+const DefaultUserAgent = "eventdb (+https://findrandomevents.com)"
+
+// DefaultEventFields are the event fields GetEventInfo requests when
+// Client.EventFields isn't set.
+const DefaultEventFields = `attending_count,can_guests_invite,can_viewer_post,category,cover,declined_count,description,end_time,guest_list_enabled,interested_count,is_canceled,is_draft,is_page_owned,is_viewer_admin,id,maybe_count,name,noreply_count,owner,parent_group,place,start_time,ticket_uri,timezone,type,updated_time`
+
+// maxPageEventPages caps how many pages of results GetPageEvents follows, so
+// a page with a huge back catalog can't keep it paging forever.
+const maxPageEventPages = 10
+
 // Client is a slimmed-down Facebook Graph API client.
 type Client struct {
 	HTTP *http.Client
+
+	// UserAgent is sent with each request. It defaults to DefaultUserAgent.
+	UserAgent string
+
+	// AccessToken, if set, authenticates each request with this token, like
+	// an app token. It's not needed if HTTP already adds a user's token.
+	AccessToken string
+
+	// Header holds extra headers to send with each request.
+	Header http.Header
+
+	// EventFields is the comma-separated list of fields GetEventInfo asks
+	// for. It defaults to DefaultEventFields.
+	EventFields string
+}
+
+// setHeaders adds the client's default headers to a request.
+func (f *Client) setHeaders(r *http.Request) {
+	for key, values := range f.Header {
+		for _, v := range values {
+			r.Header.Add(key, v)
+		}
+	}
+
+	userAgent := f.UserAgent
+	if userAgent == "" {
+		userAgent = DefaultUserAgent
+	}
+	r.Header.Set("User-Agent", userAgent)
+
+	if f.AccessToken != "" {
+		r.Header.Set("Authorization", "Bearer "+f.AccessToken)
+	}
 }
 
 // GetEventInfo fetches information for up to 50 Facebook event IDs using the
 // Facebook Graph API. If some events do not exist or are inaccessible this
-// function may return fewer event infos than the number of ids passed in.
+// function returns the events it could fetch along with a BatchError listing
+// the ones it couldn't.
 func (f *Client) GetEventInfo(ctx context.Context, ids []string) ([]json.RawMessage, error) {
 	logger := log.FromContext(ctx)
 
-	const fields = `attending_count,can_guests_invite,can_viewer_post,category,cover,declined_count,description,end_time,guest_list_enabled,interested_count,is_canceled,is_draft,is_page_owned,is_viewer_admin,id,maybe_count,name,noreply_count,owner,parent_group,place,start_time,ticket_uri,timezone,type,updated_time`
+	fields := f.EventFields
+	if fields == "" {
+		fields = DefaultEventFields
+	}
 
 	reqs := make([]map[string]string, len(ids))
 	for i, id := range ids {
@@ -41,7 +91,17 @@ func (f *Client) GetEventInfo(ctx context.Context, ids []string) ([]json.RawMess
 		return nil, err
 	}
 
-	resp, err := f.HTTP.Post("https://graph.facebook.com", "application/json", batchBody)
+	httpReq, err := http.NewRequest("POST", "https://graph.facebook.com", batchBody)
+	if err != nil {
+		return nil, err
+	}
+	f.setHeaders(httpReq)
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	// Tie the request to ctx so a canceled submit stops waiting on Facebook
+	httpReq = httpReq.WithContext(ctx)
+
+	resp, err := f.HTTP.Do(httpReq)
 	if err != nil {
 		return nil, err
 	}
@@ -57,6 +117,7 @@ func (f *Client) GetEventInfo(ctx context.Context, ids []string) ([]json.RawMess
 	}
 
 	var events []json.RawMessage
+	failures := BatchError{}
 	for i, r := range responses {
 		if r.Code != http.StatusOK {
 			fbErr := parseError(strings.NewReader(r.Body))
@@ -69,10 +130,73 @@ func (f *Client) GetEventInfo(ctx context.Context, ids []string) ([]json.RawMess
 				zap.String("error", fbErr.Message),
 				zap.String("errorType", fbErr.Type))
 
-			return events, fbErr
+			// None of the other requests will work either
+			if IsTokenExpired(fbErr) {
+				return events, fbErr
+			}
+
+			failures[ids[i]] = fbErr
+			continue
 		}
 		events = append(events, json.RawMessage(r.Body))
 	}
+	if len(failures) > 0 {
+		return events, failures
+	}
 
 	return events, nil
 }
+
+// GetPageEvents lists the IDs of a Facebook page's upcoming events. Their
+// details can then be fetched with GetEventInfo.
+func (f *Client) GetPageEvents(ctx context.Context, pageID string) ([]string, error) {
+	query := url.Values{
+		"fields":      {"id"},
+		"time_filter": {"upcoming"},
+		"limit":       {"100"},
+	}
+	next := fmt.Sprintf("https://graph.facebook.com/%s/%s/events?%s", apiVersion, url.PathEscape(pageID), query.Encode())
+
+	var ids []string
+	for i := 0; i < maxPageEventPages && next != ""; i++ {
+		httpReq, err := http.NewRequest("GET", next, nil)
+		if err != nil {
+			return ids, err
+		}
+		f.setHeaders(httpReq)
+		httpReq = httpReq.WithContext(ctx)
+
+		resp, err := f.HTTP.Do(httpReq)
+		if err != nil {
+			return ids, err
+		}
+
+		var page pageEventsResponse
+		if resp.StatusCode != http.StatusOK {
+			err = parseError(resp.Body)
+		} else {
+			err = json.NewDecoder(resp.Body).Decode(&page)
+		}
+		resp.Body.Close()
+		if err != nil {
+			return ids, err
+		}
+
+		for _, event := range page.Data {
+			ids = append(ids, event.ID)
+		}
+		next = page.Paging.Next
+	}
+
+	return ids, nil
+}
+
+// pageEventsResponse is one page of a page's /events edge.
+type pageEventsResponse struct {
+	Data []struct {
+		ID string `json:"id"`
+	} `json:"data"`
+	Paging struct {
+		Next string `json:"next"`
+	} `json:"paging"`
+}
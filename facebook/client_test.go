@@ -0,0 +1,150 @@
+package facebook
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// roundTripperFunc makes a function into an http.RoundTripper.
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
+
+func TestGetEventInfoCanceled(t *testing.T) {
+	// A Graph API that never answers
+	client := &Client{
+		HTTP: &http.Client{
+			Transport: roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+				<-r.Context().Done()
+				return nil, r.Context().Err()
+			}),
+		},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := client.GetEventInfo(ctx, []string{"1"})
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Fatal("GetEventInfo with a canceled context succeeded, want an error")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("GetEventInfo didn't return after its context was canceled")
+	}
+}
+
+func TestGetEventInfoHeaders(t *testing.T) {
+	var got http.Header
+	client := &Client{
+		HTTP: &http.Client{
+			Transport: roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+				got = r.Header
+				return &http.Response{
+					StatusCode: http.StatusOK,
+					Body:       ioutil.NopCloser(strings.NewReader(`[]`)),
+					Request:    r,
+				}, nil
+			}),
+		},
+		UserAgent:   "eventdb-test/1.0",
+		AccessToken: "app|token",
+		Header:      http.Header{"X-Debug": []string{"yes"}},
+	}
+
+	if _, err := client.GetEventInfo(context.Background(), []string{"1"}); err != nil {
+		t.Fatal(err)
+	}
+
+	for key, want := range map[string]string{
+		"User-Agent":    "eventdb-test/1.0",
+		"Authorization": "Bearer app|token",
+		"X-Debug":       "yes",
+		"Content-Type":  "application/json",
+	} {
+		if got := got.Get(key); got != want {
+			t.Errorf("request header %s = %q, want %q", key, got, want)
+		}
+	}
+
+	// Without a UserAgent the default is sent
+	client.UserAgent = ""
+	if _, err := client.GetEventInfo(context.Background(), []string{"1"}); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := got.Get("User-Agent"), DefaultUserAgent; got != want {
+		t.Errorf("default User-Agent = %q, want %q", got, want)
+	}
+}
+
+func TestGetPageEvents(t *testing.T) {
+	var paths []string
+	client := &Client{
+		HTTP: &http.Client{
+			Transport: roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+				paths = append(paths, r.URL.Path)
+
+				body := `{"data": [{"id": "1"}, {"id": "2"}], "paging": {"next": "https://graph.facebook.com/v2.9/page/events?after=2"}}`
+				if r.URL.Query().Get("after") != "" {
+					body = `{"data": [{"id": "3"}], "paging": {}}`
+				}
+				return &http.Response{
+					StatusCode: http.StatusOK,
+					Body:       ioutil.NopCloser(strings.NewReader(body)),
+					Request:    r,
+				}, nil
+			}),
+		},
+	}
+
+	ids, err := client.GetPageEvents(context.Background(), "page")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := strings.Join(ids, ","), "1,2,3"; got != want {
+		t.Errorf("GetPageEvents = %s, want %s", got, want)
+	}
+	if got, want := len(paths), 2; got != want {
+		t.Errorf("made %d requests, want %d", got, want)
+	}
+	if got, want := paths[0], "/v2.9/page/events"; got != want {
+		t.Errorf("requested %s, want %s", got, want)
+	}
+}
+
+func TestGetEventInfoFields(t *testing.T) {
+	var body string
+	client := &Client{
+		HTTP: &http.Client{
+			Transport: roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+				b, _ := ioutil.ReadAll(r.Body)
+				body = string(b)
+				return &http.Response{
+					StatusCode: http.StatusOK,
+					Body:       ioutil.NopCloser(strings.NewReader(`[]`)),
+					Request:    r,
+				}, nil
+			}),
+		},
+		EventFields: "id,name",
+	}
+
+	if _, err := client.GetEventInfo(context.Background(), []string{"1"}); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(body, "fields=id,name") {
+		t.Errorf("batch request %s doesn't ask for the configured fields", body)
+	}
+}
@@ -4,6 +4,8 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"sort"
+	"strings"
 )
 
 // Error is an error returned by the Facebook Graph API
@@ -19,6 +21,19 @@ func (f Error) Error() string {
 	return fmt.Sprintf("%s type=%q code=%d subcode=%d", f.Message, f.Type, f.Code, f.Subcode)
 }
 
+// BatchError is returned when some of the requests in a batch failed. It maps
+// the ID of each failed object to the error Facebook returned for it.
+type BatchError map[string]Error
+
+func (b BatchError) Error() string {
+	var ids []string
+	for id := range b {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return fmt.Sprintf("batch fetch failed for ids: %s", strings.Join(ids, ", "))
+}
+
 // ErrorResponse contains an Error. It's returned by the Graph API.
 type ErrorResponse struct {
 	Error Error `json:"error"`
@@ -41,6 +56,18 @@ type BatchResponse struct {
 	Body string `json:"body"`
 }
 
+// IsNotFound returns true if the error means the requested object doesn't
+// exist, usually because it was deleted.
+func IsNotFound(err error) bool {
+	e, ok := err.(Error)
+	if !ok {
+		return false
+	}
+	// 100/33 is "Unsupported get request. Object with ID does not exist"
+	// 803 is "Some of the aliases you requested do not exist"
+	return (e.Code == 100 && e.Subcode == 33) || e.Code == 803
+}
+
 // IsTokenExpired returns true if this is a token expired error from the
 // Facebook API client.
 func IsTokenExpired(err error) bool {
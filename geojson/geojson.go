@@ -2,12 +2,13 @@ package geojson
 
 import (
 	"encoding/json"
+	"fmt"
 	"math"
 )
 
-// DefaultSegments controls the number of segments output in the geometry created
-// by CircleGeom.
-var DefaultSegments float64 = 20
+// DefaultSegments is the number of segments output in the geometry created by
+// CircleGeom. Use CircleGeomN for a different number.
+const DefaultSegments = 20
 
 // EarthRadiusM is the approximate radius of the earth in meters
 const EarthRadiusM float64 = 6378137.0
@@ -30,6 +31,13 @@ func Haversine(lonFrom float64, latFrom float64, lonTo float64, latTo float64) (
 // CircleGeom outputs a GeoJSON geometry representing a circle of radius
 // radiusM meters centered at (cLat, cLng)
 func CircleGeom(cLat, cLng, radiusM float64) string {
+	return CircleGeomN(cLat, cLng, radiusM, DefaultSegments)
+}
+
+// CircleGeomN is like CircleGeom, but approximates the circle with the given
+// number of segments. More segments give a polygon closer to a true circle, at
+// the cost of a bigger geometry.
+func CircleGeomN(cLat, cLng, radiusM float64, segments int) string {
 	// Based on https://gist.github.com/mashbridge/7331812
 
 	var coords [][]float64
@@ -63,9 +71,12 @@ func CircleGeom(cLat, cLng, radiusM float64) string {
 		return []float64{lng, lat}
 	}
 
-	step := (2.0 * math.Pi) / DefaultSegments
-	for p := 0.0; p > -2*math.Pi; p -= step {
-		coords = append(coords, f(p))
+	if segments < 3 {
+		segments = 3
+	}
+	step := (2.0 * math.Pi) / float64(segments)
+	for i := 0; i < segments; i++ {
+		coords = append(coords, f(-float64(i)*step))
 	}
 	coords = append(coords, f(0))
 
@@ -75,3 +86,270 @@ func CircleGeom(cLat, cLng, radiusM float64) string {
 	})
 	return string(js)
 }
+
+// RectGeom outputs a GeoJSON geometry for the lat/lng box with the given
+// corners, like the visible area of a map. If minLng is greater than maxLng
+// the box crosses the antimeridian, and it's split there into a MultiPolygon.
+func RectGeom(minLat, minLng, maxLat, maxLng float64) string {
+	rect := func(minLng, maxLng float64) [][][]float64 {
+		return [][][]float64{{
+			{minLng, minLat},
+			{maxLng, minLat},
+			{maxLng, maxLat},
+			{minLng, maxLat},
+			{minLng, minLat},
+		}}
+	}
+
+	var geom interface{}
+	if minLng > maxLng {
+		geom = map[string]interface{}{
+			"type":        "MultiPolygon",
+			"coordinates": [][][][]float64{rect(minLng, 180), rect(-180, maxLng)},
+		}
+	} else {
+		geom = map[string]interface{}{
+			"type":        "Polygon",
+			"coordinates": rect(minLng, maxLng),
+		}
+	}
+
+	js, _ := json.Marshal(geom)
+	return string(js)
+}
+
+// Polygons holds the coordinates of a GeoJSON Polygon or MultiPolygon. Each
+// polygon is a list of rings of [lng, lat] points. The first ring is the
+// outside, the rest are holes.
+type Polygons [][][][]float64
+
+// ParsePolygons parses a GeoJSON Polygon or MultiPolygon geometry.
+func ParsePolygons(geom string) (Polygons, error) {
+	var g struct {
+		Type        string          `json:"type"`
+		Coordinates json.RawMessage `json:"coordinates"`
+	}
+	if err := json.Unmarshal([]byte(geom), &g); err != nil {
+		return nil, err
+	}
+
+	var polygons Polygons
+	switch g.Type {
+	case "Polygon":
+		var polygon [][][]float64
+		if err := json.Unmarshal(g.Coordinates, &polygon); err != nil {
+			return nil, err
+		}
+		polygons = append(polygons, polygon)
+	case "MultiPolygon":
+		if err := json.Unmarshal(g.Coordinates, &polygons); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unsupported geometry type %q", g.Type)
+	}
+
+	return polygons, nil
+}
+
+// BoundingBox returns the smallest lat/lng box that contains the polygons.
+func (p Polygons) BoundingBox() (minLat, minLng, maxLat, maxLng float64) {
+	minLat, minLng = math.Inf(1), math.Inf(1)
+	maxLat, maxLng = math.Inf(-1), math.Inf(-1)
+	for _, polygon := range p {
+		if len(polygon) == 0 {
+			continue
+		}
+		for _, pt := range polygon[0] {
+			if len(pt) < 2 {
+				continue
+			}
+			minLng, maxLng = math.Min(minLng, pt[0]), math.Max(maxLng, pt[0])
+			minLat, maxLat = math.Min(minLat, pt[1]), math.Max(maxLat, pt[1])
+		}
+	}
+	return minLat, minLng, maxLat, maxLng
+}
+
+// Contains reports whether the point (lat, lng) is inside the polygons and
+// not in one of their holes. Edges are treated as straight lines in lat/lng
+// space, which is close enough for city-sized areas.
+func (p Polygons) Contains(lat, lng float64) bool {
+	for _, polygon := range p {
+		if len(polygon) == 0 || !ringContains(polygon[0], lat, lng) {
+			continue
+		}
+		inHole := false
+		for _, hole := range polygon[1:] {
+			if ringContains(hole, lat, lng) {
+				inHole = true
+				break
+			}
+		}
+		if !inHole {
+			return true
+		}
+	}
+	return false
+}
+
+// ringContains tests whether a point is inside a ring by ray casting.
+func ringContains(ring [][]float64, lat, lng float64) bool {
+	inside := false
+	for i, j := 0, len(ring)-1; i < len(ring); j, i = i, i+1 {
+		if len(ring[i]) < 2 || len(ring[j]) < 2 {
+			continue
+		}
+		xi, yi := ring[i][0], ring[i][1]
+		xj, yj := ring[j][0], ring[j][1]
+		if (yi > lat) != (yj > lat) && lng < (xj-xi)*(lat-yi)/(yj-yi)+xi {
+			inside = !inside
+		}
+	}
+	return inside
+}
+
+// Validate checks that the polygons are well formed: there's at least one
+// polygon, every ring is closed and has at least four points, coordinates are
+// in range, and no ring crosses itself.
+func (p Polygons) Validate() error {
+	if len(p) == 0 {
+		return fmt.Errorf("no polygons")
+	}
+	for i, polygon := range p {
+		if len(polygon) == 0 {
+			return fmt.Errorf("polygon %d has no rings", i)
+		}
+		for j, ring := range polygon {
+			if err := validateRing(ring); err != nil {
+				return fmt.Errorf("polygon %d ring %d: %v", i, j, err)
+			}
+		}
+	}
+	return nil
+}
+
+func validateRing(ring [][]float64) error {
+	if len(ring) < 4 {
+		return fmt.Errorf("needs at least 4 points, has %d", len(ring))
+	}
+	for _, pt := range ring {
+		if len(pt) < 2 {
+			return fmt.Errorf("point has %d coordinates, want 2", len(pt))
+		}
+		if pt[0] < -180 || pt[0] > 180 || pt[1] < -90 || pt[1] > 90 {
+			return fmt.Errorf("point [%g, %g] is out of range", pt[0], pt[1])
+		}
+	}
+	first, last := ring[0], ring[len(ring)-1]
+	if first[0] != last[0] || first[1] != last[1] {
+		return fmt.Errorf("not closed, the first and last points must match")
+	}
+
+	// Check every pair of edges that don't share a point. This is quadratic,
+	// so callers should limit the size of untrusted input.
+	n := len(ring) - 1 // number of edges
+	for i := 0; i < n; i++ {
+		for j := i + 2; j < n; j++ {
+			if i == 0 && j == n-1 {
+				continue // the first and last edges meet at the closing point
+			}
+			if segmentsIntersect(ring[i], ring[i+1], ring[j], ring[j+1]) {
+				return fmt.Errorf("intersects itself")
+			}
+		}
+	}
+	return nil
+}
+
+// segmentsIntersect reports whether the line segments p1-p2 and p3-p4 touch
+// or cross.
+func segmentsIntersect(p1, p2, p3, p4 []float64) bool {
+	d1 := orientation(p3, p4, p1)
+	d2 := orientation(p3, p4, p2)
+	d3 := orientation(p1, p2, p3)
+	d4 := orientation(p1, p2, p4)
+
+	if ((d1 > 0 && d2 < 0) || (d1 < 0 && d2 > 0)) &&
+		((d3 > 0 && d4 < 0) || (d3 < 0 && d4 > 0)) {
+		return true
+	}
+
+	// Collinear points lying on the other segment
+	return (d1 == 0 && onSegment(p3, p4, p1)) ||
+		(d2 == 0 && onSegment(p3, p4, p2)) ||
+		(d3 == 0 && onSegment(p1, p2, p3)) ||
+		(d4 == 0 && onSegment(p1, p2, p4))
+}
+
+// orientation returns the cross product of (b-a) and (c-a), which is positive
+// if a, b, c turn counter-clockwise, negative if clockwise, and zero if they
+// are collinear.
+func orientation(a, b, c []float64) float64 {
+	return (b[0]-a[0])*(c[1]-a[1]) - (b[1]-a[1])*(c[0]-a[0])
+}
+
+// onSegment reports whether c, which is collinear with a-b, lies between them.
+func onSegment(a, b, c []float64) bool {
+	return math.Min(a[0], b[0]) <= c[0] && c[0] <= math.Max(a[0], b[0]) &&
+		math.Min(a[1], b[1]) <= c[1] && c[1] <= math.Max(a[1], b[1])
+}
+
+// NumPoints returns the total number of points in all the polygons' rings.
+func (p Polygons) NumPoints() int {
+	var n int
+	for _, polygon := range p {
+		for _, ring := range polygon {
+			n += len(ring)
+		}
+	}
+	return n
+}
+
+// Area computes the approximate area in square meters covered by the
+// polygons. Holes are subtracted from the area.
+func (p Polygons) Area() float64 {
+	var area float64
+	for _, polygon := range p {
+		for i, ring := range polygon {
+			if i == 0 {
+				area += math.Abs(ringArea(ring))
+			} else {
+				area -= math.Abs(ringArea(ring))
+			}
+		}
+	}
+	return area
+}
+
+// Area computes the approximate area in square meters covered by a GeoJSON
+// Polygon or MultiPolygon geometry. Holes are subtracted from the area.
+func Area(geom string) (float64, error) {
+	polygons, err := ParsePolygons(geom)
+	if err != nil {
+		return 0, err
+	}
+	return polygons.Area(), nil
+}
+
+// ringArea computes the signed area of a ring of [lng, lat] points on a
+// sphere. Based on "Some Algorithms for Polygons on a Sphere" by Chamberlain
+// and Duquette (JPL Publication 07-03).
+func ringArea(ring [][]float64) float64 {
+	if len(ring) < 3 {
+		return 0
+	}
+
+	rad := func(deg float64) float64 { return deg * math.Pi / 180 }
+
+	var area float64
+	for i := range ring {
+		p1 := ring[i]
+		p2 := ring[(i+1)%len(ring)]
+		if len(p1) < 2 || len(p2) < 2 {
+			continue
+		}
+		area += rad(p2[0]-p1[0]) * (2 + math.Sin(rad(p1[1])) + math.Sin(rad(p2[1])))
+	}
+	return area * EarthRadiusM * EarthRadiusM / 2
+}
@@ -0,0 +1,191 @@
+package geojson
+
+import (
+	"math"
+	"sync"
+	"testing"
+)
+
+func TestCircleGeomNArea(t *testing.T) {
+	const radiusM = 1000.0
+	trueArea := math.Pi * radiusM * radiusM
+
+	areaErr := func(segments int) float64 {
+		area, err := Area(CircleGeomN(37.77, -122.42, radiusM, segments))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return math.Abs(area-trueArea) / trueArea
+	}
+
+	err20 := areaErr(20)
+	err64 := areaErr(64)
+
+	if err64 >= err20 {
+		t.Fatalf("64 segment area error %.4f isn't less than 20 segment error %.4f", err64, err20)
+	}
+	if err64 > 0.01 {
+		t.Fatalf("64 segment area error = %.4f, want < 1%%", err64)
+	}
+}
+
+func TestCircleGeomDefault(t *testing.T) {
+	if got, want := CircleGeom(20, 20, 500), CircleGeomN(20, 20, 500, DefaultSegments); got != want {
+		t.Fatalf("CircleGeom = %s, want CircleGeomN with DefaultSegments = %s", got, want)
+	}
+}
+
+// Run with -race. The service calls CircleGeom from many requests at once.
+func TestCircleGeomConcurrent(t *testing.T) {
+	want := CircleGeom(20, 20, 500)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				if got := CircleGeom(20, 20, 500); got != want {
+					t.Errorf("concurrent CircleGeom = %s, want %s", got, want)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestRectGeom(t *testing.T) {
+	type point struct{ Lat, Lng float64 }
+
+	for _, test := range []struct {
+		Name                           string
+		MinLat, MinLng, MaxLat, MaxLng float64
+
+		Want    string
+		Inside  []point
+		Outside []point
+	}{
+		{
+			Name:   "box",
+			MinLat: 45.9, MinLng: 15.4, MaxLat: 46, MaxLng: 15.6,
+			Want:    `{"coordinates":[[[15.4,45.9],[15.6,45.9],[15.6,46],[15.4,46],[15.4,45.9]]],"type":"Polygon"}`,
+			Inside:  []point{{45.95, 15.5}},
+			Outside: []point{{45.95, 15.7}, {46.1, 15.5}, {45.95, -15.5}},
+		},
+		{
+			Name:   "zero area",
+			MinLat: 20, MinLng: 20, MaxLat: 20, MaxLng: 20,
+			Want:    `{"coordinates":[[[20,20],[20,20],[20,20],[20,20],[20,20]]],"type":"Polygon"}`,
+			Outside: []point{{20.001, 20}, {20, 20.001}},
+		},
+		{
+			Name:   "antimeridian",
+			MinLat: -20, MinLng: 170, MaxLat: -10, MaxLng: -170,
+			Want: `{"coordinates":[` +
+				`[[[170,-20],[180,-20],[180,-10],[170,-10],[170,-20]]],` +
+				`[[[-180,-20],[-170,-20],[-170,-10],[-180,-10],[-180,-20]]]` +
+				`],"type":"MultiPolygon"}`,
+			Inside:  []point{{-15, 175}, {-15, -175}},
+			Outside: []point{{-15, 0}, {-15, 165}, {-15, -165}, {-5, 175}},
+		},
+	} {
+		geom := RectGeom(test.MinLat, test.MinLng, test.MaxLat, test.MaxLng)
+		if geom != test.Want {
+			t.Errorf("%s: RectGeom() = %s, want %s", test.Name, geom, test.Want)
+			continue
+		}
+
+		polygons, err := ParsePolygons(geom)
+		if err != nil {
+			t.Fatalf("%s: %v", test.Name, err)
+		}
+		for _, pt := range test.Inside {
+			if !polygons.Contains(pt.Lat, pt.Lng) {
+				t.Errorf("%s: Contains(%v, %v) = false, want true", test.Name, pt.Lat, pt.Lng)
+			}
+		}
+		for _, pt := range test.Outside {
+			if polygons.Contains(pt.Lat, pt.Lng) {
+				t.Errorf("%s: Contains(%v, %v) = true, want false", test.Name, pt.Lat, pt.Lng)
+			}
+		}
+	}
+}
+
+func TestPolygonsContains(t *testing.T) {
+	polygons, err := ParsePolygons(CircleGeom(20, 20, 1000))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		Lat, Lng float64
+		Want     bool
+	}{
+		{Lat: 20, Lng: 20, Want: true},
+		{Lat: 20.005, Lng: 20, Want: true},
+		{Lat: 20.02, Lng: 20, Want: false},
+		{Lat: -20, Lng: -20, Want: false},
+	} {
+		if got := polygons.Contains(test.Lat, test.Lng); got != test.Want {
+			t.Errorf("Contains(%v, %v) = %v, want %v", test.Lat, test.Lng, got, test.Want)
+		}
+	}
+
+	minLat, minLng, maxLat, maxLng := polygons.BoundingBox()
+	if !(minLat < 20 && 20 < maxLat && minLng < 20 && 20 < maxLng) {
+		t.Errorf("BoundingBox() = %v, %v, %v, %v, want it around (20, 20)", minLat, minLng, maxLat, maxLng)
+	}
+}
+
+func TestPolygonsValidate(t *testing.T) {
+	for _, test := range []struct {
+		Name    string
+		Geom    string
+		WantErr bool
+	}{
+		{
+			Name: "circle",
+			Geom: CircleGeom(20, 20, 1000),
+		},
+		{
+			Name: "square with hole",
+			Geom: `{"type":"Polygon","coordinates":[[[0,0],[4,0],[4,4],[0,4],[0,0]],[[1,1],[2,1],[2,2],[1,2],[1,1]]]}`,
+		},
+		{
+			Name:    "bowtie",
+			Geom:    `{"type":"Polygon","coordinates":[[[0,0],[1,1],[1,0],[0,1],[0,0]]]}`,
+			WantErr: true,
+		},
+		{
+			Name:    "not closed",
+			Geom:    `{"type":"Polygon","coordinates":[[[0,0],[1,0],[1,1],[0,1]]]}`,
+			WantErr: true,
+		},
+		{
+			Name:    "too few points",
+			Geom:    `{"type":"Polygon","coordinates":[[[0,0],[1,0],[0,0]]]}`,
+			WantErr: true,
+		},
+		{
+			Name:    "out of range",
+			Geom:    `{"type":"Polygon","coordinates":[[[0,0],[200,0],[200,1],[0,1],[0,0]]]}`,
+			WantErr: true,
+		},
+		{
+			Name:    "empty",
+			Geom:    `{"type":"MultiPolygon","coordinates":[]}`,
+			WantErr: true,
+		},
+	} {
+		polygons, err := ParsePolygons(test.Geom)
+		if err != nil {
+			t.Fatalf("%s: %v", test.Name, err)
+		}
+		err = polygons.Validate()
+		if gotErr := err != nil; gotErr != test.WantErr {
+			t.Errorf("%s: Validate() = %v, want error: %v", test.Name, err, test.WantErr)
+		}
+	}
+}
@@ -3,6 +3,11 @@ package pg
 import (
 	"context"
 	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"net"
+	"time"
 
 	"github.com/findrandomevents/eventdb/errors"
 	"github.com/lib/pq"
@@ -15,6 +20,10 @@ func pgErr(err error) error {
 		return errors.E(errors.NotExist)
 	}
 
+	if isConnLost(err) {
+		return errors.E(errors.Unavailable, errors.Errorf("database connection lost: %v", err))
+	}
+
 	e, ok := err.(*pq.Error)
 	if !ok {
 		return err
@@ -29,3 +38,131 @@ func pgErr(err error) error {
 		return e
 	}
 }
+
+// isConnLost reports whether err means the connection to Postgres was lost or
+// refused, as when the server restarts. These errors are usually transient.
+func isConnLost(err error) bool {
+	switch err {
+	case driver.ErrBadConn, io.EOF, io.ErrUnexpectedEOF:
+		return true
+	}
+
+	if e, ok := err.(*pq.Error); ok {
+		if e.Code.Class() == "08" { // connection_exception
+			return true
+		}
+		switch e.Code.Name() {
+		case "admin_shutdown", "crash_shutdown", "cannot_connect_now":
+			return true
+		}
+		return false
+	}
+
+	_, ok := err.(net.Error)
+	return ok
+}
+
+// retryRead runs a read-only query, retrying it once if it fails because the
+// connection was lost. database/sql drops broken connections from the pool, so
+// the retry gets a fresh one. f must return errors wrapped by pgErr.
+func retryRead(ctx context.Context, f func() error) error {
+	err := f()
+	if !errors.Is(errors.Unavailable, err) || ctx.Err() != nil {
+		return err
+	}
+	return f()
+}
+
+// maxWaitBackoff caps the delay between pings in WaitForDB.
+const maxWaitBackoff = 5 * time.Second
+
+// WaitForDB pings db until it answers, for when the database starts up after
+// the app does. It waits backoff after the first failed ping, doubling the
+// delay after each one up to five seconds. Only connection errors are retried.
+// It gives up with errors.Unavailable after timeout. backoff must be positive.
+func WaitForDB(ctx context.Context, db *sql.DB, timeout, backoff time.Duration) error {
+	const op errors.Op = "pg.WaitForDB"
+
+	if backoff <= 0 {
+		return errors.E(op, errors.Invalid, "backoff must be positive")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	for {
+		err := db.PingContext(ctx)
+		if err == nil {
+			return nil
+		}
+		if !isConnLost(err) {
+			return errors.E(op, pgErr(err))
+		}
+
+		select {
+		case <-ctx.Done():
+			return errors.E(op, errors.Unavailable, errors.Errorf("database not ready after %v: %v", timeout, err))
+		case <-time.After(backoff):
+		}
+
+		backoff *= 2
+		if backoff > maxWaitBackoff {
+			backoff = maxWaitBackoff
+		}
+	}
+}
+
+// exactCountThreshold is the table size estimate below which countRows does
+// an exact COUNT(*). Small tables are cheap to count and their planner
+// estimates are often stale or missing.
+const exactCountThreshold = 10000
+
+// countRows returns the approximate number of rows in table. It uses the
+// planner's estimate from pg_class so it doesn't scan large tables.
+func countRows(ctx context.Context, db *sql.DB, table string) (int64, error) {
+	var estimate int64
+	err := db.QueryRowContext(ctx, `
+		SELECT GREATEST(reltuples, 0)::bigint
+		FROM pg_class
+		WHERE oid = to_regclass($1)
+	`, table).Scan(&estimate)
+	if err != nil {
+		return 0, pgErr(err)
+	}
+	if estimate >= exactCountThreshold {
+		return estimate, nil
+	}
+
+	var count int64
+	err = db.QueryRowContext(ctx, `SELECT COUNT(*) FROM `+pq.QuoteIdentifier(table)).Scan(&count)
+	if err != nil {
+		return 0, pgErr(err)
+	}
+	return count, nil
+}
+
+// ensureExtension installs the named extension if it isn't already. Creating
+// an extension needs rights that roles on managed databases often lack, so if a
+// DBA has already installed it we don't try.
+func ensureExtension(ctx context.Context, db *sql.DB, name string) error {
+	var exists bool
+	err := db.QueryRowContext(ctx, `
+		SELECT EXISTS (SELECT 1 FROM pg_extension WHERE extname = $1)
+	`, name).Scan(&exists)
+	if err != nil {
+		return pgErr(err)
+	}
+	if exists {
+		return nil
+	}
+
+	_, err = db.ExecContext(ctx, `CREATE EXTENSION IF NOT EXISTS `+pq.QuoteIdentifier(name))
+	if e, ok := err.(*pq.Error); ok && e.Code.Name() == "insufficient_privilege" {
+		msg := fmt.Sprintf("the %s extension isn't installed and this role can't install it; ask a database admin to run CREATE EXTENSION %s", name, name)
+		return errors.E(errors.Permission, fmt.Sprintf("%s: %v", msg, e))
+	}
+	if err != nil {
+		return pgErr(err)
+	}
+	return nil
+}
@@ -0,0 +1,144 @@
+package pg
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/findrandomevents/eventdb/errors"
+	"github.com/lib/pq"
+)
+
+func TestPgErrConnLost(t *testing.T) {
+	for _, test := range []struct {
+		Name string
+		Err  error
+		Want errors.Kind
+	}{
+		{Name: "connection failure", Err: &pq.Error{Code: "08006"}, Want: errors.Unavailable},
+		{Name: "connection does not exist", Err: &pq.Error{Code: "08003"}, Want: errors.Unavailable},
+		{Name: "admin shutdown", Err: &pq.Error{Code: "57P01"}, Want: errors.Unavailable},
+		{Name: "bad conn", Err: driver.ErrBadConn, Want: errors.Unavailable},
+		{Name: "unexpected EOF", Err: io.ErrUnexpectedEOF, Want: errors.Unavailable},
+		{Name: "unique violation", Err: &pq.Error{Code: "23505"}, Want: errors.Exist},
+	} {
+		if err := pgErr(test.Err); !errors.Is(test.Want, err) {
+			t.Errorf("%s: pgErr(%v) = %v, want %v", test.Name, test.Err, err, test.Want)
+		}
+	}
+
+	if err := pgErr(&pq.Error{Code: "42601"}); errors.Is(errors.Unavailable, err) {
+		t.Errorf("pgErr(syntax_error) = %v, want it not to be %v", err, errors.Unavailable)
+	}
+}
+
+func TestRetryRead(t *testing.T) {
+	ctx := context.Background()
+
+	// A lost connection is retried once
+	var calls int
+	err := retryRead(ctx, func() error {
+		calls++
+		if calls == 1 {
+			return pgErr(driver.ErrBadConn)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("retryRead after one lost connection: %v", err)
+	}
+	if calls != 2 {
+		t.Fatalf("retryRead called f %d times, want 2", calls)
+	}
+
+	// But only once
+	calls = 0
+	err = retryRead(ctx, func() error {
+		calls++
+		return pgErr(io.EOF)
+	})
+	if !errors.Is(errors.Unavailable, err) {
+		t.Fatalf("retryRead with the connection down = %v, want %v", err, errors.Unavailable)
+	}
+	if calls != 2 {
+		t.Fatalf("retryRead called f %d times, want 2", calls)
+	}
+
+	// Other errors aren't retried
+	calls = 0
+	retryRead(ctx, func() error {
+		calls++
+		return pgErr(&pq.Error{Code: "42601"})
+	})
+	if calls != 1 {
+		t.Fatalf("retryRead retried a syntax error %d times, want no retries", calls-1)
+	}
+}
+
+// lateConnector is a driver.Connector for a database that refuses
+// connections until ready.
+type lateConnector struct {
+	ready time.Time
+
+	mu       sync.Mutex
+	attempts int
+}
+
+func (c *lateConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	c.mu.Lock()
+	c.attempts++
+	c.mu.Unlock()
+
+	if time.Now().Before(c.ready) {
+		return nil, &net.OpError{Op: "dial", Net: "tcp", Err: errors.Str("connection refused")}
+	}
+	return stubConn{}, nil
+}
+
+func (c *lateConnector) Driver() driver.Driver { return nil }
+
+type stubConn struct{}
+
+func (stubConn) Prepare(query string) (driver.Stmt, error) { return nil, errors.Str("not implemented") }
+func (stubConn) Close() error                              { return nil }
+func (stubConn) Begin() (driver.Tx, error)                 { return nil, errors.Str("not implemented") }
+
+func TestWaitForDB(t *testing.T) {
+	ctx := context.Background()
+
+	// The database comes up a little after we start waiting
+	connector := &lateConnector{ready: time.Now().Add(100 * time.Millisecond)}
+	db := sql.OpenDB(connector)
+	defer db.Close()
+
+	if err := WaitForDB(ctx, db, 5*time.Second, 10*time.Millisecond); err != nil {
+		t.Fatalf("WaitForDB with a database that comes up late: %v", err)
+	}
+	if connector.attempts < 2 {
+		t.Fatalf("WaitForDB connected in %d attempts, want it to retry", connector.attempts)
+	}
+
+	// It never comes up
+	connector = &lateConnector{ready: time.Now().Add(time.Hour)}
+	db = sql.OpenDB(connector)
+	defer db.Close()
+
+	err := WaitForDB(ctx, db, 100*time.Millisecond, 10*time.Millisecond)
+	if !errors.Is(errors.Unavailable, err) {
+		t.Fatalf("WaitForDB with a database that never comes up = %v, want %v", err, errors.Unavailable)
+	}
+	if want := "database not ready after 100ms"; !strings.Contains(err.Error(), want) {
+		t.Fatalf("WaitForDB error %q doesn't say %q", err, want)
+	}
+
+	// No backoff would ping in a hot loop
+	if err := WaitForDB(ctx, db, 100*time.Millisecond, 0); !errors.Is(errors.Invalid, err) {
+		t.Fatalf("WaitForDB with no backoff = %v, want %v", err, errors.Invalid)
+	}
+}
@@ -5,14 +5,41 @@ import (
 	"database/sql"
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/findrandomevents/eventdb"
 	"github.com/findrandomevents/eventdb/errors"
+	"github.com/lib/pq"
 )
 
 // DestStore stores and retrives Dests from a PostgreSQL database.
 type DestStore struct {
 	DB *sql.DB
+
+	// ReadDB, if set, is used instead of DB for reads that can tolerate
+	// replication lag.
+	ReadDB *sql.DB
+
+	// EventStore is where the events side-loaded by GetWithEvent and
+	// ListForUserWithEvents are stored. If it's nil they're read as if by an
+	// EventStore with the default options.
+	EventStore *EventStore
+}
+
+// readDB returns the database used for lag-tolerant reads.
+func (s *DestStore) readDB() *sql.DB {
+	if s.ReadDB != nil {
+		return s.ReadDB
+	}
+	return s.DB
+}
+
+// events returns the EventStore used to read side-loaded events.
+func (s *DestStore) events() *EventStore {
+	if s.EventStore != nil {
+		return s.EventStore
+	}
+	return &EventStore{}
 }
 
 // Init sets up the database schema.
@@ -32,7 +59,28 @@ func (s *DestStore) Init(ctx context.Context) error {
 
 	   created_at     TIMESTAMP     NOT NULL DEFAULT NOW()
 	);
-	CREATE UNIQUE INDEX IF NOT EXISTS dest_id_idx ON dests (id);`)
+	CREATE UNIQUE INDEX IF NOT EXISTS dest_id_idx ON dests (id);
+
+	-- Speeds up ListForUser and LatestForUser, which would otherwise scan and
+	-- sort the whole table
+	CREATE INDEX IF NOT EXISTS dest_user_created_idx
+	ON dests (user_id, created_at DESC, sequence DESC);
+
+	-- Speeds up the attended dest check in EventStore.Search
+	CREATE INDEX IF NOT EXISTS dest_user_status_idx ON dests (user_id, status);
+
+	-- Speeds up the already chosen check in EventStore.Search
+	CREATE INDEX IF NOT EXISTS dest_user_event_idx ON dests (user_id, event_id);
+
+	-- Speeds up FeedbackForEvent
+	CREATE INDEX IF NOT EXISTS dest_event_idx ON dests (event_id);
+
+	-- Held by LockUser
+	CREATE TABLE IF NOT EXISTS dest_locks (
+		user_id     text         PRIMARY KEY,
+		token       text         NOT NULL,
+		expires_at  timestamptz  NOT NULL
+	);`)
 	if err != nil {
 		return errors.E(op, pgErr(err))
 	}
@@ -76,6 +124,184 @@ func (s *DestStore) Create(ctx context.Context, dest eventdb.Dest) (eventdb.Dest
 	return s.Get(ctx, destID)
 }
 
+// CreateMulti saves a new Dest for each of eventIDs in a single transaction.
+// The dests are created in order, so the last one is the newest.
+func (s *DestStore) CreateMulti(ctx context.Context, userID eventdb.UserID, eventIDs []eventdb.EventID) ([]eventdb.Dest, error) {
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, pgErr(err)
+	}
+	defer tx.Rollback()
+
+	var destIDs pq.StringArray
+	for _, eventID := range eventIDs {
+		var sequence int64
+		err := tx.QueryRowContext(ctx, `
+		INSERT INTO dests
+			(user_id, event_id)
+		VALUES
+			($1, $2)
+		RETURNING sequence`, userID, eventID).Scan(&sequence)
+		if err != nil {
+			return nil, errors.E(pgErr(err), "get dest id")
+		}
+
+		destID := fmt.Sprint(sequence)
+		_, err = tx.ExecContext(ctx, `
+		UPDATE dests
+		SET id = $1
+		WHERE sequence = $2`, destID, sequence)
+		if err != nil {
+			return nil, errors.E(pgErr(err), "set dest hash id")
+		}
+		destIDs = append(destIDs, destID)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, pgErr(err)
+	}
+
+	return s.list(ctx, `
+		WHERE id = ANY ($1)
+		ORDER BY sequence ASC
+		`, destIDs)
+}
+
+const (
+	// userLockTTL is how long a lock from LockUser lasts if it's never
+	// released, say because the server died while holding it.
+	userLockTTL = time.Minute
+	// userLockPoll is how often LockUser checks whether a held lock is free.
+	userLockPoll = 50 * time.Millisecond
+)
+
+// LockUser takes a lock keyed by userID, blocking until it's available or ctx
+// is done. It's used to keep concurrent dest generation requests for the same
+// user (say, on their phone and laptop) from choosing the same event. Call the
+// returned function to release the lock.
+//
+// The lock is a row in dest_locks, so it works across server instances without
+// tying up a pooled connection while it's held.
+func (s *DestStore) LockUser(ctx context.Context, userID eventdb.UserID) (unlock func(), err error) {
+	const op errors.Op = "DestStore.LockUser"
+
+	var token string
+	for {
+		// Take the lock if nobody has it or the last holder's expired
+		err := s.DB.QueryRowContext(ctx, `
+		INSERT INTO dest_locks (user_id, token, expires_at)
+		VALUES ($1, md5(random()::text || clock_timestamp()::text), clock_timestamp() + make_interval(secs => $2))
+		ON CONFLICT (user_id) DO UPDATE
+		SET token = EXCLUDED.token, expires_at = EXCLUDED.expires_at
+		WHERE dest_locks.expires_at < clock_timestamp()
+		RETURNING token`, userID, userLockTTL.Seconds()).Scan(&token)
+		if err == nil {
+			break
+		}
+		if err != sql.ErrNoRows {
+			return nil, errors.E(op, pgErr(err))
+		}
+
+		select {
+		case <-time.After(userLockPoll):
+		case <-ctx.Done():
+			return nil, errors.E(op, ctx.Err())
+		}
+	}
+
+	return func() {
+		// Release the lock even if ctx was canceled while it was held
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		s.DB.ExecContext(ctx, `
+		DELETE FROM dest_locks
+		WHERE user_id = $1 AND token = $2`, userID, token)
+	}, nil
+}
+
+// Count returns the approximate number of dests stored.
+func (s *DestStore) Count(ctx context.Context) (int64, error) {
+	const op errors.Op = "DestStore.Count"
+
+	count, err := countRows(ctx, s.readDB(), "dests")
+	if err != nil {
+		return 0, errors.E(op, err)
+	}
+	return count, nil
+}
+
+// CountSince returns the number of dests created for the user at or after
+// since.
+func (s *DestStore) CountSince(ctx context.Context, userID eventdb.UserID, since time.Time) (int, error) {
+	var count int
+	// created_at has no time zone, so since is converted to the session's
+	// local time to match NOW(). Otherwise its offset would be dropped.
+	err := s.DB.QueryRowContext(ctx, `
+		SELECT COUNT(*)
+		FROM dests
+		WHERE user_id = $1
+		AND created_at >= $2::timestamptz::timestamp
+	`, userID, since).Scan(&count)
+	if err != nil {
+		return 0, pgErr(err)
+	}
+	return count, nil
+}
+
+// FeedbackForEvent sums up the statuses and feedback on all users' dests for
+// an event.
+func (s *DestStore) FeedbackForEvent(ctx context.Context, eventID eventdb.EventID) (summary eventdb.FeedbackSummary, err error) {
+	err = retryRead(ctx, func() error {
+		summary, err = s.queryFeedbackForEvent(ctx, eventID)
+		return err
+	})
+	return summary, err
+}
+
+// queryFeedbackForEvent runs the query for FeedbackForEvent.
+func (s *DestStore) queryFeedbackForEvent(ctx context.Context, eventID eventdb.EventID) (eventdb.FeedbackSummary, error) {
+	summary := eventdb.FeedbackSummary{
+		EventID:  eventID,
+		Statuses: make(map[string]int),
+	}
+
+	rows, err := s.readDB().QueryContext(ctx, `
+		SELECT
+			COALESCE(status, ''),
+			COUNT(*),
+			COUNT(NULLIF(feedback, ''))
+		FROM dests
+		WHERE event_id = $1
+		GROUP BY 1
+	`, eventID)
+	if err != nil {
+		return summary, errors.E(pgErr(err), "dest feedback")
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			status       string
+			count        int
+			withFeedback int
+		)
+		if err := rows.Scan(&status, &count, &withFeedback); err != nil {
+			return summary, pgErr(err)
+		}
+
+		summary.Dests += count
+		summary.WithFeedback += withFeedback
+		if status != "" {
+			summary.Statuses[status] = count
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return summary, pgErr(err)
+	}
+
+	return summary, nil
+}
+
 // Get retrieves a Dest by ID.
 func (s *DestStore) Get(ctx context.Context, id eventdb.DestID) (eventdb.Dest, error) {
 	dests, err := s.list(ctx, "WHERE id = $1", id)
@@ -90,13 +316,26 @@ func (s *DestStore) Get(ctx context.Context, id eventdb.DestID) (eventdb.Dest, e
 	return dest, nil
 }
 
+// GetWithEvent is like Get, but also loads the dest's event in the same
+// query. The dest's Event is nil if the event isn't stored.
+func (s *DestStore) GetWithEvent(ctx context.Context, id eventdb.DestID) (eventdb.Dest, error) {
+	dests, err := s.listWithEvents(ctx, "WHERE dests.id = $1", id)
+	if err != nil {
+		return eventdb.Dest{}, err
+	}
+	if len(dests) == 0 {
+		return eventdb.Dest{}, errors.E(errors.NotExist, "dest not found")
+	}
+	return dests[0], nil
+}
+
 // Update applies a DestUpdate to the given Dest, then returns the result.
 func (s *DestStore) Update(ctx context.Context, id eventdb.DestID, update eventdb.DestUpdate) (eventdb.Dest, error) {
 	fields := []string{"id"}
 	args := []interface{}{id}
 
 	for _, field := range strings.Split(update.Mask, ",") {
-		switch field {
+		switch strings.TrimSpace(field) {
 		case "feedback":
 			fields = append(fields, "feedback")
 			args = append(args, update.Feedback)
@@ -104,6 +343,11 @@ func (s *DestStore) Update(ctx context.Context, id eventdb.DestID, update eventd
 		case "status":
 			fields = append(fields, "status")
 			args = append(args, update.Status)
+
+		case "": // allow trailing commas
+
+		default:
+			return eventdb.Dest{}, errors.E(errors.Invalid, fmt.Sprintf("unknown mask field %q", field))
 		}
 	}
 
@@ -131,22 +375,146 @@ func (s *DestStore) Update(ctx context.Context, id eventdb.DestID, update eventd
 	return dest, nil
 }
 
+const (
+	defaultDestPageSize = 10
+	maxDestPageSize     = 100
+)
+
+// destPageSize returns the number of dests to list per page for a requested
+// page size. Zero means the default and sizes over the max are clamped.
+func destPageSize(size int) int {
+	if size <= 0 {
+		return defaultDestPageSize
+	}
+	if size > maxDestPageSize {
+		return maxDestPageSize
+	}
+	return size
+}
+
 // ListForUser returns all of a user's dests, ordered by creation date.
 func (s *DestStore) ListForUser(ctx context.Context, userID eventdb.UserID, opts eventdb.DestListRequest) ([]eventdb.Dest, error) {
-	const pageSize = 10
-
-	offset := opts.Page * pageSize
-	limit := pageSize
+	limit := destPageSize(opts.PageSize)
+	offset := opts.Page * limit
 
 	return s.list(ctx, `
 		WHERE user_id = $1
-		ORDER BY created_at DESC
+		ORDER BY created_at DESC, sequence DESC
 		OFFSET $2
 		LIMIT $3
 		`, userID, offset, limit)
 }
 
-func (s *DestStore) list(ctx context.Context, expr string, vals ...interface{}) ([]eventdb.Dest, error) {
+// ListForUserWithEvents is like ListForUser, but also loads each dest's event
+// in the same query. A dest's Event is nil if the event isn't stored. If
+// opts.OnlyUpcoming is set, only dests whose events end after now are listed.
+func (s *DestStore) ListForUserWithEvents(ctx context.Context, userID eventdb.UserID, opts eventdb.DestListRequest, now time.Time) ([]eventdb.Dest, error) {
+	limit := destPageSize(opts.PageSize)
+	offset := opts.Page * limit
+
+	where := `WHERE dests.user_id = $1`
+	args := []interface{}{userID, offset, limit}
+	if opts.OnlyUpcoming {
+		where += ` AND f_event_end_time(events.data) > $4`
+		args = append(args, now)
+	}
+
+	return s.listWithEvents(ctx, where+`
+		ORDER BY dests.created_at DESC, dests.sequence DESC
+		OFFSET $2
+		LIMIT $3
+		`, args...)
+}
+
+// LatestForUser returns the user's most recently created dest.
+func (s *DestStore) LatestForUser(ctx context.Context, userID eventdb.UserID) (eventdb.Dest, error) {
+	dests, err := s.list(ctx, `
+		WHERE user_id = $1
+		ORDER BY created_at DESC, sequence DESC
+		LIMIT 1
+		`, userID)
+	if err != nil {
+		return eventdb.Dest{}, err
+	}
+	if len(dests) == 0 {
+		return eventdb.Dest{}, errors.E(errors.NotExist, "no dests")
+	}
+	return dests[0], nil
+}
+
+func (s *DestStore) listWithEvents(ctx context.Context, expr string, vals ...interface{}) (dests []eventdb.Dest, err error) {
+	err = retryRead(ctx, func() error {
+		dests, err = s.queryListWithEvents(ctx, expr, vals...)
+		return err
+	})
+	return dests, err
+}
+
+// queryListWithEvents runs the query for listWithEvents.
+func (s *DestStore) queryListWithEvents(ctx context.Context, expr string, vals ...interface{}) ([]eventdb.Dest, error) {
+	query := fmt.Sprintf(`
+	SELECT
+		dests.id,
+		dests.user_id,
+		dests.event_id,
+		COALESCE(dests.feedback, ''),
+		COALESCE(dests.status, ''),
+		dests.created_at,
+
+		events.id IS NOT NULL,
+		%s
+	FROM dests
+	LEFT JOIN events ON events.id = dests.event_id
+	%s`, s.events().eventColumnsSQL(), expr)
+
+	rows, err := s.DB.QueryContext(ctx, query, vals...)
+	if err != nil {
+		return nil, errors.E(pgErr(err), "dest list")
+	}
+	defer rows.Close()
+
+	dests := []eventdb.Dest{}
+	for rows.Next() {
+		var dest eventdb.Dest
+		var hasEvent bool
+		var event eventdb.Event
+		var timezone string
+
+		scanDest := []interface{}{
+			&dest.ID,
+			&dest.UserID,
+			&dest.EventID,
+			&dest.Feedback,
+			&dest.Status,
+			&dest.CreatedAt,
+			&hasEvent,
+		}
+		if err := rows.Scan(append(scanDest, eventScanDest(&event, &timezone)...)...); err != nil {
+			return nil, pgErr(err)
+		}
+		if hasEvent {
+			setEventLocation(&event, timezone, false)
+			dest.Event = &event
+		}
+		dests = append(dests, dest)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, pgErr(err)
+	}
+
+	return dests, nil
+}
+
+func (s *DestStore) list(ctx context.Context, expr string, vals ...interface{}) (dests []eventdb.Dest, err error) {
+	err = retryRead(ctx, func() error {
+		dests, err = s.queryList(ctx, expr, vals...)
+		return err
+	})
+	return dests, err
+}
+
+// queryList runs the query for list.
+func (s *DestStore) queryList(ctx context.Context, expr string, vals ...interface{}) ([]eventdb.Dest, error) {
 	query := fmt.Sprintf(`
 	SELECT
 		id,
@@ -176,10 +544,13 @@ func (s *DestStore) list(ctx context.Context, expr string, vals ...interface{})
 			&dest.CreatedAt,
 		)
 		if err != nil {
-			return nil, err
+			return nil, pgErr(err)
 		}
 		dests = append(dests, dest)
 	}
+	if err := rows.Err(); err != nil {
+		return nil, pgErr(err)
+	}
 
 	return dests, nil
 }
@@ -2,12 +2,16 @@ package pg
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/go-test/deep"
 
 	"github.com/findrandomevents/eventdb"
+	"github.com/findrandomevents/eventdb/errors"
 	"github.com/findrandomevents/eventdb/pg/pgtest"
 )
 
@@ -61,6 +65,217 @@ func TestDestStoreList(t *testing.T) {
 	}
 }
 
+func TestDestStoreListPageSize(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	dbx := pgtest.NewDB(t)
+	destStore := &DestStore{DB: dbx}
+	if err := destStore.Init(ctx); err != nil {
+		t.Fatalf("DestStore.Init: %v", err)
+	}
+
+	for i := 0; i < 120; i++ {
+		_, err := destStore.Create(ctx, eventdb.Dest{
+			UserID:  "user1",
+			EventID: eventdb.EventID(fmt.Sprintf("event-%d", i)),
+		})
+		if err != nil {
+			t.Fatalf("DestStore.Create: %v", err)
+		}
+	}
+
+	for _, tt := range []struct {
+		name     string
+		opts     eventdb.DestListRequest
+		expected int
+	}{
+		{"default", eventdb.DestListRequest{}, 10},
+		{"custom", eventdb.DestListRequest{PageSize: 25}, 25},
+		{"clamped", eventdb.DestListRequest{PageSize: 1000}, 100},
+		{"last page", eventdb.DestListRequest{Page: 4, PageSize: 25}, 20},
+	} {
+		dests, err := destStore.ListForUser(ctx, "user1", tt.opts)
+		if err != nil {
+			t.Fatalf("%s: DestStore.ListForUser: %v", tt.name, err)
+		}
+		if got, want := len(dests), tt.expected; got != want {
+			t.Errorf("%s: ListForUser got %d dests, want %d", tt.name, got, want)
+		}
+	}
+}
+
+func TestDestStoreListUsesIndex(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	dbx := pgtest.NewDB(t)
+	destStore := &DestStore{DB: dbx}
+	if err := destStore.Init(ctx); err != nil {
+		t.Fatalf("DestStore.Init: %v", err)
+	}
+
+	for i := 0; i < 50; i++ {
+		_, err := destStore.Create(ctx, eventdb.Dest{
+			UserID:  eventdb.UserID(fmt.Sprintf("user%d", i%5)),
+			EventID: eventdb.EventID(fmt.Sprintf("event-%d", i)),
+		})
+		if err != nil {
+			t.Fatalf("DestStore.Create: %v", err)
+		}
+	}
+	if _, err := dbx.ExecContext(ctx, `ANALYZE dests`); err != nil {
+		t.Fatal(err)
+	}
+
+	// The table is small enough that the planner would rather scan it, so
+	// discourage that on one connection to see if the index is usable.
+	conn, err := dbx.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := conn.ExecContext(ctx, `SET enable_seqscan = off`); err != nil {
+		t.Fatal(err)
+	}
+
+	rows, err := conn.QueryContext(ctx, `
+		EXPLAIN SELECT * FROM dests
+		WHERE user_id = $1
+		ORDER BY created_at DESC, sequence DESC
+		OFFSET 0
+		LIMIT 10
+		`, "user1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+
+	var plan []string
+	for rows.Next() {
+		var line string
+		if err := rows.Scan(&line); err != nil {
+			t.Fatal(err)
+		}
+		plan = append(plan, line)
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	explain := strings.Join(plan, "\n")
+	if !strings.Contains(explain, "dest_user_created_idx") {
+		t.Fatalf("ListForUser query doesn't use dest_user_created_idx:\n%s", explain)
+	}
+	if strings.Contains(explain, "Sort") {
+		t.Fatalf("ListForUser query sorts instead of reading the index in order:\n%s", explain)
+	}
+}
+
+func TestDestStoreLatestForUser(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	dbx := pgtest.NewDB(t)
+	destStore := &DestStore{DB: dbx}
+	if err := destStore.Init(ctx); err != nil {
+		t.Fatalf("DestStore.Init: %v", err)
+	}
+
+	if _, err := destStore.LatestForUser(ctx, "user1"); !errors.Is(errors.NotExist, err) {
+		t.Fatalf("LatestForUser with no dests got %v, want %v", err, errors.NotExist)
+	}
+
+	var newest eventdb.Dest
+	for i := 0; i < 3; i++ {
+		dest, err := destStore.Create(ctx, eventdb.Dest{
+			UserID:  "user1",
+			EventID: eventdb.EventID(fmt.Sprintf("event-%d", i)),
+		})
+		if err != nil {
+			t.Fatalf("DestStore.Create: %v", err)
+		}
+		newest = dest
+	}
+	// Someone else's dest doesn't count
+	if _, err := destStore.Create(ctx, eventdb.Dest{UserID: "user2", EventID: "other"}); err != nil {
+		t.Fatalf("DestStore.Create: %v", err)
+	}
+
+	latest, err := destStore.LatestForUser(ctx, "user1")
+	if err != nil {
+		t.Fatalf("DestStore.LatestForUser: %v", err)
+	}
+	if diff := deep.Equal(latest, newest); diff != nil {
+		t.Fatalf("DestStore.LatestForUser(); %v", diff)
+	}
+}
+
+func TestDestStoreCountSince(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	dbx := pgtest.NewDB(t)
+	destStore := &DestStore{DB: dbx}
+	if err := destStore.Init(ctx); err != nil {
+		t.Fatalf("DestStore.Init: %v", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		_, err := destStore.Create(ctx, eventdb.Dest{
+			UserID:  "user1",
+			EventID: eventdb.EventID(fmt.Sprintf("event-%d", i)),
+		})
+		if err != nil {
+			t.Fatalf("DestStore.Create: %v", err)
+		}
+	}
+	// Someone else's dest doesn't count
+	if _, err := destStore.Create(ctx, eventdb.Dest{UserID: "user2", EventID: "other"}); err != nil {
+		t.Fatalf("DestStore.Create: %v", err)
+	}
+
+	// Far east of UTC, so dropping the offset would put since in the future
+	east := time.FixedZone("UTC+10", 10*60*60)
+	for _, test := range []struct {
+		Name  string
+		Since time.Time
+		Want  int
+	}{
+		{
+			Name:  "an hour ago",
+			Since: time.Now().Add(-time.Hour),
+			Want:  2,
+		},
+		{
+			Name:  "an hour ago in another time zone",
+			Since: time.Now().Add(-time.Hour).In(east),
+			Want:  2,
+		},
+		{
+			Name:  "an hour from now",
+			Since: time.Now().Add(time.Hour),
+			Want:  0,
+		},
+	} {
+		count, err := destStore.CountSince(ctx, "user1", test.Since)
+		if err != nil {
+			t.Fatalf("%s: DestStore.CountSince: %v", test.Name, err)
+		}
+		if got, want := count, test.Want; got != want {
+			t.Errorf("%s: CountSince got %d, want %d", test.Name, got, want)
+		}
+	}
+}
+
 func TestDestStoreUpdate(t *testing.T) {
 	t.Parallel()
 
@@ -99,3 +314,159 @@ func TestDestStoreUpdate(t *testing.T) {
 		t.Fatalf("updated: got feedback %q, want %q", got, want)
 	}
 }
+
+func TestDestStoreWithEvents(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	dbx := pgtest.NewDB(t)
+	eventStore := &EventStore{DB: dbx}
+	if err := eventStore.Init(ctx); err != nil {
+		t.Fatalf("EventStore.Init: %v", err)
+	}
+	destStore := &DestStore{DB: dbx, EventStore: eventStore}
+	if err := destStore.Init(ctx); err != nil {
+		t.Fatalf("DestStore.Init: %v", err)
+	}
+
+	_, err := eventStore.Save(ctx, json.RawMessage(`{
+		"id": "event1",
+		"name": "Event One",
+		"start_time": "2000-01-01T12:00:00+0100",
+		"end_time": "2000-01-01T14:00:00+0100",
+		"timezone": "Europe/Berlin",
+		"place": {
+			"location": {
+				"street": "street addr",
+				"latitude": 20,
+				"longitude": 30
+			}
+		}
+	}`))
+	if err != nil {
+		t.Fatalf("EventStore.Save: %v", err)
+	}
+
+	stored, err := destStore.Create(ctx, eventdb.Dest{UserID: "user1", EventID: "event1"})
+	if err != nil {
+		t.Fatalf("DestStore.Create: %v", err)
+	}
+	missing, err := destStore.Create(ctx, eventdb.Dest{UserID: "user1", EventID: "missing"})
+	if err != nil {
+		t.Fatalf("DestStore.Create: %v", err)
+	}
+
+	want, err := eventStore.GetByID(ctx, "event1")
+	if err != nil {
+		t.Fatalf("EventStore.GetByID: %v", err)
+	}
+
+	dest, err := destStore.GetWithEvent(ctx, stored.ID)
+	if err != nil {
+		t.Fatalf("DestStore.GetWithEvent: %v", err)
+	}
+	if dest.Event == nil {
+		t.Fatal("GetWithEvent didn't load the event")
+	}
+	if diff := deep.Equal(*dest.Event, want); diff != nil {
+		t.Fatalf("GetWithEvent event: %v", diff)
+	}
+
+	dest, err = destStore.GetWithEvent(ctx, missing.ID)
+	if err != nil {
+		t.Fatalf("DestStore.GetWithEvent: %v", err)
+	}
+	if dest.Event != nil {
+		t.Fatalf("GetWithEvent for a missing event got %+v, want nil", dest.Event)
+	}
+
+	if _, err := destStore.GetWithEvent(ctx, "no-such-dest"); !errors.Is(errors.NotExist, err) {
+		t.Fatalf("GetWithEvent for a missing dest got error %v, want NotExist", err)
+	}
+
+	dests, err := destStore.ListForUserWithEvents(ctx, "user1", eventdb.DestListRequest{}, time.Time{})
+	if err != nil {
+		t.Fatalf("DestStore.ListForUserWithEvents: %v", err)
+	}
+	if got, want := len(dests), 2; got != want {
+		t.Fatalf("ListForUserWithEvents got %d dests, want %d", got, want)
+	}
+	// Newest first
+	if dests[0].ID != missing.ID || dests[0].Event != nil {
+		t.Fatalf("ListForUserWithEvents first dest = %+v, want the one with the missing event", dests[0])
+	}
+	if dests[1].ID != stored.ID || dests[1].Event == nil {
+		t.Fatalf("ListForUserWithEvents second dest = %+v, want the one with event1", dests[1])
+	}
+	if diff := deep.Equal(*dests[1].Event, want); diff != nil {
+		t.Fatalf("ListForUserWithEvents event: %v", diff)
+	}
+}
+
+func TestDestStoreFeedbackForEvent(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	dbx := pgtest.NewDB(t)
+	destStore := &DestStore{DB: dbx}
+	if err := destStore.Init(ctx); err != nil {
+		t.Fatalf("DestStore.Init: %v", err)
+	}
+
+	for _, d := range []struct {
+		user     eventdb.UserID
+		event    eventdb.EventID
+		status   string
+		feedback string
+	}{
+		{"user1", "event1", "liked", "great band"},
+		{"user2", "event1", "liked", ""},
+		{"user3", "event1", "disliked", "it was cancelled"},
+		{"user4", "event1", "", ""},
+		{"user5", "event1", "went", ""},
+		{"user1", "event2", "disliked", "boring"},
+	} {
+		dest, err := destStore.Create(ctx, eventdb.Dest{UserID: d.user, EventID: d.event})
+		if err != nil {
+			t.Fatalf("DestStore.Create: %v", err)
+		}
+		_, err = destStore.Update(ctx, dest.ID, eventdb.DestUpdate{
+			Status:   d.status,
+			Feedback: d.feedback,
+			Mask:     "status,feedback",
+		})
+		if err != nil {
+			t.Fatalf("DestStore.Update: %v", err)
+		}
+	}
+
+	summary, err := destStore.FeedbackForEvent(ctx, "event1")
+	if err != nil {
+		t.Fatalf("DestStore.FeedbackForEvent: %v", err)
+	}
+	expected := eventdb.FeedbackSummary{
+		EventID: "event1",
+		Dests:   5,
+		Statuses: map[string]int{
+			"liked":    2,
+			"disliked": 1,
+			"went":     1,
+		},
+		WithFeedback: 2,
+	}
+	if diff := deep.Equal(summary, expected); diff != nil {
+		t.Fatalf("DestStore.FeedbackForEvent: %v", diff)
+	}
+
+	summary, err = destStore.FeedbackForEvent(ctx, "no-dests")
+	if err != nil {
+		t.Fatalf("DestStore.FeedbackForEvent with no dests: %v", err)
+	}
+	if summary.Dests != 0 || len(summary.Statuses) != 0 {
+		t.Fatalf("DestStore.FeedbackForEvent with no dests = %+v, want empty", summary)
+	}
+}
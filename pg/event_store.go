@@ -4,26 +4,80 @@ import (
 	"context"
 	"database/sql"
 	"encoding/json"
+	"fmt"
+	"math"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/findrandomevents/eventdb"
 	"github.com/findrandomevents/eventdb/errors"
+	"github.com/findrandomevents/eventdb/geojson"
+	"github.com/findrandomevents/eventdb/log"
 
 	"github.com/lib/pq"
+	"go.uber.org/zap"
 )
 
 // EventStore stores and retrives Events from a PostgreSQL database. Events are
 // stored as raw Graph API responses in a Postgres JSON database.
 type EventStore struct {
 	DB *sql.DB
+
+	// ReadDB, if set, is used instead of DB for searches and lookups. Point it
+	// at a read replica to keep heavy searches from competing with writes.
+	ReadDB *sql.DB
+
+	// NoPostGIS stores and searches events using plain latitude and
+	// longitude columns, for databases without the PostGIS extension. Bounds
+	// are checked in Go, so it's only practical for small datasets.
+	NoPostGIS bool
+
+	// FetchBatchSize caps how many events are looked up by ID in one query.
+	// Bigger lookups are split into several queries. It defaults to 500.
+	FetchBatchSize int
+}
+
+const defaultFetchBatchSize = 500
+
+// batches splits eventIDs into runs of at most FetchBatchSize.
+func (e *EventStore) batches(eventIDs []eventdb.EventID) [][]eventdb.EventID {
+	size := e.FetchBatchSize
+	if size <= 0 {
+		size = defaultFetchBatchSize
+	}
+
+	var batches [][]eventdb.EventID
+	for len(eventIDs) > size {
+		batches = append(batches, eventIDs[:size])
+		eventIDs = eventIDs[size:]
+	}
+	return append(batches, eventIDs)
+}
+
+// readDB returns the database used for reads.
+func (e *EventStore) readDB() *sql.DB {
+	if e.ReadDB != nil {
+		return e.ReadDB
+	}
+	return e.DB
 }
 
 // Init sets up the database schema and creates indices.
 func (e *EventStore) Init(ctx context.Context) error {
 	const op errors.Op = "EventStore.Init"
 
+	extensions := []string{"pg_trgm"}
+	if !e.NoPostGIS {
+		extensions = append(extensions, "postgis")
+	}
+	for _, ext := range extensions {
+		if err := ensureExtension(ctx, e.DB, ext); err != nil {
+			return errors.E(op, err)
+		}
+	}
+
 	_, err := e.DB.ExecContext(ctx, `
-	CREATE EXTENSION IF NOT EXISTS postgis;
 
 	-- Create a timestamptz from a text timestamp
 	--
@@ -61,6 +115,32 @@ func (e *EventStore) Init(ctx context.Context) error {
 	LANGUAGE sql
 	IMMUTABLE;
 
+	-- The place name and street address, for text search by place
+	CREATE OR REPLACE FUNCTION f_event_place_text(jsonb)
+	RETURNS text AS $$
+		SELECT concat_ws(' ',
+			$1->'place'->>'name',
+			$1->'place'->'location'->>'street'
+		)
+	$$
+	LANGUAGE sql
+	IMMUTABLE;
+
+	-- The event's name and description, for keyword search
+	CREATE OR REPLACE FUNCTION f_event_text(jsonb)
+	RETURNS text AS $$
+		SELECT concat_ws(' ', $1->>'name', $1->>'description')
+	$$
+	LANGUAGE sql
+	IMMUTABLE;
+
+	CREATE OR REPLACE FUNCTION f_event_tsv(jsonb)
+	RETURNS tsvector AS $$
+		SELECT to_tsvector('english'::regconfig, f_event_text($1))
+	$$
+	LANGUAGE sql
+	IMMUTABLE;
+
 	-- Extract the event's duration as a time interval
 	CREATE OR REPLACE FUNCTION f_event_duration(jsonb)
 	RETURNS interval AS $$
@@ -72,12 +152,105 @@ func (e *EventStore) Init(ctx context.Context) error {
 	CREATE TABLE IF NOT EXISTS events (
      id    VARCHAR(40)   NOT NULL,
 	   data  jsonb         NOT NULL,
-	   is_bad   boolean,
-	   geom  geometry
+	   is_bad   boolean
 	);
 
+	-- The event's coordinates, for searching without PostGIS
+	ALTER TABLE events ADD COLUMN IF NOT EXISTS latitude double precision;
+	ALTER TABLE events ADD COLUMN IF NOT EXISTS longitude double precision;
+	CREATE INDEX IF NOT EXISTS event_lat_lng_idx ON events (latitude, longitude);
+
+	-- The lowest price found in the event description, see eventdb.ParsePrice
+	ALTER TABLE events ADD COLUMN IF NOT EXISTS price numeric;
+
+	-- The lowest price in each currency, see eventdb.ParsePrices
+	ALTER TABLE events ADD COLUMN IF NOT EXISTS prices jsonb;
+
+	-- Tags guessed from the name and description, see eventdb.ExtractTags
+	ALTER TABLE events ADD COLUMN IF NOT EXISTS tags text[];
+	CREATE INDEX IF NOT EXISTS event_tags_idx ON events USING GIN (tags);
+
+	-- The version of the parsing the price and tags columns came from, see
+	-- derivedVersion
+	ALTER TABLE events ADD COLUMN IF NOT EXISTS derived_version integer NOT NULL DEFAULT 0;
+
+	-- Set when Facebook reports the event no longer exists, see MarkDeleted
+	ALTER TABLE events ADD COLUMN IF NOT EXISTS is_deleted boolean NOT NULL DEFAULT FALSE;
+	ALTER TABLE events ADD COLUMN IF NOT EXISTS deleted_at timestamptz;
+
+	-- When the event was last downloaded from Facebook, see ListStale
+	ALTER TABLE events ADD COLUMN IF NOT EXISTS fetched_at timestamptz NOT NULL DEFAULT now();
+	CREATE INDEX IF NOT EXISTS event_fetched_at_idx ON events (fetched_at) WHERE NOT is_deleted;
+
+	-- How many dests have picked the event, see IncrementChosen
+	ALTER TABLE events ADD COLUMN IF NOT EXISTS times_chosen integer NOT NULL DEFAULT 0;
+
+	-- Bumped whenever the event's data, bad flag, or deleted flag change, see
+	-- EventStore.Changes
+	ALTER TABLE events ADD COLUMN IF NOT EXISTS updated_at timestamptz NOT NULL DEFAULT clock_timestamp();
+	CREATE INDEX IF NOT EXISTS event_updated_at_idx ON events (updated_at, id);
+
 	CREATE UNIQUE INDEX IF NOT EXISTS event_id_idx ON events (id);
 
+	-- For finding events at the same place, see EventStore.AtVenue
+	CREATE INDEX IF NOT EXISTS event_place_id_idx ON events ((data->'place'->>'id'));
+
+	-- Users' reports of broken events, see EventStore.Report
+	CREATE TABLE IF NOT EXISTS event_reports (
+		event_id    VARCHAR(40)  NOT NULL,
+		user_id     VARCHAR(40)  NOT NULL,
+		reason      text         NOT NULL,
+		created_at  timestamptz  NOT NULL DEFAULT now(),
+		PRIMARY KEY (event_id, user_id)
+	);
+	CREATE INDEX IF NOT EXISTS event_reports_created_at_idx ON event_reports (created_at);
+
+	-- Events removed by EventStore.Delete, for EventStore.Changes
+	CREATE TABLE IF NOT EXISTS event_tombstones (
+		id          text         PRIMARY KEY,
+		deleted_at  timestamptz  NOT NULL DEFAULT clock_timestamp()
+	);
+	CREATE INDEX IF NOT EXISTS event_tombstones_deleted_at_idx ON event_tombstones (deleted_at, id);
+
+	-- Places and owners whose events are never returned, see EventStore.Block
+	CREATE TABLE IF NOT EXISTS blocklist (
+		kind        text         NOT NULL,
+		id          text         NOT NULL,
+		reason      text         NOT NULL DEFAULT '',
+		created_at  timestamptz  NOT NULL DEFAULT now(),
+		PRIMARY KEY (kind, id)
+	);
+
+	-- Trigram index to speed up ILIKE matches on EventSearchRequest.PlaceName
+	CREATE INDEX IF NOT EXISTS event_place_text_idx
+	ON events
+	USING GIN (f_event_place_text(data) gin_trgm_ops);
+
+	-- Full text index for EventSearchRequest.Query
+	CREATE INDEX IF NOT EXISTS event_text_idx
+	ON events
+	USING GIN (f_event_tsv(data));
+
+	-- Trigram index for fuzzy Query matches
+	CREATE INDEX IF NOT EXISTS event_text_trgm_idx
+	ON events
+	USING GIN (f_event_text(data) gin_trgm_ops);
+	`)
+	if err != nil {
+		return errors.E(op, pgErr(err))
+	}
+
+	if err := e.backfillDerived(ctx); err != nil {
+		return errors.E(op, err)
+	}
+
+	if e.NoPostGIS {
+		return nil
+	}
+
+	_, err = e.DB.ExecContext(ctx, `
+	ALTER TABLE events ADD COLUMN IF NOT EXISTS geom geometry;
+
 	-- Geospatial index to speed up EventStore.Search
 	CREATE INDEX IF NOT EXISTS event_search_idx
 	ON events
@@ -87,6 +260,11 @@ func (e *EventStore) Init(ctx context.Context) error {
 	)
 	WHERE f_event_duration(data) < interval '10 hours'
 	AND f_event_address(data) IS NOT NULL;
+
+	-- For distances along the earth's surface, see EventStore.AtVenue
+	CREATE INDEX IF NOT EXISTS event_geog_idx
+	ON events
+	USING GIST ((geom::geography));
 	`)
 	if err != nil {
 		return errors.E(op, pgErr(err))
@@ -96,39 +274,57 @@ func (e *EventStore) Init(ctx context.Context) error {
 }
 
 // doSearch executes a search query with EventSearchRequest and returns all the
-// event IDs that match.
-func (e *EventStore) doSearch(ctx context.Context, params eventdb.EventSearchRequest) ([]eventdb.EventID, error) {
-	rows, err := e.DB.QueryContext(ctx, `
-		SELECT data->>'id' AS id
-		FROM events
-		WHERE
-			-- Restrict to events within the given GeoJSON bounds
-			ST_Within(
-				geom,
-				ST_CollectionExtract(
-					ST_MakeValid(ST_SetSRID(ST_GeomFromGeoJSON($1), 4326)),
-					3
-				)
-			)
-
-			-- Events without an address are usually not specific to one place in a city
-			-- and we can't draw a dot on the map
-			AND f_event_address(data) IS NOT NULL
+// event IDs that match, soonest first.
+//
+// If the request is Fuzzy and no events match Query exactly, it falls back to
+// approximate matches, most similar first. An Offset past the last exact match
+// just gets an empty page.
+//
+// If addressless is set it returns only the events with coordinates but no
+// street address, which regular searches leave out.
+func (e *EventStore) doSearch(ctx context.Context, params eventdb.EventSearchRequest, addressless bool) (eventIDs []eventdb.EventID, err error) {
+	search := func(fuzzy bool) error {
+		eventIDs, err = e.searchIDs(ctx, params, fuzzy, addressless)
+		return err
+	}
 
-			-- Filter to events that are in the requested time window
-			AND tstzrange(f_event_start_time(data), f_event_end_time(data)) && tstzrange($2, $3)
+	if err := retryRead(ctx, func() error { return search(false) }); err != nil {
+		return nil, err
+	}
+	if len(eventIDs) == 0 && params.Fuzzy && params.Query != "" {
+		exact := false
+		if params.Offset > 0 {
+			// The page may be empty only because it's past the exact matches
+			first := params
+			first.Limit, first.Offset = 1, 0
+			var ids []eventdb.EventID
+			err := retryRead(ctx, func() (err error) {
+				ids, err = e.searchIDs(ctx, first, false, addressless)
+				return err
+			})
+			if err != nil {
+				return nil, err
+			}
+			exact = len(ids) > 0
+		}
+		if !exact {
+			if err := retryRead(ctx, func() error { return search(true) }); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return eventIDs, nil
+}
 
-			-- Remove day-long events (not practical to attend)
-			AND f_event_duration(data) < interval '10 hours'
+// searchIDs builds and runs the query for doSearch. If fuzzy is set, Query is
+// matched by trigram similarity rather than as a full text search.
+func (e *EventStore) searchIDs(ctx context.Context, params eventdb.EventSearchRequest, fuzzy, addressless bool) ([]eventdb.EventID, error) {
+	query, args, bounds, err := e.searchQuery(params, fuzzy, addressless)
+	if err != nil {
+		return nil, err
+	}
 
-			-- Filter out "bad" events determined uninteresting
-			-- by event text analysis
-			AND ($4 OR is_bad IS NULL OR is_bad = FALSE)
-		`,
-		params.Bounds,
-		params.Start,
-		params.End,
-		params.IncludeBad)
+	rows, err := e.readDB().QueryContext(ctx, query, args...)
 	if err != nil {
 		return nil, pgErr(err)
 	}
@@ -137,218 +333,1456 @@ func (e *EventStore) doSearch(ctx context.Context, params eventdb.EventSearchReq
 	var eventIDs []eventdb.EventID
 	for rows.Next() {
 		var id eventdb.EventID
-		if err = rows.Scan(&id); err != nil {
+		var lat, lng float64
+		if err = rows.Scan(&id, &lat, &lng); err != nil {
 			return nil, pgErr(err)
 		}
+		if bounds != nil && !bounds.Contains(lat, lng) {
+			continue
+		}
 		eventIDs = append(eventIDs, id)
 	}
 	if err = rows.Err(); err != nil {
 		return nil, pgErr(err)
 	}
 
+	if bounds != nil {
+		eventIDs = page(eventIDs, params.Limit, params.Offset)
+	}
+
 	return eventIDs, err
 }
 
-// Search executes a search query with EventSearchRequest and returns all the
-// Events that match, with the description truncated in the database to save
-// bandiwdth.
-func (e *EventStore) Search(ctx context.Context, params eventdb.EventSearchRequest) ([]eventdb.Event, error) {
-	eventIDs, err := e.doSearch(ctx, params)
-	if err != nil {
-		return nil, err
+// searchQuery builds the SQL query for searchIDs. If bounds is non-nil, the
+// rows must still be checked against it, and paged, in Go.
+func (e *EventStore) searchQuery(params eventdb.EventSearchRequest, fuzzy, addressless bool) (query string, args []interface{}, bounds geojson.Polygons, err error) {
+	var where []string
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
 	}
-	events, err := e.fetchEvents(ctx, eventIDs)
-	if err != nil {
-		return nil, err
+
+	// Events without an address are usually not specific to one place in a city
+	// and we can't draw a dot on the map
+	hasAddress := `f_event_address(data) IS NOT NULL`
+	if addressless {
+		hasAddress = `f_event_address(data) IS NULL`
+		if params.Bounds == "" {
+			where = append(where, hasAddress, e.hasCoordsSQL())
+		}
 	}
 
-	return events, nil
-}
+	if params.Bounds != "" && e.NoPostGIS {
+		bounds, err = geojson.ParsePolygons(params.Bounds)
+		if err != nil {
+			return "", nil, nil, errors.E(errors.Invalid, errors.Errorf("bad bounds: %v", err))
+		}
 
-// SearchFull executes a search query with EventSearchRequest and returns the raw Graph API
-// JSON for all the events that match.
-func (e *EventStore) SearchFull(ctx context.Context, params eventdb.EventSearchRequest) ([]json.RawMessage, error) {
-	eventIDs, err := e.doSearch(ctx, params)
-	if err != nil {
-		return nil, err
+		// Narrow it down to the bounding box here, and check the exact
+		// bounds below.
+		minLat, minLng, maxLat, maxLng := bounds.BoundingBox()
+		where = append(where,
+			`latitude BETWEEN `+arg(minLat)+` AND `+arg(maxLat),
+			`longitude BETWEEN `+arg(minLng)+` AND `+arg(maxLng),
+			hasAddress,
+		)
+	} else if params.Bounds != "" {
+		where = append(where,
+			// Restrict to events within the given GeoJSON bounds
+			`ST_Within(
+				geom,
+				ST_CollectionExtract(
+					ST_MakeValid(ST_SetSRID(ST_GeomFromGeoJSON(`+arg(params.Bounds)+`), 4326)),
+					3
+				)
+			)`,
+			hasAddress,
+		)
 	}
-	return e.fetchEventsFull(ctx, eventIDs)
-}
 
-// Save creates or updates an Event in the database, given a JSON message from
-// the Graph API.
-func (e *EventStore) Save(ctx context.Context, eventJS json.RawMessage) (eventdb.Event, error) {
-	var evtID struct {
-		ID eventdb.EventID `json:"id"`
+	// Restrict to events within the radius of the center
+	if params.RadiusM > 0 {
+		if !params.HasCenter() {
+			return "", nil, nil, errors.E(errors.Invalid, "radius needs a center")
+		}
+		lat, lng, radius := arg(params.CenterLat), arg(params.CenterLng), arg(params.RadiusM)
+		if e.NoPostGIS {
+			// Narrow it down to the bounding box, then check the exact
+			// distance.
+			where = append(where,
+				`ABS(latitude - `+lat+`) <= `+radius+` / 111320.0`,
+				`ABS(longitude - `+lng+`) <= `+radius+` / (111320.0 * GREATEST(COS(RADIANS(`+lat+`)), 0.01))`,
+				distanceSQL(lat, lng)+` <= `+radius,
+			)
+		} else {
+			where = append(where,
+				`ST_DWithin(geom::geography, ST_SetSRID(ST_MakePoint(`+lng+`, `+lat+`), 4326)::geography, `+radius+`)`)
+		}
+		if params.Bounds == "" && !addressless {
+			where = append(where, hasAddress)
+		}
 	}
-	if err := json.Unmarshal([]byte(eventJS), &evtID); err != nil {
-		return eventdb.Event{}, err
+
+	// Deleted and blocked events are never returned
+	where = append(where, `NOT is_deleted`, notBlockedSQL)
+
+	// Filter to events that are in the requested time window
+	where = append(where,
+		`tstzrange(f_event_start_time(data), f_event_end_time(data)) && tstzrange(`+arg(params.Start)+`, `+arg(params.End)+`)`)
+
+	// Leave out events that have been going on too long to join
+	if params.MaxElapsedMinutes > 0 {
+		earliest := params.Start.Add(-time.Duration(params.MaxElapsedMinutes) * time.Minute)
+		where = append(where, `f_event_start_time(data) > `+arg(earliest))
 	}
-	eventID := evtID.ID
 
-	tx, err := e.DB.BeginTx(ctx, nil)
-	if err != nil {
-		return eventdb.Event{}, pgErr(err)
+	// Leave out events that haven't been refetched recently. fetched_at is
+	// set by the database clock, so compare it to that.
+	if params.FreshnessWindow > 0 {
+		where = append(where, `fetched_at > now() - `+arg(params.FreshnessWindow.Seconds())+`::float8 * interval '1 second'`)
 	}
-	defer tx.Rollback()
 
-	_, err = tx.ExecContext(ctx, `
-		INSERT INTO events
-			(id, data)
-		VALUES
-			($1, $2)
-		ON CONFLICT (id) DO UPDATE
-			SET data=$2
-		`, eventID, []byte(eventJS))
-	if err != nil {
-		return eventdb.Event{}, errors.E(pgErr(err), "insert event")
+	// Remove day-long events (not practical to attend) unless they're
+	// explicitly requested. This can't use event_search_idx.
+	if !params.AllowLongEvents {
+		where = append(where, `f_event_duration(data) < interval '10 hours'`)
 	}
 
-	_, err = tx.ExecContext(ctx, `
-		UPDATE events
-		SET geom = ST_SetSRID(ST_MakePoint(
-			(data->'place'->'location'->>'longitude')::float,
-			(data->'place'->'location'->>'latitude')::float), 4326)
-		WHERE
-			id = $1
-	`, eventID)
-	if err != nil {
-		return eventdb.Event{}, errors.E(pgErr(err), "set geom")
+	// Filter out "bad" events determined uninteresting
+	// by event text analysis
+	if !params.IncludeBad {
+		where = append(where, `(is_bad IS NULL OR is_bad = FALSE)`)
 	}
 
-	if err = tx.Commit(); err != nil {
-		return eventdb.Event{}, pgErr(err)
+	// Filter out events that cost money. A price of zero is free.
+	if params.FreeOnly {
+		where = append(where, `(COALESCE(price, 0) = 0 AND COALESCE(data->>'ticket_uri', '') = '')`)
 	}
 
-	event, err := e.GetByID(ctx, eventID)
-	if err != nil {
-		return event, err
+	// Filter out events that cost more than the max in the given currency
+	if params.MaxPrice > 0 {
+		where = append(where, `(prices IS NULL OR (prices->>`+arg(strings.ToUpper(params.Currency))+`)::numeric <= `+arg(params.MaxPrice)+`)`)
 	}
 
-	return event, nil
-}
+	// Or a max in the currency's minor unit
+	if params.MaxPriceCents > 0 {
+		maxPrice := float64(params.MaxPriceCents) / math.Pow10(eventdb.MinorUnits(params.Currency))
+		where = append(where, `(prices IS NULL OR (prices->>`+arg(strings.ToUpper(params.Currency))+`)::numeric <= `+arg(maxPrice)+`)`)
+	}
 
-// SetBad updates an event's 'bad' flag, which determines whether it gets
-// filtered from search results.
-func (e *EventStore) SetBad(ctx context.Context, eventID eventdb.EventID, isBad bool) error {
-	_, err := e.DB.ExecContext(ctx, `
-	UPDATE events
-	SET is_bad = $1
-	WHERE id = $2
-	`, isBad, eventID)
-	if err != nil {
-		return err
+	// Filter out events the user already went to
+	if params.UserID != "" && len(params.AttendedStatuses) > 0 {
+		where = append(where, `NOT EXISTS (
+			SELECT 1 FROM dests
+			WHERE dests.event_id = events.id
+			AND dests.user_id = `+arg(params.UserID)+`
+			AND dests.status = ANY (`+arg(pq.StringArray(params.AttendedStatuses))+`)
+		)`)
 	}
 
-	return nil
+	// Filter out events the user was already sent to. dests.created_at has no
+	// time zone, so ChosenSince is converted to the session's local time.
+	if params.UserID != "" && params.ExcludeChosen {
+		where = append(where, `NOT EXISTS (
+			SELECT 1 FROM dests
+			WHERE dests.event_id = events.id
+			AND dests.user_id = `+arg(params.UserID)+`
+			AND dests.created_at >= `+arg(params.ChosenSince)+`::timestamptz::timestamp
+		)`)
+	}
+
+	// Match any of the tags
+	if len(params.Tags) > 0 {
+		where = append(where, `tags && `+arg(pq.StringArray(params.Tags)))
+	}
+
+	// Match the place name or address as a case-insensitive substring
+	if params.PlaceName != "" {
+		where = append(where, `f_event_place_text(data) ILIKE '%' || `+arg(escapeLike(params.PlaceName))+` || '%'`)
+	}
+
+	// Match keywords in the name or description
+	orderBy := `f_event_start_time(data) ASC, id ASC`
+	if params.Query != "" {
+		q := arg(params.Query)
+		if fuzzy {
+			where = append(where, q+` <% f_event_text(data)`)
+			orderBy = `word_similarity(` + q + `, f_event_text(data)) DESC, ` + orderBy
+		} else {
+			where = append(where, `f_event_tsv(data) @@ plainto_tsquery('english', `+q+`)`)
+		}
+	}
+
+	switch params.OrderBy {
+	case eventdb.OrderByStartTime:
+	case eventdb.OrderByTimesChosen:
+		orderBy = `times_chosen DESC, ` + orderBy
+	case eventdb.OrderByStartingSoon:
+		where = append(where, `f_event_start_time(data) >= `+arg(params.Start))
+	case eventdb.OrderByDistance:
+		if !params.HasCenter() {
+			return "", nil, nil, errors.E(errors.Invalid, "ordering by distance needs a center")
+		}
+		// Events without coordinates have a NULL distance and sort last
+		orderBy = distanceSQL(arg(params.CenterLat), arg(params.CenterLng)) + ` ASC NULLS LAST, ` + orderBy
+	default:
+		return "", nil, nil, errors.E(errors.Invalid, fmt.Sprintf("unknown order %q", params.OrderBy))
+	}
+
+	query = `
+		SELECT id, COALESCE(latitude, 0), COALESCE(longitude, 0)
+		FROM events
+		WHERE ` + strings.Join(where, "\n\t\t\tAND ") + `
+		ORDER BY ` + orderBy
+
+	// Keep the first event at each venue, then put the survivors back in
+	// order. Events without a place ID are their own venue.
+	if params.DedupeByVenue {
+		query = `
+		SELECT id, COALESCE(latitude, 0), COALESCE(longitude, 0)
+		FROM (
+			SELECT DISTINCT ON (COALESCE(data->'place'->>'id', id))
+				id, data, latitude, longitude, times_chosen
+			FROM events
+			WHERE ` + strings.Join(where, "\n\t\t\tAND ") + `
+			ORDER BY COALESCE(data->'place'->>'id', id), ` + orderBy + `
+		) AS events
+		ORDER BY ` + orderBy
+	}
+
+	// Paging has to wait until the bounds are checked in Go
+	if bounds == nil {
+		if params.Limit > 0 {
+			query += ` LIMIT ` + arg(params.Limit)
+		}
+		if params.Offset > 0 {
+			query += ` OFFSET ` + arg(params.Offset)
+		}
+	}
+
+	return query, args, bounds, nil
 }
 
-// GetByID finds an event by its ID
-func (e *EventStore) GetByID(ctx context.Context, eventID eventdb.EventID) (eventdb.Event, error) {
-	events, err := e.fetchEvents(ctx, []eventdb.EventID{eventID})
-	if err != nil {
-		return eventdb.Event{}, errors.E(err)
+// distanceSQL computes the distance in meters from lat, lng to an event's
+// latitude and longitude columns, the same way as geojson.Haversine so it
+// agrees with setDistances. The columns are kept up to date with or without
+// PostGIS.
+func distanceSQL(lat, lng string) string {
+	return fmt.Sprintf(`(%[1]v * 2 * ASIN(SQRT(LEAST(1,
+		POWER(SIN(RADIANS(latitude - %[2]s) / 2), 2)
+		+ COS(RADIANS(%[2]s)) * COS(RADIANS(latitude)) * POWER(SIN(RADIANS(longitude - %[3]s) / 2), 2)
+	))))`, geojson.EarthRadiusM, lat, lng)
+}
+
+// setDistances sets each event's DistanceM from the search's center, if it
+// has one.
+func setDistances(events []eventdb.Event, params eventdb.EventSearchRequest) {
+	if !params.HasCenter() {
+		return
+	}
+	for i := range events {
+		events[i].DistanceM = geojson.Haversine(params.CenterLng, params.CenterLat, events[i].Longitude, events[i].Latitude)
 	}
+}
 
-	if len(events) == 0 {
-		return eventdb.Event{}, errors.E(errors.NotExist)
+// page returns the page of ids selected by limit and offset. A zero limit
+// means no limit.
+func page(ids []eventdb.EventID, limit, offset int) []eventdb.EventID {
+	if offset >= len(ids) {
+		return nil
+	}
+	ids = ids[offset:]
+	if limit > 0 && limit < len(ids) {
+		ids = ids[:limit]
 	}
+	return ids
+}
 
-	event := events[0]
-	return event, nil
+// escapeLike escapes the LIKE wildcards in s so it's matched literally.
+func escapeLike(s string) string {
+	return likeEscaper.Replace(s)
 }
 
-// GetMulti finds multiple events simultaneously by their IDs.
-func (e *EventStore) GetMulti(ctx context.Context, eventIDs []eventdb.EventID) ([]eventdb.Event, error) {
-	events, err := e.fetchEvents(ctx, eventIDs)
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// Search executes a search query with EventSearchRequest and returns all the
+// Events that match, with the description truncated in the database to save
+// bandiwdth. If the search has a center, each event's DistanceM is
+// set.
+func (e *EventStore) Search(ctx context.Context, params eventdb.EventSearchRequest) ([]eventdb.Event, error) {
+	eventIDs, err := e.doSearch(ctx, params, false)
 	if err != nil {
-		return events, errors.E(err, "get multi")
+		return nil, err
+	}
+	events, err := e.fetchEvents(ctx, e.readDB(), eventIDs, params.TimesInUTC)
+	if err != nil {
+		return nil, err
 	}
+	setDistances(events, params)
 
 	return events, nil
 }
 
-func (e *EventStore) fetchEvents(ctx context.Context, eventIDs []eventdb.EventID) ([]eventdb.Event, error) {
-	events := []eventdb.Event{}
-
-	var idStrings pq.StringArray
-	for _, id := range eventIDs {
-		idStrings = append(idStrings, string(id))
+// SearchAddressless is like Search, but returns only the events that have
+// coordinates but no street address. Regular searches leave these out, so
+// admins can use it to see what's being dropped.
+func (e *EventStore) SearchAddressless(ctx context.Context, params eventdb.EventSearchRequest) ([]eventdb.Event, error) {
+	eventIDs, err := e.doSearch(ctx, params, true)
+	if err != nil {
+		return nil, err
+	}
+	events, err := e.fetchEvents(ctx, e.readDB(), eventIDs, params.TimesInUTC)
+	if err != nil {
+		return nil, err
 	}
+	setDistances(events, params)
 
-	rows, err := e.DB.QueryContext(ctx, `
-	SELECT
-		COALESCE(data->>'id', '') AS id,
+	return events, nil
+}
 
-		COALESCE(data->>'name', '') AS name,
-		COALESCE(data->'cover'->>'source', '') AS cover,
-		f_event_start_time(data) AS start_time,
-		f_event_end_time(data) AS end_time,
-		COALESCE( ST_Y(ST_Transform(geom, 4326)), 0) AS latitude,
-		COALESCE( ST_X(ST_Transform(geom, 4326)), 0) AS longitude,
+// SearchCount returns how many events match the EventSearchRequest, ignoring
+// Limit and Offset. The events are counted in the database, unless there are
+// bounds without PostGIS, which have to be checked in Go.
+func (e *EventStore) SearchCount(ctx context.Context, params eventdb.EventSearchRequest) (int, error) {
+	const op errors.Op = "EventStore.SearchCount"
 
-		COALESCE(data->>'is_canceled', 'false') AS is_canceled,
+	params.Limit, params.Offset = 0, 0
 
-		COALESCE(is_bad, 'false'),
+	if params.Bounds != "" && e.NoPostGIS {
+		eventIDs, err := e.doSearch(ctx, params, false)
+		if err != nil {
+			return 0, errors.E(op, err)
+		}
+		return len(eventIDs), nil
+	}
 
-        COALESCE(data->>'description', '') AS description,
+	count := func(fuzzy bool) (n int, err error) {
+		query, args, _, err := e.searchQuery(params, fuzzy, false)
+		if err != nil {
+			return 0, err
+		}
+		err = retryRead(ctx, func() error {
+			err := e.readDB().QueryRowContext(ctx, `SELECT count(*) FROM (`+query+`) AS matches`, args...).Scan(&n)
+			return pgErr(err)
+		})
+		return n, err
+	}
 
-		COALESCE(data->'place'->>'name', '') AS place,
-		COALESCE(f_event_address(data), '') AS address,
+	// Like doSearch, only count approximate matches if nothing matches exactly
+	n, err := count(false)
+	if err == nil && n == 0 && params.Fuzzy && params.Query != "" {
+		n, err = count(true)
+	}
+	if err != nil {
+		return 0, errors.E(op, err)
+	}
+	return n, nil
+}
 
-		COALESCE(data->>'timezone', '') AS timezone
+// ExplainSearch runs EXPLAIN ANALYZE on the query Search would run for params
+// and returns the plan as JSON, to check which indexes a search uses. Fuzzy
+// matching isn't explained.
+func (e *EventStore) ExplainSearch(ctx context.Context, params eventdb.EventSearchRequest) (json.RawMessage, error) {
+	const op errors.Op = "EventStore.ExplainSearch"
 
-	FROM events
-	WHERE
-		id = ANY ($1)
-	ORDER BY start_time ASC
-	`, idStrings)
+	query, args, _, err := e.searchQuery(params, false, false)
 	if err != nil {
-		return events, errors.E(pgErr(err), "select events")
+		return nil, errors.E(op, err)
 	}
-	defer rows.Close()
 
-	for rows.Next() {
-		var timezone string
+	var plan string
+	err = retryRead(ctx, func() error {
+		err := e.readDB().QueryRowContext(ctx, `EXPLAIN (ANALYZE, FORMAT JSON) `+query, args...).Scan(&plan)
+		return pgErr(err)
+	})
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+	return json.RawMessage(plan), nil
+}
 
-		var event eventdb.Event
-		err = rows.Scan(
-			&event.ID,
-			&event.Name,
-			&event.Cover,
-			&event.StartTime,
-			&event.EndTime,
-			&event.Latitude,
-			&event.Longitude,
-			&event.IsCanceled,
-			&event.IsBad,
-			&event.Description,
-			&event.Place,
-			&event.Address,
-			&timezone,
-		)
-		if err != nil {
-			return events, err
-		}
+// Extent returns the bounding box of the events that match the
+// EventSearchRequest, ignoring Limit and Offset. It returns a NotExist error
+// if no events match.
+func (e *EventStore) Extent(ctx context.Context, params eventdb.EventSearchRequest) (minLat, minLng, maxLat, maxLng float64, err error) {
+	const op errors.Op = "EventStore.Extent"
+
+	params.Limit, params.Offset = 0, 0
+
+	// The box is NULL if none of the events have coordinates
+	var n int
+	var bMinLat, bMinLng, bMaxLat, bMaxLng sql.NullFloat64
+	extent := func(cond string, args []interface{}) error {
+		return retryRead(ctx, func() error {
+			err := e.readDB().QueryRowContext(ctx, e.extentSQL(cond), args...).Scan(&n, &bMinLat, &bMinLng, &bMaxLat, &bMaxLng)
+			return pgErr(err)
+		})
+	}
 
-		location, err := time.LoadLocation(timezone)
+	if params.Bounds != "" && e.NoPostGIS {
+		// The bounds have to be checked in Go, so find the events first
+		eventIDs, err := e.doSearch(ctx, params, false)
 		if err != nil {
-			location = time.UTC
+			return 0, 0, 0, 0, errors.E(op, err)
+		}
+		var ids pq.StringArray
+		for _, id := range eventIDs {
+			ids = append(ids, string(id))
+		}
+		err = extent(`id = ANY ($1)`, []interface{}{ids})
+	} else {
+		search := func(fuzzy bool) error {
+			query, args, _, err := e.searchQuery(params, fuzzy, false)
+			if err != nil {
+				return err
+			}
+			return extent(`id IN (SELECT id FROM (`+query+`) AS matches)`, args)
 		}
 
-		event.StartTime = event.StartTime.In(location)
-		event.EndTime = event.EndTime.In(location)
-
-		events = append(events, event)
+		// Like doSearch, only use approximate matches if nothing matches exactly
+		err = search(false)
+		if err == nil && n == 0 && params.Fuzzy && params.Query != "" {
+			err = search(true)
+		}
 	}
-	if err := rows.Err(); err != nil {
-		return events, err
+	if err != nil {
+		return 0, 0, 0, 0, errors.E(op, err)
+	}
+	if !bMinLat.Valid {
+		return 0, 0, 0, 0, errors.E(op, errors.NotExist, "no matching events with a location")
 	}
 
+	return bMinLat.Float64, bMinLng.Float64, bMaxLat.Float64, bMaxLng.Float64, nil
+}
+
+// extentSQL selects how many events match cond, and the bounding box of the
+// ones with coordinates as min lat, min lng, max lat, max lng.
+func (e *EventStore) extentSQL(cond string) string {
+	if e.NoPostGIS {
+		return `
+		SELECT count(*), MIN(latitude), MIN(longitude), MAX(latitude), MAX(longitude)
+		FROM events WHERE ` + cond
+	}
+	return `
+		SELECT n, ST_YMin(extent), ST_XMin(extent), ST_YMax(extent), ST_XMax(extent)
+		FROM (SELECT count(*) AS n, ST_Extent(geom) AS extent FROM events WHERE ` + cond + `) AS e`
+}
+
+// SearchFull executes a search query with EventSearchRequest and returns the raw Graph API
+// JSON for all the events that match.
+func (e *EventStore) SearchFull(ctx context.Context, params eventdb.EventSearchRequest) ([]json.RawMessage, error) {
+	eventIDs, err := e.doSearch(ctx, params, false)
+	if err != nil {
+		return nil, err
+	}
+	return e.fetchEventsFull(ctx, e.readDB(), eventIDs)
+}
+
+// AtVenue returns up to limit events, other than the given one, that are at
+// the same place: either they share its Facebook place ID or they're within
+// radiusM meters of it. Only events that overlap the start to end window and
+// aren't bad or deleted are returned, soonest first.
+func (e *EventStore) AtVenue(ctx context.Context, id eventdb.EventID, radiusM float64, start, end time.Time, limit int) ([]eventdb.Event, error) {
+	const op errors.Op = "EventStore.AtVenue"
+
+	// Within radiusM of the venue
+	nearby := `ST_DWithin(events.geom::geography, venue.geom::geography, $2)`
+	if e.NoPostGIS {
+		// Close enough to a circle at the small radiuses this is used for
+		nearby = `events.latitude BETWEEN venue.latitude - $2 / 111320.0 AND venue.latitude + $2 / 111320.0
+			AND events.longitude BETWEEN venue.longitude - $2 / (111320.0 * COS(RADIANS(venue.latitude)))
+				AND venue.longitude + $2 / (111320.0 * COS(RADIANS(venue.latitude)))`
+	}
+
+	var eventIDs []eventdb.EventID
+	err := retryRead(ctx, func() error {
+		eventIDs = nil
+
+		// Each half of the union can use its own index, which an OR of the
+		// two conditions can't
+		rows, err := e.readDB().QueryContext(ctx, `
+		WITH venue AS (SELECT * FROM events WHERE id = $1)
+		SELECT events.id
+		FROM events
+		WHERE events.id IN (
+				SELECT events.id
+				FROM events, venue
+				WHERE events.data->'place'->>'id' = venue.data->'place'->>'id'
+				UNION
+				SELECT events.id
+				FROM events, venue
+				WHERE `+nearby+`
+			)
+			AND events.id <> $1
+			AND NOT events.is_deleted
+			AND `+notBlockedSQL+`
+			AND (events.is_bad IS NULL OR events.is_bad = FALSE)
+			AND tstzrange(f_event_start_time(events.data), f_event_end_time(events.data)) && tstzrange($3, $4)
+		ORDER BY f_event_start_time(events.data) ASC, events.id ASC
+		LIMIT $5
+		`, id, radiusM, start, end, limit)
+		if err != nil {
+			return pgErr(err)
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var eventID eventdb.EventID
+			if err := rows.Scan(&eventID); err != nil {
+				return pgErr(err)
+			}
+			eventIDs = append(eventIDs, eventID)
+		}
+		if err := rows.Err(); err != nil {
+			return pgErr(err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+
+	events, err := e.fetchEvents(ctx, e.readDB(), eventIDs, false)
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+	return events, nil
+}
+
+// SearchWithin returns the events within radiusM meters of lat, lng that
+// overlap the start to end window. It's a Search with RadiusM set, so it
+// leaves out the same events.
+//
+// Unlike searching with a CircleGeom bounds, the distance is measured along
+// the earth's surface, so events near the edge aren't cut off by the
+// polygon's straight sides.
+func (e *EventStore) SearchWithin(ctx context.Context, lat, lng, radiusM float64, start, end time.Time) ([]eventdb.Event, error) {
+	const op errors.Op = "EventStore.SearchWithin"
+
+	events, err := e.Search(ctx, eventdb.EventSearchRequest{
+		CenterLat: lat,
+		CenterLng: lng,
+		RadiusM:   radiusM,
+		Start:     start,
+		End:       end,
+	})
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+	return events, nil
+}
+
+// derivedVersion is the version of the columns Save derives from an event's
+// name and description, like price and tags. Bump it when the parsing changes
+// so Init recomputes them for events that were saved before.
+const derivedVersion = 2
+
+// backfillBatchSize is how many events backfillDerived updates at a time.
+const backfillBatchSize = 500
+
+// backfillDerived recomputes the derived columns of events saved with an
+// older derivedVersion.
+func (e *EventStore) backfillDerived(ctx context.Context) error {
+	for {
+		rows, err := e.DB.QueryContext(ctx, `
+			SELECT id, COALESCE(data->>'name', ''), COALESCE(data->>'description', '')
+			FROM events
+			WHERE derived_version < $1
+			LIMIT $2
+		`, derivedVersion, backfillBatchSize)
+		if err != nil {
+			return errors.E(pgErr(err), "select events to backfill")
+		}
+		type stale struct {
+			ID          eventdb.EventID
+			Name        string
+			Description string
+		}
+		var batch []stale
+		for rows.Next() {
+			var s stale
+			if err := rows.Scan(&s.ID, &s.Name, &s.Description); err != nil {
+				rows.Close()
+				return pgErr(err)
+			}
+			batch = append(batch, s)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return pgErr(err)
+		}
+		if len(batch) == 0 {
+			return nil
+		}
+
+		for _, s := range batch {
+			price, pricesJS, err := derivePrices(s.Description)
+			if err != nil {
+				return err
+			}
+			tags := pq.StringArray(eventdb.ExtractTags(s.Name, s.Description))
+			_, err = e.DB.ExecContext(ctx, `
+				UPDATE events
+				SET price = $2, prices = $3, tags = $4, derived_version = $5
+				WHERE id = $1
+			`, s.ID, price, pricesJS, tags, derivedVersion)
+			if err != nil {
+				return errors.E(pgErr(err), "backfill event")
+			}
+		}
+	}
+}
+
+// derivePrices computes the price and prices columns from an event's
+// description.
+func derivePrices(description string) (price sql.NullFloat64, pricesJS sql.NullString, err error) {
+	if p, ok := eventdb.ParsePrice(description); ok {
+		price = sql.NullFloat64{Float64: p.Amount, Valid: true}
+	}
+
+	if prices := eventdb.ParsePrices(description); len(prices) > 0 {
+		byCurrency := map[string]float64{}
+		for _, p := range prices {
+			byCurrency[p.Currency] = p.Amount
+		}
+		js, err := json.Marshal(byCurrency)
+		if err != nil {
+			return price, pricesJS, err
+		}
+		pricesJS = sql.NullString{String: string(js), Valid: true}
+	}
+
+	return price, pricesJS, nil
+}
+
+// Save creates or updates an Event in the database, given a JSON message from
+// the Graph API.
+func (e *EventStore) Save(ctx context.Context, eventJS json.RawMessage) (eventdb.Event, error) {
+	var evt struct {
+		ID          eventdb.EventID `json:"id"`
+		Name        string          `json:"name"`
+		Description string          `json:"description"`
+		Place       struct {
+			Location struct {
+				Latitude  *float64 `json:"latitude"`
+				Longitude *float64 `json:"longitude"`
+			} `json:"location"`
+		} `json:"place"`
+	}
+	if err := json.Unmarshal([]byte(eventJS), &evt); err != nil {
+		return eventdb.Event{}, err
+	}
+	eventID := evt.ID
+
+	// eventGeomSQL leaves out impossible coordinates. Log them so we can tell
+	// why the event is missing from search.
+	if lat, lng := evt.Place.Location.Latitude, evt.Place.Location.Longitude; lat != nil && lng != nil {
+		if *lat < -90 || *lat > 90 || *lng < -180 || *lng > 180 {
+			log.FromContext(ctx).Warn("event has out of range coordinates",
+				zap.String("eventID", string(eventID)),
+				zap.Float64("latitude", *lat),
+				zap.Float64("longitude", *lng))
+		}
+	}
+
+	price, pricesJS, err := derivePrices(evt.Description)
+	if err != nil {
+		return eventdb.Event{}, err
+	}
+
+	tags := pq.StringArray(eventdb.ExtractTags(evt.Name, evt.Description))
+
+	tx, err := e.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return eventdb.Event{}, pgErr(err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO events
+			(id, data, price, prices, tags, derived_version)
+		VALUES
+			($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (id) DO UPDATE
+			SET data=$2, price=$3, prices=$4, tags=$5, derived_version=$6, is_deleted=FALSE, deleted_at=NULL,
+				fetched_at = now(),
+				updated_at = CASE
+					WHEN events.data IS DISTINCT FROM EXCLUDED.data OR events.is_deleted THEN clock_timestamp()
+					ELSE events.updated_at
+				END
+		`, eventID, []byte(eventJS), price, pricesJS, tags, derivedVersion)
+	if err != nil {
+		return eventdb.Event{}, errors.E(pgErr(err), "insert event")
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		UPDATE events
+		SET `+e.locationSQL()+`
+		WHERE
+			id = $1
+	`, eventID)
+	if err != nil {
+		return eventdb.Event{}, errors.E(pgErr(err), "set geom")
+	}
+
+	// The event is back if it was deleted for good, see Delete
+	_, err = tx.ExecContext(ctx, `DELETE FROM event_tombstones WHERE id = $1`, eventID)
+	if err != nil {
+		return eventdb.Event{}, errors.E(pgErr(err), "clear tombstone")
+	}
+
+	if err = tx.Commit(); err != nil {
+		return eventdb.Event{}, pgErr(err)
+	}
+
+	// Read from the primary, the replica may not have the event yet
+	events, err := e.fetchEvents(ctx, e.DB, []eventdb.EventID{eventID}, false)
+	if err != nil {
+		return eventdb.Event{}, err
+	}
+	if len(events) == 0 {
+		return eventdb.Event{}, errors.E(errors.NotExist)
+	}
+
+	return events[0], nil
+}
+
+const (
+	eventLatSQL = `(data->'place'->'location'->>'latitude')::float`
+	eventLngSQL = `(data->'place'->'location'->>'longitude')::float`
+
+	eventCoordsValidSQL = eventLatSQL + ` BETWEEN -90 AND 90 AND ` + eventLngSQL + ` BETWEEN -180 AND 180`
+
+	// eventGeomSQL builds an event's geom column from the coordinates in its
+	// Graph API JSON. Out of range coordinates give a NULL geom rather than a
+	// point that breaks distance math.
+	eventGeomSQL = `CASE WHEN ` + eventCoordsValidSQL + `
+		THEN ST_SetSRID(ST_MakePoint(` + eventLngSQL + `, ` + eventLatSQL + `), 4326)
+		END`
+)
+
+// locationSQL is a SET clause that updates an event's location columns from
+// its Graph API JSON.
+func (e *EventStore) locationSQL() string {
+	set := `
+		latitude = CASE WHEN ` + eventCoordsValidSQL + ` THEN ` + eventLatSQL + ` END,
+		longitude = CASE WHEN ` + eventCoordsValidSQL + ` THEN ` + eventLngSQL + ` END`
+	if !e.NoPostGIS {
+		set += `,
+		geom = ` + eventGeomSQL
+	}
+	return set
+}
+
+// RebuildGeoms recomputes the location columns from the JSON data for every
+// event that has coordinates. It's used to repair the columns if they get out
+// of sync, for example after a bulk import. Events are updated in batches so
+// the table isn't locked for the whole rebuild. It returns the number of events
+// updated.
+func (e *EventStore) RebuildGeoms(ctx context.Context) (int, error) {
+	const op errors.Op = "EventStore.RebuildGeoms"
+
+	const batchSize = 1000
+
+	var total int
+	var lastID string
+	for {
+		rows, err := e.DB.QueryContext(ctx, `
+		UPDATE events
+		SET `+e.locationSQL()+`
+		WHERE id IN (
+			SELECT id
+			FROM events
+			WHERE
+				id COLLATE "C" > $1
+				AND data->'place'->'location'->>'latitude' IS NOT NULL
+				AND data->'place'->'location'->>'longitude' IS NOT NULL
+			ORDER BY id COLLATE "C"
+			LIMIT $2
+		)
+		RETURNING id
+		`, lastID, batchSize)
+		if err != nil {
+			return total, errors.E(op, pgErr(err))
+		}
+
+		var n int
+		for rows.Next() {
+			var id string
+			if err := rows.Scan(&id); err != nil {
+				rows.Close()
+				return total, errors.E(op, pgErr(err))
+			}
+			if id > lastID {
+				lastID = id
+			}
+			n++
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return total, errors.E(op, pgErr(err))
+		}
+
+		total += n
+		if n < batchSize {
+			return total, nil
+		}
+	}
+}
+
+// Count returns the approximate number of events stored.
+func (e *EventStore) Count(ctx context.Context) (int64, error) {
+	const op errors.Op = "EventStore.Count"
+
+	count, err := countRows(ctx, e.readDB(), "events")
+	if err != nil {
+		return 0, errors.E(op, err)
+	}
+	return count, nil
+}
+
+// Changes returns up to limit events that changed after since, oldest change
+// first. Events that were deleted or marked bad are returned as tombstones
+// instead. next is the cursor to pass as since to get the following page; it's
+// equal to since if there were no changes.
+//
+// Changes are ordered by time and then ID, so events that changed at the same
+// time, like the rows backfilled when updated_at was added, can be split
+// across pages without any being skipped.
+func (e *EventStore) Changes(ctx context.Context, since eventdb.SyncCursor, limit int) (events []eventdb.Event, tombstones []eventdb.EventID, next eventdb.SyncCursor, err error) {
+	const op errors.Op = "EventStore.Changes"
+
+	next = since
+
+	// Cursors from before IDs were added only have a time
+	after := `(updated_at, id) > ($2, $3)`
+	args := []interface{}{limit, since.UpdatedAt, since.ID}
+	if since.ID == "" {
+		after = `updated_at > $2`
+		args = args[:2]
+	}
+
+	// Events that were removed for good only have a tombstone left
+	rows, err := e.readDB().QueryContext(ctx, `
+	SELECT id, hidden, updated_at
+	FROM (
+		SELECT
+			id,
+			is_deleted OR COALESCE(is_bad, FALSE) AS hidden,
+			updated_at
+		FROM events
+		UNION ALL
+		SELECT id, TRUE, deleted_at
+		FROM event_tombstones
+	) AS changes
+	WHERE `+after+`
+	ORDER BY updated_at ASC, id ASC
+	LIMIT $1
+	`, args...)
+	if err != nil {
+		return nil, nil, next, errors.E(op, pgErr(err))
+	}
+	defer rows.Close()
+
+	type change struct {
+		id        eventdb.EventID
+		hidden    bool
+		updatedAt time.Time
+	}
+	var changes []change
+	for rows.Next() {
+		var c change
+		if err := rows.Scan(&c.id, &c.hidden, &c.updatedAt); err != nil {
+			return nil, nil, next, errors.E(op, pgErr(err))
+		}
+		changes = append(changes, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, next, errors.E(op, pgErr(err))
+	}
+
+	var liveIDs []eventdb.EventID
+	for _, c := range changes {
+		if c.hidden {
+			tombstones = append(tombstones, c.id)
+		} else {
+			liveIDs = append(liveIDs, c.id)
+		}
+		next = eventdb.SyncCursor{UpdatedAt: c.updatedAt, ID: c.id}
+	}
+
+	events = []eventdb.Event{}
+	if len(liveIDs) > 0 {
+		events, err = e.fetchEvents(ctx, e.readDB(), liveIDs, false)
+		if err != nil {
+			return nil, nil, since, errors.E(op, err)
+		}
+	}
+
+	return events, tombstones, next, nil
+}
+
+// ListStale returns the IDs of up to limit events that were last fetched from
+// Facebook before fetchedBefore and end after endsAfter, least recently
+// fetched first. Deleted events aren't included.
+func (e *EventStore) ListStale(ctx context.Context, fetchedBefore, endsAfter time.Time, limit int) ([]eventdb.EventID, error) {
+	const op errors.Op = "EventStore.ListStale"
+
+	rows, err := e.readDB().QueryContext(ctx, `
+		SELECT id
+		FROM events
+		WHERE fetched_at < $1
+		AND f_event_end_time(data) > $2
+		AND NOT is_deleted
+		ORDER BY fetched_at ASC, id ASC
+		LIMIT $3
+	`, fetchedBefore, endsAfter, limit)
+	if err != nil {
+		return nil, errors.E(op, pgErr(err))
+	}
+	defer rows.Close()
+
+	var ids []eventdb.EventID
+	for rows.Next() {
+		var id eventdb.EventID
+		if err := rows.Scan(&id); err != nil {
+			return nil, errors.E(op, pgErr(err))
+		}
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.E(op, pgErr(err))
+	}
+
+	return ids, nil
+}
+
+// MarkFetched sets when the events were last fetched to now without changing
+// them, so events that couldn't be refetched don't stay at the front of
+// ListStale.
+func (e *EventStore) MarkFetched(ctx context.Context, eventIDs []eventdb.EventID) error {
+	const op errors.Op = "EventStore.MarkFetched"
+
+	var idStrings pq.StringArray
+	for _, id := range eventIDs {
+		idStrings = append(idStrings, string(id))
+	}
+
+	_, err := e.DB.ExecContext(ctx, `
+		UPDATE events
+		SET fetched_at = now()
+		WHERE id = ANY ($1)
+	`, idStrings)
+	if err != nil {
+		return errors.E(op, pgErr(err))
+	}
+	return nil
+}
+
+// MarkDeleted flags an event as deleted so it no longer appears in search
+// results, and records when it was deleted. Saving the event again clears the
+// flag. It returns errors.NotExist if the event isn't stored.
+func (e *EventStore) MarkDeleted(ctx context.Context, eventID eventdb.EventID) error {
+	const op errors.Op = "EventStore.MarkDeleted"
+
+	res, err := e.DB.ExecContext(ctx, `
+	UPDATE events
+	SET
+		is_deleted = TRUE,
+		deleted_at = COALESCE(deleted_at, now()),
+		updated_at = CASE WHEN is_deleted THEN updated_at ELSE clock_timestamp() END
+	WHERE id = $1
+	`, eventID)
+	if err != nil {
+		return errors.E(op, pgErr(err))
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return errors.E(op, pgErr(err))
+	}
+	if n == 0 {
+		return errors.E(op, errors.NotExist)
+	}
+
+	return nil
+}
+
+// Delete removes an event and any reports about it from the database. Unlike
+// MarkDeleted, dests that point to it are left without an event. Only a
+// tombstone is kept, so EventStore.Changes can tell clients it's gone. It
+// returns errors.NotExist if the event isn't stored.
+func (e *EventStore) Delete(ctx context.Context, eventID eventdb.EventID) error {
+	const op errors.Op = "EventStore.Delete"
+
+	res, err := e.DB.ExecContext(ctx, `
+	WITH reports AS (
+		DELETE FROM event_reports WHERE event_id = $1
+	), deleted AS (
+		DELETE FROM events WHERE id = $1 RETURNING id
+	)
+	INSERT INTO event_tombstones (id)
+	SELECT id FROM deleted
+	ON CONFLICT (id) DO UPDATE SET deleted_at = clock_timestamp()
+	`, eventID)
+	if err != nil {
+		return errors.E(op, pgErr(err))
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return errors.E(op, pgErr(err))
+	}
+	if n == 0 {
+		return errors.E(op, errors.NotExist)
+	}
+
+	return nil
+}
+
+// IncrementChosen adds one to the count of times an event has been picked
+// for a dest. It returns errors.NotExist if the event isn't stored.
+func (e *EventStore) IncrementChosen(ctx context.Context, eventID eventdb.EventID) error {
+	const op errors.Op = "EventStore.IncrementChosen"
+
+	res, err := e.DB.ExecContext(ctx, `
+	UPDATE events
+	SET times_chosen = times_chosen + 1
+	WHERE id = $1
+	`, eventID)
+	if err != nil {
+		return errors.E(op, pgErr(err))
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return errors.E(op, pgErr(err))
+	}
+	if n == 0 {
+		return errors.E(op, errors.NotExist)
+	}
+
+	return nil
+}
+
+// Report records a user's report that an event is broken. A user has at most
+// one report per event, so reporting again replaces the reason. It returns
+// how many users have reported the event.
+func (e *EventStore) Report(ctx context.Context, report eventdb.EventReport) (reporters int, err error) {
+	const op errors.Op = "EventStore.Report"
+
+	_, err = e.DB.ExecContext(ctx, `
+	INSERT INTO event_reports (event_id, user_id, reason)
+	VALUES ($1, $2, $3)
+	ON CONFLICT (event_id, user_id) DO UPDATE
+		SET reason = EXCLUDED.reason, created_at = now()
+	`, report.EventID, report.UserID, report.Reason)
+	if err != nil {
+		return 0, errors.E(op, pgErr(err))
+	}
+
+	return e.Reporters(ctx, report.EventID)
+}
+
+// Reporters counts the users who have reported an event.
+func (e *EventStore) Reporters(ctx context.Context, eventID eventdb.EventID) (int, error) {
+	const op errors.Op = "EventStore.Reporters"
+
+	var n int
+	err := e.DB.QueryRowContext(ctx, `
+	SELECT COUNT(*) FROM event_reports WHERE event_id = $1
+	`, eventID).Scan(&n)
+	if err != nil {
+		return 0, errors.E(op, pgErr(err))
+	}
+	return n, nil
+}
+
+// ListReports returns the most recent event reports, newest first.
+func (e *EventStore) ListReports(ctx context.Context, limit int) ([]eventdb.EventReport, error) {
+	const op errors.Op = "EventStore.ListReports"
+
+	rows, err := e.readDB().QueryContext(ctx, `
+	SELECT event_id, user_id, reason, created_at
+	FROM event_reports
+	ORDER BY created_at DESC, event_id, user_id
+	LIMIT $1
+	`, limit)
+	if err != nil {
+		return nil, errors.E(op, pgErr(err))
+	}
+	defer rows.Close()
+
+	reports := []eventdb.EventReport{}
+	for rows.Next() {
+		var report eventdb.EventReport
+		if err := rows.Scan(&report.EventID, &report.UserID, &report.Reason, &report.CreatedAt); err != nil {
+			return nil, errors.E(op, pgErr(err))
+		}
+		reports = append(reports, report)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.E(op, pgErr(err))
+	}
+	return reports, nil
+}
+
+// notBlockedSQL leaves out events at a blocklisted place or from a
+// blocklisted owner.
+const notBlockedSQL = `NOT EXISTS (
+			SELECT 1 FROM blocklist
+			WHERE (blocklist.kind, blocklist.id) IN (
+				('place', events.data->'place'->>'id'),
+				('owner', events.data->'owner'->>'id')
+			)
+		)`
+
+// Block adds a place or owner to the blocklist, so their events are left out
+// of searches. Blocking one again replaces the reason.
+func (e *EventStore) Block(ctx context.Context, block eventdb.Block) (eventdb.Block, error) {
+	const op errors.Op = "EventStore.Block"
+
+	err := e.DB.QueryRowContext(ctx, `
+	INSERT INTO blocklist (kind, id, reason)
+	VALUES ($1, $2, $3)
+	ON CONFLICT (kind, id) DO UPDATE
+		SET reason = EXCLUDED.reason
+	RETURNING created_at
+	`, block.Kind, block.ID, block.Reason).Scan(&block.CreatedAt)
+	if err != nil {
+		return block, errors.E(op, pgErr(err))
+	}
+	return block, nil
+}
+
+// Unblock removes a place or owner from the blocklist. It returns
+// errors.NotExist if they weren't blocked.
+func (e *EventStore) Unblock(ctx context.Context, kind eventdb.BlockKind, id string) error {
+	const op errors.Op = "EventStore.Unblock"
+
+	res, err := e.DB.ExecContext(ctx, `
+	DELETE FROM blocklist WHERE kind = $1 AND id = $2
+	`, kind, id)
+	if err != nil {
+		return errors.E(op, pgErr(err))
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return errors.E(op, pgErr(err))
+	}
+	if n == 0 {
+		return errors.E(op, errors.NotExist)
+	}
+	return nil
+}
+
+// ListBlocks returns the blocklist, newest first.
+func (e *EventStore) ListBlocks(ctx context.Context) ([]eventdb.Block, error) {
+	const op errors.Op = "EventStore.ListBlocks"
+
+	blocks := []eventdb.Block{}
+	err := retryRead(ctx, func() error {
+		blocks = blocks[:0]
+
+		rows, err := e.readDB().QueryContext(ctx, `
+		SELECT kind, id, reason, created_at
+		FROM blocklist
+		ORDER BY created_at DESC, kind, id
+		`)
+		if err != nil {
+			return pgErr(err)
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var block eventdb.Block
+			if err := rows.Scan(&block.Kind, &block.ID, &block.Reason, &block.CreatedAt); err != nil {
+				return pgErr(err)
+			}
+			blocks = append(blocks, block)
+		}
+		if err := rows.Err(); err != nil {
+			return pgErr(err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+	return blocks, nil
+}
+
+// SetBad updates an event's 'bad' flag, which determines whether it gets
+// filtered from search results.
+func (e *EventStore) SetBad(ctx context.Context, eventID eventdb.EventID, isBad bool) error {
+	_, err := e.DB.ExecContext(ctx, `
+	UPDATE events
+	SET
+		is_bad = $1,
+		updated_at = CASE WHEN is_bad IS DISTINCT FROM $1 THEN clock_timestamp() ELSE updated_at END
+	WHERE id = $2
+	`, isBad, eventID)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// GetByID finds an event by its ID
+func (e *EventStore) GetByID(ctx context.Context, eventID eventdb.EventID) (eventdb.Event, error) {
+	events, err := e.fetchEvents(ctx, e.readDB(), []eventdb.EventID{eventID}, false)
+	if err != nil {
+		return eventdb.Event{}, errors.E(err)
+	}
+
+	if len(events) == 0 {
+		return eventdb.Event{}, errors.E(errors.NotExist)
+	}
+
+	event := events[0]
+	return event, nil
+}
+
+// Exists reports whether an event with the ID is stored, including deleted
+// events. It's cheaper than GetByID when the event itself isn't needed. It
+// reads from the primary so it sees events that were just saved.
+func (e *EventStore) Exists(ctx context.Context, eventID eventdb.EventID) (exists bool, err error) {
+	const op errors.Op = "EventStore.Exists"
+
+	err = retryRead(ctx, func() error {
+		err := e.DB.QueryRowContext(ctx, `
+		SELECT EXISTS (SELECT 1 FROM events WHERE id = $1)
+		`, eventID).Scan(&exists)
+		return pgErr(err)
+	})
+	if err != nil {
+		return false, errors.E(op, err)
+	}
+	return exists, nil
+}
+
+// GetMulti finds multiple events simultaneously by their IDs.
+func (e *EventStore) GetMulti(ctx context.Context, eventIDs []eventdb.EventID) ([]eventdb.Event, error) {
+	events, err := e.fetchEvents(ctx, e.readDB(), eventIDs, false)
+	if err != nil {
+		return events, errors.E(err, "get multi")
+	}
+
+	return events, nil
+}
+
+// GetMultiPrimary is like GetMulti, but it reads from the primary so it sees
+// events that were just saved.
+func (e *EventStore) GetMultiPrimary(ctx context.Context, eventIDs []eventdb.EventID) ([]eventdb.Event, error) {
+	events, err := e.fetchEvents(ctx, e.DB, eventIDs, false)
+	if err != nil {
+		return events, errors.E(err, "get multi primary")
+	}
+
+	return events, nil
+}
+
+// hasCoordsSQL matches events with a stored location.
+func (e *EventStore) hasCoordsSQL() string {
+	if e.NoPostGIS {
+		return `(latitude IS NOT NULL AND longitude IS NOT NULL)`
+	}
+	return `geom IS NOT NULL`
+}
+
+// latLngSQL selects an event's latitude and longitude.
+func (e *EventStore) latLngSQL() string {
+	if e.NoPostGIS {
+		return `COALESCE(latitude, 0) AS latitude,
+		COALESCE(longitude, 0) AS longitude`
+	}
+	return `COALESCE( ST_Y(ST_Transform(geom, 4326)), 0) AS latitude,
+		COALESCE( ST_X(ST_Transform(geom, 4326)), 0) AS longitude`
+}
+
+// fetchEvents returns the events with the given IDs, in the same order. Times
+// are in each event's time zone, or in UTC if utc is set.
+func (e *EventStore) fetchEvents(ctx context.Context, db *sql.DB, eventIDs []eventdb.EventID, utc bool) ([]eventdb.Event, error) {
+	events := []eventdb.Event{}
+	for _, batch := range e.batches(eventIDs) {
+		var found []eventdb.Event
+		err := retryRead(ctx, func() (err error) {
+			found, err = e.queryEvents(ctx, db, batch, utc)
+			return err
+		})
+		if err != nil {
+			return events, err
+		}
+		events = append(events, found...)
+	}
+	return events, nil
+}
+
+// queryEvents runs the query for fetchEvents.
+func (e *EventStore) queryEvents(ctx context.Context, db *sql.DB, eventIDs []eventdb.EventID, utc bool) ([]eventdb.Event, error) {
+	events := []eventdb.Event{}
+
+	var idStrings pq.StringArray
+	for _, id := range eventIDs {
+		idStrings = append(idStrings, string(id))
+	}
+
+	rows, err := db.QueryContext(ctx, `
+	SELECT
+		`+e.eventColumnsSQL()+`
+	FROM events
+	WHERE
+		id = ANY ($1)
+	ORDER BY array_position($1::text[], id::text)
+	`, idStrings)
+	if err != nil {
+		return events, errors.E(pgErr(err), "select events")
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var event eventdb.Event
+		var timezone string
+		if err := rows.Scan(eventScanDest(&event, &timezone)...); err != nil {
+			return events, pgErr(err)
+		}
+		setEventLocation(&event, timezone, utc)
+
+		events = append(events, event)
+	}
+	if err := rows.Err(); err != nil {
+		return events, pgErr(err)
+	}
+
+	return events, nil
+}
+
+// eventColumnsSQL selects the event columns read by eventScanDest. None of
+// them are NULL, even for the missing side of an outer join, except tags,
+// which scans as nil.
+func (e *EventStore) eventColumnsSQL() string {
+	return `COALESCE(data->>'id', '') AS id,
+
+		COALESCE(data->>'name', '') AS name,
+		COALESCE(data->'cover'->>'source', '') AS cover,
+		COALESCE(data->'cover'->>'id', '') AS cover_id,
+		COALESCE((data->'cover'->>'offset_x')::float8, 0) AS cover_offset_x,
+		COALESCE((data->'cover'->>'offset_y')::float8, 0) AS cover_offset_y,
+		COALESCE(f_event_start_time(data), '0001-01-01 00:00:00+00') AS start_time,
+		COALESCE(f_event_end_time(data), '0001-01-01 00:00:00+00') AS end_time,
+		` + e.latLngSQL() + `,
+
+		COALESCE(data->>'is_canceled', 'false') AS is_canceled,
+
+		COALESCE(is_bad, 'false'),
+		COALESCE(is_deleted, 'false'),
+
+		COALESCE(data->>'description', '') AS description,
+
+		COALESCE(data->'place'->>'name', '') AS place,
+		COALESCE(f_event_address(data), '') AS address,
+		COALESCE(data->'place'->'location'->>'country', '') AS country,
+		COALESCE(data->>'category', '') AS category,
+		tags,
+
+		COALESCE(data->>'timezone', '') AS timezone,
+
+		COALESCE(times_chosen, 0)`
+}
+
+// eventScanDest returns the Scan destinations for the columns selected by
+// eventColumnsSQL. The event's time zone is scanned into timezone, to be
+// passed to setEventLocation.
+func eventScanDest(event *eventdb.Event, timezone *string) []interface{} {
+	return []interface{}{
+		&event.ID,
+		&event.Name,
+		&event.Cover,
+		&event.CoverID,
+		&event.CoverOffsetX,
+		&event.CoverOffsetY,
+		&event.StartTime,
+		&event.EndTime,
+		&event.Latitude,
+		&event.Longitude,
+		&event.IsCanceled,
+		&event.IsBad,
+		&event.IsDeleted,
+		&event.Description,
+		&event.Place,
+		&event.Address,
+		&event.Country,
+		&event.Category,
+		(*pq.StringArray)(&event.Tags),
+		timezone,
+		&event.TimesChosen,
+	}
+}
+
+// setEventLocation puts the event's times in its time zone, or in UTC if utc
+// is set.
+func setEventLocation(event *eventdb.Event, timezone string, utc bool) {
+	location := time.UTC
+	if !utc {
+		location = loadLocation(timezone)
+	}
+	event.StartTime = event.StartTime.In(location)
+	event.EndTime = event.EndTime.In(location)
+}
+
+// locations caches time.LoadLocation, which reads the zone from disk each
+// time it's called.
+var locations = struct {
+	sync.Mutex
+	m map[string]*time.Location
+}{m: make(map[string]*time.Location)}
+
+// maxLocations bounds the locations cache in case events have junk time zone
+// names. There are only a few hundred real ones.
+const maxLocations = 1000
+
+// loadLocation is a cached time.LoadLocation. Unknown zones are treated as
+// UTC.
+func loadLocation(name string) *time.Location {
+	locations.Lock()
+	defer locations.Unlock()
+
+	if location, ok := locations.m[name]; ok {
+		return location
+	}
+
+	location, err := time.LoadLocation(name)
+	if err != nil {
+		location = time.UTC
+	}
+	if len(locations.m) < maxLocations {
+		locations.m[name] = location
+	}
+	return location
+}
+
+// fetchEventsFull is like fetchEvents, but returns raw Graph API JSON.
+func (e *EventStore) fetchEventsFull(ctx context.Context, db *sql.DB, eventIDs []eventdb.EventID) ([]json.RawMessage, error) {
+	events := []json.RawMessage{}
+	for _, batch := range e.batches(eventIDs) {
+		var found []json.RawMessage
+		err := retryRead(ctx, func() (err error) {
+			found, err = e.queryEventsFull(ctx, db, batch)
+			return err
+		})
+		if err != nil {
+			return events, err
+		}
+		events = append(events, found...)
+	}
 	return events, nil
 }
 
-func (e *EventStore) fetchEventsFull(ctx context.Context, eventIDs []eventdb.EventID) ([]json.RawMessage, error) {
+// queryEventsFull runs the query for fetchEventsFull.
+func (e *EventStore) queryEventsFull(ctx context.Context, db *sql.DB, eventIDs []eventdb.EventID) ([]json.RawMessage, error) {
 	events := []json.RawMessage{}
 
 	var idStrings pq.StringArray
@@ -356,13 +1790,13 @@ func (e *EventStore) fetchEventsFull(ctx context.Context, eventIDs []eventdb.Eve
 		idStrings = append(idStrings, string(id))
 	}
 
-	rows, err := e.DB.QueryContext(ctx, `
+	rows, err := db.QueryContext(ctx, `
 	SELECT
 		data::text AS data
 	FROM events
 	WHERE
 		id = ANY ($1)
-	ORDER BY f_event_start_time(data) ASC
+	ORDER BY array_position($1::text[], id::text)
 	`, idStrings)
 	if err != nil {
 		return events, errors.E(pgErr(err), "select events")
@@ -2,10 +2,15 @@ package pg
 
 import (
 	"context"
+	"database/sql"
 	"encoding/json"
 	"fmt"
+	"math"
 	"math/rand"
+	"net/url"
 	"reflect"
+	"sort"
+	"strings"
 	"testing"
 	"time"
 
@@ -15,6 +20,7 @@ import (
 	"github.com/findrandomevents/eventdb/pg/pgtest"
 
 	"github.com/go-test/deep"
+	"github.com/lib/pq"
 )
 
 func TestEventSave(t *testing.T) {
@@ -43,6 +49,7 @@ func TestEventSave(t *testing.T) {
 				"place": {
 					"name": "A place",
 					"location": {
+						"country": "Slovenia",
 						"latitude": 20,
 						"longitude": -20
 					}
@@ -59,6 +66,7 @@ func TestEventSave(t *testing.T) {
 				Name:        "Some event",
 				Description: "Some Description",
 				Place:       "A place",
+				Country:     "Slovenia",
 				Latitude:    20,
 				Longitude:   -20,
 				StartTime:   time.Date(2017, 5, 17, 15, 0, 0, 0, time.UTC),
@@ -261,6 +269,214 @@ func TestEventGet(t *testing.T) {
 	}
 }
 
+func TestEventCover(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	dbx := pgtest.NewDB(t)
+	eventStore := &EventStore{DB: dbx}
+	if err := eventStore.Init(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := eventStore.Save(ctx, json.RawMessage(`{
+		"id": "1",
+		"name": "Some event",
+		"start_time": "2017-05-17T17:00:00+0200",
+		"end_time": "2017-05-17T20:00:00+0200",
+		"cover": {
+			"offset_x": 0,
+			"offset_y": 14,
+			"source": "https://scontent.xx.fbcdn.net/v/t1.0-9/p720x720/cover.jpg",
+			"id": "1239062959554198"
+		}
+	}`))
+	if err != nil {
+		t.Fatalf("save event: %v", err)
+	}
+	_, err = eventStore.Save(ctx, json.RawMessage(`{
+		"id": "2",
+		"name": "No cover",
+		"start_time": "2017-05-17T17:00:00+0200",
+		"end_time": "2017-05-17T20:00:00+0200"
+	}`))
+	if err != nil {
+		t.Fatalf("save event: %v", err)
+	}
+
+	for _, test := range []struct {
+		ID        eventdb.EventID
+		WantCover string
+		WantID    string
+		WantX     float64
+		WantY     float64
+	}{
+		{
+			ID:        "1",
+			WantCover: "https://scontent.xx.fbcdn.net/v/t1.0-9/p720x720/cover.jpg",
+			WantID:    "1239062959554198",
+			WantX:     0,
+			WantY:     14,
+		},
+		{
+			ID: "2",
+		},
+	} {
+		event, err := eventStore.GetByID(ctx, test.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if event.Cover != test.WantCover || event.CoverID != test.WantID {
+			t.Errorf("event %s cover = %q (id %q), want %q (id %q)", test.ID, event.Cover, event.CoverID, test.WantCover, test.WantID)
+		}
+		if event.CoverOffsetX != test.WantX || event.CoverOffsetY != test.WantY {
+			t.Errorf("event %s cover offset = (%v, %v), want (%v, %v)", test.ID, event.CoverOffsetX, event.CoverOffsetY, test.WantX, test.WantY)
+		}
+	}
+}
+
+func TestEventExists(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	dbx := pgtest.NewDB(t)
+	eventStore := &EventStore{DB: dbx}
+	if err := eventStore.Init(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := eventStore.Save(ctx, json.RawMessage(`{
+		"id": "99999",
+		"name": "Some event",
+		"start_time": "2017-05-17T17:00:00+0200",
+		"end_time": "2017-05-17T20:00:00+0200"
+	}`))
+	if err != nil {
+		t.Fatalf("save event: %v", err)
+	}
+
+	for _, test := range []struct {
+		ID   eventdb.EventID
+		Want bool
+	}{
+		{ID: "99999", Want: true},
+		{ID: "nonexistent", Want: false},
+	} {
+		got, err := eventStore.Exists(ctx, test.ID)
+		if err != nil {
+			t.Fatalf("Exists(%q): %v", test.ID, err)
+		}
+		if got != test.Want {
+			t.Fatalf("Exists(%q) = %v, want %v", test.ID, got, test.Want)
+		}
+	}
+}
+
+func TestEventGetMultiBatches(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	dbx := pgtest.NewDB(t)
+	eventStore := &EventStore{DB: dbx, FetchBatchSize: 3}
+	if err := eventStore.Init(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	var wantIDs []eventdb.EventID
+	for i := 0; i < 10; i++ {
+		id := eventdb.EventID(fmt.Sprint(1000 + i))
+		_, err := eventStore.Save(ctx, json.RawMessage(fmt.Sprintf(`{
+			"id": "%s",
+			"name": "Some event",
+			"start_time": "2017-05-17T17:00:00+0200",
+			"end_time": "2017-05-17T20:00:00+0200"
+		}`, id)))
+		if err != nil {
+			t.Fatalf("save event: %v", err)
+		}
+		// Newest first, so the order isn't just insertion order
+		wantIDs = append([]eventdb.EventID{id}, wantIDs...)
+	}
+
+	events, err := eventStore.GetMulti(ctx, wantIDs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var gotIDs []eventdb.EventID
+	for _, event := range events {
+		gotIDs = append(gotIDs, event.ID)
+	}
+	if diff := deep.Equal(gotIDs, wantIDs); diff != nil {
+		t.Fatalf("GetMulti: %v", diff)
+	}
+}
+
+func TestRebuildGeoms(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	dbx := pgtest.NewDB(t)
+	eventStore := &EventStore{DB: dbx}
+	if err := eventStore.Init(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, js := range []string{`{
+		"id": "1",
+		"start_time": "2000-01-01T00:00:00Z",
+		"place": {
+			"location": {
+				"street": "street addr",
+				"latitude": 20,
+				"longitude": -20
+			}
+		}
+	}`, `{
+		"id": "2",
+		"start_time": "2000-01-01T00:00:00Z"
+	}`} {
+		if _, err := eventStore.Save(ctx, json.RawMessage(js)); err != nil {
+			t.Fatalf("save event: %v", err)
+		}
+	}
+
+	if _, err := dbx.ExecContext(ctx, `UPDATE events SET geom = NULL`); err != nil {
+		t.Fatalf("clear geoms: %v", err)
+	}
+
+	broken, err := eventStore.GetByID(ctx, "1")
+	if err != nil {
+		t.Fatalf("GetByID: %v", err)
+	}
+	if got, want := broken.Latitude, 0.0; got != want {
+		t.Fatalf("before RebuildGeoms(): latitude = %v, want %v", got, want)
+	}
+
+	count, err := eventStore.RebuildGeoms(ctx)
+	if err != nil {
+		t.Fatalf("RebuildGeoms: %v", err)
+	}
+	if got, want := count, 1; got != want {
+		t.Fatalf("RebuildGeoms() = %d, want %d", got, want)
+	}
+
+	fixed, err := eventStore.GetByID(ctx, "1")
+	if err != nil {
+		t.Fatalf("GetByID: %v", err)
+	}
+	if fixed.Latitude != 20 || fixed.Longitude != -20 {
+		t.Fatalf("after RebuildGeoms(): lat,lng = %v,%v, want 20,-20", fixed.Latitude, fixed.Longitude)
+	}
+}
+
 func TestEventSearchFilter(t *testing.T) {
 	t.Parallel()
 
@@ -295,50 +511,98 @@ func TestEventSearchFilter(t *testing.T) {
 			WantIDs: []eventdb.EventID{"1"},
 		},
 		{
-			Name: "out of in bounds",
+			Name: "dedupe by venue",
 			Events: []string{`{
 				"id": "1",
-				"start_time": "2000-01-01T00:00:00Z",
+				"start_time": "2000-01-01T01:00:00Z",
+				"place": {
+					"id": "p1",
+					"location": {
+						"street": "street addr",
+						"latitude": 20,
+						"longitude": 20
+					}
+				}
+			}`, `{
+				"id": "2",
+				"start_time": "2000-01-01T02:00:00Z",
+				"place": {
+					"id": "p1",
+					"location": {
+						"street": "street addr",
+						"latitude": 20,
+						"longitude": 20
+					}
+				}
+			}`, `{
+				"id": "3",
+				"start_time": "2000-01-01T03:00:00Z",
 				"place": {
+					"id": "p1",
 					"location": {
 						"street": "street addr",
 						"latitude": 20,
 						"longitude": 20
 					}
 				}
+			}`, `{
+				"id": "4",
+				"start_time": "2000-01-01T02:00:00Z",
+				"place": {
+					"id": "p2",
+					"location": {
+						"street": "street addr",
+						"latitude": 20.0001,
+						"longitude": 20
+					}
+				}
 			}`},
 			Search: eventdb.EventSearchRequest{
-				Bounds: geojson.CircleGeom(0, 0, 1),
-				Start:  time.Date(1999, 1, 1, 0, 0, 0, 0, time.UTC),
-				End:    time.Date(2001, 1, 1, 0, 0, 0, 0, time.UTC),
+				Bounds:        geojson.CircleGeom(20, 20, 100),
+				Start:         time.Date(1999, 1, 1, 0, 0, 0, 0, time.UTC),
+				End:           time.Date(2001, 1, 1, 0, 0, 0, 0, time.UTC),
+				DedupeByVenue: true,
 			},
-			WantIDs: nil,
+			WantIDs: []eventdb.EventID{"1", "4"},
 		},
 		{
-			Name: "no street address",
+			Name: "started too long ago",
 			Events: []string{`{
 				"id": "1",
-				"start_time": "2000-01-01T00:00:00Z",
+				"start_time": "2000-01-01T09:00:00Z",
+				"end_time": "2000-01-01T13:00:00Z",
 				"place": {
 					"location": {
+						"street": "street addr",
+						"latitude": 20,
+						"longitude": 20
+					}
+				}
+			}`, `{
+				"id": "2",
+				"start_time": "2000-01-01T11:50:00Z",
+				"end_time": "2000-01-01T13:00:00Z",
+				"place": {
+					"location": {
+						"street": "street addr",
 						"latitude": 20,
 						"longitude": 20
 					}
 				}
 			}`},
 			Search: eventdb.EventSearchRequest{
-				Bounds: geojson.CircleGeom(20, 20, 1),
-				Start:  time.Date(1999, 1, 1, 0, 0, 0, 0, time.UTC),
-				End:    time.Date(2001, 1, 1, 0, 0, 0, 0, time.UTC),
+				Bounds:            geojson.CircleGeom(20, 20, 1),
+				Start:             time.Date(2000, 1, 1, 12, 0, 0, 0, time.UTC),
+				End:               time.Date(2000, 1, 1, 14, 0, 0, 0, time.UTC),
+				MaxElapsedMinutes: 30,
 			},
-			WantIDs: nil,
+			WantIDs: []eventdb.EventID{"2"},
 		},
 		{
-			Name: "long event",
+			Name: "out of in bounds",
 			Events: []string{`{
 				"id": "1",
 				"start_time": "2000-01-01T00:00:00Z",
-				"end_time": "2001-01-01T00:00:00Z",
 				"place": {
 					"location": {
 						"street": "street addr",
@@ -348,21 +612,19 @@ func TestEventSearchFilter(t *testing.T) {
 				}
 			}`},
 			Search: eventdb.EventSearchRequest{
-				Bounds: geojson.CircleGeom(20, 20, 1),
+				Bounds: geojson.CircleGeom(0, 0, 1),
 				Start:  time.Date(1999, 1, 1, 0, 0, 0, 0, time.UTC),
 				End:    time.Date(2001, 1, 1, 0, 0, 0, 0, time.UTC),
 			},
 			WantIDs: nil,
 		},
 		{
-			Name: "ends before search window",
+			Name: "no street address",
 			Events: []string{`{
 				"id": "1",
 				"start_time": "2000-01-01T00:00:00Z",
-				"end_time": "2000-01-01T01:00:00Z",
 				"place": {
 					"location": {
-						"street": "street addr",
 						"latitude": 20,
 						"longitude": 20
 					}
@@ -370,16 +632,17 @@ func TestEventSearchFilter(t *testing.T) {
 			}`},
 			Search: eventdb.EventSearchRequest{
 				Bounds: geojson.CircleGeom(20, 20, 1),
-				Start:  time.Date(2000, 1, 1, 9, 0, 0, 0, time.UTC),
-				End:    time.Date(2000, 1, 1, 10, 0, 0, 0, time.UTC),
+				Start:  time.Date(1999, 1, 1, 0, 0, 0, 0, time.UTC),
+				End:    time.Date(2001, 1, 1, 0, 0, 0, 0, time.UTC),
 			},
 			WantIDs: nil,
 		},
 		{
-			Name: "starts after search window",
+			Name: "long event",
 			Events: []string{`{
 				"id": "1",
-				"start_time": "2001-01-01T00:00:00Z",
+				"start_time": "2000-01-01T00:00:00Z",
+				"end_time": "2001-01-01T00:00:00Z",
 				"place": {
 					"location": {
 						"street": "street addr",
@@ -390,17 +653,17 @@ func TestEventSearchFilter(t *testing.T) {
 			}`},
 			Search: eventdb.EventSearchRequest{
 				Bounds: geojson.CircleGeom(20, 20, 1),
-				Start:  time.Date(2000, 1, 1, 1, 0, 0, 0, time.UTC),
-				End:    time.Date(2000, 1, 1, 2, 0, 0, 0, time.UTC),
+				Start:  time.Date(1999, 1, 1, 0, 0, 0, 0, time.UTC),
+				End:    time.Date(2001, 1, 1, 0, 0, 0, 0, time.UTC),
 			},
 			WantIDs: nil,
 		},
 		{
-			Name: "starts in middle of search window",
+			Name: "allow long events",
 			Events: []string{`{
 				"id": "1",
-				"start_time": "2000-01-01T01:00:00Z",
-				"end_time": "2000-01-01T02:00:00Z",
+				"start_time": "2000-01-01T00:00:00Z",
+				"end_time": "2000-01-04T00:00:00Z",
 				"place": {
 					"location": {
 						"street": "street addr",
@@ -410,18 +673,19 @@ func TestEventSearchFilter(t *testing.T) {
 				}
 			}`},
 			Search: eventdb.EventSearchRequest{
-				Bounds: geojson.CircleGeom(20, 20, 1),
-				Start:  time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC),
-				End:    time.Date(2000, 1, 1, 5, 0, 0, 0, time.UTC),
+				Bounds:          geojson.CircleGeom(20, 20, 1),
+				Start:           time.Date(2000, 1, 2, 0, 0, 0, 0, time.UTC),
+				End:             time.Date(2000, 1, 2, 1, 0, 0, 0, time.UTC),
+				AllowLongEvents: true,
 			},
 			WantIDs: []eventdb.EventID{"1"},
 		},
 		{
-			Name: "ends after search window",
+			Name: "three day event",
 			Events: []string{`{
 				"id": "1",
-				"start_time": "2000-01-01T01:00:00Z",
-				"end_time": "2000-01-01T03:00:00Z",
+				"start_time": "2000-01-01T00:00:00Z",
+				"end_time": "2000-01-04T00:00:00Z",
 				"place": {
 					"location": {
 						"street": "street addr",
@@ -432,17 +696,17 @@ func TestEventSearchFilter(t *testing.T) {
 			}`},
 			Search: eventdb.EventSearchRequest{
 				Bounds: geojson.CircleGeom(20, 20, 1),
-				Start:  time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC),
-				End:    time.Date(2000, 1, 1, 2, 0, 0, 0, time.UTC),
+				Start:  time.Date(2000, 1, 2, 0, 0, 0, 0, time.UTC),
+				End:    time.Date(2000, 1, 2, 1, 0, 0, 0, time.UTC),
 			},
-			WantIDs: []eventdb.EventID{"1"},
+			WantIDs: nil,
 		},
 		{
-			Name: "bad event",
+			Name: "free only",
 			Events: []string{`{
 				"id": "1",
 				"start_time": "2000-01-01T00:00:00Z",
-				"description": "$5 bad description",
+				"description": "come one, come all",
 				"place": {
 					"location": {
 						"street": "street addr",
@@ -450,21 +714,32 @@ func TestEventSearchFilter(t *testing.T) {
 						"longitude": 20
 					}
 				}
-			}`},
-			IsBad: true,
-			Search: eventdb.EventSearchRequest{
-				Bounds: geojson.CircleGeom(20, 20, 1),
-				Start:  time.Date(1999, 1, 1, 0, 0, 0, 0, time.UTC),
-				End:    time.Date(2001, 1, 1, 0, 0, 0, 0, time.UTC),
-			},
-			WantIDs: nil,
-		},
-		{
-			Name: "include bad",
-			Events: []string{`{
-				"id": "1",
+			}`, `{
+				"id": "2",
 				"start_time": "2000-01-01T00:00:00Z",
-				"description": "$5 bad description",
+				"ticket_uri": "https://example.com/tickets",
+				"place": {
+					"location": {
+						"street": "street addr",
+						"latitude": 20,
+						"longitude": 20
+					}
+				}
+			}`, `{
+				"id": "3",
+				"start_time": "2000-01-01T00:00:00Z",
+				"description": "entry $5",
+				"place": {
+					"location": {
+						"street": "street addr",
+						"latitude": 20,
+						"longitude": 20
+					}
+				}
+			}`, `{
+				"id": "4",
+				"start_time": "2000-01-01T00:00:00Z",
+				"description": "entry $0",
 				"place": {
 					"location": {
 						"street": "street addr",
@@ -473,120 +748,1519 @@ func TestEventSearchFilter(t *testing.T) {
 					}
 				}
 			}`},
-			IsBad: true,
 			Search: eventdb.EventSearchRequest{
 				Bounds:     geojson.CircleGeom(20, 20, 1),
 				Start:      time.Date(1999, 1, 1, 0, 0, 0, 0, time.UTC),
 				End:        time.Date(2001, 1, 1, 0, 0, 0, 0, time.UTC),
 				IncludeBad: true,
+				FreeOnly:   true,
 			},
-			WantIDs: []eventdb.EventID{"1"},
+			WantIDs: []eventdb.EventID{"1", "4"},
 		},
-	} {
-		dbx := pgtest.NewDB(t)
-		store := &EventStore{DB: dbx}
-		if err := store.Init(ctx); err != nil {
-			t.Fatal(err)
-		}
-
-		for _, e := range test.Events {
-			saved, err := store.Save(ctx, json.RawMessage(e))
-			if err != nil {
-				t.Fatalf("event save: %v", err)
-			}
-
-			if err := store.SetBad(ctx, saved.ID, test.IsBad); err != nil {
-				t.Fatalf("set bad: %v", err)
-			}
-		}
-
-		res, err := store.Search(ctx, test.Search)
-		if err != nil {
-			t.Fatalf("event search: %v", err)
-		}
-		var ids []eventdb.EventID
-		for _, e := range res {
+		{
+			Name: "max price cents",
+			Events: []string{`{
+				"id": "1",
+				"start_time": "2000-01-01T00:00:00Z",
+				"description": "Tickets $4.99"
+			}`, `{
+				"id": "2",
+				"start_time": "2000-01-01T00:00:00Z",
+				"description": "Tickets 5 dollars, 5.01 at the door"
+			}`, `{
+				"id": "3",
+				"start_time": "2000-01-01T00:00:00Z",
+				"description": "Tickets $5.50"
+			}`, `{
+				"id": "4",
+				"start_time": "2000-01-01T00:00:00Z",
+				"description": "No price listed"
+			}`},
+			Search: eventdb.EventSearchRequest{
+				Start:         time.Date(1999, 1, 1, 0, 0, 0, 0, time.UTC),
+				End:           time.Date(2001, 1, 1, 0, 0, 0, 0, time.UTC),
+				IncludeBad:    true,
+				Currency:      "USD",
+				MaxPriceCents: 500,
+			},
+			WantIDs: []eventdb.EventID{"1", "2", "4"},
+		},
+		{
+			Name: "max price cents without cents",
+			Events: []string{`{
+				"id": "1",
+				"start_time": "2000-01-01T00:00:00Z",
+				"description": "入場料 ¥800"
+			}`, `{
+				"id": "2",
+				"start_time": "2000-01-01T00:00:00Z",
+				"description": "入場料 ¥1,500"
+			}`},
+			Search: eventdb.EventSearchRequest{
+				Start:         time.Date(1999, 1, 1, 0, 0, 0, 0, time.UTC),
+				End:           time.Date(2001, 1, 1, 0, 0, 0, 0, time.UTC),
+				Currency:      "JPY",
+				MaxPriceCents: 1000,
+			},
+			WantIDs: []eventdb.EventID{"1"},
+		},
+		{
+			Name: "max price in currency",
+			Events: []string{`{
+				"id": "1",
+				"start_time": "2000-01-01T00:00:00Z",
+				"description": "入場料 ¥800"
+			}`, `{
+				"id": "2",
+				"start_time": "2000-01-01T00:00:00Z",
+				"description": "入場料 ¥1,500"
+			}`, `{
+				"id": "3",
+				"start_time": "2000-01-01T00:00:00Z",
+				"description": "Eintritt 5 €"
+			}`, `{
+				"id": "4",
+				"start_time": "2000-01-01T00:00:00Z",
+				"description": "No price listed"
+			}`, `{
+				"id": "5",
+				"start_time": "2000-01-01T00:00:00Z",
+				"description": "Tickets 20,00 € or ¥900"
+			}`},
+			Search: eventdb.EventSearchRequest{
+				Start:    time.Date(1999, 1, 1, 0, 0, 0, 0, time.UTC),
+				End:      time.Date(2001, 1, 1, 0, 0, 0, 0, time.UTC),
+				Currency: "JPY",
+				MaxPrice: 1000,
+			},
+			WantIDs: []eventdb.EventID{"1", "4", "5"},
+		},
+		{
+			Name: "query",
+			Events: []string{`{
+				"id": "1",
+				"name": "Jazz night",
+				"start_time": "2000-01-01T00:00:00Z",
+				"description": "Live music at the park"
+			}`, `{
+				"id": "2",
+				"name": "Poetry reading",
+				"start_time": "2000-01-01T00:00:00Z",
+				"description": "Bring your own poems"
+			}`},
+			Search: eventdb.EventSearchRequest{
+				Start: time.Date(1999, 1, 1, 0, 0, 0, 0, time.UTC),
+				End:   time.Date(2001, 1, 1, 0, 0, 0, 0, time.UTC),
+				Query: "jazz",
+			},
+			WantIDs: []eventdb.EventID{"1"},
+		},
+		{
+			Name: "misspelled query",
+			Events: []string{`{
+				"id": "1",
+				"name": "Jazz night",
+				"start_time": "2000-01-01T00:00:00Z",
+				"description": "Live music at the park"
+			}`, `{
+				"id": "2",
+				"name": "Poetry reading",
+				"start_time": "2000-01-01T00:00:00Z",
+				"description": "Bring your own poems"
+			}`},
+			Search: eventdb.EventSearchRequest{
+				Start: time.Date(1999, 1, 1, 0, 0, 0, 0, time.UTC),
+				End:   time.Date(2001, 1, 1, 0, 0, 0, 0, time.UTC),
+				Query: "jaz",
+			},
+			WantIDs: nil,
+		},
+		{
+			Name: "misspelled query fuzzy",
+			Events: []string{`{
+				"id": "1",
+				"name": "Jazz night",
+				"start_time": "2000-01-01T00:00:00Z",
+				"description": "Live music at the park"
+			}`, `{
+				"id": "2",
+				"name": "Poetry reading",
+				"start_time": "2000-01-01T00:00:00Z",
+				"description": "Bring your own poems"
+			}`},
+			Search: eventdb.EventSearchRequest{
+				Start: time.Date(1999, 1, 1, 0, 0, 0, 0, time.UTC),
+				End:   time.Date(2001, 1, 1, 0, 0, 0, 0, time.UTC),
+				Query: "jaz",
+				Fuzzy: true,
+			},
+			WantIDs: []eventdb.EventID{"1"},
+		},
+		{
+			Name: "fuzzy query past the exact matches",
+			Events: []string{`{
+				"id": "1",
+				"name": "Jazz night",
+				"start_time": "2000-01-01T00:00:00Z",
+				"description": "Live music at the park"
+			}`, `{
+				"id": "2",
+				"name": "Jazzz festival",
+				"start_time": "2000-01-01T00:00:00Z",
+				"description": "Bring your own chair"
+			}`},
+			Search: eventdb.EventSearchRequest{
+				Start:  time.Date(1999, 1, 1, 0, 0, 0, 0, time.UTC),
+				End:    time.Date(2001, 1, 1, 0, 0, 0, 0, time.UTC),
+				Query:  "jazz",
+				Fuzzy:  true,
+				Offset: 1,
+			},
+			WantIDs: nil,
+		},
+		{
+			Name: "tags",
+			Events: []string{`{
+				"id": "1",
+				"name": "Jazz night",
+				"start_time": "2000-01-01T00:00:00Z",
+				"description": "Live music, free entry"
+			}`, `{
+				"id": "2",
+				"name": "Poetry reading",
+				"start_time": "2000-01-01T00:00:00Z",
+				"description": "Bring your own poems"
+			}`, `{
+				"id": "3",
+				"name": "Picnic",
+				"start_time": "2000-01-01T00:00:00Z",
+				"description": "Lunch in the park"
+			}`},
+			Search: eventdb.EventSearchRequest{
+				Start: time.Date(1999, 1, 1, 0, 0, 0, 0, time.UTC),
+				End:   time.Date(2001, 1, 1, 0, 0, 0, 0, time.UTC),
+				Tags:  []string{"live music", "outdoor"},
+			},
+			WantIDs: []eventdb.EventID{"1", "3"},
+		},
+		{
+			Name: "place name",
+			Events: []string{`{
+				"id": "1",
+				"start_time": "2000-01-01T00:00:00Z",
+				"place": {
+					"name": "Golden Gate Park",
+					"location": {
+						"street": "501 Stanyan St",
+						"latitude": 20,
+						"longitude": 20
+					}
+				}
+			}`, `{
+				"id": "2",
+				"start_time": "2000-01-01T00:00:00Z",
+				"place": {
+					"name": "Dolores Park",
+					"location": {
+						"street": "Dolores St & 19th St",
+						"latitude": 20,
+						"longitude": 20
+					}
+				}
+			}`},
+			Search: eventdb.EventSearchRequest{
+				Bounds:    geojson.CircleGeom(20, 20, 1),
+				Start:     time.Date(1999, 1, 1, 0, 0, 0, 0, time.UTC),
+				End:       time.Date(2001, 1, 1, 0, 0, 0, 0, time.UTC),
+				PlaceName: "golden gate",
+			},
+			WantIDs: []eventdb.EventID{"1"},
+		},
+		{
+			Name: "place name matches address",
+			Events: []string{`{
+				"id": "1",
+				"start_time": "2000-01-01T00:00:00Z",
+				"place": {
+					"name": "Golden Gate Park",
+					"location": {
+						"street": "501 Stanyan St",
+						"latitude": 20,
+						"longitude": 20
+					}
+				}
+			}`},
+			Search: eventdb.EventSearchRequest{
+				Bounds:    geojson.CircleGeom(20, 20, 1),
+				Start:     time.Date(1999, 1, 1, 0, 0, 0, 0, time.UTC),
+				End:       time.Date(2001, 1, 1, 0, 0, 0, 0, time.UTC),
+				PlaceName: "STANYAN",
+			},
+			WantIDs: []eventdb.EventID{"1"},
+		},
+		{
+			Name: "place name wildcards are literal",
+			Events: []string{`{
+				"id": "1",
+				"start_time": "2000-01-01T00:00:00Z",
+				"place": {
+					"name": "Golden Gate Park",
+					"location": {
+						"street": "501 Stanyan St",
+						"latitude": 20,
+						"longitude": 20
+					}
+				}
+			}`},
+			Search: eventdb.EventSearchRequest{
+				Bounds:    geojson.CircleGeom(20, 20, 1),
+				Start:     time.Date(1999, 1, 1, 0, 0, 0, 0, time.UTC),
+				End:       time.Date(2001, 1, 1, 0, 0, 0, 0, time.UTC),
+				PlaceName: "gold%park",
+			},
+			WantIDs: nil,
+		},
+		{
+			Name: "no bounds",
+			Events: []string{`{
+				"id": "1",
+				"start_time": "2000-01-01T00:00:00Z",
+				"place": {
+					"location": {
+						"street": "street addr",
+						"latitude": 20,
+						"longitude": 20
+					}
+				}
+			}`, `{
+				"id": "2",
+				"start_time": "2000-01-01T01:00:00Z",
+				"place": {
+					"name": "Somewhere without an address"
+				}
+			}`, `{
+				"id": "3",
+				"start_time": "2003-01-01T00:00:00Z",
+				"place": {
+					"location": {
+						"street": "street addr",
+						"latitude": -40,
+						"longitude": 100
+					}
+				}
+			}`},
+			Search: eventdb.EventSearchRequest{
+				Start: time.Date(1999, 1, 1, 0, 0, 0, 0, time.UTC),
+				End:   time.Date(2001, 1, 1, 0, 0, 0, 0, time.UTC),
+			},
+			WantIDs: []eventdb.EventID{"1", "2"},
+		},
+		{
+			Name: "limit and offset",
+			Events: []string{`{
+				"id": "1",
+				"start_time": "2000-01-01T03:00:00Z"
+			}`, `{
+				"id": "2",
+				"start_time": "2000-01-01T02:00:00Z"
+			}`, `{
+				"id": "3",
+				"start_time": "2000-01-01T01:00:00Z"
+			}`},
+			Search: eventdb.EventSearchRequest{
+				Start:  time.Date(1999, 1, 1, 0, 0, 0, 0, time.UTC),
+				End:    time.Date(2001, 1, 1, 0, 0, 0, 0, time.UTC),
+				Limit:  2,
+				Offset: 1,
+			},
+			WantIDs: []eventdb.EventID{"2", "1"},
+		},
+		{
+			Name: "ends before search window",
+			Events: []string{`{
+				"id": "1",
+				"start_time": "2000-01-01T00:00:00Z",
+				"end_time": "2000-01-01T01:00:00Z",
+				"place": {
+					"location": {
+						"street": "street addr",
+						"latitude": 20,
+						"longitude": 20
+					}
+				}
+			}`},
+			Search: eventdb.EventSearchRequest{
+				Bounds: geojson.CircleGeom(20, 20, 1),
+				Start:  time.Date(2000, 1, 1, 9, 0, 0, 0, time.UTC),
+				End:    time.Date(2000, 1, 1, 10, 0, 0, 0, time.UTC),
+			},
+			WantIDs: nil,
+		},
+		{
+			Name: "starts after search window",
+			Events: []string{`{
+				"id": "1",
+				"start_time": "2001-01-01T00:00:00Z",
+				"place": {
+					"location": {
+						"street": "street addr",
+						"latitude": 20,
+						"longitude": 20
+					}
+				}
+			}`},
+			Search: eventdb.EventSearchRequest{
+				Bounds: geojson.CircleGeom(20, 20, 1),
+				Start:  time.Date(2000, 1, 1, 1, 0, 0, 0, time.UTC),
+				End:    time.Date(2000, 1, 1, 2, 0, 0, 0, time.UTC),
+			},
+			WantIDs: nil,
+		},
+		{
+			Name: "starts in middle of search window",
+			Events: []string{`{
+				"id": "1",
+				"start_time": "2000-01-01T01:00:00Z",
+				"end_time": "2000-01-01T02:00:00Z",
+				"place": {
+					"location": {
+						"street": "street addr",
+						"latitude": 20,
+						"longitude": 20
+					}
+				}
+			}`},
+			Search: eventdb.EventSearchRequest{
+				Bounds: geojson.CircleGeom(20, 20, 1),
+				Start:  time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC),
+				End:    time.Date(2000, 1, 1, 5, 0, 0, 0, time.UTC),
+			},
+			WantIDs: []eventdb.EventID{"1"},
+		},
+		{
+			Name: "ends after search window",
+			Events: []string{`{
+				"id": "1",
+				"start_time": "2000-01-01T01:00:00Z",
+				"end_time": "2000-01-01T03:00:00Z",
+				"place": {
+					"location": {
+						"street": "street addr",
+						"latitude": 20,
+						"longitude": 20
+					}
+				}
+			}`},
+			Search: eventdb.EventSearchRequest{
+				Bounds: geojson.CircleGeom(20, 20, 1),
+				Start:  time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC),
+				End:    time.Date(2000, 1, 1, 2, 0, 0, 0, time.UTC),
+			},
+			WantIDs: []eventdb.EventID{"1"},
+		},
+		{
+			Name: "bad event",
+			Events: []string{`{
+				"id": "1",
+				"start_time": "2000-01-01T00:00:00Z",
+				"description": "$5 bad description",
+				"place": {
+					"location": {
+						"street": "street addr",
+						"latitude": 20,
+						"longitude": 20
+					}
+				}
+			}`},
+			IsBad: true,
+			Search: eventdb.EventSearchRequest{
+				Bounds: geojson.CircleGeom(20, 20, 1),
+				Start:  time.Date(1999, 1, 1, 0, 0, 0, 0, time.UTC),
+				End:    time.Date(2001, 1, 1, 0, 0, 0, 0, time.UTC),
+			},
+			WantIDs: nil,
+		},
+		{
+			Name: "include bad",
+			Events: []string{`{
+				"id": "1",
+				"start_time": "2000-01-01T00:00:00Z",
+				"description": "$5 bad description",
+				"place": {
+					"location": {
+						"street": "street addr",
+						"latitude": 20,
+						"longitude": 20
+					}
+				}
+			}`},
+			IsBad: true,
+			Search: eventdb.EventSearchRequest{
+				Bounds:     geojson.CircleGeom(20, 20, 1),
+				Start:      time.Date(1999, 1, 1, 0, 0, 0, 0, time.UTC),
+				End:        time.Date(2001, 1, 1, 0, 0, 0, 0, time.UTC),
+				IncludeBad: true,
+			},
+			WantIDs: []eventdb.EventID{"1"},
+		},
+	} {
+		// Searches should give the same results with and without PostGIS
+		for _, noPostGIS := range []bool{false, true} {
+			name := test.Name
+			if noPostGIS {
+				name += " (no postgis)"
+			}
+			testEventSearchFilter(ctx, t, name, &EventStore{DB: pgtest.NewDB(t), NoPostGIS: noPostGIS}, test.Events, test.IsBad, test.Search, test.WantIDs)
+		}
+	}
+}
+
+func testEventSearchFilter(ctx context.Context, t *testing.T, name string, store *EventStore, events []string, isBad bool, search eventdb.EventSearchRequest, wantIDs []eventdb.EventID) {
+	t.Helper()
+
+	if err := store.Init(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, e := range events {
+		saved, err := store.Save(ctx, json.RawMessage(e))
+		if err != nil {
+			t.Fatalf("event save: %v", err)
+		}
+
+		if err := store.SetBad(ctx, saved.ID, isBad); err != nil {
+			t.Fatalf("set bad: %v", err)
+		}
+	}
+
+	res, err := store.Search(ctx, search)
+	if err != nil {
+		t.Fatalf("event search: %v", err)
+	}
+	var ids []eventdb.EventID
+	for _, e := range res {
+		ids = append(ids, e.ID)
+	}
+
+	if got, want := ids, wantIDs; !reflect.DeepEqual(got, want) {
+		t.Fatalf("search (%v): got ids=%v, want %v", name, got, want)
+	}
+
+	fullRes, err := store.SearchFull(ctx, search)
+	if err != nil {
+		t.Fatalf("event search (full): %v", err)
+	}
+	var fullIDs []eventdb.EventID
+	for _, e := range fullRes {
+		var fbData struct {
+			ID eventdb.EventID `json:"id"`
+		}
+		if err := json.Unmarshal(e, &fbData); err != nil {
+			t.Fatalf("SearchFull (%v): %v", name, err)
+		}
+		fullIDs = append(fullIDs, fbData.ID)
+	}
+
+	if got, want := fullIDs, wantIDs; !reflect.DeepEqual(got, want) {
+		t.Fatalf("search (full) (%v): got ids=%v, want %v", name, got, want)
+	}
+}
+
+func BenchmarkSearch(b *testing.B) {
+	b.Skip("this benchmark is really flaky")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	dbx := pgtest.NewDB(b)
+	store := &EventStore{DB: dbx}
+	if err := store.Init(ctx); err != nil {
+		b.Fatal(err)
+	}
+
+	for i := 0; i < 500; i++ {
+		id := fmt.Sprint(i)
+		lat := rand.Float64() * 10
+		lng := rand.Float64() * 10
+		js := fmt.Sprintf(`{
+				"id": %q,
+				"start_time": "2000-01-01T00:00:00Z",
+				"place": {
+					"location": {
+						"street": "street addr",
+						"latitude": %f,
+						"longitude": %f
+					}
+				}
+			}`, id, lat, lng)
+
+		if _, err := store.Save(ctx, json.RawMessage(js)); err != nil {
+			b.Fatalf("save: %v", err)
+		}
+	}
+
+	params := eventdb.EventSearchRequest{
+		Bounds: geojson.CircleGeom(0, 0, 1),
+		Start:  time.Date(1999, 1, 1, 0, 0, 0, 0, time.UTC),
+		End:    time.Date(2001, 1, 1, 0, 0, 0, 0, time.UTC),
+	}
+
+	b.ResetTimer()
+
+	for n := 0; n < b.N; n++ {
+		_, err := store.Search(ctx, params)
+		if err != nil {
+			b.Fatalf("search: %v", err)
+		}
+	}
+}
+
+func TestEventSearchTimesInUTC(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	dbx := pgtest.NewDB(t)
+	store := &EventStore{DB: dbx}
+	if err := store.Init(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := store.Save(ctx, json.RawMessage(`{
+		"id": "1",
+		"start_time": "2000-01-01T12:00:00+0100",
+		"timezone": "Europe/Berlin",
+		"place": {
+			"location": {
+				"street": "street addr",
+				"latitude": 20,
+				"longitude": 20
+			}
+		}
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, utc := range []bool{false, true} {
+		events, err := store.Search(ctx, eventdb.EventSearchRequest{
+			Bounds:     geojson.CircleGeom(20, 20, 1),
+			Start:      time.Date(1999, 1, 1, 0, 0, 0, 0, time.UTC),
+			End:        time.Date(2001, 1, 1, 0, 0, 0, 0, time.UTC),
+			TimesInUTC: utc,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(events) != 1 {
+			t.Fatalf("TimesInUTC=%v: got %d events, want 1", utc, len(events))
+		}
+
+		start := events[0].StartTime
+		want := time.Date(2000, 1, 1, 11, 0, 0, 0, time.UTC)
+		if !start.Equal(want) {
+			t.Fatalf("TimesInUTC=%v: start = %v, want %v", utc, start, want)
+		}
+		wantZone := "Europe/Berlin"
+		if utc {
+			wantZone = "UTC"
+		}
+		if got := start.Location().String(); got != wantZone {
+			t.Fatalf("TimesInUTC=%v: start time zone = %q, want %q", utc, got, wantZone)
+		}
+	}
+}
+
+func TestLoadLocation(t *testing.T) {
+	t.Parallel()
+
+	first := loadLocation("America/New_York")
+	if got, want := first.String(), "America/New_York"; got != want {
+		t.Fatalf("loadLocation() = %q, want %q", got, want)
+	}
+	for i := 0; i < 100; i++ {
+		if loadLocation("America/New_York") != first {
+			t.Fatalf("loadLocation() reloaded a cached zone")
+		}
+	}
+
+	if got := loadLocation("Not/A_Zone"); got != time.UTC {
+		t.Fatalf("loadLocation() of unknown zone = %v, want UTC", got)
+	}
+}
+
+// BenchmarkLoadLocation shows the per-event cost of converting times to the
+// event's zone, with and without the cache.
+func BenchmarkLoadLocation(b *testing.B) {
+	b.Run("uncached", func(b *testing.B) {
+		for n := 0; n < b.N; n++ {
+			if _, err := time.LoadLocation("Europe/Belgrade"); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("cached", func(b *testing.B) {
+		for n := 0; n < b.N; n++ {
+			loadLocation("Europe/Belgrade")
+		}
+	})
+}
+
+func getTZ(location string) *time.Location {
+	l, err := time.LoadLocation(location)
+	if err != nil {
+		panic(err)
+	}
+	return l
+}
+
+func TestEventCount(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	dbx := pgtest.NewDB(t)
+	eventStore := &EventStore{DB: dbx}
+	if err := eventStore.Init(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	count, err := eventStore.Count(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := count, int64(0); got != want {
+		t.Fatalf("empty store Count() = %d, want %d", got, want)
+	}
+
+	for i := 0; i < 3; i++ {
+		js := fmt.Sprintf(`{"id": "%d", "start_time": "2000-01-01T00:00:00Z"}`, i)
+		if _, err := eventStore.Save(ctx, json.RawMessage(js)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	count, err = eventStore.Count(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := count, int64(3); got != want {
+		t.Fatalf("Count() = %d, want %d", got, want)
+	}
+}
+
+func TestEventChanges(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	dbx := pgtest.NewDB(t)
+	store := &EventStore{DB: dbx}
+	if err := store.Init(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	save := func(id, name string) {
+		js := fmt.Sprintf(`{"id": %q, "name": %q, "start_time": "2000-01-01T00:00:00Z"}`, id, name)
+		if _, err := store.Save(ctx, json.RawMessage(js)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	changes := func(since eventdb.SyncCursor) (ids, tombstones []eventdb.EventID, next eventdb.SyncCursor) {
+		events, tombstones, next, err := store.Changes(ctx, since, 100)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, e := range events {
 			ids = append(ids, e.ID)
 		}
+		sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+		sort.Slice(tombstones, func(i, j int) bool { return tombstones[i] < tombstones[j] })
+		return ids, tombstones, next
+	}
+
+	save("1", "one")
+	save("2", "two")
+	save("3", "three")
+
+	ids, tombstones, cursor := changes(eventdb.SyncCursor{})
+	if got, want := ids, []eventdb.EventID{"1", "2", "3"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("first sync got events %v, want %v", got, want)
+	}
+	if len(tombstones) != 0 {
+		t.Fatalf("first sync got tombstones %v, want none", tombstones)
+	}
+
+	// Nothing changed yet
+	ids, tombstones, next := changes(cursor)
+	if len(ids) != 0 || len(tombstones) != 0 || next != cursor {
+		t.Fatalf("sync with no changes got events=%v tombstones=%v next=%v, want nothing", ids, tombstones, next)
+	}
+
+	save("1", "one (edited)")
+	save("2", "two") // unchanged
+	save("4", "four")
+	if err := store.MarkDeleted(ctx, "3"); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.SetBad(ctx, "4", true); err != nil {
+		t.Fatal(err)
+	}
+
+	ids, tombstones, next = changes(cursor)
+	if got, want := ids, []eventdb.EventID{"1"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("delta sync got events %v, want %v", got, want)
+	}
+	if got, want := tombstones, []eventdb.EventID{"3", "4"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("delta sync got tombstones %v, want %v", got, want)
+	}
+	if !next.UpdatedAt.After(cursor.UpdatedAt) {
+		t.Fatalf("delta sync cursor %v isn't after %v", next, cursor)
+	}
+}
+
+func TestEventChangesSameTime(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	dbx := pgtest.NewDB(t)
+	store := &EventStore{DB: dbx}
+	if err := store.Init(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	var want []eventdb.EventID
+	for _, id := range []eventdb.EventID{"1", "2", "3", "4", "5"} {
+		js := fmt.Sprintf(`{"id": %q, "start_time": "2000-01-01T00:00:00Z"}`, id)
+		if _, err := store.Save(ctx, json.RawMessage(js)); err != nil {
+			t.Fatal(err)
+		}
+		want = append(want, id)
+	}
+
+	// Like the rows backfilled when the column was added
+	if _, err := dbx.ExecContext(ctx, `UPDATE events SET updated_at = '2018-01-01T00:00:00Z'`); err != nil {
+		t.Fatal(err)
+	}
+
+	var got []eventdb.EventID
+	var cursor eventdb.SyncCursor
+	for page := 0; page < 10; page++ {
+		events, tombstones, next, err := store.Changes(ctx, cursor, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(tombstones) != 0 {
+			t.Fatalf("got tombstones %v, want none", tombstones)
+		}
+		for _, e := range events {
+			got = append(got, e.ID)
+		}
+		if next == cursor {
+			break
+		}
+		cursor = next
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("paging through changes at the same time got %v, want %v", got, want)
+	}
+}
+
+func TestEventInitRestrictedRole(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// An admin installs the extensions ahead of time, like a DBA would on a
+	// managed database
+	admin := pgtest.NewDB(t)
+	for _, ext := range []string{"postgis", "pg_trgm"} {
+		if _, err := admin.ExecContext(ctx, `CREATE EXTENSION IF NOT EXISTS `+ext); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	db := restrictedDB(ctx, t, admin)
+	defer db.Close()
+
+	store := &EventStore{DB: db}
+	if err := store.Init(ctx); err != nil {
+		t.Fatalf("Init as a role that can't create extensions: %v", err)
+	}
+}
+
+func TestEnsureExtensionRestrictedRole(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Nobody has installed PostGIS, and only superusers can
+	admin := pgtest.NewDB(t)
+	db := restrictedDB(ctx, t, admin)
+	defer db.Close()
+
+	err := ensureExtension(ctx, db, "postgis")
+	if !errors.Is(errors.Permission, err) {
+		t.Fatalf("ensureExtension as a role that can't create extensions got %v, want %v", err, errors.Permission)
+	}
+	if want := "ask a database admin to run CREATE EXTENSION postgis"; !strings.Contains(err.Error(), want) {
+		t.Fatalf("ensureExtension error %q doesn't say to %q", err, want)
+	}
+}
+
+// restrictedDB connects to admin's database as a new role that can create
+// tables but isn't a superuser, like the roles on managed databases.
+func restrictedDB(ctx context.Context, t *testing.T, admin *sql.DB) *sql.DB {
+	t.Helper()
+
+	var dbName string
+	if err := admin.QueryRowContext(ctx, `SELECT current_database()`).Scan(&dbName); err != nil {
+		t.Fatal(err)
+	}
+	role := fmt.Sprintf("eventdb_test_%d", rand.Int63())
+	if _, err := admin.ExecContext(ctx, `CREATE ROLE `+role+` LOGIN PASSWORD 'test' NOSUPERUSER NOCREATEDB`); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		// ctx is canceled by the time the test is done with the role
+		admin.ExecContext(context.Background(), `DROP OWNED BY `+role+`; DROP ROLE `+role)
+	})
+	if _, err := admin.ExecContext(ctx, `GRANT CREATE ON SCHEMA public TO `+role); err != nil {
+		t.Fatal(err)
+	}
+
+	u, err := url.Parse(pgtest.DefaultURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u.User = url.UserPassword(role, "test")
+	u.Path = "/" + dbName
+	db, err := sql.Open("postgres", u.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func TestEventReadDB(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Two separate databases, so we can tell which one a read went to
+	primary := &EventStore{DB: pgtest.NewDB(t)}
+	replica := &EventStore{DB: pgtest.NewDB(t)}
+	for _, store := range []*EventStore{primary, replica} {
+		if err := store.Init(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	store := &EventStore{DB: primary.DB, ReadDB: replica.DB}
+
+	const eventJS = `{
+		"id": "1",
+		"start_time": "2000-01-01T00:00:00Z",
+		"place": {
+			"location": {
+				"street": "street addr",
+				"latitude": 20,
+				"longitude": 20
+			}
+		}
+	}`
+	search := eventdb.EventSearchRequest{
+		Bounds: geojson.CircleGeom(20, 20, 1000),
+		Start:  time.Date(1999, 1, 1, 0, 0, 0, 0, time.UTC),
+		End:    time.Date(2001, 1, 1, 0, 0, 0, 0, time.UTC),
+	}
+
+	// Writes go to the primary, and Save reads its result back from there
+	saved, err := store.Save(ctx, json.RawMessage(eventJS))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := saved.ID, eventdb.EventID("1"); got != want {
+		t.Fatalf("saved id = %q, want %q", got, want)
+	}
+	if _, err := primary.GetByID(ctx, "1"); err != nil {
+		t.Fatalf("primary get: %v", err)
+	}
+
+	// Reads go to the replica, which hasn't seen the event yet
+	if _, err := store.GetByID(ctx, "1"); !errors.Is(errors.NotExist, err) {
+		t.Fatalf("get before replication: err = %v, want NotExist", err)
+	}
+	events, err := store.Search(ctx, search)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 0 {
+		t.Fatalf("search before replication returned %d events, want 0", len(events))
+	}
+
+	// "Replicate" the event
+	if _, err := replica.Save(ctx, json.RawMessage(eventJS)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.GetByID(ctx, "1"); err != nil {
+		t.Fatalf("get after replication: %v", err)
+	}
+	events, err = store.Search(ctx, search)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 {
+		t.Fatalf("search after replication returned %d events, want 1", len(events))
+	}
+}
+
+func TestEventSearchExcludeAttended(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	dbx := pgtest.NewDB(t)
+	store := &EventStore{DB: dbx}
+	if err := store.Init(ctx); err != nil {
+		t.Fatal(err)
+	}
+	destStore := &DestStore{DB: dbx}
+	if err := destStore.Init(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, id := range []string{"1", "2", "3"} {
+		_, err := store.Save(ctx, json.RawMessage(`{
+			"id": "`+id+`",
+			"start_time": "2000-01-01T00:00:00Z",
+			"place": {
+				"location": {
+					"street": "street addr",
+					"latitude": 20,
+					"longitude": 20
+				}
+			}
+		}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The user went to 1 and skipped 2. Someone else went to 3.
+	for _, d := range []struct {
+		UserID  eventdb.UserID
+		EventID eventdb.EventID
+		Status  string
+	}{
+		{"user", "1", "liked"},
+		{"user", "2", ""},
+		{"other", "3", "went"},
+	} {
+		dest, err := destStore.Create(ctx, eventdb.Dest{UserID: d.UserID, EventID: d.EventID})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if d.Status == "" {
+			continue
+		}
+		_, err = destStore.Update(ctx, dest.ID, eventdb.DestUpdate{Status: d.Status, Mask: "status"})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	events, err := store.Search(ctx, eventdb.EventSearchRequest{
+		Bounds: geojson.CircleGeom(20, 20, 1000),
+		Start:  time.Date(1999, 1, 1, 0, 0, 0, 0, time.UTC),
+		End:    time.Date(2001, 1, 1, 0, 0, 0, 0, time.UTC),
+		UserID: "user",
+
+		AttendedStatuses: []string{"went", "liked"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []eventdb.EventID
+	for _, e := range events {
+		ids = append(ids, e.ID)
+	}
+	if got, want := ids, []eventdb.EventID{"2", "3"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("search got ids=%v, want %v", got, want)
+	}
+}
+
+func TestEventSearchExcludeChosen(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	dbx := pgtest.NewDB(t)
+	store := &EventStore{DB: dbx}
+	if err := store.Init(ctx); err != nil {
+		t.Fatal(err)
+	}
+	destStore := &DestStore{DB: dbx}
+	if err := destStore.Init(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, id := range []string{"1", "2", "3"} {
+		_, err := store.Save(ctx, json.RawMessage(`{
+			"id": "`+id+`",
+			"start_time": "2000-01-01T00:00:00Z",
+			"place": {
+				"location": {
+					"street": "street addr",
+					"latitude": 20,
+					"longitude": 20
+				}
+			}
+		}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
 
-		if got, want := ids, test.WantIDs; !reflect.DeepEqual(got, want) {
-			t.Fatalf("search (%v): got ids=%v, want %v", test.Name, got, want)
+	// The user was sent to 1, with no feedback. Someone else was sent to 2.
+	for _, d := range []eventdb.Dest{
+		{UserID: "user", EventID: "1"},
+		{UserID: "other", EventID: "2"},
+	} {
+		if _, err := destStore.Create(ctx, d); err != nil {
+			t.Fatal(err)
 		}
+	}
 
-		fullRes, err := store.SearchFull(ctx, test.Search)
+	for _, test := range []struct {
+		Name        string
+		ChosenSince time.Time
+		Want        []eventdb.EventID
+	}{
+		{
+			Name: "every dest",
+			Want: []eventdb.EventID{"2", "3"},
+		},
+		{
+			Name:        "dest expired",
+			ChosenSince: time.Now().Add(time.Hour),
+			Want:        []eventdb.EventID{"1", "2", "3"},
+		},
+		{
+			Name:        "dest fresh, since in another time zone",
+			ChosenSince: time.Now().Add(-time.Hour).In(time.FixedZone("UTC+10", 10*60*60)),
+			Want:        []eventdb.EventID{"2", "3"},
+		},
+	} {
+		events, err := store.Search(ctx, eventdb.EventSearchRequest{
+			Bounds:        geojson.CircleGeom(20, 20, 1000),
+			Start:         time.Date(1999, 1, 1, 0, 0, 0, 0, time.UTC),
+			End:           time.Date(2001, 1, 1, 0, 0, 0, 0, time.UTC),
+			UserID:        "user",
+			ExcludeChosen: true,
+			ChosenSince:   test.ChosenSince,
+		})
 		if err != nil {
-			t.Fatalf("event search (full): %v", err)
+			t.Fatal(err)
 		}
-		var fullIDs []eventdb.EventID
-		for _, e := range fullRes {
-			var fbData struct {
-				ID eventdb.EventID `json:"id"`
-			}
-			if err := json.Unmarshal(e, &fbData); err != nil {
-				t.Fatalf("SearchFull (%v): %v", test.Name, err)
+		var ids []eventdb.EventID
+		for _, e := range events {
+			ids = append(ids, e.ID)
+		}
+		if got, want := ids, test.Want; !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: search got ids=%v, want %v", test.Name, got, want)
+		}
+	}
+}
+
+func TestEventSearchFreshnessWindow(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	dbx := pgtest.NewDB(t)
+	store := &EventStore{DB: dbx}
+	if err := store.Init(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, id := range []string{"fresh", "stale"} {
+		_, err := store.Save(ctx, json.RawMessage(`{
+			"id": "`+id+`",
+			"start_time": "2000-01-01T00:00:00Z",
+			"place": {
+				"location": {
+					"street": "street addr",
+					"latitude": 20,
+					"longitude": 20
+				}
 			}
-			fullIDs = append(fullIDs, fbData.ID)
+		}`))
+		if err != nil {
+			t.Fatal(err)
 		}
+	}
+	_, err := dbx.ExecContext(ctx, `UPDATE events SET fetched_at = now() - interval '2 days' WHERE id = 'stale'`)
+	if err != nil {
+		t.Fatal(err)
+	}
 
-		if got, want := fullIDs, test.WantIDs; !reflect.DeepEqual(got, want) {
-			t.Fatalf("search (full) (%v): got ids=%v, want %v", test.Name, got, want)
+	for _, test := range []struct {
+		Window time.Duration
+		Want   []eventdb.EventID
+	}{
+		{0, []eventdb.EventID{"fresh", "stale"}},
+		{24 * time.Hour, []eventdb.EventID{"fresh"}},
+	} {
+		events, err := store.Search(ctx, eventdb.EventSearchRequest{
+			Bounds:          geojson.CircleGeom(20, 20, 1000),
+			Start:           time.Date(1999, 1, 1, 0, 0, 0, 0, time.UTC),
+			End:             time.Date(2001, 1, 1, 0, 0, 0, 0, time.UTC),
+			FreshnessWindow: test.Window,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var ids []eventdb.EventID
+		for _, e := range events {
+			ids = append(ids, e.ID)
+		}
+		if got, want := ids, test.Want; !reflect.DeepEqual(got, want) {
+			t.Errorf("search with freshness window %v got ids=%v, want %v", test.Window, got, want)
 		}
 	}
 }
 
-func BenchmarkSearch(b *testing.B) {
-	b.Skip("this benchmark is really flaky")
+func TestEventSaveBadCoordinates(t *testing.T) {
+	t.Parallel()
 
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	dbx := pgtest.NewDB(b)
+	dbx := pgtest.NewDB(t)
 	store := &EventStore{DB: dbx}
 	if err := store.Init(ctx); err != nil {
-		b.Fatal(err)
+		t.Fatal(err)
 	}
 
-	for i := 0; i < 500; i++ {
-		id := fmt.Sprint(i)
-		lat := rand.Float64() * 10
-		lng := rand.Float64() * 10
-		js := fmt.Sprintf(`{
+	_, err := store.Save(ctx, json.RawMessage(`{
+		"id": "1",
+		"start_time": "2000-01-01T00:00:00Z",
+		"place": {
+			"location": {
+				"street": "street addr",
+				"latitude": 200,
+				"longitude": 20
+			}
+		}
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var hasGeom bool
+	if err := dbx.QueryRowContext(ctx, `SELECT geom IS NOT NULL FROM events WHERE id = '1'`).Scan(&hasGeom); err != nil {
+		t.Fatal(err)
+	}
+	if hasGeom {
+		t.Fatalf("event with latitude 200 has a geom, want none")
+	}
+
+	events, err := store.Search(ctx, eventdb.EventSearchRequest{
+		Bounds: geojson.CircleGeom(20, 20, 10000),
+		Start:  time.Date(1999, 1, 1, 0, 0, 0, 0, time.UTC),
+		End:    time.Date(2001, 1, 1, 0, 0, 0, 0, time.UTC),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 0 {
+		t.Fatalf("search returned %d events, want event with bad coordinates left out", len(events))
+	}
+}
+
+func TestEventSearchWithin(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Distances from (20, 20). The polygon from CircleGeom only reaches
+	// about 988m out between its corners, which cuts off the edge event.
+	events := map[string][2]float64{
+		"inside":  {20.003176, 20.0033799},  // 500m to the northeast
+		"edge":    {20.0088282, 20.0014881}, // 995m, between the polygon's corners
+		"outside": {20.0092526, 20.0},       // 1030m to the north
+	}
+
+	start := time.Date(1999, 1, 1, 0, 0, 0, 0, time.UTC)
+	end := time.Date(2001, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	for _, noPostGIS := range []bool{false, true} {
+		store := &EventStore{DB: pgtest.NewDB(t), NoPostGIS: noPostGIS}
+		if err := store.Init(ctx); err != nil {
+			t.Fatal(err)
+		}
+
+		for id, loc := range events {
+			_, err := store.Save(ctx, json.RawMessage(fmt.Sprintf(`{
 				"id": %q,
 				"start_time": "2000-01-01T00:00:00Z",
 				"place": {
 					"location": {
 						"street": "street addr",
-						"latitude": %f,
-						"longitude": %f
+						"latitude": %v,
+						"longitude": %v
 					}
 				}
-			}`, id, lat, lng)
+			}`, id, loc[0], loc[1])))
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
 
-		if _, err := store.Save(ctx, json.RawMessage(js)); err != nil {
-			b.Fatalf("save: %v", err)
+		found, err := store.SearchWithin(ctx, 20, 20, 1000, start, end)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var ids []eventdb.EventID
+		for _, e := range found {
+			ids = append(ids, e.ID)
+		}
+		if got, want := ids, []eventdb.EventID{"edge", "inside"}; !reflect.DeepEqual(got, want) {
+			t.Errorf("SearchWithin (noPostGIS=%v) got ids=%v, want %v", noPostGIS, got, want)
+		}
+
+		found, err = store.Search(ctx, eventdb.EventSearchRequest{
+			Bounds: geojson.CircleGeom(20, 20, 1000),
+			Start:  start,
+			End:    end,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = nil
+		for _, e := range found {
+			ids = append(ids, e.ID)
+		}
+		if got, want := ids, []eventdb.EventID{"inside"}; !reflect.DeepEqual(got, want) {
+			t.Errorf("Search with a CircleGeom (noPostGIS=%v) got ids=%v, want %v", noPostGIS, got, want)
 		}
 	}
+}
 
-	params := eventdb.EventSearchRequest{
-		Bounds: geojson.CircleGeom(0, 0, 1),
-		Start:  time.Date(1999, 1, 1, 0, 0, 0, 0, time.UTC),
-		End:    time.Date(2001, 1, 1, 0, 0, 0, 0, time.UTC),
+func TestEventSearchOrderByDistance(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// The nearest events to (20, 20) start last, so sorting by distance
+	// reverses the usual order.
+	events := []struct {
+		ID        eventdb.EventID
+		Lat, Lng  float64
+		StartTime string
+		DistanceM float64
+	}{
+		{"far", 20.0092526, 20.0, "2000-01-01T00:00:00Z", 1030},
+		{"middle", 20.003176, 20.0033799, "2000-01-01T01:00:00Z", 500},
+		{"near", 20.0, 20.0009569, "2000-01-01T02:00:00Z", 100},
 	}
 
-	b.ResetTimer()
+	for _, noPostGIS := range []bool{false, true} {
+		store := &EventStore{DB: pgtest.NewDB(t), NoPostGIS: noPostGIS}
+		if err := store.Init(ctx); err != nil {
+			t.Fatal(err)
+		}
 
-	for n := 0; n < b.N; n++ {
-		_, err := store.Search(ctx, params)
+		for _, event := range events {
+			_, err := store.Save(ctx, json.RawMessage(fmt.Sprintf(`{
+				"id": %q,
+				"start_time": %q,
+				"place": {
+					"location": {
+						"street": "street addr",
+						"latitude": %v,
+						"longitude": %v
+					}
+				}
+			}`, event.ID, event.StartTime, event.Lat, event.Lng)))
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		search := eventdb.EventSearchRequest{
+			Bounds: geojson.CircleGeom(20, 20, 2000),
+			Start:  time.Date(1999, 1, 1, 0, 0, 0, 0, time.UTC),
+			End:    time.Date(2001, 1, 1, 0, 0, 0, 0, time.UTC),
+		}
+
+		// Without a center, nothing changes
+		found, err := store.Search(ctx, search)
 		if err != nil {
-			b.Fatalf("search: %v", err)
+			t.Fatal(err)
+		}
+		var ids []eventdb.EventID
+		for _, e := range found {
+			ids = append(ids, e.ID)
+			if e.DistanceM != 0 {
+				t.Errorf("Search without a center (noPostGIS=%v) got distance %v for %s, want 0", noPostGIS, e.DistanceM, e.ID)
+			}
+		}
+		if got, want := ids, []eventdb.EventID{"far", "middle", "near"}; !reflect.DeepEqual(got, want) {
+			t.Errorf("Search without a center (noPostGIS=%v) got ids=%v, want %v", noPostGIS, got, want)
+		}
+
+		search.CenterLat, search.CenterLng = 20, 20
+		search.OrderBy = eventdb.OrderByDistance
+		found, err = store.Search(ctx, search)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = nil
+		for _, e := range found {
+			ids = append(ids, e.ID)
+		}
+		if got, want := ids, []eventdb.EventID{"near", "middle", "far"}; !reflect.DeepEqual(got, want) {
+			t.Fatalf("Search by distance (noPostGIS=%v) got ids=%v, want %v", noPostGIS, got, want)
+		}
+		for i, e := range found {
+			if want := events[len(events)-1-i].DistanceM; math.Abs(e.DistanceM-want) > 5 {
+				t.Errorf("Search by distance (noPostGIS=%v) got distance %v for %s, want about %v", noPostGIS, e.DistanceM, e.ID, want)
+			}
+		}
+
+		// Paging happens after sorting
+		search.Limit = 1
+		search.Offset = 1
+		found, err = store.Search(ctx, search)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(found) != 1 || found[0].ID != "middle" {
+			t.Errorf("Search by distance with offset 1 (noPostGIS=%v) got %v, want [middle]", noPostGIS, found)
+		}
+
+		search.CenterLat, search.CenterLng = 0, 0
+		if _, err := store.Search(ctx, search); !errors.Is(errors.Invalid, err) {
+			t.Errorf("Search by distance without a center (noPostGIS=%v) got error %v, want Invalid", noPostGIS, err)
 		}
 	}
 }
 
-func getTZ(location string) *time.Location {
-	l, err := time.LoadLocation(location)
+func TestEventSearchCount(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	for _, noPostGIS := range []bool{false, true} {
+		store := &EventStore{DB: pgtest.NewDB(t), NoPostGIS: noPostGIS}
+		if err := store.Init(ctx); err != nil {
+			t.Fatal(err)
+		}
+
+		for id, name := range map[string]string{"1": "Jazz night", "2": "Jazz brunch", "3": "Poetry reading"} {
+			_, err := store.Save(ctx, json.RawMessage(fmt.Sprintf(`{
+				"id": %q,
+				"name": %q,
+				"start_time": "2000-01-01T00:00:00Z",
+				"place": {
+					"location": {
+						"street": "street addr",
+						"latitude": 20,
+						"longitude": 20
+					}
+				}
+			}`, id, name)))
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		for _, test := range []struct {
+			Name  string
+			Query string
+			Want  int
+		}{
+			{"exact matches", "jazz", 2},
+			{"fuzzy fallback", "jaz", 2},
+			{"no matches", "opera", 0},
+		} {
+			n, err := store.SearchCount(ctx, eventdb.EventSearchRequest{
+				Start:  time.Date(1999, 1, 1, 0, 0, 0, 0, time.UTC),
+				End:    time.Date(2001, 1, 1, 0, 0, 0, 0, time.UTC),
+				Bounds: geojson.CircleGeom(20, 20, 1000),
+				Query:  test.Query,
+				Fuzzy:  true,
+				Limit:  1,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := n, test.Want; got != want {
+				t.Errorf("%s (noPostGIS=%v): SearchCount = %d, want %d", test.Name, noPostGIS, got, want)
+			}
+		}
+	}
+}
+
+func TestEventInitBackfills(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	store := &EventStore{DB: pgtest.NewDB(t)}
+	if err := store.Init(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// Like an event saved before prices and tags were parsed
+	_, err := store.Save(ctx, json.RawMessage(`{
+		"id": "1",
+		"name": "Live jazz night",
+		"start_time": "2000-01-01T00:00:00Z",
+		"description": "Tickets $12"
+	}`))
 	if err != nil {
-		panic(err)
+		t.Fatal(err)
+	}
+	_, err = store.DB.ExecContext(ctx, `UPDATE events SET price = NULL, prices = NULL, tags = NULL, derived_version = 0`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := store.Init(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	var price float64
+	var prices string
+	var tags pq.StringArray
+	err = store.DB.QueryRowContext(ctx, `SELECT price, prices, tags FROM events WHERE id = '1'`).Scan(&price, &prices, &tags)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := price, 12.0; got != want {
+		t.Errorf("backfilled price = %v, want %v", got, want)
+	}
+	if got, want := prices, `{"USD": 12}`; got != want {
+		t.Errorf("backfilled prices = %s, want %s", got, want)
+	}
+	if got, want := []string(tags), eventdb.ExtractTags("Live jazz night", "Tickets $12"); !reflect.DeepEqual(got, want) {
+		t.Errorf("backfilled tags = %v, want %v", got, want)
 	}
-	return l
 }
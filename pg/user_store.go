@@ -3,16 +3,32 @@ package pg
 import (
 	"context"
 	"database/sql"
+	"encoding/base64"
+	"encoding/json"
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/findrandomevents/eventdb"
 	"github.com/findrandomevents/eventdb/errors"
+	"github.com/lib/pq"
 )
 
 // UserStore stores metadata about users in a PostgreSQL database.
 type UserStore struct {
 	DB *sql.DB
+
+	// ReadDB, if set, is used instead of DB for reads that can tolerate
+	// replication lag.
+	ReadDB *sql.DB
+}
+
+// readDB returns the database used for lag-tolerant reads.
+func (u *UserStore) readDB() *sql.DB {
+	if u.ReadDB != nil {
+		return u.ReadDB
+	}
+	return u.DB
 }
 
 // Init sets up the database schema and creates indices.
@@ -39,6 +55,27 @@ func (u *UserStore) Init(ctx context.Context) error {
 	CREATE UNIQUE INDEX IF NOT EXISTS user_token_idx
 	ON users (sequence)
 	WHERE facebook_token != '';
+
+	-- See UserStore.TouchActivity
+	ALTER TABLE users ADD COLUMN IF NOT EXISTS last_active_at timestamptz;
+
+	-- See UserStore.SetLastLocation
+	ALTER TABLE users ADD COLUMN IF NOT EXISTS last_lat double precision;
+	ALTER TABLE users ADD COLUMN IF NOT EXISTS last_lng double precision;
+
+	-- See UserStore.RecordFBTokenResult
+	ALTER TABLE users ADD COLUMN IF NOT EXISTS fb_token_successes double precision NOT NULL DEFAULT 0;
+	ALTER TABLE users ADD COLUMN IF NOT EXISTS fb_token_failures double precision NOT NULL DEFAULT 0;
+
+	CREATE TABLE IF NOT EXISTS saved_locations (
+		user_id    TEXT              NOT NULL,
+		label      TEXT              NOT NULL,
+		lat        double precision  NOT NULL,
+		lng        double precision  NOT NULL,
+		created_at timestamptz       NOT NULL DEFAULT now(),
+
+		PRIMARY KEY (user_id, label)
+	);
 	`)
 	if err != nil {
 		return errors.E(op, pgErr(err))
@@ -47,6 +84,34 @@ func (u *UserStore) Init(ctx context.Context) error {
 	return nil
 }
 
+// Count returns the approximate number of users stored.
+func (u *UserStore) Count(ctx context.Context) (int64, error) {
+	const op errors.Op = "UserStore.Count"
+
+	count, err := countRows(ctx, u.readDB(), "users")
+	if err != nil {
+		return 0, errors.E(op, err)
+	}
+	return count, nil
+}
+
+// CountFBTokens returns how many users have a Facebook token saved. When it's
+// zero, events can't be fetched from Facebook.
+func (u *UserStore) CountFBTokens(ctx context.Context) (int, error) {
+	const op errors.Op = "UserStore.CountFBTokens"
+
+	var n int
+	err := u.readDB().QueryRowContext(ctx, `
+		SELECT COUNT(*)
+		FROM users
+		WHERE LENGTH(facebook_token) > 0
+	`).Scan(&n)
+	if err != nil {
+		return 0, errors.E(op, pgErr(err))
+	}
+	return n, nil
+}
+
 // RandomFBToken returns the Facebook OAuth token for a random user in the database
 func (u *UserStore) RandomFBToken(ctx context.Context) (userID eventdb.UserID, token string, err error) {
 	err = u.DB.QueryRowContext(ctx, `
@@ -68,13 +133,64 @@ func (u *UserStore) RandomFBToken(ctx context.Context) (userID eventdb.UserID, t
 	return eventdb.UserID(userID), token, nil
 }
 
+// fbTokenDecay is how much RecordFBTokenResult discounts a token's earlier
+// results each time it records a new one, so WeightedFBToken goes by how the
+// token has done recently.
+const fbTokenDecay = 0.9
+
+// WeightedFBToken is like RandomFBToken, but favors tokens that have been
+// working. Each token is weighted by its share of recent successes, see
+// RecordFBTokenResult. Tokens with no record, and failing ones, still get
+// picked sometimes so they can recover.
+func (u *UserStore) WeightedFBToken(ctx context.Context) (userID eventdb.UserID, token string, err error) {
+	const op errors.Op = "UserStore.WeightedFBToken"
+
+	// Weighted random sampling, as in Efraimidis and Spirakis: the row with
+	// the biggest random()^(1/weight) wins, where the weight is
+	// (successes+1) / (successes+failures+2).
+	err = u.DB.QueryRowContext(ctx, `
+		SELECT user_id, facebook_token
+		FROM users
+		WHERE LENGTH(facebook_token) > 0
+		ORDER BY random() ^ ((fb_token_successes + fb_token_failures + 2) / (fb_token_successes + 1)) DESC
+		LIMIT 1
+		`).Scan(&userID, &token)
+	if err == sql.ErrNoRows {
+		return userID, token, errors.E(op, "no facebook tokens available", pgErr(err))
+	}
+	if err != nil {
+		return userID, token, errors.E(op, pgErr(err))
+	}
+
+	return userID, token, nil
+}
+
+// RecordFBTokenResult records whether a request with the user's Facebook token
+// worked, for WeightedFBToken. The record is reset whenever Update saves the
+// user's token.
+func (u *UserStore) RecordFBTokenResult(ctx context.Context, userID eventdb.UserID, ok bool) error {
+	const op errors.Op = "UserStore.RecordFBTokenResult"
+
+	_, err := u.DB.ExecContext(ctx, `
+		UPDATE users
+		SET
+			fb_token_successes = fb_token_successes * $2 + CASE WHEN $3 THEN 1 ELSE 0 END,
+			fb_token_failures = fb_token_failures * $2 + CASE WHEN $3 THEN 0 ELSE 1 END
+		WHERE user_id = $1
+		`, userID, fbTokenDecay, ok)
+	if err != nil {
+		return errors.E(op, pgErr(err))
+	}
+	return nil
+}
+
 // Update applies a UserUpdate to the given User, then returns the result.
 func (u *UserStore) Update(ctx context.Context, userID eventdb.UserID, update eventdb.UserUpdate) (eventdb.User, error) {
 	fields := []string{"user_id"}
 	args := []interface{}{userID}
 
 	for _, field := range strings.Split(update.Mask, ",") {
-		switch field {
+		switch strings.TrimSpace(field) {
 		case "timeZone":
 			fields = append(fields, "time_zone")
 			args = append(args, update.TimeZone)
@@ -84,15 +200,27 @@ func (u *UserStore) Update(ctx context.Context, userID eventdb.UserID, update ev
 			args = append(args, update.FacebookID)
 
 		case "facebookToken":
-			fields = append(fields, "facebook_token")
-			args = append(args, update.FacebookToken)
+			// A new token starts with a clean record
+			fields = append(fields, "facebook_token", "fb_token_successes", "fb_token_failures")
+			args = append(args, update.FacebookToken, 0, 0)
 
 		case "birthday":
 			fields = append(fields, "birthday")
 			args = append(args, update.Birthday)
+
+		case "": // allow trailing commas
+
+		default:
+			return eventdb.User{}, errors.E(errors.Invalid, fmt.Sprintf("unknown mask field %q", field))
 		}
 	}
 
+	// With nothing to set, inserting would create an empty row for a new
+	// user. Just return the user as it is.
+	if len(fields) == 1 {
+		return u.GetByID(ctx, userID)
+	}
+
 	var placeholders []string
 	for i := range fields {
 		placeholders = append(placeholders, fmt.Sprintf("$%d", i+1))
@@ -107,12 +235,11 @@ func (u *UserStore) Update(ctx context.Context, userID eventdb.UserID, update ev
 	}
 
 	query := fmt.Sprintf(`
-		INSERT INTO users(%s) VALUES(%s)`,
+		INSERT INTO users(%s) VALUES(%s)
+		ON CONFLICT (user_id) DO UPDATE SET %s`,
 		strings.Join(fields, ", "),
-		strings.Join(placeholders, ", "))
-	if len(updates) > 0 {
-		query += " ON CONFLICT (user_id) DO UPDATE SET " + strings.Join(updates, ", ")
-	}
+		strings.Join(placeholders, ", "),
+		strings.Join(updates, ", "))
 
 	_, err := u.DB.ExecContext(ctx, query, args...)
 	if err != nil {
@@ -127,29 +254,270 @@ func (u *UserStore) Update(ctx context.Context, userID eventdb.UserID, update ev
 	return user, nil
 }
 
+// TouchActivity records that the user was active just now. Users without a
+// row yet are left alone, so activity alone doesn't create one.
+func (u *UserStore) TouchActivity(ctx context.Context, userID eventdb.UserID) error {
+	_, err := u.DB.ExecContext(ctx, `
+		UPDATE users SET last_active_at = now() WHERE user_id = $1
+	`, userID)
+	if err != nil {
+		return pgErr(err)
+	}
+	return nil
+}
+
+// SetLastLocation records where the user last generated a Dest. Like
+// TouchActivity, it doesn't create a row for a user that doesn't have one.
+func (u *UserStore) SetLastLocation(ctx context.Context, userID eventdb.UserID, lat, lng float64) error {
+	_, err := u.DB.ExecContext(ctx, `
+		UPDATE users SET last_lat = $2, last_lng = $3 WHERE user_id = $1
+	`, userID, lat, lng)
+	if err != nil {
+		return pgErr(err)
+	}
+	return nil
+}
+
+// SaveLocation stores one of the user's saved locations, replacing any with
+// the same label.
+func (u *UserStore) SaveLocation(ctx context.Context, userID eventdb.UserID, loc eventdb.SavedLocation) error {
+	_, err := u.DB.ExecContext(ctx, `
+		INSERT INTO saved_locations (user_id, label, lat, lng) VALUES ($1, $2, $3, $4)
+		ON CONFLICT (user_id, label) DO UPDATE SET lat = $3, lng = $4
+	`, userID, loc.Label, loc.Lat, loc.Lng)
+	if err != nil {
+		return pgErr(err)
+	}
+	return nil
+}
+
+// GetLocation returns the user's saved location with the given label.
+func (u *UserStore) GetLocation(ctx context.Context, userID eventdb.UserID, label string) (eventdb.SavedLocation, error) {
+	loc := eventdb.SavedLocation{Label: label}
+	err := retryRead(ctx, func() error {
+		err := u.DB.QueryRowContext(ctx, `
+			SELECT lat, lng
+			FROM saved_locations
+			WHERE user_id = $1 AND label = $2
+		`, userID, label).Scan(&loc.Lat, &loc.Lng)
+		return pgErr(err)
+	})
+	return loc, err
+}
+
+// ListLocations returns the user's saved locations, sorted by label.
+func (u *UserStore) ListLocations(ctx context.Context, userID eventdb.UserID) (locs []eventdb.SavedLocation, err error) {
+	err = retryRead(ctx, func() error {
+		locs = []eventdb.SavedLocation{}
+
+		rows, err := u.DB.QueryContext(ctx, `
+			SELECT label, lat, lng
+			FROM saved_locations
+			WHERE user_id = $1
+			ORDER BY label
+		`, userID)
+		if err != nil {
+			return pgErr(err)
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var loc eventdb.SavedLocation
+			if err := rows.Scan(&loc.Label, &loc.Lat, &loc.Lng); err != nil {
+				return pgErr(err)
+			}
+			locs = append(locs, loc)
+		}
+		if err := rows.Err(); err != nil {
+			return pgErr(err)
+		}
+		return nil
+	})
+	return locs, err
+}
+
+// DeleteLocation removes one of the user's saved locations. It returns a
+// NotExist error if there's none with the label.
+func (u *UserStore) DeleteLocation(ctx context.Context, userID eventdb.UserID, label string) error {
+	res, err := u.DB.ExecContext(ctx, `
+		DELETE FROM saved_locations WHERE user_id = $1 AND label = $2
+	`, userID, label)
+	if err != nil {
+		return pgErr(err)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return pgErr(err)
+	}
+	if n == 0 {
+		return errors.E(errors.NotExist, "saved location not found")
+	}
+	return nil
+}
+
 // GetByID retrieves a User by ID.
 func (u *UserStore) GetByID(ctx context.Context, userID eventdb.UserID) (eventdb.User, error) {
 	var user eventdb.User
+	var lastActive pq.NullTime
 
-	err := u.DB.QueryRowContext(ctx, `
-		SELECT
-			COALESCE(user_id, ''),
-			COALESCE(birthday, '0001-01-01'),
-			COALESCE(facebook_id, ''),
-			COALESCE(facebook_token, ''),
-			COALESCE(time_zone, '')
-		FROM users
-		WHERE user_id = $1
-	`, userID).Scan(
-		&user.ID,
-		&user.Birthday,
-		&user.FacebookID,
-		&user.FacebookToken,
-		&user.TimeZone,
-	)
+	err := retryRead(ctx, func() error {
+		err := u.DB.QueryRowContext(ctx, `
+			SELECT
+				COALESCE(user_id, ''),
+				COALESCE(birthday, '0001-01-01'),
+				COALESCE(facebook_id, ''),
+				COALESCE(facebook_token, ''),
+				COALESCE(time_zone, ''),
+				last_active_at,
+				COALESCE(last_lat, 0),
+				COALESCE(last_lng, 0)
+			FROM users
+			WHERE user_id = $1
+		`, userID).Scan(
+			&user.ID,
+			&user.Birthday,
+			&user.FacebookID,
+			&user.FacebookToken,
+			&user.TimeZone,
+			&lastActive,
+			&user.LastLat,
+			&user.LastLng,
+		)
+		return pgErr(err)
+	})
 	if err != nil {
-		return user, pgErr(err)
+		return user, err
 	}
+	user.LastActiveAt = lastActive.Time
 
 	return user, nil
 }
+
+// Search returns a page of users in the UserSearchRequest's order, along with
+// the cursor for the next page, which is empty if this is the last one. Pages
+// are keyed on the sort columns rather than offsets, so they stay stable as
+// users sign up. Facebook tokens aren't loaded, so FacebookToken is empty.
+func (u *UserStore) Search(ctx context.Context, req eventdb.UserSearchRequest) (users []eventdb.User, next string, err error) {
+	const op errors.Op = "UserStore.Search"
+
+	if req.Limit <= 0 {
+		return nil, "", errors.E(op, errors.Invalid, "limit must be positive")
+	}
+
+	var after userCursor
+	if req.Cursor != "" {
+		if after, err = parseUserCursor(req.Cursor); err != nil {
+			return nil, "", errors.E(op, errors.Invalid, errors.Errorf("bad cursor: %v", err))
+		}
+	}
+
+	// Users who were never active sort first, as if active at the zero time
+	lastActive := `COALESCE(last_active_at, '0001-01-01 00:00:00+00')`
+
+	var where, orderBy string
+	args := []interface{}{req.Limit + 1}
+	switch req.OrderBy {
+	case eventdb.UserOrderBySequence:
+		orderBy = `sequence`
+		if req.Cursor != "" {
+			where = `WHERE sequence > $2`
+			args = append(args, after.Sequence)
+		}
+	case eventdb.UserOrderByLastActive:
+		orderBy = lastActive + `, sequence`
+		if req.Cursor != "" {
+			where = `WHERE (` + lastActive + `, sequence) > ($2, $3)`
+			args = append(args, after.LastActive, after.Sequence)
+		}
+	default:
+		return nil, "", errors.E(op, errors.Invalid, fmt.Sprintf("unknown order %q", req.OrderBy))
+	}
+
+	// Get one extra row to tell if there's another page
+	var cursors []userCursor
+	err = retryRead(ctx, func() error {
+		users, cursors = nil, nil
+
+		rows, err := u.readDB().QueryContext(ctx, `
+			SELECT
+				sequence,
+				COALESCE(user_id, ''),
+				COALESCE(birthday, '0001-01-01'),
+				COALESCE(facebook_id, ''),
+				COALESCE(time_zone, ''),
+				`+lastActive+`,
+				COALESCE(last_lat, 0),
+				COALESCE(last_lng, 0)
+			FROM users
+			`+where+`
+			ORDER BY `+orderBy+`
+			LIMIT $1
+		`, args...)
+		if err != nil {
+			return pgErr(err)
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var user eventdb.User
+			var cursor userCursor
+			err := rows.Scan(
+				&cursor.Sequence,
+				&user.ID,
+				&user.Birthday,
+				&user.FacebookID,
+				&user.TimeZone,
+				&cursor.LastActive,
+				&user.LastLat,
+				&user.LastLng,
+			)
+			if err != nil {
+				return pgErr(err)
+			}
+			if !cursor.LastActive.Equal(time.Time{}) {
+				user.LastActiveAt = cursor.LastActive
+			}
+			users = append(users, user)
+			cursors = append(cursors, cursor)
+		}
+		if err := rows.Err(); err != nil {
+			return pgErr(err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, "", errors.E(op, err)
+	}
+
+	if len(users) > req.Limit {
+		users = users[:req.Limit]
+		next = cursors[req.Limit-1].String()
+	}
+	if users == nil {
+		users = []eventdb.User{}
+	}
+	return users, next, nil
+}
+
+// userCursor marks the last user on a page of Search results.
+type userCursor struct {
+	Sequence   int64     `json:"s"`
+	LastActive time.Time `json:"a"`
+}
+
+// String encodes the cursor for UserSearchReply.Next.
+func (c userCursor) String() string {
+	js, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(js)
+}
+
+func parseUserCursor(s string) (userCursor, error) {
+	var c userCursor
+	js, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return c, err
+	}
+	err = json.Unmarshal(js, &c)
+	return c, err
+}
@@ -111,3 +111,195 @@ func TestRandomFBToken(t *testing.T) {
 		t.Fatalf("RandomFBToken() userID = %q, want %q", got, want)
 	}
 }
+
+func TestWeightedFBToken(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	db := pgtest.NewDB(t)
+	store := &UserStore{DB: db}
+	if err := store.Init(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, err := store.WeightedFBToken(ctx); !errors.Is(errors.NotExist, err) {
+		t.Fatalf("WeightedFBToken() with no tokens got %v, want %v", err, errors.NotExist)
+	}
+
+	for _, user := range []struct {
+		ID        eventdb.UserID
+		Successes int
+		Failures  int
+	}{
+		{ID: "healthy", Successes: 20},
+		// It used to work, but it's been failing lately
+		{ID: "failing", Successes: 20, Failures: 20},
+	} {
+		_, err := store.Update(ctx, user.ID, eventdb.UserUpdate{
+			FacebookToken: "token-" + string(user.ID),
+			Mask:          "facebookToken",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < user.Successes; i++ {
+			if err := store.RecordFBTokenResult(ctx, user.ID, true); err != nil {
+				t.Fatal(err)
+			}
+		}
+		for i := 0; i < user.Failures; i++ {
+			if err := store.RecordFBTokenResult(ctx, user.ID, false); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	// The healthy token's weight is about 0.9 and the failing one's about
+	// 0.17, so it should win about 5 times in 6.
+	const picks = 400
+	counts := map[eventdb.UserID]int{}
+	for i := 0; i < picks; i++ {
+		userID, token, err := store.WeightedFBToken(ctx)
+		if err != nil {
+			t.Fatalf("WeightedFBToken(): %v", err)
+		}
+		if got, want := token, "token-"+string(userID); got != want {
+			t.Fatalf("WeightedFBToken() = %q for %s, want %q", got, userID, want)
+		}
+		counts[userID]++
+	}
+	if counts["healthy"] < picks*3/4 {
+		t.Fatalf("WeightedFBToken() picked the healthy token %d of %d times, want it favored", counts["healthy"], picks)
+	}
+	if counts["failing"] == 0 {
+		t.Fatalf("WeightedFBToken() never picked the failing token, want it tried sometimes")
+	}
+
+	// Saving a new token clears the failures
+	_, err := store.Update(ctx, "failing", eventdb.UserUpdate{
+		FacebookToken: "token-failing",
+		Mask:          "facebookToken",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var successes, failures float64
+	err = db.QueryRowContext(ctx, `
+		SELECT fb_token_successes, fb_token_failures FROM users WHERE user_id = 'failing'
+	`).Scan(&successes, &failures)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if successes != 0 || failures != 0 {
+		t.Fatalf("after a new token got %v successes and %v failures, want none", successes, failures)
+	}
+}
+
+func TestCountFBTokens(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	db := pgtest.NewDB(t)
+	store := &UserStore{DB: db}
+	if err := store.Init(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, update := range []struct {
+		ID    eventdb.UserID
+		Token string
+	}{
+		{ID: "user1", Token: "token1"},
+		{ID: "user2", Token: "token2"},
+		{ID: "user3", Token: ""},
+	} {
+		_, err := store.Update(ctx, update.ID, eventdb.UserUpdate{
+			FacebookToken: update.Token,
+			Mask:          "facebookToken",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := store.TouchActivity(ctx, "user4"); err != nil {
+		t.Fatal(err)
+	}
+
+	count, err := store.CountFBTokens(ctx)
+	if err != nil {
+		t.Fatalf("CountFBTokens(): %v", err)
+	}
+	if got, want := count, 2; got != want {
+		t.Fatalf("CountFBTokens() = %d, want %d", got, want)
+	}
+}
+
+func TestTouchActivityNewUser(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	db := pgtest.NewDB(t)
+	store := &UserStore{DB: db}
+	if err := store.Init(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// Activity by a user we've never stored doesn't create a row
+	if err := store.TouchActivity(ctx, "newuser"); err != nil {
+		t.Fatal(err)
+	}
+	_, err := store.GetByID(ctx, "newuser")
+	if got, want := err, errors.E(errors.NotExist); !errors.Match(want, got) {
+		t.Fatalf("GetByID after TouchActivity error=%v, want %v", got, want)
+	}
+}
+
+func TestUserUpdateEmptyMask(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	db := pgtest.NewDB(t)
+	store := &UserStore{DB: db}
+	if err := store.Init(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// A new user with an empty mask shouldn't get a row
+	_, err := store.Update(ctx, "newuser", eventdb.UserUpdate{TimeZone: "UTC"})
+	if got, want := err, errors.E(errors.NotExist); !errors.Match(want, got) {
+		t.Fatalf("Update(new user, empty mask) error=%v, want %v", got, want)
+	}
+	_, err = store.GetByID(ctx, "newuser")
+	if got, want := err, errors.E(errors.NotExist); !errors.Match(want, got) {
+		t.Fatalf("GetByID after empty update error=%v, want %v", got, want)
+	}
+
+	// So a later update still works
+	updated, err := store.Update(ctx, "newuser", eventdb.UserUpdate{
+		TimeZone: "UTC",
+		Mask:     "timeZone",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := updated.TimeZone, "UTC"; got != want {
+		t.Fatalf("updated.TimeZone = %q, want %q", got, want)
+	}
+
+	// An existing user with an empty mask is unchanged
+	unchanged, err := store.Update(ctx, "newuser", eventdb.UserUpdate{TimeZone: "America/New_York"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := deep.Equal(unchanged, updated); diff != nil {
+		t.Fatalf("Update(existing user, empty mask) changed the user: %v", diff)
+	}
+}
@@ -0,0 +1,206 @@
+package eventdb
+
+import (
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Price parsing is rough. Facebook events don't have a structured price field,
+// so we look for amounts next to currency symbols in the description.
+
+// A Price is an amount of money in a currency.
+type Price struct {
+	Amount float64
+	// Currency is an ISO 4217 code, like "USD".
+	Currency string
+}
+
+// currencyCodes maps the currency markers we recognize to ISO 4217 codes.
+// "$" is ambiguous, we assume US dollars.
+var currencyCodes = map[string]string{
+	"$": "USD",
+	"¥": "JPY",
+	"₹": "INR",
+	"₡": "CRC",
+	"₱": "PHP",
+	"£": "GBP",
+	"€": "EUR",
+	"₩": "KRW",
+	"₨": "INR",
+	"﷼": "SAR",
+	"₽": "RUB",
+
+	"usd":     "USD",
+	"eur":     "EUR",
+	"gbp":     "GBP",
+	"jpy":     "JPY",
+	"inr":     "INR",
+	"dollars": "USD",
+	"euros":   "EUR",
+	"yen":     "JPY",
+	"rs":      "INR",
+	"rs.":     "INR",
+}
+
+const (
+	currencySymbols = `\$|¥|₹|₡|₱|£|€|₩|₨|﷼|₽`
+	currencyCodeRE  = `(?i:USD|EUR|GBP|JPY|INR)\b`
+	currencyWordRE  = `(?i:dollars|euros|yen)\b`
+
+	// Amounts may use either US (1,000.50) or European (1.000,50) formatting,
+	// parseAmount sorts out which.
+	amountRE = `(\d+(?:[.,]\d+)*)`
+)
+
+var pricePatterns = []struct {
+	re *regexp.Regexp
+	// Which submatches hold the amount and the currency marker
+	amount, currency int
+}{
+	// $5, € 10,50, EUR 10. Codes must start a word, so "Amateur 5K" isn't
+	// 5 euros.
+	{regexp.MustCompile(`(` + currencySymbols + `|\b` + currencyCodeRE + `)\s?` + amountRE), 2, 1},
+	// 5$, 10,50 €, 1.000 yen
+	{regexp.MustCompile(amountRE + `\s?(` + currencySymbols + `|` + currencyCodeRE + `|` + currencyWordRE + `)`), 1, 2},
+	// Rs 200 (India)
+	{regexp.MustCompile(`\b(Rs\.?) *` + amountRE), 2, 1},
+}
+
+// ParsePrices finds all the prices in an event's description, like "$5" or
+// "10,50 €". It returns the lowest price in each currency, in the order the
+// currencies first appear.
+func ParsePrices(description string) []Price {
+	type found struct {
+		pos   int
+		price Price
+	}
+	var all []found
+
+	for _, pattern := range pricePatterns {
+		for _, idx := range pattern.re.FindAllStringSubmatchIndex(description, -1) {
+			amountStr := description[idx[2*pattern.amount]:idx[2*pattern.amount+1]]
+			marker := description[idx[2*pattern.currency]:idx[2*pattern.currency+1]]
+
+			amount, ok := parseAmount(amountStr)
+			if !ok {
+				continue
+			}
+			currency, ok := currencyCodes[strings.ToLower(marker)]
+			if !ok {
+				continue
+			}
+			all = append(all, found{idx[0], Price{amount, currency}})
+		}
+	}
+
+	var prices []Price
+	byCurrency := map[string]int{}
+	for len(all) > 0 {
+		// Take the earliest match remaining
+		first := 0
+		for i := range all {
+			if all[i].pos < all[first].pos {
+				first = i
+			}
+		}
+		p := all[first].price
+		all = append(all[:first], all[first+1:]...)
+
+		if i, ok := byCurrency[p.Currency]; ok {
+			if p.Amount < prices[i].Amount {
+				prices[i].Amount = p.Amount
+			}
+			continue
+		}
+		byCurrency[p.Currency] = len(prices)
+		prices = append(prices, p)
+	}
+
+	return prices
+}
+
+// ParsePrice looks for prices in an event's description and returns the
+// lowest one. If the description lists prices in more than one currency the
+// amounts aren't really comparable, but the lowest is a good enough guess. ok is
+// false if no price was found.
+func ParsePrice(description string) (price Price, ok bool) {
+	for _, p := range ParsePrices(description) {
+		if !ok || p.Amount < price.Amount {
+			price = p
+			ok = true
+		}
+	}
+	return price, ok
+}
+
+// ExtractPrice is like ParsePrice, but returns the amount in cents, that is
+// the currency's minor unit, along with its ISO 4217 code. Currencies without
+// a minor unit, like yen, are counted in whole units.
+func ExtractPrice(description string) (cents int, currency string, ok bool) {
+	price, ok := ParsePrice(description)
+	if !ok {
+		return 0, "", false
+	}
+	return int(math.Round(price.Amount * math.Pow10(MinorUnits(price.Currency)))), price.Currency, true
+}
+
+// zeroDecimalCurrencies are the currencies we recognize that have no minor
+// unit, per ISO 4217.
+var zeroDecimalCurrencies = map[string]bool{
+	"JPY": true,
+	"KRW": true,
+}
+
+// MinorUnits returns how many decimal places the currency with the given ISO
+// 4217 code has: 2 for cents, or 0 for currencies like yen that have none.
+func MinorUnits(currency string) int {
+	if zeroDecimalCurrencies[strings.ToUpper(currency)] {
+		return 0
+	}
+	return 2
+}
+
+// parseAmount parses a number formatted in either the US style (1,000.50) or
+// the European style (1.000,50).
+func parseAmount(s string) (float64, bool) {
+	lastDot := strings.LastIndex(s, ".")
+	lastComma := strings.LastIndex(s, ",")
+
+	var decimalSep, thousandsSep string
+	switch {
+	case lastDot >= 0 && lastComma >= 0:
+		// Whichever comes last is the decimal separator
+		if lastDot > lastComma {
+			decimalSep, thousandsSep = ".", ","
+		} else {
+			decimalSep, thousandsSep = ",", "."
+		}
+	case lastDot >= 0 || lastComma >= 0:
+		sep := "."
+		if lastComma >= 0 {
+			sep = ","
+		}
+		// A single separator followed by three digits, like 1,000 or 1.000,
+		// groups thousands. Otherwise it's a decimal point.
+		if strings.Count(s, sep) > 1 || len(s)-strings.LastIndex(s, sep)-1 == 3 {
+			thousandsSep = sep
+		} else {
+			decimalSep = sep
+		}
+	}
+
+	if thousandsSep != "" {
+		s = strings.Replace(s, thousandsSep, "", -1)
+	}
+	if decimalSep == "," {
+		s = strings.Replace(s, ",", ".", 1)
+	}
+
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, false
+	}
+	return v, true
+}
@@ -0,0 +1,88 @@
+package eventdb
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParsePrice(t *testing.T) {
+	for _, test := range []struct {
+		Description string
+		Want        Price
+		WantOK      bool
+	}{
+		{Description: "Tickets are $5 at the door", Want: Price{5, "USD"}, WantOK: true},
+		{Description: "Eintritt: 10.50 €", Want: Price{10.5, "EUR"}, WantOK: true},
+		{Description: "Eintritt: 10,50 €", Want: Price{10.5, "EUR"}, WantOK: true},
+		{Description: "Eintritt: €1.250,50", Want: Price{1250.5, "EUR"}, WantOK: true},
+		{Description: "Tickets $1,250.50", Want: Price{1250.5, "USD"}, WantOK: true},
+		{Description: "入場料 ¥1,000", Want: Price{1000, "JPY"}, WantOK: true},
+		{Description: "Entry 1.500 yen", Want: Price{1500, "JPY"}, WantOK: true},
+		{Description: "Entry is 20 dollars, 15 dollars for students", Want: Price{15, "USD"}, WantOK: true},
+		{Description: "Tickets EUR 12", Want: Price{12, "EUR"}, WantOK: true},
+		{Description: "Tickets 12EUR", Want: Price{12, "EUR"}, WantOK: true},
+		{Description: "Amateur 5K, entry $10", Want: Price{10, "USD"}, WantOK: true},
+		{Description: "Amateur 5K fun run", WantOK: false},
+		{Description: "Entry Rs 200", Want: Price{200, "INR"}, WantOK: true},
+		{Description: "Free entry, all welcome", WantOK: false},
+	} {
+		got, ok := ParsePrice(test.Description)
+		if ok != test.WantOK {
+			t.Fatalf("ParsePrice(%q) ok = %v, want %v", test.Description, ok, test.WantOK)
+		}
+		if got != test.Want {
+			t.Fatalf("ParsePrice(%q) = %v, want %v", test.Description, got, test.Want)
+		}
+	}
+}
+
+func TestParsePrices(t *testing.T) {
+	for _, test := range []struct {
+		Description string
+		Want        []Price
+	}{
+		{
+			Description: "Tickets €12 / $15, €8 for students",
+			Want:        []Price{{8, "EUR"}, {15, "USD"}},
+		},
+		{
+			Description: "¥2000 (about $18)",
+			Want:        []Price{{2000, "JPY"}, {18, "USD"}},
+		},
+		{
+			Description: "No prices here",
+			Want:        nil,
+		},
+	} {
+		got := ParsePrices(test.Description)
+		if !reflect.DeepEqual(got, test.Want) {
+			t.Fatalf("ParsePrices(%q) = %v, want %v", test.Description, got, test.Want)
+		}
+	}
+}
+
+func TestExtractPrice(t *testing.T) {
+	for _, test := range []struct {
+		Description  string
+		WantCents    int
+		WantCurrency string
+		WantOK       bool
+	}{
+		{Description: "Tickets are $5 at the door", WantCents: 500, WantCurrency: "USD", WantOK: true},
+		{Description: "Entry 5 dollars", WantCents: 500, WantCurrency: "USD", WantOK: true},
+		{Description: "Eintritt: 10,50 €", WantCents: 1050, WantCurrency: "EUR", WantOK: true},
+		{Description: "Entry Rs 200", WantCents: 20000, WantCurrency: "INR", WantOK: true},
+		{Description: "Tickets $0.29", WantCents: 29, WantCurrency: "USD", WantOK: true},
+		{Description: "入場料 ¥1,000", WantCents: 1000, WantCurrency: "JPY", WantOK: true},
+		{Description: "입장료 ₩5000", WantCents: 5000, WantCurrency: "KRW", WantOK: true},
+		{Description: "Free entry, all welcome", WantOK: false},
+	} {
+		cents, currency, ok := ExtractPrice(test.Description)
+		if ok != test.WantOK {
+			t.Fatalf("ExtractPrice(%q) ok = %v, want %v", test.Description, ok, test.WantOK)
+		}
+		if cents != test.WantCents || currency != test.WantCurrency {
+			t.Fatalf("ExtractPrice(%q) = %d %s, want %d %s", test.Description, cents, currency, test.WantCents, test.WantCurrency)
+		}
+	}
+}
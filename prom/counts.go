@@ -0,0 +1,86 @@
+package prom
+
+import (
+	"context"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+
+	"github.com/findrandomevents/eventdb/log"
+)
+
+// A CountFunc returns the number of stored objects of some kind. Approximate
+// counts are fine.
+type CountFunc func(ctx context.Context) (int64, error)
+
+// WatchCounts exports an eventdb_<name>_total gauge for each of counts and
+// refreshes them every interval until ctx is canceled. Use it to track data
+// growth without querying the database directly.
+func WatchCounts(ctx context.Context, interval time.Duration, counts map[string]CountFunc) {
+	logger := log.FromContext(ctx)
+
+	gauges := make(map[string]prometheus.Gauge)
+	for name := range counts {
+		gauge := prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "eventdb_" + name + "_total",
+			Help: "Approximate number of " + name + " stored in the database.",
+		})
+		promRegister(gauge)
+		gauges[name] = gauge
+	}
+
+	refresh := func() {
+		for name, count := range counts {
+			n, err := count(ctx)
+			if err != nil {
+				logger.Warn("refresh count failed", zap.String("name", name), zap.Error(err))
+				continue
+			}
+			gauges[name].Set(float64(n))
+		}
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		refresh()
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// WatchGauge sets gauge to the result of count every interval until ctx is
+// canceled. If interval isn't positive it logs an error and returns right
+// away.
+func WatchGauge(ctx context.Context, interval time.Duration, gauge prometheus.Gauge, count CountFunc) {
+	logger := log.FromContext(ctx)
+
+	if interval <= 0 {
+		logger.Error("gauge not watched, interval must be positive", zap.Duration("interval", interval))
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		n, err := count(ctx)
+		if err != nil {
+			logger.Warn("refresh gauge failed", zap.Error(err))
+		} else {
+			gauge.Set(float64(n))
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
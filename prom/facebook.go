@@ -0,0 +1,22 @@
+package prom
+
+import "github.com/prometheus/client_golang/prometheus"
+
+// FacebookTokenExpired counts the users whose Facebook tokens turned out to be
+// expired. A spike means a wave of users need to log in again.
+var FacebookTokenExpired = prometheus.NewCounter(prometheus.CounterOpts{
+	Name: "eventdb_facebook_token_expired_total",
+	Help: "Total number of user Facebook tokens found to be expired.",
+})
+
+// FacebookTokensAvailable is how many users have a Facebook token that events
+// can be fetched with. At zero, event submissions fail.
+var FacebookTokensAvailable = prometheus.NewGauge(prometheus.GaugeOpts{
+	Name: "eventdb_facebook_tokens_available",
+	Help: "Number of users with a saved Facebook token.",
+})
+
+func init() {
+	promRegister(FacebookTokenExpired)
+	promRegister(FacebookTokensAvailable)
+}
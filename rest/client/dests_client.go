@@ -3,6 +3,7 @@ package client
 import (
 	"context"
 	"fmt"
+	"net/url"
 
 	"github.com/findrandomevents/eventdb"
 )
@@ -17,6 +18,18 @@ type DestsClient struct {
 // was successful.
 func (c *DestsClient) Generate(ctx context.Context, opts eventdb.DestGenerateRequest) (eventdb.DestGenerateReply, error) {
 	endpoint := fmt.Sprintf("/dests/generate?lat=%f&lng=%f", opts.Lat, opts.Lng)
+	if opts.RadiusM != 0 {
+		endpoint += fmt.Sprintf("&radius=%f", opts.RadiusM)
+	}
+	if opts.WindowMinutes != 0 {
+		endpoint += fmt.Sprintf("&window=%d", opts.WindowMinutes)
+	}
+	if opts.CooldownDisabled {
+		endpoint += "&cooldownDisabled=true"
+	}
+	if opts.Location != "" {
+		endpoint += "&location=" + url.QueryEscape(opts.Location)
+	}
 	var resp eventdb.DestGenerateReply
 	if err := c.client.doJSON(ctx, "POST", endpoint, nil, &resp); err != nil {
 		return resp, err
@@ -24,6 +37,16 @@ func (c *DestsClient) Generate(ctx context.Context, opts eventdb.DestGenerateReq
 	return resp, nil
 }
 
+// CreateMulti creates dests for a user directly, without going through
+// Generate. It's only available to admins.
+func (c *DestsClient) CreateMulti(ctx context.Context, req eventdb.DestCreateMultiRequest) ([]eventdb.Dest, error) {
+	var resp []eventdb.Dest
+	if err := c.client.doJSON(ctx, "POST", "/dests/batch", req, &resp); err != nil {
+		return resp, err
+	}
+	return resp, nil
+}
+
 // Get retrieves a Dest from the database.
 func (c *DestsClient) Get(ctx context.Context, id eventdb.DestID) (eventdb.Dest, error) {
 	var resp eventdb.Dest
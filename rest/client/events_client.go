@@ -2,6 +2,10 @@ package client
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"time"
 
 	"github.com/findrandomevents/eventdb"
 )
@@ -21,9 +25,182 @@ func (c *EventsClient) Search(ctx context.Context, req eventdb.EventSearchReques
 	return resp, nil
 }
 
+// Explain returns the database query plan for a search, as JSON, instead of
+// its results. It's only available to admins.
+func (c *EventsClient) Explain(ctx context.Context, req eventdb.EventSearchRequest) (json.RawMessage, error) {
+	var resp json.RawMessage
+	if err := c.client.doJSON(ctx, "POST", "/events/search?explain=1", req, &resp); err != nil {
+		return resp, err
+	}
+	return resp, nil
+}
+
+// SearchByDay is like Search, but groups the results by the day they start
+// on in the time zone tz. It's only available to admins.
+func (c *EventsClient) SearchByDay(ctx context.Context, req eventdb.EventSearchRequest, tz string) ([]eventdb.EventDay, error) {
+	var resp []eventdb.EventDay
+	if err := c.client.doJSON(ctx, "POST", "/events/search?format=by-day&tz="+url.QueryEscape(tz), req, &resp); err != nil {
+		return resp, err
+	}
+	return resp, nil
+}
+
+// Extent returns the bounding box of the events matching req. It's only
+// available to admins.
+func (c *EventsClient) Extent(ctx context.Context, req eventdb.EventSearchRequest) (eventdb.EventExtent, error) {
+	var resp eventdb.EventExtent
+
+	js, err := json.Marshal(req)
+	if err != nil {
+		return resp, err
+	}
+	endpoint := "/events/extent?json=" + url.QueryEscape(string(js))
+	if err := c.client.doJSON(ctx, "GET", endpoint, nil, &resp); err != nil {
+		return resp, err
+	}
+	return resp, nil
+}
+
+// Next returns the soonest upcoming event near lat, lng that there's still
+// time to get to.
+func (c *EventsClient) Next(ctx context.Context, lat, lng float64) (eventdb.Event, error) {
+	var resp eventdb.Event
+	endpoint := fmt.Sprintf("/events/next?lat=%f&lng=%f", lat, lng)
+	if err := c.client.doJSON(ctx, "GET", endpoint, nil, &resp); err != nil {
+		return resp, err
+	}
+	return resp, nil
+}
+
+// Sync returns the events that changed after since and sinceID. Pass the
+// reply's Next and NextID as since and sinceID to get the following changes.
+func (c *EventsClient) Sync(ctx context.Context, since time.Time, sinceID eventdb.EventID) (eventdb.EventSyncReply, error) {
+	var resp eventdb.EventSyncReply
+	endpoint := "/events/sync?since=" + url.QueryEscape(since.Format(time.RFC3339Nano))
+	if sinceID != "" {
+		endpoint += "&sinceID=" + url.QueryEscape(string(sinceID))
+	}
+	if err := c.client.doJSON(ctx, "GET", endpoint, nil, &resp); err != nil {
+		return resp, err
+	}
+	return resp, nil
+}
+
+// RefreshStale refetches up to limit upcoming events that haven't been
+// downloaded from Facebook in olderThan. It's only available to admins.
+func (c *EventsClient) RefreshStale(ctx context.Context, olderThan time.Duration, limit int) (eventdb.EventRefreshReply, error) {
+	var resp eventdb.EventRefreshReply
+	endpoint := fmt.Sprintf("/events/refresh?olderThan=%s&limit=%d", url.QueryEscape(olderThan.String()), limit)
+	if err := c.client.doJSON(ctx, "POST", endpoint, nil, &resp); err != nil {
+		return resp, err
+	}
+	return resp, nil
+}
+
+// ValidateBounds checks whether a GeoJSON polygon can be used as search
+// bounds, and if not, why.
+func (c *EventsClient) ValidateBounds(ctx context.Context, bounds string) (eventdb.BoundsValidateReply, error) {
+	var resp eventdb.BoundsValidateReply
+	req := eventdb.BoundsValidateRequest{Bounds: bounds}
+	if err := c.client.doJSON(ctx, "POST", "/events/validate-bounds", req, &resp); err != nil {
+		return resp, err
+	}
+	return resp, nil
+}
+
+// Import saves Graph API-shaped event JSON from sources other than
+// Facebook. It's only available to admins.
+func (c *EventsClient) Import(ctx context.Context, events []json.RawMessage) error {
+	return c.client.doJSON(ctx, "POST", "/events/import", events, nil)
+}
+
 // Submit downloads the events using the Facebook API and saves them to the
 // EventStore. It uses a random user's Facebook API token to fetch the event
-// so some users must be logged in with Facebook for this method to work.
-func (c *EventsClient) Submit(ctx context.Context, req eventdb.EventSubmitRequest) error {
-	return c.client.doJSON(ctx, "POST", "/events", req, nil)
+// so some users must be logged in with Facebook for this method to work. The
+// reply says which events were flagged bad and why.
+func (c *EventsClient) Submit(ctx context.Context, req eventdb.EventSubmitRequest) (eventdb.EventSubmitReply, error) {
+	var resp eventdb.EventSubmitReply
+	if err := c.client.doJSON(ctx, "POST", "/events", req, &resp); err != nil {
+		return resp, err
+	}
+	return resp, nil
+}
+
+// AtVenue returns the other upcoming events at the same place as the given
+// event.
+func (c *EventsClient) AtVenue(ctx context.Context, id eventdb.EventID) ([]eventdb.Event, error) {
+	var resp []eventdb.Event
+	if err := c.client.doJSON(ctx, "GET", "/events/"+url.PathEscape(string(id))+"/venue", nil, &resp); err != nil {
+		return resp, err
+	}
+	return resp, nil
+}
+
+// Delete removes an event from the database for good. It's only available to
+// admins.
+func (c *EventsClient) Delete(ctx context.Context, id eventdb.EventID) error {
+	return c.client.doJSON(ctx, "DELETE", "/events/"+url.PathEscape(string(id)), nil, nil)
+}
+
+// Feedback sums up the feedback users have left on their dests for an event.
+// It's only available to admins.
+func (c *EventsClient) Feedback(ctx context.Context, id eventdb.EventID) (eventdb.FeedbackSummary, error) {
+	var resp eventdb.FeedbackSummary
+	if err := c.client.doJSON(ctx, "GET", "/events/"+url.PathEscape(string(id))+"/feedback", nil, &resp); err != nil {
+		return resp, err
+	}
+	return resp, nil
+}
+
+// Report flags an event as broken or wrong, saying why. Events reported by
+// enough users are hidden from search.
+func (c *EventsClient) Report(ctx context.Context, id eventdb.EventID, reason string) error {
+	req := eventdb.EventReportRequest{Reason: reason}
+	return c.client.doJSON(ctx, "POST", "/events/"+url.PathEscape(string(id))+"/report", req, nil)
+}
+
+// ListReports returns the most recent event reports. It's only available to
+// admins.
+func (c *EventsClient) ListReports(ctx context.Context) ([]eventdb.EventReport, error) {
+	var resp []eventdb.EventReport
+	if err := c.client.doJSON(ctx, "GET", "/events/reports", nil, &resp); err != nil {
+		return resp, err
+	}
+	return resp, nil
+}
+
+// Blocklist returns the places and owners whose events are blocked. It's
+// only available to admins.
+func (c *EventsClient) Blocklist(ctx context.Context) ([]eventdb.Block, error) {
+	var resp []eventdb.Block
+	if err := c.client.doJSON(ctx, "GET", "/events/blocklist", nil, &resp); err != nil {
+		return resp, err
+	}
+	return resp, nil
+}
+
+// Block keeps all of a place's or owner's events out of search and generate.
+// It's only available to admins.
+func (c *EventsClient) Block(ctx context.Context, block eventdb.Block) (eventdb.Block, error) {
+	var resp eventdb.Block
+	if err := c.client.doJSON(ctx, "POST", "/events/blocklist", block, &resp); err != nil {
+		return resp, err
+	}
+	return resp, nil
+}
+
+// Unblock removes a place or owner from the blocklist. It's only available to
+// admins.
+func (c *EventsClient) Unblock(ctx context.Context, kind eventdb.BlockKind, id string) error {
+	return c.client.doJSON(ctx, "DELETE", "/events/blocklist/"+url.PathEscape(string(kind))+"/"+url.PathEscape(id), nil, nil)
+}
+
+// BadFilterRules lists the rules the server uses to flag bad events. It's
+// only available to admins.
+func (c *EventsClient) BadFilterRules(ctx context.Context) ([]eventdb.BadRule, error) {
+	var resp []eventdb.BadRule
+	if err := c.client.doJSON(ctx, "GET", "/events/bad-filter", nil, &resp); err != nil {
+		return resp, err
+	}
+	return resp, nil
 }
@@ -2,6 +2,8 @@ package client
 
 import (
 	"context"
+	"net/url"
+	"strconv"
 
 	"github.com/findrandomevents/eventdb"
 )
@@ -28,3 +30,48 @@ func (c *UsersClient) Get(ctx context.Context, id string) (eventdb.User, error)
 	}
 	return resp, nil
 }
+
+// Search lists users a page at a time. Pass the reply's Next as the request's
+// Cursor to get the following page. It's only available to admins.
+func (c *UsersClient) Search(ctx context.Context, req eventdb.UserSearchRequest) (eventdb.UserSearchReply, error) {
+	query := url.Values{}
+	if req.OrderBy != "" {
+		query.Set("orderBy", string(req.OrderBy))
+	}
+	if req.Cursor != "" {
+		query.Set("cursor", req.Cursor)
+	}
+	if req.Limit != 0 {
+		query.Set("limit", strconv.Itoa(req.Limit))
+	}
+
+	var resp eventdb.UserSearchReply
+	if err := c.client.doJSON(ctx, "GET", "/users/?"+query.Encode(), nil, &resp); err != nil {
+		return resp, err
+	}
+	return resp, nil
+}
+
+// Locations lists the user's saved locations.
+func (c *UsersClient) Locations(ctx context.Context, id string) ([]eventdb.SavedLocation, error) {
+	var resp []eventdb.SavedLocation
+	if err := c.client.doJSON(ctx, "GET", "/users/"+id+"/locations", nil, &resp); err != nil {
+		return resp, err
+	}
+	return resp, nil
+}
+
+// SaveLocation saves a location under its label, replacing any with the same
+// label.
+func (c *UsersClient) SaveLocation(ctx context.Context, id string, loc eventdb.SavedLocation) (eventdb.SavedLocation, error) {
+	var resp eventdb.SavedLocation
+	if err := c.client.doJSON(ctx, "POST", "/users/"+id+"/locations", loc, &resp); err != nil {
+		return resp, err
+	}
+	return resp, nil
+}
+
+// DeleteLocation removes a saved location.
+func (c *UsersClient) DeleteLocation(ctx context.Context, id, label string) error {
+	return c.client.doJSON(ctx, "DELETE", "/users/"+id+"/locations/"+url.PathEscape(label), nil, nil)
+}
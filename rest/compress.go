@@ -0,0 +1,162 @@
+package rest
+
+import (
+	"compress/gzip"
+	"net/http"
+	"strings"
+)
+
+// Compress gzips responses for clients that accept it.
+type Compress struct {
+	// MinSize is the smallest response body, in bytes, that gets compressed.
+	// Below it the gzip overhead isn't worth it. It defaults to 1400, about
+	// one TCP packet.
+	MinSize int
+}
+
+const defaultCompressMinSize = 1400
+
+// Wrap returns a handler that gzips h's responses when the request's
+// Accept-Encoding allows it. Responses that are small or that already have a
+// Content-Encoding are sent as is.
+func (c Compress) Wrap(h http.Handler) http.Handler {
+	minSize := c.MinSize
+	if minSize <= 0 {
+		minSize = defaultCompressMinSize
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+
+		if r.Method == "HEAD" || !acceptsGzip(r) {
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		gw := &gzipResponseWriter{
+			ResponseWriter: w,
+			minSize:        minSize,
+			status:         http.StatusOK,
+		}
+		defer gw.Close()
+
+		h.ServeHTTP(gw, r)
+	})
+}
+
+// acceptsGzip reports whether the request's Accept-Encoding includes gzip
+// without q=0.
+func acceptsGzip(r *http.Request) bool {
+	for _, enc := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		parts := strings.Split(enc, ";")
+		if strings.TrimSpace(parts[0]) != "gzip" {
+			continue
+		}
+		for _, param := range parts[1:] {
+			if q := strings.Replace(param, " ", "", -1); q == "q=0" || q == "q=0.0" {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// gzipResponseWriter buffers the start of a response until it knows whether
+// it's big enough to compress.
+type gzipResponseWriter struct {
+	http.ResponseWriter
+
+	minSize int
+	status  int
+	buf     []byte
+
+	started bool
+	gz      *gzip.Writer
+}
+
+func (w *gzipResponseWriter) WriteHeader(status int) {
+	if w.started {
+		return
+	}
+	w.status = status
+}
+
+func (w *gzipResponseWriter) Write(p []byte) (int, error) {
+	if w.started {
+		if w.gz != nil {
+			return w.gz.Write(p)
+		}
+		return w.ResponseWriter.Write(p)
+	}
+
+	w.buf = append(w.buf, p...)
+	if len(w.buf) >= w.minSize {
+		if err := w.start(true); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// start sends the headers and buffered body, compressing the rest of the
+// response if compress is set and the content isn't already encoded.
+func (w *gzipResponseWriter) start(compress bool) error {
+	w.started = true
+
+	header := w.Header()
+	if header.Get("Content-Type") == "" && len(w.buf) > 0 {
+		header.Set("Content-Type", http.DetectContentType(w.buf))
+	}
+	if header.Get("Content-Encoding") != "" || isCompressed(header.Get("Content-Type")) {
+		compress = false
+	}
+
+	if compress {
+		header.Set("Content-Encoding", "gzip")
+		header.Del("Content-Length")
+		w.gz = gzip.NewWriter(w.ResponseWriter)
+	}
+	w.ResponseWriter.WriteHeader(w.status)
+
+	buf := w.buf
+	w.buf = nil
+	if len(buf) == 0 {
+		return nil
+	}
+	if w.gz != nil {
+		_, err := w.gz.Write(buf)
+		return err
+	}
+	_, err := w.ResponseWriter.Write(buf)
+	return err
+}
+
+// Close finishes the response. Responses that never reached minSize are sent
+// uncompressed.
+func (w *gzipResponseWriter) Close() error {
+	if !w.started {
+		return w.start(false)
+	}
+	if w.gz != nil {
+		return w.gz.Close()
+	}
+	return nil
+}
+
+// isCompressed reports whether content of this type is already compressed,
+// so gzipping it again would only waste time.
+func isCompressed(contentType string) bool {
+	mediaType := strings.TrimSpace(strings.Split(contentType, ";")[0])
+	switch {
+	case strings.HasPrefix(mediaType, "image/") && mediaType != "image/svg+xml",
+		strings.HasPrefix(mediaType, "video/"),
+		strings.HasPrefix(mediaType, "audio/"):
+		return true
+	}
+	switch mediaType {
+	case "application/gzip", "application/zip", "application/x-gzip":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,134 @@
+package rest
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CORS configures which web origins may make cross-origin requests to the
+// API.
+type CORS struct {
+	// AllowedOrigins lists the origins (e.g. "https://findrandomevents.com")
+	// that may make cross-origin requests. "*" allows any origin, but only
+	// when AllowCredentials is false.
+	AllowedOrigins []string
+
+	// Origins, if set, is used instead of AllowedOrigins. Unlike
+	// AllowedOrigins it can be changed while requests are being served.
+	Origins *OriginList
+
+	// AllowCredentials lets browsers send cookies with cross-origin requests,
+	// which is needed for the jwt cookie auth used by the web app. When it's
+	// set the request's Origin is reflected back instead of "*".
+	AllowCredentials bool
+
+	// MaxAge is how long browsers may cache a preflight response.
+	MaxAge time.Duration
+}
+
+var (
+	corsAllowedMethods = []string{"GET", "POST", "PUT", "PATCH", "OPTIONS", "HEAD"}
+	corsAllowedHeaders = []string{"Authorization", "Content-Type"}
+)
+
+// Wrap returns a handler that adds CORS headers to h's responses and answers
+// preflight requests from allowed origins.
+func (c CORS) Wrap(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Add("Vary", "Origin")
+
+		preflight := r.Method == "OPTIONS" && r.Header.Get("Access-Control-Request-Method") != ""
+
+		allowOrigin, ok := c.allowOrigin(origin)
+		if !ok {
+			if preflight {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Set("Access-Control-Allow-Origin", allowOrigin)
+		if c.AllowCredentials {
+			w.Header().Set("Access-Control-Allow-Credentials", "true")
+		}
+
+		if !preflight {
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Set("Access-Control-Allow-Methods", strings.Join(corsAllowedMethods, ", "))
+		w.Header().Set("Access-Control-Allow-Headers", strings.Join(corsAllowedHeaders, ", "))
+		if c.MaxAge > 0 {
+			w.Header().Set("Access-Control-Max-Age", strconv.Itoa(int(c.MaxAge/time.Second)))
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+// allowOrigin checks origin against the allowlist and returns the value to
+// send in Access-Control-Allow-Origin.
+func (c CORS) allowOrigin(origin string) (string, bool) {
+	allowedOrigins := c.AllowedOrigins
+	if c.Origins != nil {
+		allowedOrigins = c.Origins.Get()
+	}
+
+	for _, allowed := range allowedOrigins {
+		allowed = strings.TrimSpace(allowed)
+		if allowed == "*" {
+			// Browsers reject a wildcard on credentialed requests, and
+			// reflecting any origin with credentials would let any site act
+			// as the logged-in user.
+			if c.AllowCredentials {
+				continue
+			}
+			return "*", true
+		}
+		if strings.EqualFold(allowed, origin) {
+			return origin, true
+		}
+	}
+	return "", false
+}
+
+// OriginList is a list of allowed CORS origins that's safe to change while
+// CORS is reading it, so the list can be reloaded without a restart.
+type OriginList struct {
+	mu      sync.RWMutex
+	origins []string
+}
+
+// NewOriginList returns an OriginList that starts out allowing origins.
+func NewOriginList(origins []string) *OriginList {
+	l := &OriginList{}
+	l.Set(origins)
+	return l
+}
+
+// Get returns the allowed origins. The caller must not modify the slice.
+func (l *OriginList) Get() []string {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.origins
+}
+
+// Set replaces the allowed origins.
+func (l *OriginList) Set(origins []string) {
+	origins = append([]string(nil), origins...)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.origins = origins
+}
@@ -3,6 +3,7 @@ package rest
 import (
 	"context"
 	"encoding/json"
+	"fmt"
 	"io/ioutil"
 	"net/http"
 	"strconv"
@@ -37,6 +38,10 @@ func newDestsHandler(service *service.Service) *DestsHandler {
 		"/generate",
 		prom.InstrumentHandler("DestGenerate", http.HandlerFunc(h.HandleGenerate)),
 	).Methods("POST")
+	m.Handle(
+		"/batch",
+		prom.InstrumentHandler("DestCreateMulti", http.HandlerFunc(h.HandleCreateMulti)),
+	).Methods("POST")
 	m.Handle(
 		"/{id}",
 		prom.InstrumentHandler("DestGenerate", http.HandlerFunc(h.HandleGet)),
@@ -54,8 +59,12 @@ func newDestsHandler(service *service.Service) *DestsHandler {
 func (h *DestsHandler) HandleList(w http.ResponseWriter, r *http.Request) {
 	handleJSON(w, r, func(ctx context.Context) (interface{}, error) {
 		page, _ := strconv.Atoi(r.FormValue("p"))
+		pageSize, _ := strconv.Atoi(r.FormValue("pageSize"))
+		onlyUpcoming, _ := strconv.ParseBool(r.FormValue("onlyUpcoming"))
 		return h.service.DestList(ctx, eventdb.DestListRequest{
-			Page: page,
+			Page:         page,
+			PageSize:     pageSize,
+			OnlyUpcoming: onlyUpcoming,
 		})
 	})
 }
@@ -69,6 +78,18 @@ func (h *DestsHandler) HandleGet(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+// HandleCreateMulti wraps Service.DestCreateMulti in a REST interface
+func (h *DestsHandler) HandleCreateMulti(w http.ResponseWriter, r *http.Request) {
+	handleJSON(w, r, func(ctx context.Context) (interface{}, error) {
+		var req eventdb.DestCreateMultiRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			return nil, errors.E(errors.Invalid, err)
+		}
+
+		return h.service.DestCreateMulti(ctx, req.UserID, req.EventIDs)
+	})
+}
+
 // HandleUpdate wraps Service.DestUpdate in a REST interface
 func (h *DestsHandler) HandleUpdate(w http.ResponseWriter, r *http.Request) {
 	destID := strings.TrimLeft(r.URL.Path, "/")
@@ -92,16 +113,46 @@ func parseGenerateRequest(r *http.Request) (eventdb.DestGenerateRequest, error)
 
 	if len(body) > 0 {
 		if err := json.Unmarshal(body, &req); err != nil {
-			return req, err
+			return req, errors.E(errors.Invalid, err)
 		}
 	} else {
-		latStr := r.FormValue("lat")
-		lat, _ := strconv.ParseFloat(latStr, 64)
-		req.Lat = lat
+		latStr, lngStr := r.FormValue("lat"), r.FormValue("lng")
+		if (latStr == "") != (lngStr == "") {
+			return req, errors.E(errors.Invalid, "lat and lng must be given together")
+		}
+		if latStr != "" {
+			req.Lat, err = strconv.ParseFloat(latStr, 64)
+			if err != nil {
+				return req, errors.E(errors.Invalid, fmt.Sprintf("invalid lat %q", latStr))
+			}
+			req.Lng, err = strconv.ParseFloat(lngStr, 64)
+			if err != nil {
+				return req, errors.E(errors.Invalid, fmt.Sprintf("invalid lng %q", lngStr))
+			}
+		}
+
+		if radiusStr := r.FormValue("radius"); radiusStr != "" {
+			req.RadiusM, err = strconv.ParseFloat(radiusStr, 64)
+			if err != nil {
+				return req, errors.E(errors.Invalid, fmt.Sprintf("invalid radius %q", radiusStr))
+			}
+		}
+
+		if noticeStr := r.FormValue("notice"); noticeStr != "" {
+			req.MinNoticeMinutes, err = strconv.Atoi(noticeStr)
+			if err != nil {
+				return req, errors.E(errors.Invalid, fmt.Sprintf("invalid notice %q", noticeStr))
+			}
+		}
 
-		lngStr := r.FormValue("lng")
-		lng, _ := strconv.ParseFloat(lngStr, 64)
-		req.Lng = lng
+		if windowStr := r.FormValue("window"); windowStr != "" {
+			req.WindowMinutes, err = strconv.Atoi(windowStr)
+			if err != nil {
+				return req, errors.E(errors.Invalid, fmt.Sprintf("invalid window %q", windowStr))
+			}
+		}
+		req.CooldownDisabled, _ = strconv.ParseBool(r.FormValue("cooldownDisabled"))
+		req.Location = r.FormValue("location")
 	}
 
 	userIDStr, _ := mux.Vars(r)["id"]
@@ -5,10 +5,13 @@ import (
 	"encoding/json"
 	"io/ioutil"
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/gorilla/mux"
 
 	"github.com/findrandomevents/eventdb"
+	"github.com/findrandomevents/eventdb/auth"
 	"github.com/findrandomevents/eventdb/errors"
 	"github.com/findrandomevents/eventdb/prom"
 	"github.com/findrandomevents/eventdb/service"
@@ -31,14 +34,74 @@ func newEventsHandler(service *service.Service) *EventsHandler {
 		"/",
 		prom.InstrumentHandler("EventSubmit", http.HandlerFunc(h.HandleSubmit)),
 	).Methods("POST")
+	m.Handle(
+		"/import",
+		prom.InstrumentHandler("EventImport", http.HandlerFunc(h.HandleImport)),
+	).Methods("POST")
 	m.Handle(
 		"/search",
 		prom.InstrumentHandler("EventSearch", http.HandlerFunc(h.HandleSearch)),
 	).Methods("POST", "GET")
+	m.Handle(
+		"/extent",
+		prom.InstrumentHandler("EventExtent", http.HandlerFunc(h.HandleExtent)),
+	).Methods("GET")
+	m.Handle(
+		"/sync",
+		prom.InstrumentHandler("EventSync", http.HandlerFunc(h.HandleSync)),
+	).Methods("GET")
+	m.Handle(
+		"/refresh",
+		prom.InstrumentHandler("RefreshStale", http.HandlerFunc(h.HandleRefresh)),
+	).Methods("POST")
+	m.Handle(
+		"/validate-bounds",
+		prom.InstrumentHandler("ValidateBounds", http.HandlerFunc(h.HandleValidateBounds)),
+	).Methods("POST")
+	m.Handle(
+		"/bad-filter",
+		prom.InstrumentHandler("BadFilterRules", http.HandlerFunc(h.HandleBadFilter)),
+	).Methods("GET")
+	m.Handle(
+		"/reports",
+		prom.InstrumentHandler("EventReportList", http.HandlerFunc(h.HandleReportList)),
+	).Methods("GET")
+	m.Handle(
+		"/blocklist",
+		prom.InstrumentHandler("BlocklistList", http.HandlerFunc(h.HandleBlocklistList)),
+	).Methods("GET")
+	m.Handle(
+		"/blocklist",
+		prom.InstrumentHandler("BlocklistAdd", http.HandlerFunc(h.HandleBlocklistAdd)),
+	).Methods("POST")
+	m.Handle(
+		"/blocklist/{kind}/{id}",
+		prom.InstrumentHandler("BlocklistDelete", http.HandlerFunc(h.HandleBlocklistDelete)),
+	).Methods("DELETE")
+	m.Handle(
+		"/next",
+		prom.InstrumentHandler("NextEvent", http.HandlerFunc(h.HandleNext)),
+	).Methods("GET")
 	m.Handle(
 		"/{id}",
 		prom.InstrumentHandler("EventGet", http.HandlerFunc(h.HandleGet)),
 	).Methods("GET")
+	m.Handle(
+		"/{id}",
+		prom.InstrumentHandler("EventDelete", http.HandlerFunc(h.HandleDelete)),
+	).Methods("DELETE")
+	m.Handle(
+		"/{id}/venue",
+		prom.InstrumentHandler("EventsAtVenue", http.HandlerFunc(h.HandleVenue)),
+	).Methods("GET")
+	m.Handle(
+		"/{id}/feedback",
+		prom.InstrumentHandler("EventFeedback", http.HandlerFunc(h.HandleFeedback)),
+	).Methods("GET")
+	m.Handle(
+		"/{id}/report",
+		prom.InstrumentHandler("EventReport", http.HandlerFunc(h.HandleReport)),
+	).Methods("POST")
 
 	h.Handler = m
 
@@ -54,6 +117,99 @@ func (h *EventsHandler) HandleGet(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+// HandleDelete wraps Service.EventDelete in a REST interface
+func (h *EventsHandler) HandleDelete(w http.ResponseWriter, r *http.Request) {
+	eventID, _ := mux.Vars(r)["id"]
+
+	handleJSON(w, r, func(ctx context.Context) (interface{}, error) {
+		if err := h.service.EventDelete(ctx, eventdb.EventID(eventID)); err != nil {
+			return nil, err
+		}
+		return nil, nil
+	})
+}
+
+// HandleNext wraps Service.NextEvent in a REST interface
+func (h *EventsHandler) HandleNext(w http.ResponseWriter, r *http.Request) {
+	handleJSON(w, r, func(ctx context.Context) (interface{}, error) {
+		lat, err := strconv.ParseFloat(r.FormValue("lat"), 64)
+		if err != nil {
+			return nil, errors.E(errors.Invalid, errors.Errorf("bad lat: %v", err))
+		}
+		lng, err := strconv.ParseFloat(r.FormValue("lng"), 64)
+		if err != nil {
+			return nil, errors.E(errors.Invalid, errors.Errorf("bad lng: %v", err))
+		}
+
+		return h.service.NextEvent(ctx, lat, lng)
+	})
+}
+
+// HandleSync wraps Service.EventSync in a REST interface
+func (h *EventsHandler) HandleSync(w http.ResponseWriter, r *http.Request) {
+	handleJSON(w, r, func(ctx context.Context) (interface{}, error) {
+		var since eventdb.SyncCursor
+		if s := r.FormValue("since"); s != "" {
+			var err error
+			since.UpdatedAt, err = time.Parse(time.RFC3339Nano, s)
+			if err != nil {
+				return nil, errors.E(errors.Invalid, errors.Errorf("bad since: %v", err))
+			}
+		}
+		since.ID = eventdb.EventID(r.FormValue("sinceID"))
+
+		events, tombstones, next, err := h.service.EventSync(ctx, since)
+		if err != nil {
+			return nil, err
+		}
+		return eventdb.EventSyncReply{
+			Events:     events,
+			Tombstones: tombstones,
+			Next:       next.UpdatedAt,
+			NextID:     next.ID,
+		}, nil
+	})
+}
+
+// HandleRefresh wraps Service.RefreshStale in a REST interface
+func (h *EventsHandler) HandleRefresh(w http.ResponseWriter, r *http.Request) {
+	handleJSON(w, r, func(ctx context.Context) (interface{}, error) {
+		olderThan, err := time.ParseDuration(r.FormValue("olderThan"))
+		if err != nil {
+			return nil, errors.E(errors.Invalid, errors.Errorf("bad olderThan: %v", err))
+		}
+		limit, err := strconv.Atoi(r.FormValue("limit"))
+		if err != nil {
+			return nil, errors.E(errors.Invalid, errors.Errorf("bad limit: %v", err))
+		}
+
+		// If it fails partway through, the count so far is in the error's
+		// details, see service.RefreshError
+		refreshed, err := h.service.RefreshStale(ctx, olderThan, limit)
+		if err != nil {
+			return nil, err
+		}
+		return eventdb.EventRefreshReply{Refreshed: refreshed}, nil
+	})
+}
+
+// HandleValidateBounds wraps Service.ValidateBounds in a REST interface
+func (h *EventsHandler) HandleValidateBounds(w http.ResponseWriter, r *http.Request) {
+	handleJSON(w, r, func(ctx context.Context) (interface{}, error) {
+		var req eventdb.BoundsValidateRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			return nil, errors.E(errors.Invalid, err)
+		}
+
+		area, valid, reason := h.service.ValidateBounds(ctx, req.Bounds)
+		return eventdb.BoundsValidateReply{
+			AreaM2: area,
+			Valid:  valid,
+			Reason: reason,
+		}, nil
+	})
+}
+
 // HandleSubmit wraps Service.EventSubmit in a REST interface
 func (h *EventsHandler) HandleSubmit(w http.ResponseWriter, r *http.Request) {
 	handleJSON(w, r, func(ctx context.Context) (interface{}, error) {
@@ -62,36 +218,167 @@ func (h *EventsHandler) HandleSubmit(w http.ResponseWriter, r *http.Request) {
 			return nil, errors.E(errors.Invalid, err)
 		}
 
-		if err := h.service.EventSubmit(ctx, req); err != nil {
+		return h.service.EventSubmit(ctx, req)
+	})
+}
+
+// HandleImport wraps Service.EventImport in a REST interface
+func (h *EventsHandler) HandleImport(w http.ResponseWriter, r *http.Request) {
+	handleJSON(w, r, func(ctx context.Context) (interface{}, error) {
+		var events []json.RawMessage
+		if err := json.NewDecoder(r.Body).Decode(&events); err != nil {
+			return nil, errors.E(errors.Invalid, err)
+		}
+
+		if err := h.service.EventImport(ctx, events); err != nil {
 			return nil, err
 		}
 		return nil, nil
 	})
 }
 
-// HandleSearch wraps Service.EventSearch in a REST interface
-func (h *EventsHandler) HandleSearch(w http.ResponseWriter, r *http.Request) {
+// HandleVenue wraps Service.EventsAtVenue in a REST interface
+func (h *EventsHandler) HandleVenue(w http.ResponseWriter, r *http.Request) {
+	eventID, _ := mux.Vars(r)["id"]
+
 	handleJSON(w, r, func(ctx context.Context) (interface{}, error) {
-		var js []byte
-		var err error
+		return h.service.EventsAtVenue(ctx, eventdb.EventID(eventID))
+	})
+}
 
-		if r.FormValue("json") != "" {
-			js = []byte(r.FormValue("json"))
-		} else {
-			js, err = ioutil.ReadAll(r.Body)
-			if err != nil {
-				return nil, errors.E(errors.Invalid, err)
-			}
+// HandleFeedback wraps Service.EventFeedback in a REST interface
+func (h *EventsHandler) HandleFeedback(w http.ResponseWriter, r *http.Request) {
+	eventID, _ := mux.Vars(r)["id"]
+
+	handleJSON(w, r, func(ctx context.Context) (interface{}, error) {
+		return h.service.EventFeedback(ctx, eventdb.EventID(eventID))
+	})
+}
+
+// HandleReport wraps Service.EventReport in a REST interface
+func (h *EventsHandler) HandleReport(w http.ResponseWriter, r *http.Request) {
+	eventID, _ := mux.Vars(r)["id"]
+
+	handleJSON(w, r, func(ctx context.Context) (interface{}, error) {
+		var req eventdb.EventReportRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			return nil, errors.E(errors.Invalid, err)
+		}
+
+		if err := h.service.EventReport(ctx, eventdb.EventID(eventID), req.Reason); err != nil {
+			return nil, err
 		}
+		return nil, nil
+	})
+}
 
-		var params eventdb.EventSearchRequest
-		if err := json.Unmarshal(js, &params); err != nil {
+// HandleBlocklistList wraps Service.BlocklistList in a REST interface
+func (h *EventsHandler) HandleBlocklistList(w http.ResponseWriter, r *http.Request) {
+	handleJSON(w, r, func(ctx context.Context) (interface{}, error) {
+		return h.service.BlocklistList(ctx)
+	})
+}
+
+// HandleBlocklistAdd wraps Service.BlocklistAdd in a REST interface
+func (h *EventsHandler) HandleBlocklistAdd(w http.ResponseWriter, r *http.Request) {
+	handleJSON(w, r, func(ctx context.Context) (interface{}, error) {
+		var block eventdb.Block
+		if err := json.NewDecoder(r.Body).Decode(&block); err != nil {
 			return nil, errors.E(errors.Invalid, err)
 		}
 
-		if r.FormValue("format") == "full" {
+		return h.service.BlocklistAdd(ctx, block)
+	})
+}
+
+// HandleBlocklistDelete wraps Service.BlocklistDelete in a REST interface
+func (h *EventsHandler) HandleBlocklistDelete(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	handleJSON(w, r, func(ctx context.Context) (interface{}, error) {
+		if err := h.service.BlocklistDelete(ctx, eventdb.BlockKind(vars["kind"]), vars["id"]); err != nil {
+			return nil, err
+		}
+		return nil, nil
+	})
+}
+
+// HandleBadFilter wraps Service.BadFilterRules in a REST interface
+func (h *EventsHandler) HandleBadFilter(w http.ResponseWriter, r *http.Request) {
+	handleJSON(w, r, func(ctx context.Context) (interface{}, error) {
+		return h.service.BadFilterRules(ctx)
+	})
+}
+
+// HandleReportList wraps Service.EventReportList in a REST interface
+func (h *EventsHandler) HandleReportList(w http.ResponseWriter, r *http.Request) {
+	handleJSON(w, r, func(ctx context.Context) (interface{}, error) {
+		return h.service.EventReportList(ctx)
+	})
+}
+
+// HandleSearch wraps Service.EventSearch in a REST interface. With explain=1
+// it returns the query plan from Service.EventSearchExplain instead. The
+// format param picks another kind of search, e.g. format=by-day&tz=Europe/Paris
+// for Service.EventSearchByDay.
+func (h *EventsHandler) HandleSearch(w http.ResponseWriter, r *http.Request) {
+	handleJSON(w, r, func(ctx context.Context) (interface{}, error) {
+		params, err := searchRequest(r)
+		if err != nil {
+			return nil, err
+		}
+
+		if r.FormValue("explain") == "1" {
+			return h.service.EventSearchExplain(ctx, params)
+		}
+
+		// Logged-out users get the limited public search
+		if auth.User(ctx).ID == "" {
+			return h.service.EventSearchPublic(ctx, params)
+		}
+
+		switch r.FormValue("format") {
+		case "full":
 			return h.service.EventSearchFull(ctx, params)
+		case "addressless":
+			return h.service.EventSearchAddressless(ctx, params)
+		case "by-day":
+			return h.service.EventSearchByDay(ctx, params, r.FormValue("tz"))
 		}
 		return h.service.EventSearch(ctx, params)
 	})
 }
+
+// searchRequest reads an EventSearchRequest from the json query parameter, or
+// from the request body if it isn't set.
+func searchRequest(r *http.Request) (eventdb.EventSearchRequest, error) {
+	var params eventdb.EventSearchRequest
+
+	var js []byte
+	if r.FormValue("json") != "" {
+		js = []byte(r.FormValue("json"))
+	} else {
+		var err error
+		js, err = ioutil.ReadAll(r.Body)
+		if err != nil {
+			return params, errors.E(errors.Invalid, err)
+		}
+	}
+
+	if err := json.Unmarshal(js, &params); err != nil {
+		return params, errors.E(errors.Invalid, err)
+	}
+	return params, nil
+}
+
+// HandleExtent wraps Service.EventExtent in a REST interface. The search is
+// passed as JSON in the json query parameter, as with HandleSearch.
+func (h *EventsHandler) HandleExtent(w http.ResponseWriter, r *http.Request) {
+	handleJSON(w, r, func(ctx context.Context) (interface{}, error) {
+		params, err := searchRequest(r)
+		if err != nil {
+			return nil, err
+		}
+		return h.service.EventExtent(ctx, params)
+	})
+}
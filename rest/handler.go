@@ -7,9 +7,11 @@ import (
 	"encoding/json"
 	"fmt"
 	"math/rand"
+	"net"
 	"net/http"
 	"path"
 	"strings"
+	"time"
 
 	"go.uber.org/zap"
 
@@ -22,7 +24,8 @@ import (
 // New creates a new REST service wrapping an eventdb Service.
 func New(service *service.Service) *Handler {
 	return &Handler{
-		Auth: service.Auth,
+		Auth:    service.Auth,
+		service: service,
 
 		UsersHandler:  newUsersHandler(service),
 		EventsHandler: newEventsHandler(service),
@@ -37,6 +40,8 @@ type Handler struct {
 	UsersHandler  *UsersHandler
 	EventsHandler *EventsHandler
 	DestsHandler  *DestsHandler
+
+	service *service.Service
 }
 
 func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
@@ -50,7 +55,7 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	// Get auth info from the JWT header
 	user, err := h.Auth.FromRequest(r)
 	if err == auth.ErrExpired {
-		writeErrorResp(w, errors.Response{
+		writeErrorResp(w, r, errors.Response{
 			Error:  "auth token expired",
 			Status: http.StatusUnauthorized,
 		})
@@ -64,8 +69,43 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	// Decorate the logger with the user id
 	logger = logger.With(zap.String("userid", user.ID))
 	ctx = log.ToContext(ctx, logger)
+
+	// Let admins pretend it's some other time, see service.WithNow
+	if header := r.Header.Get("X-Now"); header != "" {
+		if !user.IsAdmin {
+			writeErrorResp(w, r, errors.Response{
+				Error:  "X-Now is only allowed for admins",
+				Status: http.StatusForbidden,
+			})
+			return
+		}
+		now, err := time.Parse(time.RFC3339, header)
+		if err != nil {
+			writeErrorResp(w, r, errors.Response{
+				Error:  "bad X-Now, want RFC 3339 time",
+				Status: http.StatusBadRequest,
+			})
+			return
+		}
+		logger.Info("overriding now", zap.Time("now", now))
+		ctx = service.WithNow(ctx, now)
+	}
+
+	// Rate limit anonymous requests by where they came from
+	if ip, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		ctx = service.WithClientIP(ctx, ip)
+	} else {
+		ctx = service.WithClientIP(ctx, r.RemoteAddr)
+	}
+
 	r = r.WithContext(ctx)
 
+	if h.service != nil {
+		if err := h.service.UserActive(ctx); err != nil {
+			logger.Warn("record user activity failed", zap.Error(err))
+		}
+	}
+
 	switch head {
 	case "users":
 		if h.UsersHandler != nil {
@@ -89,20 +129,113 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		}
 
 	case "healthz":
+		coin := "tails"
 		if rand.Intn(2) == 0 {
-			fmt.Fprintln(w, "heads")
+			coin = "heads"
+		}
+		if wantsJSON(r) {
+			writeJSON(w, r, http.StatusOK, map[string]string{"status": coin})
+		} else {
+			fmt.Fprintln(w, coin)
+		}
+
+	case "readyz":
+		if h.service != nil {
+			h.serveReady(w, r)
 		} else {
-			fmt.Fprintln(w, "tails")
+			http.NotFound(w, r)
 		}
 
 	case "":
-		http.Redirect(w, r, "https://findrandomevents.com", http.StatusTemporaryRedirect)
+		if wantsJSON(r) {
+			w.Header().Set("Location", homeURL)
+			writeJSON(w, r, http.StatusTemporaryRedirect, map[string]string{"location": homeURL})
+		} else {
+			http.Redirect(w, r, homeURL, http.StatusTemporaryRedirect)
+		}
 
 	default:
-		http.NotFound(w, r)
+		if wantsJSON(r) {
+			writeErrorResp(w, r, errors.Response{
+				Error:  "not found",
+				Status: http.StatusNotFound,
+			})
+		} else {
+			http.NotFound(w, r)
+		}
 	}
 }
 
+// ReadyReply is the body of the /readyz endpoint.
+type ReadyReply struct {
+	// Status is "ok", or "degraded" if the service is up but can't fetch
+	// events from Facebook.
+	Status string `json:"status"`
+
+	// FacebookTokens is how many users have a Facebook token saved.
+	FacebookTokens int `json:"facebookTokens"`
+}
+
+// serveReady reports whether the service can handle requests. Without any
+// Facebook tokens it can still serve stored events, so that's reported as
+// degraded rather than failing the check.
+func (h *Handler) serveReady(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	tokens, err := h.service.FacebookTokenCount(ctx)
+	if err != nil {
+		log.FromContext(ctx).Error("readiness check failed", zap.Error(err))
+		writeErrorResp(w, r, errors.Response{
+			Error:  "database unavailable",
+			Status: http.StatusServiceUnavailable,
+		})
+		return
+	}
+
+	reply := ReadyReply{Status: "ok", FacebookTokens: tokens}
+	if tokens == 0 {
+		log.FromContext(ctx).Warn("no facebook tokens available")
+		reply.Status = "degraded"
+	}
+	writeJSON(w, r, http.StatusOK, reply)
+}
+
+// homeURL is where requests for / are redirected.
+const homeURL = "https://findrandomevents.com"
+
+// wantsJSON reports whether the client asked for a JSON response in its Accept
+// header.
+func wantsJSON(r *http.Request) bool {
+	for _, accept := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType := strings.TrimSpace(strings.Split(accept, ";")[0])
+		if mediaType == "application/json" {
+			return true
+		}
+	}
+	return false
+}
+
+// wantsIndent reports whether a response should be indented for reading.
+// Browsers get indented JSON so the API is easy to poke at, and everything
+// else gets compact JSON to save bandwidth. The compact=1 and pretty=1 query
+// parameters override this.
+func wantsIndent(r *http.Request) bool {
+	query := r.URL.Query()
+	if query.Get("compact") == "1" {
+		return false
+	}
+	if query.Get("pretty") == "1" {
+		return true
+	}
+	for _, accept := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType := strings.TrimSpace(strings.Split(accept, ";")[0])
+		if mediaType == "text/html" {
+			return true
+		}
+	}
+	return false
+}
+
 // ShiftPath splits off the first component of p, which will be cleaned of
 // relative components before processing. head will never contain a slash and
 // tail will always be a rooted path without trailing slash.
@@ -132,29 +265,34 @@ func handleJSON(w http.ResponseWriter, r *http.Request, f func(context.Context)
 			errResp.Error = fmt.Sprintf("%s: %s", errResp.Error, err.Error())
 		}
 
-		writeErrorResp(w, errResp)
+		writeErrorResp(w, r, errResp)
 		return
 	}
 
-	js, err := json.MarshalIndent(resp, "", "\t")
-	if err != nil {
-		logger.Error("write json failed", zap.Error(err))
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-
-	w.Header().Set("Content-Type", "application/json; charset=utf-8")
-	w.Write(js)
+	writeJSON(w, r, http.StatusOK, resp)
 }
 
-func writeErrorResp(w http.ResponseWriter, resp errors.Response) {
-	js, err := json.MarshalIndent(resp, "", "\t")
+// writeJSON writes resp with the given status, indented if the request
+// wantsIndent.
+func writeJSON(w http.ResponseWriter, r *http.Request, status int, resp interface{}) {
+	var js []byte
+	var err error
+	if wantsIndent(r) {
+		js, err = json.MarshalIndent(resp, "", "\t")
+	} else {
+		js, err = json.Marshal(resp)
+	}
 	if err != nil {
+		log.FromContext(r.Context()).Error("write json failed", zap.Error(err))
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json; charset=utf-8")
-	w.WriteHeader(resp.Status)
+	w.WriteHeader(status)
 	w.Write(js)
 }
+
+func writeErrorResp(w http.ResponseWriter, r *http.Request, resp errors.Response) {
+	writeJSON(w, r, resp.Status, resp)
+}
@@ -0,0 +1,67 @@
+package rest
+
+import (
+	"fmt"
+	"net/http"
+	"runtime/debug"
+
+	"go.uber.org/zap"
+
+	"github.com/findrandomevents/eventdb/errors"
+	"github.com/findrandomevents/eventdb/log"
+)
+
+// Recover turns panics in handlers into 500 responses, so one bad request
+// can't take down the server.
+type Recover struct{}
+
+// Wrap returns a handler that recovers from panics in h, logs them with their
+// stack trace, and responds with an internal server error. If h already
+// started its response, the connection is aborted instead.
+func (Recover) Wrap(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rw := &startedResponseWriter{ResponseWriter: w}
+
+		defer func() {
+			p := recover()
+			if p == nil {
+				return
+			}
+			// Handlers panic with this on purpose to abort the response
+			if p == http.ErrAbortHandler {
+				panic(p)
+			}
+
+			log.FromContext(r.Context()).Error("handler panicked",
+				zap.String("panic", fmt.Sprint(p)),
+				zap.String("stack", string(debug.Stack())))
+
+			if rw.started {
+				panic(http.ErrAbortHandler)
+			}
+			writeErrorResp(w, r, errors.Response{
+				Error:  http.StatusText(http.StatusInternalServerError),
+				Status: http.StatusInternalServerError,
+			})
+		}()
+
+		h.ServeHTTP(rw, r)
+	})
+}
+
+// startedResponseWriter records whether the response has been started, after
+// which the status can't be changed.
+type startedResponseWriter struct {
+	http.ResponseWriter
+	started bool
+}
+
+func (w *startedResponseWriter) WriteHeader(status int) {
+	w.started = true
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *startedResponseWriter) Write(p []byte) (int, error) {
+	w.started = true
+	return w.ResponseWriter.Write(p)
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"net/http"
+	"strconv"
 
 	"github.com/findrandomevents/eventdb"
 	"github.com/findrandomevents/eventdb/errors"
@@ -26,10 +27,26 @@ func newUsersHandler(service *service.Service) *UsersHandler {
 	}
 
 	m := mux.NewRouter()
+	m.Handle(
+		"/",
+		prom.InstrumentHandler("UserSearch", http.HandlerFunc(h.HandleSearch)),
+	).Methods("GET")
 	m.Handle(
 		"/{id}",
 		prom.InstrumentHandler("UserGet", http.HandlerFunc(h.HandleGet)),
 	).Methods("GET")
+	m.Handle(
+		"/{id}/locations",
+		prom.InstrumentHandler("UserLocationList", http.HandlerFunc(h.HandleLocationList)),
+	).Methods("GET")
+	m.Handle(
+		"/{id}/locations",
+		prom.InstrumentHandler("UserLocationSave", http.HandlerFunc(h.HandleLocationSave)),
+	).Methods("POST")
+	m.Handle(
+		"/{id}/locations/{label}",
+		prom.InstrumentHandler("UserLocationDelete", http.HandlerFunc(h.HandleLocationDelete)),
+	).Methods("DELETE")
 	m.Handle(
 		"/{id}",
 		prom.InstrumentHandler("UserUpdate", http.HandlerFunc(h.HandleUpdate)),
@@ -71,3 +88,57 @@ func (h *UsersHandler) HandleGet(w http.ResponseWriter, r *http.Request) {
 		return user, nil
 	})
 }
+
+// HandleSearch wraps Service.UserSearch in a REST interface
+func (h *UsersHandler) HandleSearch(w http.ResponseWriter, r *http.Request) {
+	handleJSON(w, r, func(ctx context.Context) (interface{}, error) {
+		req := eventdb.UserSearchRequest{
+			OrderBy: eventdb.UserOrder(r.FormValue("orderBy")),
+			Cursor:  r.FormValue("cursor"),
+		}
+		if limit := r.FormValue("limit"); limit != "" {
+			var err error
+			req.Limit, err = strconv.Atoi(limit)
+			if err != nil {
+				return nil, errors.E(errors.Invalid, errors.Errorf("bad limit: %v", err))
+			}
+		}
+
+		return h.service.UserSearch(ctx, req)
+	})
+}
+
+// HandleLocationList wraps Service.UserLocationList in a REST interface
+func (h *UsersHandler) HandleLocationList(w http.ResponseWriter, r *http.Request) {
+	userID, _ := mux.Vars(r)["id"]
+
+	handleJSON(w, r, func(ctx context.Context) (interface{}, error) {
+		return h.service.UserLocationList(ctx, eventdb.UserID(userID))
+	})
+}
+
+// HandleLocationSave wraps Service.UserLocationSave in a REST interface
+func (h *UsersHandler) HandleLocationSave(w http.ResponseWriter, r *http.Request) {
+	userID, _ := mux.Vars(r)["id"]
+
+	handleJSON(w, r, func(ctx context.Context) (interface{}, error) {
+		var loc eventdb.SavedLocation
+		if err := json.NewDecoder(r.Body).Decode(&loc); err != nil {
+			return nil, errors.E(errors.Invalid, err)
+		}
+
+		return h.service.UserLocationSave(ctx, eventdb.UserID(userID), loc)
+	})
+}
+
+// HandleLocationDelete wraps Service.UserLocationDelete in a REST interface
+func (h *UsersHandler) HandleLocationDelete(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	handleJSON(w, r, func(ctx context.Context) (interface{}, error) {
+		if err := h.service.UserLocationDelete(ctx, eventdb.UserID(vars["id"]), vars["label"]); err != nil {
+			return nil, err
+		}
+		return nil, nil
+	})
+}
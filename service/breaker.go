@@ -0,0 +1,46 @@
+package service
+
+import (
+	"sync"
+	"time"
+)
+
+// circuitBreaker stops calls to a failing dependency for a while, so retries
+// don't pile onto an outage. It opens after threshold failures in a row and
+// stays open for cooldown. The zero value is ready to use and starts closed.
+type circuitBreaker struct {
+	mu        sync.Mutex
+	failures  int
+	openUntil time.Time
+}
+
+// allow reports whether a call may be made at time now.
+func (b *circuitBreaker) allow(now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return !now.Before(b.openUntil)
+}
+
+// success records a call that worked, closing the breaker.
+func (b *circuitBreaker) success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures = 0
+}
+
+// failure records a failed call at time now. It reports whether the breaker
+// is now open.
+func (b *circuitBreaker) failure(now time.Time, threshold int, cooldown time.Duration) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures++
+	if b.failures < threshold {
+		return false
+	}
+	b.failures = 0
+	b.openUntil = now.Add(cooldown)
+	return true
+}
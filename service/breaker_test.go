@@ -0,0 +1,40 @@
+package service
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	var b circuitBreaker
+	now := time.Date(2017, 8, 17, 14, 0, 0, 0, time.UTC)
+
+	const threshold = 3
+	const cooldown = time.Minute
+
+	if !b.allow(now) {
+		t.Fatal("new breaker doesn't allow calls")
+	}
+
+	// A success resets the count
+	b.failure(now, threshold, cooldown)
+	b.failure(now, threshold, cooldown)
+	b.success()
+	if b.failure(now, threshold, cooldown) {
+		t.Fatal("breaker opened on the first failure after a success")
+	}
+
+	if b.failure(now, threshold, cooldown) {
+		t.Fatalf("breaker opened after %d failures, want %d", 2, threshold)
+	}
+	if !b.failure(now, threshold, cooldown) {
+		t.Fatalf("breaker didn't open after %d failures", threshold)
+	}
+
+	if b.allow(now.Add(cooldown - time.Second)) {
+		t.Fatal("open breaker allowed a call during the cooldown")
+	}
+	if !b.allow(now.Add(cooldown)) {
+		t.Fatal("breaker didn't allow calls after the cooldown")
+	}
+}
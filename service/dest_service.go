@@ -2,7 +2,8 @@ package service
 
 import (
 	"context"
-	"math/rand"
+	"fmt"
+	"math"
 	"time"
 
 	"github.com/findrandomevents/eventdb"
@@ -16,11 +17,205 @@ import (
 // DestGenerate finds a new random event near the user's location and returns
 // a DestGenerateReply that includes the new event and whether or not the search
 // was successful.
+//
+// Events are searched for in 90 minute windows, starting with the soonest, up
+// to 48 hours ahead. Normally a user must wait for their last event to start
+// before they can generate another, and get GenerateWait until then. Requests
+// can change the window with WindowMinutes, and skip the wait with
+// CooldownDisabled for back-to-back suggestions.
+//
+// Unless GenerateEnabled is set it only returns a notice that Facebook cut off
+// the Events API.
 func (s *Service) DestGenerate(ctx context.Context, opts eventdb.DestGenerateRequest) (eventdb.DestGenerateReply, error) {
+	const op errors.Op = "Service.DestGenerate"
+
+	if !s.GenerateEnabled {
+		return generateDisabledReply(opts), nil
+	}
+
+	ctx, cancel := withTimeout(ctx, s.GenerateTimeout, defaultGenerateTimeout)
+	defer cancel()
+
+	reply := eventdb.DestGenerateReply{
+		Result: eventdb.GenerateOK,
+		Dests:  []eventdb.Dest{},
+		Events: []eventdb.Event{},
+	}
+
+	userID := opts.UserID
+
+	currentUser := auth.User(ctx)
+	if currentUser.ID == "" {
+		return reply, errors.E(op, errors.Permission)
+	}
+	if userID == "me" || userID == "" {
+		userID = eventdb.UserID(currentUser.ID)
+	}
+	if userID != eventdb.UserID(currentUser.ID) && !currentUser.IsAdmin { // Only admins can look up other users
+		return reply, errors.E(op, errors.Permission)
+	}
+
+	if opts.Location != "" {
+		loc, err := s.UserStore.GetLocation(ctx, userID, opts.Location)
+		if errors.Is(errors.NotExist, err) {
+			return reply, errors.E(op, userID, errors.Invalid, fmt.Sprintf("no saved location %q", opts.Location))
+		}
+		if err != nil {
+			return reply, errors.E(op, userID, errors.KindOf(err), errors.Errorf("get saved location: %v", err))
+		}
+		opts.Lat, opts.Lng = loc.Lat, loc.Lng
+	}
+
+	if math.IsNaN(opts.Lat) || math.IsNaN(opts.Lng) ||
+		opts.Lat < -90 || opts.Lat > 90 || opts.Lng < -180 || opts.Lng > 180 {
+		return reply, errors.E(op, userID, errors.Invalid, "lat or lng out of range")
+	}
+
+	if window := time.Duration(opts.WindowMinutes) * time.Minute; opts.WindowMinutes != 0 &&
+		(window < minGenerateWindow || window > generateHorizon) {
+		return reply, errors.E(op, userID, errors.Invalid,
+			fmt.Sprintf("window must be between %.0f and %.0f minutes", minGenerateWindow.Minutes(), generateHorizon.Minutes()))
+	}
+	if opts.RadiusM != 0 && !(opts.RadiusM >= minGenerateRadiusM && opts.RadiusM <= maxGenerateRadiusM) {
+		return reply, errors.E(op, userID, errors.Invalid,
+			fmt.Sprintf("radius must be between %.0fm and %.0fm", minGenerateRadiusM, maxGenerateRadiusM))
+	}
+
+	if opts.Lat == 0 && opts.Lng == 0 {
+		// No location given, so search where the user last generated
+		user, err := s.UserStore.GetByID(ctx, userID)
+		if err != nil && !errors.Is(errors.NotExist, err) {
+			return reply, errors.E(op, userID, errors.KindOf(err), errors.Errorf("get user: %v", err))
+		}
+		if user.LastLat == 0 && user.LastLng == 0 {
+			return reply, errors.E(op, userID, errors.Invalid, "missing lat and lng")
+		}
+		opts.Lat, opts.Lng = user.LastLat, user.LastLng
+	} else if err := s.UserStore.SetLastLocation(ctx, userID, opts.Lat, opts.Lng); err != nil {
+		// It's only a default for later generates, so don't fail this one
+		log.FromContext(ctx).Warn("save last location failed",
+			zap.Error(err),
+			zap.String("userID", string(userID)))
+	}
+
+	// Hold a per-user lock while choosing so that simultaneous requests from
+	// the same user (on different devices) can't both pick the same event.
+	unlock, err := s.DestStore.LockUser(ctx, userID)
+	if err != nil {
+		return reply, errors.E(op, userID, errors.KindOf(err), errors.Errorf("lock user: %v", err))
+	}
+
+	retryAfter, err := s.dailyLimit(ctx, userID)
+	if err != nil {
+		unlock()
+		return reply, errors.E(op, userID, errors.KindOf(err), errors.Errorf("check daily limit: %v", err))
+	}
+
+	var chosenID eventdb.EventID
+	var result eventdb.DestGenerateResult
+	if retryAfter.IsZero() {
+		chosenID, result, err = s.nextEvent(ctx, userID, opts)
+		if err != nil {
+			unlock()
+			return reply, errors.E(op, errors.KindOf(err), errors.Errorf("nextEvent failed: %v", err))
+		}
+	} else {
+		result = eventdb.GenerateLimit
+		reply.RetryAfter = &retryAfter
+	}
+	reply.Result = result
+
+	var created eventdb.Dest
+	if result == eventdb.GenerateOK {
+		created, err = s.DestStore.Create(ctx, eventdb.Dest{
+			UserID:  userID,
+			EventID: chosenID,
+		})
+		if err != nil {
+			unlock()
+			return reply, errors.E(op, userID, errors.KindOf(err), errors.Errorf("create dest: %v", err))
+		}
+	}
+	unlock()
+
+	if result == eventdb.GenerateOK {
+		// The count is only used to see which events get picked, so it's
+		// not worth failing the generate over.
+		if err := s.EventStore.IncrementChosen(ctx, chosenID); err != nil {
+			log.FromContext(ctx).Warn("increment times chosen failed",
+				zap.Error(err),
+				zap.String("eventID", string(chosenID)))
+		}
+
+		// A failed notification shouldn't fail the generate. The dest is
+		// already saved and the user will see it in the app.
+		if err := s.notifier().NotifyDest(ctx, created); err != nil {
+			log.FromContext(ctx).Warn("notify dest failed",
+				zap.Error(err),
+				zap.String("destID", string(created.ID)))
+		}
+	}
+
+	dests, err := s.DestList(ctx, eventdb.DestListRequest{})
+	if err != nil {
+		return reply, errors.E(op, userID, errors.KindOf(err), errors.Errorf("list dests: %v", err))
+	}
+	reply.Dests = dests
+
+	destEvents := []eventdb.Event{}
+	for i := range dests {
+		dest := &dests[i]
+
+		// The event may have been deleted since the dest was created
+		if dest.Event != nil {
+			destEvents = append(destEvents, *dest.Event)
+		}
+		dest.Event = nil
+	}
+	reply.Events = destEvents
+
+	return reply, nil
+}
+
+// dailyLimit checks whether the user has hit MaxDestsPerDay. If they have it
+// returns the time the limit resets, the next midnight in their time zone.
+// Otherwise it returns the zero time.
+func (s *Service) dailyLimit(ctx context.Context, userID eventdb.UserID) (time.Time, error) {
+	if s.MaxDestsPerDay <= 0 {
+		return time.Time{}, nil
+	}
+
+	loc := time.UTC
+	user, err := s.UserStore.GetByID(ctx, userID)
+	if err != nil && !errors.Is(errors.NotExist, err) {
+		return time.Time{}, err
+	}
+	if user.TimeZone != "" {
+		if l, err := time.LoadLocation(user.TimeZone); err == nil {
+			loc = l
+		}
+	}
+
+	now := s.now(ctx).In(loc)
+	midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, loc)
+
+	count, err := s.DestStore.CountSince(ctx, userID, midnight)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if count < s.MaxDestsPerDay {
+		return time.Time{}, nil
+	}
+
+	return midnight.AddDate(0, 0, 1), nil
+}
+
+// generateDisabledReply is what DestGenerate returns while it's turned off.
+func generateDisabledReply(opts eventdb.DestGenerateRequest) eventdb.DestGenerateReply {
 	return eventdb.DestGenerateReply{
 		Result: eventdb.GenerateWait,
 		Dests: []eventdb.Dest{{
-			ID:      eventdb.DestID(0),
+			ID:      eventdb.DestID("0"),
 			UserID:  opts.UserID,
 			EventID: eventdb.EventID("findrandomevents"),
 		}},
@@ -42,61 +237,7 @@ I am applying to have the API re-activated and the app restored. If/when this ha
 			Place:     "Cape Disappointment",
 			Address:   "☹️",
 		}},
-	}, nil
-
-	// const op errors.Op = "Service.DestGenerate"
-	//
-	// reply := eventdb.DestGenerateReply{
-	// 	Result: eventdb.GenerateOK,
-	// 	Dests:  []eventdb.Dest{},
-	// 	Events: []eventdb.Event{},
-	// }
-	//
-	// userID := opts.UserID
-	//
-	// currentUser := auth.User(ctx)
-	// if currentUser.ID == "" {
-	// 	return reply, errors.E(op, errors.Permission)
-	// }
-	// if userID == "me" || userID == "" {
-	// 	userID = eventdb.UserID(currentUser.ID)
-	// }
-	// if userID != eventdb.UserID(currentUser.ID) && !currentUser.IsAdmin { // Only admins can look up other users
-	// 	return reply, errors.E(op, errors.Permission)
-	// }
-	//
-	// chosenID, result, err := s.nextEvent(ctx, userID, opts)
-	// if err != nil {
-	// 	return reply, errors.E(op, errors.Internal, "nextEvent failed", err)
-	// }
-	// reply.Result = result
-	//
-	// if result == eventdb.GenerateOK {
-	// 	_, err = s.DestStore.Create(ctx, eventdb.Dest{
-	// 		UserID:  userID,
-	// 		EventID: chosenID,
-	// 	})
-	// 	if err != nil {
-	// 		return reply, errors.E(op, userID, errors.Internal, "create dest", err)
-	// 	}
-	// }
-	//
-	// dests, err := s.DestList(ctx, eventdb.DestListRequest{})
-	// if err != nil {
-	// 	return reply, errors.E(op, userID, errors.Internal, "list dests", err)
-	// }
-	// reply.Dests = dests
-	//
-	// destEvents := []eventdb.Event{}
-	// for i := range dests {
-	// 	dest := &dests[i]
-	//
-	// 	destEvents = append(destEvents, *dest.Event)
-	// 	dest.Event = nil
-	// }
-	// reply.Events = destEvents
-	//
-	// return reply, nil
+	}
 }
 
 // TODO(maxhawkins): clean this up
@@ -106,74 +247,151 @@ func (s *Service) nextEvent(ctx context.Context, userID eventdb.UserID, opts eve
 
 	var chosenID eventdb.EventID
 
-	now := time.Now()
-	if s.Time != nil {
-		now = s.Time.Now()
-	}
-
-	// We batch in 90 minute chunks. If the event isn't within 90m
-	// we look within 180m and so on
-	const timeWindow = 90 * time.Minute
-
-	userLat, userLng := opts.Lat, opts.Lng
+	now := s.now(ctx)
+
+	// Wait for the last event to start, unless the request wants
+	// back-to-back suggestions
+	if !opts.CooldownDisabled {
+		lastDest, err := s.DestStore.LatestForUser(ctx, userID)
+		switch {
+		case err == nil:
+			// If the last event was deleted there's nothing to wait for
+			lastEvent, err := s.EventStore.GetByID(ctx, lastDest.EventID)
+			if err != nil && !errors.Is(errors.NotExist, err) {
+				return chosenID, eventdb.GenerateError, errors.E(op, userID, errors.KindOf(err), errors.Errorf("get last event: %v", err))
+			}
 
-	// ~5mi radius
-	const radiusM = 8000.0
-	bounds := geojson.CircleGeom(userLat, userLng, radiusM)
+			if err == nil && lastEvent.StartTime.After(now) {
+				return chosenID, eventdb.GenerateWait, nil
+			}
+		case !errors.Is(errors.NotExist, err):
+			return chosenID, eventdb.GenerateError, errors.E(op, userID, errors.KindOf(err), errors.Errorf("get last dest: %v", err))
+		}
+	}
 
-	// Get a list of existing dests so we don't repeat
-	alreadyChosen, err := s.DestStore.ListForUser(ctx, userID, eventdb.DestListRequest{})
-	if err != nil {
-		return chosenID, eventdb.GenerateError, errors.E(op, userID, err, "list dests")
+	radius := opts.RadiusM
+	if radius == 0 {
+		radius = generateRadiusM
 	}
 
-	if len(alreadyChosen) > 0 {
-		lastDest := alreadyChosen[0]
-		lastEvent, err := s.EventStore.GetByID(ctx, lastDest.EventID)
+	if s.MinAreaEvents > 0 {
+		n, err := s.EventStore.SearchCount(ctx, eventdb.EventSearchRequest{
+			Bounds: geojson.CircleGeom(opts.Lat, opts.Lng, radius),
+			Start:  now,
+			End:    now.Add(generateHorizon),
+		})
 		if err != nil {
-			return chosenID, eventdb.GenerateError, errors.E(op, userID, err, "get last event")
+			return chosenID, eventdb.GenerateError, errors.E(op, userID, errors.KindOf(err), errors.Errorf("count area events: %v", err))
 		}
+		if n < s.MinAreaEvents {
+			return chosenID, eventdb.GenerateUnderserved, nil
+		}
+	}
 
-		if lastEvent.StartTime.After(now) {
-			return chosenID, eventdb.GenerateWait, nil
+	// Events the user attended or was already sent to are excluded by the
+	// search. If there's nothing nearby, look a little farther out.
+	opts.UserID = userID
+	var candidates []eventdb.Event
+	for _, radius := range s.generateRadii(radius) {
+		var err error
+		candidates, err = s.candidateEvents(ctx, opts, radius, nil)
+		if err != nil {
+			return chosenID, eventdb.GenerateError, errors.E(op, userID, err)
+		}
+		if len(candidates) > 0 {
+			break
 		}
 	}
+	if len(candidates) == 0 {
+		return chosenID, eventdb.GenerateNoResults, nil
+	}
+
+	// Now find a random event, favoring the best scoring ones
+	chosen, ok := pickScored(s.scorer(), opts, candidates, s.float64())
+	if !ok {
+		return chosenID, eventdb.GenerateNoResults, nil
+	}
+	return chosen.ID, eventdb.GenerateOK, nil
+}
+
+const (
+	// generateRadiusM is how far from the user DestGenerate looks for events
+	// by default, about 5mi. Requests can ask for a radius between
+	// minGenerateRadiusM and maxGenerateRadiusM instead.
+	generateRadiusM    = 8000.0
+	minGenerateRadiusM = 100.0
+	maxGenerateRadiusM = 50000.0
+	// generateHorizon is how far ahead DestGenerate looks for events.
+	generateHorizon = 48 * time.Hour
+	// minGenerateWindow is the smallest window a request can ask for. Smaller
+	// ones would take hundreds of searches to cover the horizon.
+	minGenerateWindow = 15 * time.Minute
+)
+
+// candidateEvents returns the events within radius meters of opts.Lat,
+// opts.Lng that the user could get to in time, starting with the soonest
+// window that has any. Events opts.UserID has attended or was suggested
+// within DestRepeatAfter are left out, as are any keep returns false for if
+// it's non-nil. It returns no events if there's nothing eligible in the next
+// two days.
+func (s *Service) candidateEvents(ctx context.Context, opts eventdb.DestGenerateRequest, radius float64, keep func(eventdb.Event) bool) ([]eventdb.Event, error) {
+	const op errors.Op = "Service.candidateEvents"
+
+	now := s.now(ctx)
+
+	// We batch in 90 minute chunks by default. If the event isn't within 90m
+	// we look within 180m and so on
+	timeWindow := 90 * time.Minute
+	if opts.WindowMinutes > 0 {
+		timeWindow = time.Duration(opts.WindowMinutes) * time.Minute
+	}
+
+	bounds := geojson.CircleGeom(opts.Lat, opts.Lng, radius)
+
+	// Suggestions older than DestRepeatAfter don't count against an event
+	var chosenSince time.Time
+	if s.DestRepeatAfter > 0 {
+		chosenSince = now.Add(-s.DestRepeatAfter)
+	}
 
-	// Start searching 10m out (allow for travel time)
-	searchTime := now.Add(10 * time.Minute)
+	// Start searching 10m out by default (allow for travel time)
+	notice := 10 * time.Minute
+	if opts.MinNoticeMinutes > 0 {
+		notice = time.Duration(opts.MinNoticeMinutes) * time.Minute
+	}
+	searchTime := now.Add(notice)
+	earliestStart := searchTime
 
 	// TODO(maxhawkins): if it's your first event or you haven't been to one in a while,
 	// favor events that are really close by. It's easier to get going.
 
 	for {
 		// If there's nothing in the next two days we don't have anything in the db
-		if searchTime.Sub(now) > 48*time.Hour {
-			return chosenID, eventdb.GenerateNoResults, nil
+		if searchTime.Sub(now) > generateHorizon {
+			return nil, nil
 		}
 
 		events, err := s.EventStore.Search(ctx, eventdb.EventSearchRequest{
 			Bounds: bounds,
 			Start:  searchTime,
 			End:    searchTime.Add(timeWindow),
+
+			UserID:           opts.UserID,
+			AttendedStatuses: s.AttendedStatuses,
+
+			ExcludeChosen: opts.UserID != "",
+			ChosenSince:   chosenSince,
 		})
 		if errors.Is(errors.NotExist, err) {
-			return chosenID, eventdb.GenerateNoResults, nil
+			return nil, nil
 		}
 		if err != nil {
-			return chosenID, eventdb.GenerateError, errors.E(op, userID, "search failed", err)
+			return nil, errors.E(op, errors.KindOf(err), errors.Errorf("search failed: %v", err))
 		}
 
 		var goodEvents []eventdb.Event
 		for _, event := range events {
-			var badEvent bool
-
-			// Filter out things we've already suggested
-			for _, chosen := range alreadyChosen {
-				if chosen.EventID == event.ID {
-					badEvent = true
-					break
-				}
-			}
+			badEvent := keep != nil && !keep(event)
 
 			// TODO(maxhawkins): if it's far away, make this longer
 			// As a rule of thumb, if it takes longer to get there than you'll
@@ -185,6 +403,11 @@ func (s *Service) nextEvent(ctx context.Context, userID eventdb.UserID, opts eve
 				badEvent = true
 			}
 
+			// Filter out things that start before we could get there
+			if !opts.AllowStarted && event.StartTime.Before(earliestStart) {
+				badEvent = true
+			}
+
 			// The good ones get added to the list
 			if !badEvent {
 				goodEvents = append(goodEvents, event)
@@ -197,24 +420,76 @@ func (s *Service) nextEvent(ctx context.Context, userID eventdb.UserID, opts eve
 			continue
 		}
 
-		// Now find a random event
-		n := rand.Intn(len(goodEvents))
-		return goodEvents[n].ID, eventdb.GenerateOK, nil
+		return goodEvents, nil
+	}
+}
+
+// maxDestCreateMulti is the most dests DestCreateMulti will create at once.
+const maxDestCreateMulti = 100
+
+// DestCreateMulti creates a dest for userID for each of eventIDs, in order.
+// It's only available to admins.
+func (s *Service) DestCreateMulti(ctx context.Context, userID eventdb.UserID, eventIDs []eventdb.EventID) ([]eventdb.Dest, error) {
+	const op errors.Op = "Service.DestCreateMulti"
+
+	currentUser := auth.User(ctx)
+	if !currentUser.IsAdmin {
+		return nil, errors.E(op, errors.Permission, currentUser.ID)
+	}
+
+	if userID == "" {
+		return nil, errors.E(op, errors.Invalid, "missing user id")
+	}
+	if len(eventIDs) == 0 {
+		return nil, errors.E(op, errors.Invalid, "no event ids")
+	}
+	if len(eventIDs) > maxDestCreateMulti {
+		err := fmt.Errorf("event list length (%d) > max (%d)", len(eventIDs), maxDestCreateMulti)
+		return nil, errors.E(op, errors.Invalid, err)
+	}
+
+	// Make sure all the events exist
+	events, err := s.EventStore.GetMulti(ctx, eventIDs)
+	if err != nil {
+		return nil, errors.E(op, errors.Internal, err)
+	}
+	found := make(map[eventdb.EventID]bool)
+	for _, event := range events {
+		found[event.ID] = true
+	}
+	for _, id := range eventIDs {
+		if !found[id] {
+			return nil, errors.E(op, errors.Invalid, fmt.Sprintf("event %q not found", id))
+		}
+	}
+
+	dests, err := s.DestStore.CreateMulti(ctx, userID, eventIDs)
+	if err != nil {
+		return nil, errors.E(op, userID, errors.Internal, err)
 	}
+
+	return dests, nil
 }
 
 // DestUpdate updates a Dest with the user's feedback
+//
+// Like DestGet, non-admins get errors.NotExist for other users' dests.
 func (s *Service) DestUpdate(ctx context.Context, id eventdb.DestID, update eventdb.DestUpdate) (eventdb.Dest, error) {
 	const op errors.Op = "Service.DestUpdate"
 
+	if err := checkMask(update.Mask, "feedback", "status"); err != nil {
+		return eventdb.Dest{}, errors.E(op, err)
+	}
+
+	currentUser := auth.User(ctx)
+
 	dest, err := s.DestStore.Get(ctx, id)
 	if err != nil {
-		return dest, err
+		return eventdb.Dest{}, errors.E(op, currentUser.ID, err)
 	}
 
-	currentUser := auth.User(ctx)
 	if !currentUser.IsAdmin && currentUser.ID != string(dest.UserID) {
-		return dest, errors.E(op, errors.Permission, currentUser.ID)
+		return eventdb.Dest{}, errors.E(op, errors.NotExist, currentUser.ID)
 	}
 
 	dest, err = s.DestStore.Update(ctx, id, update)
@@ -226,35 +501,35 @@ func (s *Service) DestUpdate(ctx context.Context, id eventdb.DestID, update even
 }
 
 // DestGet retrieves a Dest from the database.
+//
+// Non-admins get errors.NotExist for other users' dests, the same as for
+// dests that don't exist, so they can't probe which dest IDs are in use.
 func (s *Service) DestGet(ctx context.Context, id eventdb.DestID) (eventdb.Dest, error) {
 	const op errors.Op = "Service.DestGet"
 
-	logger := log.FromContext(ctx)
-
 	currentUser := auth.User(ctx)
 
-	dest, err := s.DestStore.Get(ctx, id)
+	dest, err := s.DestStore.GetWithEvent(ctx, id)
 	if err != nil {
-		return dest, errors.E(op, currentUser.ID, err)
+		return eventdb.Dest{}, errors.E(op, currentUser.ID, err)
 	}
 
 	if !currentUser.IsAdmin && currentUser.ID != string(dest.UserID) {
-		return dest, errors.E(op, errors.Permission, currentUser.ID)
+		return eventdb.Dest{}, errors.E(op, errors.NotExist, currentUser.ID)
 	}
 
-	event, err := s.EventStore.GetByID(ctx, dest.EventID)
-	if err == nil {
-		dest.Event = &event
+	if event := dest.Event; event != nil {
+		event.Status = event.StatusAt(s.now(ctx))
+		dest.EventUnavailable = event.IsBad || event.IsDeleted
 	} else {
-		logger.Error("failed to get event",
-			zap.Error(err),
-			zap.String("eventID", string(dest.EventID)))
+		dest.EventUnavailable = true
 	}
 
 	return dest, nil
 }
 
-// DestList lists a user's Dests by creation date.
+// DestList lists a user's Dests by creation date, with their events and the
+// events' current status.
 func (s *Service) DestList(ctx context.Context, opts eventdb.DestListRequest) ([]eventdb.Dest, error) {
 	const op errors.Op = "Service.DestList"
 
@@ -262,31 +537,23 @@ func (s *Service) DestList(ctx context.Context, opts eventdb.DestListRequest) ([
 	if userID == "" {
 		return nil, errors.E(op, errors.NotLoggedIn)
 	}
-
-	dests, err := s.DestStore.ListForUser(ctx, eventdb.UserID(userID), opts)
-	if err != nil {
-		return nil, errors.E(op, userID, err)
+	if opts.Page < 0 || opts.PageSize < 0 {
+		return nil, errors.E(op, errors.Invalid, "page and pageSize must not be negative")
 	}
 
-	// Side-load the events
-	var eventIDs []eventdb.EventID
-	for _, dest := range dests {
-		eventIDs = append(eventIDs, dest.EventID)
-	}
-	events, err := s.EventStore.GetMulti(ctx, eventIDs)
+	now := s.now(ctx)
+
+	dests, err := s.DestStore.ListForUserWithEvents(ctx, eventdb.UserID(userID), opts, now)
 	if err != nil {
 		return nil, errors.E(op, userID, err)
 	}
 
-	// TODO(maxhawkins): optimize with a join
-	for i := range dests {
-		dest := &dests[i]
-
-		for _, event := range events {
-			if dest.EventID == event.ID {
-				dest.Event = &event
-				break
-			}
+	for i, dest := range dests {
+		if event := dest.Event; event != nil {
+			event.Status = event.StatusAt(now)
+			dests[i].EventUnavailable = event.IsBad || event.IsDeleted
+		} else {
+			dests[i].EventUnavailable = true
 		}
 	}
 
@@ -6,12 +6,18 @@ import (
 	"fmt"
 	"math"
 	"math/rand"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/findrandomevents/eventdb"
 	"github.com/findrandomevents/eventdb/auth"
 	"github.com/findrandomevents/eventdb/errors"
 	"github.com/findrandomevents/eventdb/facebook"
+	"github.com/findrandomevents/eventdb/geojson"
+	"github.com/findrandomevents/eventdb/log"
+	"github.com/findrandomevents/eventdb/prom"
+	"go.uber.org/zap"
 )
 
 // EventSearch queries the database for events matching the EventSearchRequest
@@ -22,13 +28,21 @@ func (s *Service) EventSearch(ctx context.Context, req eventdb.EventSearchReques
 	if !auth.User(ctx).IsAdmin {
 		return nil, errors.E(op, errors.Permission)
 	}
+	if err := checkAdminSearch(&req); err != nil {
+		return nil, errors.E(op, err)
+	}
+
+	// Events that already started aren't starting soon
+	if now := s.now(ctx); req.OrderBy == eventdb.OrderByStartingSoon && req.Start.Before(now) {
+		req.Start = now
+	}
 
-	ctx, cancel := context.WithTimeout(ctx, 60*time.Second)
+	ctx, cancel := withTimeout(ctx, s.SearchTimeout, defaultSearchTimeout)
 	defer cancel()
 
 	events, err := s.EventStore.Search(ctx, req)
 	if err != nil {
-		err = errors.E(op, errors.Internal, "event search", err)
+		err = errors.E(op, errors.KindOf(err), errors.Errorf("event search: %v", err))
 		return nil, err
 	}
 
@@ -42,6 +56,338 @@ func (s *Service) EventSearch(ctx context.Context, req eventdb.EventSearchReques
 	return events, nil
 }
 
+// EventSearchByDay is like EventSearch, but groups the results by the day
+// they start on in the time zone tz, an IANA name like "Europe/Ljubljana".
+// UTC is used if tz is empty. Days are in order and only days with events are
+// included. Within a day events are in the search's order.
+func (s *Service) EventSearchByDay(ctx context.Context, req eventdb.EventSearchRequest, tz string) ([]eventdb.EventDay, error) {
+	const op errors.Op = "Service.EventSearchByDay"
+
+	location, err := time.LoadLocation(tz)
+	if err != nil {
+		return nil, errors.E(op, errors.Invalid, fmt.Sprintf("unknown time zone %q", tz))
+	}
+
+	events, err := s.EventSearch(ctx, req)
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+
+	return groupByDay(events, location), nil
+}
+
+// groupByDay puts events into buckets by the day they start in location.
+func groupByDay(events []eventdb.Event, location *time.Location) []eventdb.EventDay {
+	days := []eventdb.EventDay{}
+	index := make(map[string]int)
+	for _, event := range events {
+		date := event.StartTime.In(location).Format("2006-01-02")
+		i, ok := index[date]
+		if !ok {
+			i = len(days)
+			index[date] = i
+			days = append(days, eventdb.EventDay{Date: date})
+		}
+		days[i].Events = append(days[i].Events, event)
+	}
+
+	// The dates sort as strings
+	sort.SliceStable(days, func(i, j int) bool {
+		return days[i].Date < days[j].Date
+	})
+	return days
+}
+
+// EventSearchExplain returns the Postgres query plan for an EventSearch, as
+// JSON, instead of its results. The search is run to time it. It's only
+// available to admins.
+func (s *Service) EventSearchExplain(ctx context.Context, req eventdb.EventSearchRequest) (json.RawMessage, error) {
+	const op errors.Op = "Service.EventSearchExplain"
+
+	if !auth.User(ctx).IsAdmin {
+		return nil, errors.E(op, errors.Permission)
+	}
+	if err := checkAdminSearch(&req); err != nil {
+		return nil, errors.E(op, err)
+	}
+
+	ctx, cancel := withTimeout(ctx, s.SearchTimeout, defaultSearchTimeout)
+	defer cancel()
+
+	plan, err := s.EventStore.ExplainSearch(ctx, req)
+	if err != nil {
+		return nil, errors.E(op, errors.Internal, err)
+	}
+	return plan, nil
+}
+
+// EventExtent returns the bounding box of the events matching the
+// EventSearchRequest, so a map can start out showing all of them. Limit and
+// Offset are ignored. It returns errors.NotExist if no events match. It's
+// only available to admins.
+func (s *Service) EventExtent(ctx context.Context, req eventdb.EventSearchRequest) (eventdb.EventExtent, error) {
+	const op errors.Op = "Service.EventExtent"
+
+	var extent eventdb.EventExtent
+
+	if !auth.User(ctx).IsAdmin {
+		return extent, errors.E(op, errors.Permission)
+	}
+	if err := checkAdminSearch(&req); err != nil {
+		return extent, errors.E(op, err)
+	}
+
+	ctx, cancel := withTimeout(ctx, s.SearchTimeout, defaultSearchTimeout)
+	defer cancel()
+
+	var err error
+	extent.MinLat, extent.MinLng, extent.MaxLat, extent.MaxLng, err = s.EventStore.Extent(ctx, req)
+	if errors.Is(errors.NotExist, err) {
+		return extent, errors.E(op, errors.NotExist, "no matching events")
+	}
+	if err != nil {
+		return extent, errors.E(op, errors.Internal, err)
+	}
+	return extent, nil
+}
+
+// maxTimeOnlySearchResults is the page size limit for admin searches without
+// bounds, which would otherwise return every event in the time window.
+const maxTimeOnlySearchResults = 500
+
+// checkAdminSearch validates the options on an admin search request.
+// Searches without Bounds must be paged.
+func checkAdminSearch(req *eventdb.EventSearchRequest) error {
+	if req.Limit < 0 || req.Offset < 0 {
+		return errors.E(errors.Invalid, "limit and offset must not be negative")
+	}
+	if req.MaxElapsedMinutes < 0 {
+		return errors.E(errors.Invalid, "max elapsed minutes must not be negative")
+	}
+	if req.FreshnessWindow < 0 {
+		return errors.E(errors.Invalid, "freshness window must not be negative")
+	}
+	if req.MaxPrice < 0 {
+		return errors.E(errors.Invalid, "max price must not be negative")
+	}
+	if req.MaxPrice > 0 && req.Currency == "" {
+		return errors.E(errors.Invalid, "currency is required with max price")
+	}
+	if req.MaxPriceCents < 0 {
+		return errors.E(errors.Invalid, "max price cents must not be negative")
+	}
+	if req.MaxPriceCents > 0 && req.Currency == "" {
+		return errors.E(errors.Invalid, "currency is required with max price cents")
+	}
+	if !(req.CenterLat >= -90 && req.CenterLat <= 90) || !(req.CenterLng >= -180 && req.CenterLng <= 180) {
+		return errors.E(errors.Invalid, "center out of range")
+	}
+	if req.RadiusM < 0 {
+		return errors.E(errors.Invalid, "radius must not be negative")
+	}
+	if req.RadiusM > 0 && !req.HasCenter() {
+		return errors.E(errors.Invalid, "radius needs a center")
+	}
+	switch req.OrderBy {
+	case eventdb.OrderByStartTime, eventdb.OrderByTimesChosen, eventdb.OrderByStartingSoon:
+	case eventdb.OrderByDistance:
+		if !req.HasCenter() {
+			return errors.E(errors.Invalid, "ordering by distance needs a center")
+		}
+	default:
+		return errors.E(errors.Invalid, fmt.Sprintf("unknown order %q", req.OrderBy))
+	}
+	if req.Bounds == "" && (req.Limit == 0 || req.Limit > maxTimeOnlySearchResults) {
+		req.Limit = maxTimeOnlySearchResults
+	}
+	return nil
+}
+
+const (
+	// Anonymous searches are limited to small areas and time windows so the
+	// public search can't be used to scrape the database.
+	publicSearchMaxAreaM2   = 1000 * 1000 * 1000 // 1000 km²
+	publicSearchMaxDuration = 48 * time.Hour
+	publicSearchMaxResults  = 20
+
+	defaultPublicSearchesPerMinute = 30
+)
+
+// maxBoundsPoints limits the size of the polygons ValidateBounds checks.
+// Checking for self-intersections takes time quadratic in the number of points.
+const maxBoundsPoints = 5000
+
+// ValidateBounds checks whether a GeoJSON geometry can be used as the Bounds
+// of a search, so map UIs can warn about a bad shape before searching. It
+// returns the area of the bounds in square meters and, if they aren't valid,
+// a reason that can be shown to the user.
+//
+// Logged-out users are held to the area limit of EventSearchPublic.
+func (s *Service) ValidateBounds(ctx context.Context, bounds string) (area float64, valid bool, reason string) {
+	if bounds == "" {
+		return 0, false, "bounds are required"
+	}
+	polygons, err := geojson.ParsePolygons(bounds)
+	if err != nil {
+		return 0, false, fmt.Sprintf("bounds must be a GeoJSON Polygon or MultiPolygon: %v", err)
+	}
+	if n := polygons.NumPoints(); n > maxBoundsPoints {
+		return 0, false, fmt.Sprintf("bounds have %d points, the limit is %d. Try drawing a simpler shape", n, maxBoundsPoints)
+	}
+	if err := polygons.Validate(); err != nil {
+		return 0, false, fmt.Sprintf("bounds are not a valid shape: %v", err)
+	}
+
+	area = polygons.Area()
+	if auth.User(ctx).ID == "" && area > publicSearchMaxAreaM2 {
+		return area, false, fmt.Sprintf("area is too large: %.0f km², the limit is %.0f km²", area/1e6, publicSearchMaxAreaM2/1e6)
+	}
+
+	return area, true, ""
+}
+
+// EventSearchPublic is a limited version of EventSearch that doesn't require
+// the user to be logged in. It's used by public "what's nearby" widgets.
+//
+// Searches are rate limited for each client IP, must include bounds of
+// a limited size, and only return a few upcoming events that haven't been
+// marked bad or canceled.
+func (s *Service) EventSearchPublic(ctx context.Context, req eventdb.EventSearchRequest) ([]eventdb.Event, error) {
+	const op errors.Op = "Service.EventSearchPublic"
+
+	if !s.allowPublicSearch(ctx) {
+		return nil, errors.E(op, errors.RateLimited, "rate limit exceeded, try again later")
+	}
+
+	if req.Bounds == "" {
+		return nil, errors.E(op, errors.Invalid, "bounds are required")
+	}
+	area, err := geojson.Area(req.Bounds)
+	if err != nil {
+		return nil, errors.E(op, errors.Invalid, errors.Errorf("bad bounds: %v", err))
+	}
+	if area > publicSearchMaxAreaM2 {
+		return nil, errors.E(op, errors.Invalid, "bounds are too large")
+	}
+
+	now := s.now(ctx)
+	if req.Start.Before(now) {
+		req.Start = now
+	}
+	if maxEnd := req.Start.Add(publicSearchMaxDuration); req.End.IsZero() || req.End.After(maxEnd) {
+		req.End = maxEnd
+	}
+
+	ctx, cancel := withTimeout(ctx, s.SearchTimeout, defaultSearchTimeout)
+	defer cancel()
+
+	events, err := s.EventStore.Search(ctx, eventdb.EventSearchRequest{
+		Bounds:     req.Bounds,
+		Start:      req.Start,
+		End:        req.End,
+		TimesInUTC: req.TimesInUTC,
+	})
+	if err != nil {
+		return nil, errors.E(op, errors.KindOf(err), errors.Errorf("event search: %v", err))
+	}
+
+	curated := []eventdb.Event{}
+	for _, event := range events {
+		if event.IsBad || event.IsCanceled {
+			continue
+		}
+		if desc := []rune(event.Description); len(desc) > 100 {
+			event.Description = string(desc[:97]) + "…"
+		}
+		curated = append(curated, event)
+
+		if len(curated) == publicSearchMaxResults {
+			break
+		}
+	}
+
+	return curated, nil
+}
+
+// NextEvent returns the soonest upcoming event near lat, lng that there's
+// still time to get to. Unlike DestGenerate it's deterministic and doesn't
+// create a Dest, so it doesn't require a login. It returns errors.NotExist if
+// there are no eligible events in the next two days.
+//
+// Anonymous requests share the EventSearchPublic rate limit.
+func (s *Service) NextEvent(ctx context.Context, lat, lng float64) (eventdb.Event, error) {
+	const op errors.Op = "Service.NextEvent"
+
+	if auth.User(ctx).ID == "" {
+		if !s.allowPublicSearch(ctx) {
+			return eventdb.Event{}, errors.E(op, errors.RateLimited, "rate limit exceeded, try again later")
+		}
+	}
+
+	if math.IsNaN(lat) || math.IsNaN(lng) ||
+		lat < -90 || lat > 90 || lng < -180 || lng > 180 {
+		return eventdb.Event{}, errors.E(op, errors.Invalid, "lat or lng out of range")
+	}
+
+	ctx, cancel := withTimeout(ctx, s.GenerateTimeout, defaultGenerateTimeout)
+	defer cancel()
+
+	candidates, err := s.candidateEvents(ctx, eventdb.DestGenerateRequest{Lat: lat, Lng: lng}, generateRadiusM, func(event eventdb.Event) bool {
+		return !event.IsBad && !event.IsCanceled
+	})
+	if err != nil {
+		return eventdb.Event{}, errors.E(op, errors.Internal, err)
+	}
+	if len(candidates) == 0 {
+		return eventdb.Event{}, errors.E(op, errors.NotExist, "no upcoming events nearby")
+	}
+
+	next := candidates[0]
+	for _, event := range candidates[1:] {
+		if event.StartTime.Before(next.StartTime) ||
+			(event.StartTime.Equal(next.StartTime) && event.ID < next.ID) {
+			next = event
+		}
+	}
+
+	return next, nil
+}
+
+// syncPageSize is the most changes EventSync returns at a time.
+const syncPageSize = 500
+
+// EventSync returns the events that changed after since, along with the IDs
+// of events that were deleted or marked bad, so clients can keep a local
+// mirror of the database. Pass next as since to get the following changes.
+// If next equals since, the client is up to date.
+func (s *Service) EventSync(ctx context.Context, since eventdb.SyncCursor) (events []eventdb.Event, tombstones []eventdb.EventID, next eventdb.SyncCursor, err error) {
+	const op errors.Op = "Service.EventSync"
+
+	if auth.User(ctx).ID == "" {
+		return nil, nil, since, errors.E(op, errors.Permission)
+	}
+
+	ctx, cancel := withTimeout(ctx, s.SearchTimeout, defaultSearchTimeout)
+	defer cancel()
+
+	events, tombstones, next, err = s.EventStore.Changes(ctx, since, syncPageSize)
+	if err != nil {
+		return nil, nil, since, errors.E(op, errors.Internal, err)
+	}
+
+	return events, tombstones, next, nil
+}
+
+// allowPublicSearch reports whether an anonymous search from the client in ctx
+// may run now without going over PublicSearchesPerMinute.
+func (s *Service) allowPublicSearch(ctx context.Context) bool {
+	perMinute := s.PublicSearchesPerMinute
+	if perMinute <= 0 {
+		perMinute = defaultPublicSearchesPerMinute
+	}
+	return s.publicSearchLimiter.allow(clientIP(ctx), time.Now(), perMinute)
+}
+
 // EventSearchFull queries the database for events matching the EventSearchRequest
 // and returns the raw Graph API JSON data for the matching results.
 func (s *Service) EventSearchFull(ctx context.Context, params eventdb.EventSearchRequest) ([]json.RawMessage, error) {
@@ -50,47 +396,310 @@ func (s *Service) EventSearchFull(ctx context.Context, params eventdb.EventSearc
 	if !auth.User(ctx).IsAdmin {
 		return nil, errors.E(op, errors.Permission)
 	}
+	if err := checkAdminSearch(&params); err != nil {
+		return nil, errors.E(op, err)
+	}
 
-	ctx, cancel := context.WithTimeout(ctx, 60*time.Second)
+	ctx, cancel := withTimeout(ctx, s.SearchTimeout, defaultSearchTimeout)
 	defer cancel()
 
 	return s.EventStore.SearchFull(ctx, params)
 }
 
+// EventSearchAddressless finds the events matching params that have
+// coordinates but no street address. Regular searches leave these events out,
+// so this shows admins which stored events users never see. It's only
+// available to admins.
+func (s *Service) EventSearchAddressless(ctx context.Context, params eventdb.EventSearchRequest) ([]eventdb.Event, error) {
+	const op errors.Op = "Service.EventSearchAddressless"
+
+	if !auth.User(ctx).IsAdmin {
+		return nil, errors.E(op, errors.Permission)
+	}
+	if err := checkAdminSearch(&params); err != nil {
+		return nil, errors.E(op, err)
+	}
+
+	ctx, cancel := withTimeout(ctx, s.SearchTimeout, defaultSearchTimeout)
+	defer cancel()
+
+	events, err := s.EventStore.SearchAddressless(ctx, params)
+	if err != nil {
+		return nil, errors.E(op, errors.KindOf(err), errors.Errorf("event search: %v", err))
+	}
+	return events, nil
+}
+
 // EventGet retrieves an event from the database.
 func (s *Service) EventGet(ctx context.Context, id eventdb.EventID) (eventdb.Event, error) {
 	const op errors.Op = "Service.EventGet"
 
 	event, err := s.EventStore.GetByID(ctx, id)
 	if err != nil {
-		return event, errors.E(op, errors.Internal, "event get failed", err)
+		return event, errors.E(op, errors.KindOf(err), errors.Errorf("event get failed: %v", err))
 	}
 
 	return event, err
 }
 
+// EventDelete removes an event from the database for good, for spam and
+// events that were deleted from Facebook. Users' dests for the event are kept
+// and show it as unavailable. It's only available to admins.
+func (s *Service) EventDelete(ctx context.Context, id eventdb.EventID) error {
+	const op errors.Op = "Service.EventDelete"
+
+	if !auth.User(ctx).IsAdmin {
+		return errors.E(op, errors.Permission)
+	}
+
+	err := s.EventStore.Delete(ctx, id)
+	if errors.Is(errors.NotExist, err) {
+		return errors.E(op, errors.NotExist, "event not found")
+	}
+	if err != nil {
+		return errors.E(op, errors.Internal, err)
+	}
+	return nil
+}
+
+const (
+	// venueRadiusM is how close two events must be for EventsAtVenue to
+	// count them as the same place when they don't share a place ID.
+	venueRadiusM = 50.0
+	// venueHorizon is how far ahead EventsAtVenue looks for events.
+	venueHorizon = 30 * 24 * time.Hour
+	// maxVenueEvents is the most events EventsAtVenue returns.
+	maxVenueEvents = 50
+)
+
+// EventsAtVenue returns the other upcoming events at the same place as the
+// given event, soonest first, so users who liked an event can find more
+// happening there.
+func (s *Service) EventsAtVenue(ctx context.Context, id eventdb.EventID) ([]eventdb.Event, error) {
+	const op errors.Op = "Service.EventsAtVenue"
+
+	exists, err := s.EventStore.Exists(ctx, id)
+	if err != nil {
+		return nil, errors.E(op, errors.Internal, err)
+	}
+	if !exists {
+		return nil, errors.E(op, errors.NotExist, "event not found")
+	}
+
+	now := s.now(ctx)
+	events, err := s.EventStore.AtVenue(ctx, id, venueRadiusM, now, now.Add(venueHorizon), maxVenueEvents)
+	if err != nil {
+		return nil, errors.E(op, errors.Internal, err)
+	}
+	return events, nil
+}
+
+// facebookBatchSize is the most events fetchAndSave can get from Facebook in
+// one request.
+const facebookBatchSize = 50
+
 // EventSubmit downloads the events using the Facebook API and saves them to the
 // EventStore. It uses a random user's Facebook API token to fetch the event
 // so some users must be logged in with Facebook for this method to work.
-func (s *Service) EventSubmit(ctx context.Context, req eventdb.EventSubmitRequest) error {
+//
+// The reply says which of the saved events were flagged bad and why. If some
+// events couldn't be fetched, the error is a SubmitError, which lists the
+// saved ones too so clients still get them.
+func (s *Service) EventSubmit(ctx context.Context, req eventdb.EventSubmitRequest) (eventdb.EventSubmitReply, error) {
 	const op errors.Op = "Service.EventSubmit"
 
+	var reply eventdb.EventSubmitReply
+
+	ctx, cancel := withTimeout(ctx, s.SubmitTimeout, defaultSubmitTimeout)
+	defer cancel()
+
 	userID := eventdb.UserID(auth.User(ctx).ID)
 
 	if userID == "" {
-		return errors.E(op, errors.Permission)
+		return reply, errors.E(op, errors.Permission)
 	}
 
-	eventIDs := req.EventIDs
-	if len(eventIDs) > 50 {
-		err := fmt.Errorf("event list length (%d) > max (50)", len(eventIDs))
-		return errors.E(op, errors.Invalid, userID, err)
+	var eventIDs []eventdb.EventID
+	for _, input := range req.EventIDs {
+		id, err := eventdb.ParseEventID(string(input))
+		if err != nil {
+			return reply, errors.E(op, errors.Invalid, userID, err)
+		}
+		eventIDs = append(eventIDs, id)
+	}
+	if max := s.maxSubmitIDs(); len(eventIDs) > max {
+		err := fmt.Errorf("event list length (%d) > max (%d)", len(eventIDs), max)
+		return reply, errors.E(op, errors.Invalid, userID, err)
 	}
 
-	err := retry(ctx, 3, func() error {
-		fetcherID, oauthToken, err := s.UserStore.RandomFBToken(ctx)
+	// Facebook only takes so many events per request
+	failed := facebook.BatchError{}
+	for len(eventIDs) > 0 {
+		batch := eventIDs
+		if len(batch) > facebookBatchSize {
+			batch = batch[:facebookBatchSize]
+		}
+		eventIDs = eventIDs[len(batch):]
+
+		saved, batchFailed, err := s.fetchAndSave(ctx, batch)
+		reply.Events = append(reply.Events, saved...)
 		if err != nil {
-			return errors.E(op, errors.Internal, userID, err)
+			return reply, errors.E(op, userID, err)
+		}
+		for id, fbErr := range batchFailed {
+			failed[id] = fbErr
+		}
+	}
+
+	if len(failed) > 0 {
+		submitErr := SubmitError{
+			Total:  len(req.EventIDs),
+			Failed: map[eventdb.EventID]string{},
+			Saved:  reply.Events,
+		}
+		for id, fbErr := range failed {
+			submitErr.Failed[eventdb.EventID(id)] = fbErr.Message
+		}
+		return reply, errors.E(op, errors.Invalid, userID, submitErr)
+	}
+
+	return reply, nil
+}
+
+// maxRefresh is the most events RefreshStale will refetch in one call.
+const maxRefresh = 1000
+
+// RefreshStale refetches upcoming events that haven't been downloaded from
+// Facebook in olderThan, up to limit of them, and re-saves them. Events that
+// fail to fetch are skipped until they're stale again. It returns how many
+// events were refreshed, and if it fails partway through the error is a
+// RefreshError with the count so far. It's only available to admins.
+func (s *Service) RefreshStale(ctx context.Context, olderThan time.Duration, limit int) (refreshed int, err error) {
+	const op errors.Op = "Service.RefreshStale"
+
+	if !auth.User(ctx).IsAdmin {
+		return 0, errors.E(op, errors.Permission)
+	}
+	if olderThan <= 0 {
+		return 0, errors.E(op, errors.Invalid, "olderThan must be positive")
+	}
+	if limit <= 0 || limit > maxRefresh {
+		return 0, errors.E(op, errors.Invalid, fmt.Sprintf("limit must be between 1 and %d", maxRefresh))
+	}
+
+	now := s.now(ctx)
+	eventIDs, err := s.EventStore.ListStale(ctx, now.Add(-olderThan), now, limit)
+	if err != nil {
+		return 0, errors.E(op, errors.Internal, err)
+	}
+
+	fail := func(err error) (int, error) {
+		return refreshed, errors.E(op, errors.KindOf(err), RefreshError{Refreshed: refreshed, Err: err})
+	}
+
+	for len(eventIDs) > 0 {
+		batch := eventIDs
+		if len(batch) > facebookBatchSize {
+			batch = batch[:facebookBatchSize]
+		}
+		eventIDs = eventIDs[len(batch):]
+
+		before, err := s.EventStore.GetMulti(ctx, batch)
+		if err != nil {
+			return fail(err)
+		}
+
+		_, failed, err := s.fetchAndSave(ctx, batch)
+		if err != nil {
+			return fail(err)
+		}
+		if len(failed) > 0 {
+			log.FromContext(ctx).Warn("refresh failed for some events",
+				zap.Error(failed))
+
+			var failedIDs []eventdb.EventID
+			for id := range failed {
+				failedIDs = append(failedIDs, eventdb.EventID(id))
+			}
+			if err := s.EventStore.MarkFetched(ctx, failedIDs); err != nil {
+				return fail(err)
+			}
+		}
+		refreshed += len(batch) - len(failed)
+
+		if err := s.logChanges(ctx, before); err != nil {
+			return fail(err)
+		}
+	}
+
+	return refreshed, nil
+}
+
+// logChanges logs what changed in each event since the before snapshot was
+// taken, for auditing refreshes.
+func (s *Service) logChanges(ctx context.Context, before []eventdb.Event) error {
+	var ids []eventdb.EventID
+	for _, event := range before {
+		ids = append(ids, event.ID)
+	}
+	// Read from the primary, the replica may not have the refresh yet
+	after, err := s.EventStore.GetMultiPrimary(ctx, ids)
+	if err != nil {
+		return err
+	}
+
+	afterByID := make(map[eventdb.EventID]eventdb.Event)
+	for _, event := range after {
+		afterByID[event.ID] = event
+	}
+
+	logger := log.FromContext(ctx)
+	for _, old := range before {
+		updated, ok := afterByID[old.ID]
+		if !ok {
+			continue
+		}
+		changes := eventdb.DiffEvents(old, updated)
+		if len(changes) == 0 {
+			continue
+		}
+		logger.Info("event changed",
+			zap.String("eventID", string(old.ID)),
+			zap.Any("changes", changes))
+	}
+	return nil
+}
+
+// recordFBToken records whether a Facebook request with the user's token
+// worked, so healthy tokens are picked more often. Errors are only logged,
+// since the request itself is done either way.
+func (s *Service) recordFBToken(ctx context.Context, userID eventdb.UserID, ok bool) {
+	if err := s.UserStore.RecordFBTokenResult(ctx, userID, ok); err != nil {
+		log.FromContext(ctx).Warn("record facebook token result failed",
+			zap.Error(err),
+			zap.String("userID", string(userID)))
+	}
+}
+
+// fetchAndSave downloads the events from Facebook using a random user's token,
+// favoring ones that have been working, and saves them, returning how each
+// saved event was filtered. Events Facebook couldn't return are listed in
+// failed, and the ones it says don't exist are marked deleted.
+func (s *Service) fetchAndSave(ctx context.Context, eventIDs []eventdb.EventID) (saved []eventdb.EventSubmitResult, failed facebook.BatchError, err error) {
+	const op errors.Op = "Service.fetchAndSave"
+
+	errFacebookDown := errors.E(op, errors.Unavailable, "facebook requests are failing, try again in a minute")
+
+	err = retry(ctx, 3, func() error {
+		saved, failed = nil, nil
+
+		if !s.facebookBreaker.allow(s.clock()) {
+			return noRetry{errFacebookDown}
+		}
+
+		fetcherID, oauthToken, err := s.UserStore.WeightedFBToken(ctx)
+		if err != nil {
+			return errors.E(op, errors.Internal, err)
 		}
 
 		client := s.FacebookClient(oauthToken)
@@ -102,41 +711,374 @@ func (s *Service) EventSubmit(ctx context.Context, req eventdb.EventSubmitReques
 
 		events, err := client.GetEventInfo(ctx, eventIDStrs)
 		if facebook.IsTokenExpired(err) {
+			prom.FacebookTokenExpired.Inc()
+			log.FromContext(ctx).Warn("facebook token expired",
+				zap.String("userID", string(fetcherID)))
+
 			_, err = s.UserStore.Update(ctx, fetcherID, eventdb.UserUpdate{
 				FacebookToken: "",
 				Mask:          "facebookToken",
 			})
 			if err != nil {
-				return errors.E(op, userID, "expire user token", err)
+				return errors.E(op, errors.KindOf(err), errors.Errorf("expire user token: %v", err))
 			}
-			return errors.E(op, userID, "facebook token expired")
+			return errors.E(op, "facebook token expired")
+
+		} else if batchErr, ok := err.(facebook.BatchError); ok {
+			// Save the events we did get. The failures are reported to the
+			// client, retrying won't help.
+			failed = batchErr
 
 		} else if err != nil {
+			s.recordFBToken(ctx, fetcherID, false)
+			if s.facebookFailed(ctx) {
+				log.FromContext(ctx).Error("stopping facebook requests after repeated failures",
+					zap.Error(err))
+				return noRetry{errFacebookDown}
+			}
 			return err
 		}
+		s.facebookBreaker.success()
+		s.recordFBToken(ctx, fetcherID, true)
 
 		for _, e := range events {
-			event, err := s.EventStore.Save(ctx, e)
+			result, err := s.saveEvent(ctx, e)
 			if err != nil {
-				return errors.E(op, errors.Internal, "save event", err)
-			}
-
-			if err := s.EventStore.SetBad(ctx, event.ID, eventdb.IsBadEvent(event)); err != nil {
-				return errors.E(op, errors.Internal, "mark bad", err)
+				return errors.E(op, err)
 			}
+			saved = append(saved, result)
 		}
 
 		return nil
 	})
 	if err != nil {
+		return nil, nil, errors.E(op, err)
+	}
+
+	// Events that Facebook says don't exist anymore were probably deleted.
+	// Stop serving them if we have them stored.
+	for id, fbErr := range failed {
+		if !facebook.IsNotFound(fbErr) {
+			continue
+		}
+		err := s.EventStore.MarkDeleted(ctx, eventdb.EventID(id))
+		if err != nil && !errors.Is(errors.NotExist, err) {
+			return saved, nil, errors.E(op, errors.KindOf(err), errors.Errorf("mark deleted: %v", err))
+		}
+	}
+
+	return saved, failed, nil
+}
+
+// saveEvent stores Graph API event JSON and runs the bad filter on it.
+func (s *Service) saveEvent(ctx context.Context, eventJS json.RawMessage) (eventdb.EventSubmitResult, error) {
+	event, err := s.EventStore.Save(ctx, eventJS)
+	if err != nil {
+		return eventdb.EventSubmitResult{}, errors.E(errors.KindOf(err), errors.Errorf("save event: %v", err))
+	}
+	result := eventdb.EventSubmitResult{ID: event.ID}
+
+	if s.DisableBadFilterOnIngest {
+		return result, nil
+	}
+
+	result.BadReason = s.badFilter().Reason(event)
+
+	// Refetching a reported event shouldn't undo its reports.
+	reporters, err := s.EventStore.Reporters(ctx, event.ID)
+	if err != nil {
+		return result, errors.E(errors.KindOf(err), errors.Errorf("count reports: %v", err))
+	}
+	if result.BadReason == "" && reporters >= s.reportThreshold() {
+		result.BadReason = fmt.Sprintf("reported by %d users", reporters)
+	}
+	result.IsBad = result.BadReason != ""
+
+	if err := s.EventStore.SetBad(ctx, event.ID, result.IsBad); err != nil {
+		return result, errors.E(errors.KindOf(err), errors.Errorf("mark bad: %v", err))
+	}
+	return result, nil
+}
+
+// BadFilterRules lists the rules of the bad event filter in use, so admins can
+// check that a deploy has the rules they expect. It's only available to
+// admins.
+func (s *Service) BadFilterRules(ctx context.Context) ([]eventdb.BadRule, error) {
+	const op errors.Op = "Service.BadFilterRules"
+
+	if !auth.User(ctx).IsAdmin {
+		return nil, errors.E(op, errors.Permission)
+	}
+	return s.badFilter().Rules(), nil
+}
+
+// maxReportReason is the longest reason EventReport accepts, in bytes.
+const maxReportReason = 500
+
+// EventReport records the logged-in user's report that an event is broken or
+// wrong. Once ReportThreshold different users have reported an event it's
+// marked bad.
+func (s *Service) EventReport(ctx context.Context, id eventdb.EventID, reason string) error {
+	const op errors.Op = "Service.EventReport"
+
+	userID := eventdb.UserID(auth.User(ctx).ID)
+	if userID == "" {
+		return errors.E(op, errors.NotLoggedIn)
+	}
+
+	reason = strings.TrimSpace(reason)
+	if reason == "" {
+		return errors.E(op, errors.Invalid, userID, "reason is required")
+	}
+	if len(reason) > maxReportReason {
+		return errors.E(op, errors.Invalid, userID, fmt.Sprintf("reason length (%d) > max (%d)", len(reason), maxReportReason))
+	}
+
+	exists, err := s.EventStore.Exists(ctx, id)
+	if err != nil {
+		return errors.E(op, errors.Internal, userID, err)
+	}
+	if !exists {
+		return errors.E(op, errors.NotExist, userID, "event not found")
+	}
+
+	reporters, err := s.EventStore.Report(ctx, eventdb.EventReport{
+		EventID: id,
+		UserID:  userID,
+		Reason:  reason,
+	})
+	if err != nil {
+		return errors.E(op, errors.Internal, userID, err)
+	}
+
+	if reporters >= s.reportThreshold() {
+		if err := s.EventStore.SetBad(ctx, id, true); err != nil {
+			return errors.E(op, userID, errors.KindOf(err), errors.Errorf("mark bad: %v", err))
+		}
+	}
+
+	return nil
+}
+
+// maxReportList is the most reports EventReportList returns.
+const maxReportList = 1000
+
+// EventReportList returns the most recent event reports, newest first. It's
+// only available to admins.
+func (s *Service) EventReportList(ctx context.Context) ([]eventdb.EventReport, error) {
+	const op errors.Op = "Service.EventReportList"
+
+	if !auth.User(ctx).IsAdmin {
+		return nil, errors.E(op, errors.Permission)
+	}
+
+	reports, err := s.EventStore.ListReports(ctx, maxReportList)
+	if err != nil {
+		return nil, errors.E(op, errors.Internal, err)
+	}
+	return reports, nil
+}
+
+// BlocklistAdd blocks a place or owner, so none of their events are returned
+// by search or picked by DestGenerate, even ones saved later. It's only
+// available to admins.
+func (s *Service) BlocklistAdd(ctx context.Context, block eventdb.Block) (eventdb.Block, error) {
+	const op errors.Op = "Service.BlocklistAdd"
+
+	if !auth.User(ctx).IsAdmin {
+		return block, errors.E(op, errors.Permission)
+	}
+	block.ID = strings.TrimSpace(block.ID)
+	if err := checkBlock(block.Kind, block.ID); err != nil {
+		return block, errors.E(op, err)
+	}
+	block.Reason = strings.TrimSpace(block.Reason)
+
+	block, err := s.EventStore.Block(ctx, block)
+	if err != nil {
+		return block, errors.E(op, errors.Internal, err)
+	}
+	return block, nil
+}
+
+// BlocklistDelete unblocks a place or owner. It's only available to admins.
+func (s *Service) BlocklistDelete(ctx context.Context, kind eventdb.BlockKind, id string) error {
+	const op errors.Op = "Service.BlocklistDelete"
+
+	if !auth.User(ctx).IsAdmin {
+		return errors.E(op, errors.Permission)
+	}
+	id = strings.TrimSpace(id)
+	if err := checkBlock(kind, id); err != nil {
 		return errors.E(op, err)
 	}
 
+	err := s.EventStore.Unblock(ctx, kind, id)
+	if errors.Is(errors.NotExist, err) {
+		return errors.E(op, errors.NotExist, fmt.Sprintf("%s %q isn't blocked", kind, id))
+	}
+	if err != nil {
+		return errors.E(op, errors.Internal, err)
+	}
+	return nil
+}
+
+// BlocklistList returns the blocked places and owners, newest first. It's
+// only available to admins.
+func (s *Service) BlocklistList(ctx context.Context) ([]eventdb.Block, error) {
+	const op errors.Op = "Service.BlocklistList"
+
+	if !auth.User(ctx).IsAdmin {
+		return nil, errors.E(op, errors.Permission)
+	}
+
+	blocks, err := s.EventStore.ListBlocks(ctx)
+	if err != nil {
+		return nil, errors.E(op, errors.Internal, err)
+	}
+	return blocks, nil
+}
+
+// checkBlock returns an Invalid error if kind and id don't name a place or
+// owner.
+func checkBlock(kind eventdb.BlockKind, id string) error {
+	switch kind {
+	case eventdb.BlockPlace, eventdb.BlockOwner:
+	default:
+		return errors.E(errors.Invalid, fmt.Sprintf("unknown block kind %q", kind))
+	}
+	if id == "" {
+		return errors.E(errors.Invalid, "missing id")
+	}
+	return nil
+}
+
+// EventFeedback sums up the feedback users have left on their dests for an
+// event, so admins can find events that consistently disappoint. It's only
+// available to admins.
+func (s *Service) EventFeedback(ctx context.Context, id eventdb.EventID) (eventdb.FeedbackSummary, error) {
+	const op errors.Op = "Service.EventFeedback"
+
+	if !auth.User(ctx).IsAdmin {
+		return eventdb.FeedbackSummary{}, errors.E(op, errors.Permission)
+	}
+
+	summary, err := s.DestStore.FeedbackForEvent(ctx, id)
+	if err != nil {
+		return summary, errors.E(op, errors.Internal, err)
+	}
+	return summary, nil
+}
+
+// maxImport is the most events EventImport will save in one call.
+const maxImport = 500
+
+// importTimeLayouts are the start_time formats EventImport accepts. The Graph
+// API uses the first, RFC 3339 is for events from other sources.
+var importTimeLayouts = []string{"2006-01-02T15:04:05-0700", time.RFC3339}
+
+// EventImport saves events from sources other than the live Facebook API,
+// like archives or partner feeds, without fetching them. Each event must be
+// JSON in the shape of a Graph API event with at least an id and start_time.
+// If any event is malformed nothing is saved. It's only available to admins.
+func (s *Service) EventImport(ctx context.Context, events []json.RawMessage) error {
+	const op errors.Op = "Service.EventImport"
+
+	if !auth.User(ctx).IsAdmin {
+		return errors.E(op, errors.Permission)
+	}
+	if len(events) > maxImport {
+		return errors.E(op, errors.Invalid, fmt.Sprintf("event list length (%d) > max (%d)", len(events), maxImport))
+	}
+
+	for i, eventJS := range events {
+		if err := checkImport(eventJS); err != nil {
+			return errors.E(op, errors.Invalid, errors.Errorf("event %d: %v", i, err))
+		}
+	}
+
+	ctx, cancel := withTimeout(ctx, s.SubmitTimeout, defaultSubmitTimeout)
+	defer cancel()
+
+	for _, eventJS := range events {
+		if _, err := s.saveEvent(ctx, eventJS); err != nil {
+			return errors.E(op, err)
+		}
+	}
+
 	return nil
 }
 
+// checkImport makes sure imported event JSON has the fields the EventStore
+// needs to index it.
+func checkImport(eventJS json.RawMessage) error {
+	var event struct {
+		ID        string `json:"id"`
+		StartTime string `json:"start_time"`
+	}
+	if err := json.Unmarshal(eventJS, &event); err != nil {
+		return err
+	}
+	if event.ID == "" {
+		return fmt.Errorf("id is required")
+	}
+	if event.StartTime == "" {
+		return fmt.Errorf("start_time is required")
+	}
+	for _, layout := range importTimeLayouts {
+		if _, err := time.Parse(layout, event.StartTime); err == nil {
+			return nil
+		}
+	}
+	return fmt.Errorf("bad start_time %q", event.StartTime)
+}
+
+// SubmitError is returned by EventSubmit when some of the submitted events
+// couldn't be fetched from Facebook. The rest of the events are saved.
+type SubmitError struct {
+	// Total is the number of events submitted.
+	Total int
+	// Failed maps the IDs of the events that couldn't be fetched to the reason
+	// Facebook gave.
+	Failed map[eventdb.EventID]string
+	// Saved says what happened to the events that were saved, like the
+	// EventSubmitReply would.
+	Saved []eventdb.EventSubmitResult
+}
+
+func (e SubmitError) Error() string {
+	return fmt.Sprintf("%d of %d events couldn't be fetched", len(e.Failed), e.Total)
+}
+
+// Details lists the failed IDs and the saved events' results for the client.
+// It implements errors.Detailer.
+func (e SubmitError) Details() interface{} {
+	return map[string]interface{}{
+		"failed": e.Failed,
+		"events": e.Saved,
+	}
+}
+
+// RefreshError is returned by RefreshStale when it fails partway through.
+type RefreshError struct {
+	// Refreshed is the number of events refreshed before the failure.
+	Refreshed int
+	// Err is what went wrong.
+	Err error
+}
+
+func (e RefreshError) Error() string {
+	return fmt.Sprintf("%v (after refreshing %d events)", e.Err, e.Refreshed)
+}
+
+// Details has the number of events refreshed, like the EventRefreshReply
+// would. It implements errors.Detailer.
+func (e RefreshError) Details() interface{} {
+	return eventdb.EventRefreshReply{Refreshed: e.Refreshed}
+}
+
 // retry is a simple exponential backoff function. If you cancel the context
-// passed to it retries will stop.
+// passed to it retries will stop. f can return a noRetry to give up early.
 func retry(ctx context.Context, count int, f func() error) error {
 	retries := count
 
@@ -146,15 +1088,27 @@ RETRY:
 	}
 
 	if err := f(); err != nil {
+		if nr, ok := err.(noRetry); ok {
+			return nr.error
+		}
 		if retries == 0 {
 			return err
 		}
 
 		retries--
 		backoff := (math.Pow(2, float64(retries)) + rand.Float64()) * float64(time.Second)
-		time.Sleep(time.Duration(backoff))
+		select {
+		case <-time.After(time.Duration(backoff)):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
 		goto RETRY
 	}
 
 	return nil
 }
+
+// noRetry wraps an error returned to retry to stop it from trying again.
+type noRetry struct {
+	error
+}
@@ -0,0 +1,67 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/findrandomevents/eventdb"
+)
+
+// Notifier is told when something happens that the user should hear about,
+// like a new dest being generated. It's used to send push notifications.
+type Notifier interface {
+	NotifyDest(ctx context.Context, dest eventdb.Dest) error
+}
+
+type nopNotifier struct{}
+
+func (nopNotifier) NotifyDest(ctx context.Context, dest eventdb.Dest) error { return nil }
+
+// notifier returns s.Notifier, or a Notifier that does nothing if it's unset.
+func (s *Service) notifier() Notifier {
+	if s.Notifier == nil {
+		return nopNotifier{}
+	}
+	return s.Notifier
+}
+
+// WebhookNotifier is a Notifier that POSTs the JSON for each new dest to URL.
+type WebhookNotifier struct {
+	URL  string
+	HTTP *http.Client
+}
+
+// NotifyDest POSTs dest to the webhook URL.
+func (w *WebhookNotifier) NotifyDest(ctx context.Context, dest eventdb.Dest) error {
+	js, err := json.Marshal(dest)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", w.URL, bytes.NewReader(js))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+
+	client := w.HTTP
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("webhook returned status %d", resp.StatusCode)
+	}
+
+	return nil
+}
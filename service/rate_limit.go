@@ -0,0 +1,79 @@
+package service
+
+import (
+	"sync"
+	"time"
+)
+
+// rateLimiter is a token bucket rate limiter. The zero value is ready to use
+// and starts with a full bucket.
+type rateLimiter struct {
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+// allow reports whether an action is allowed at time now, given a rate limit
+// of perMinute actions per minute. Up to a minute's worth of actions may
+// happen in a burst.
+func (l *rateLimiter) allow(now time.Time, perMinute int) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	burst := float64(perMinute)
+	if l.last.IsZero() {
+		l.tokens = burst
+	} else {
+		l.tokens += now.Sub(l.last).Minutes() * float64(perMinute)
+		if l.tokens > burst {
+			l.tokens = burst
+		}
+	}
+	l.last = now
+
+	if l.tokens < 1 {
+		return false
+	}
+	l.tokens--
+	return true
+}
+
+// clientRateLimiter keeps a separate rateLimiter for each client, so one
+// busy client can't use up everyone else's limit. The zero value is ready to
+// use.
+type clientRateLimiter struct {
+	mu      sync.Mutex
+	clients map[string]*rateLimiter
+}
+
+// maxIdleClients is how many clients clientRateLimiter tracks before it
+// forgets the ones whose buckets have refilled.
+const maxIdleClients = 10000
+
+// allow is like rateLimiter.allow, but only counts actions by client.
+func (l *clientRateLimiter) allow(client string, now time.Time, perMinute int) bool {
+	l.mu.Lock()
+	if l.clients == nil {
+		l.clients = make(map[string]*rateLimiter)
+	}
+	if len(l.clients) >= maxIdleClients {
+		// A bucket that's been idle for a minute is full again, so
+		// dropping it doesn't change anything.
+		for c, cl := range l.clients {
+			cl.mu.Lock()
+			idle := now.Sub(cl.last) >= time.Minute
+			cl.mu.Unlock()
+			if idle {
+				delete(l.clients, c)
+			}
+		}
+	}
+	cl, ok := l.clients[client]
+	if !ok {
+		cl = new(rateLimiter)
+		l.clients[client] = cl
+	}
+	l.mu.Unlock()
+
+	return cl.allow(now, perMinute)
+}
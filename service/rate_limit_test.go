@@ -0,0 +1,31 @@
+package service
+
+import (
+	"testing"
+	"time"
+)
+
+func TestClientRateLimiter(t *testing.T) {
+	var l clientRateLimiter
+	now := time.Date(2017, 8, 17, 14, 0, 0, 0, time.UTC)
+
+	const perMinute = 2
+
+	for i := 0; i < perMinute; i++ {
+		if !l.allow("1.2.3.4", now, perMinute) {
+			t.Fatalf("call %d was limited, want %d allowed", i+1, perMinute)
+		}
+	}
+	if l.allow("1.2.3.4", now, perMinute) {
+		t.Fatal("client went over the limit")
+	}
+
+	// Other clients have their own limit
+	if !l.allow("5.6.7.8", now, perMinute) {
+		t.Fatal("another client's calls counted against a new one")
+	}
+
+	if !l.allow("1.2.3.4", now.Add(time.Minute/perMinute), perMinute) {
+		t.Fatal("client still limited after the bucket refilled")
+	}
+}
@@ -0,0 +1,106 @@
+package service
+
+import (
+	"math"
+
+	"github.com/findrandomevents/eventdb"
+	"github.com/findrandomevents/eventdb/geojson"
+)
+
+// A DestScorer rates how good a candidate event would be as a user's next
+// dest. DestGenerate picks among the candidates with probability proportional
+// to their scores, so an event scored 2 is twice as likely as one scored 1.
+// Events scored zero or less are never picked.
+type DestScorer interface {
+	Score(req eventdb.DestGenerateRequest, event eventdb.Event) float64
+}
+
+// DestScorerFunc adapts a function to the DestScorer interface.
+type DestScorerFunc func(req eventdb.DestGenerateRequest, event eventdb.Event) float64
+
+// Score calls f(req, event).
+func (f DestScorerFunc) Score(req eventdb.DestGenerateRequest, event eventdb.Event) float64 {
+	return f(req, event)
+}
+
+// UniformScorer scores every event the same, so they're all equally likely.
+// It's the default.
+type UniformScorer struct{}
+
+// Score returns 1.
+func (UniformScorer) Score(eventdb.DestGenerateRequest, eventdb.Event) float64 {
+	return 1
+}
+
+// DistanceScorer favors events close to the user. An event's score halves for
+// every HalfDistanceM meters between it and the user.
+type DistanceScorer struct {
+	HalfDistanceM float64
+}
+
+// Score returns 0.5^(distance/HalfDistanceM).
+func (d DistanceScorer) Score(req eventdb.DestGenerateRequest, event eventdb.Event) float64 {
+	if d.HalfDistanceM <= 0 {
+		return 1
+	}
+	distM := geojson.Haversine(req.Lng, req.Lat, event.Longitude, event.Latitude)
+	return math.Pow(0.5, distM/d.HalfDistanceM)
+}
+
+// FeaturedScorer favors featured events. They're scored Weight, other events
+// are scored 1.
+type FeaturedScorer struct {
+	Featured map[eventdb.EventID]bool
+	Weight   float64
+}
+
+// Score returns Weight if the event is featured and 1 otherwise.
+func (f FeaturedScorer) Score(req eventdb.DestGenerateRequest, event eventdb.Event) float64 {
+	if f.Featured[event.ID] {
+		return f.Weight
+	}
+	return 1
+}
+
+// MultiScorer combines several factors by multiplying their scores.
+type MultiScorer []DestScorer
+
+// Score returns the product of the scores.
+func (m MultiScorer) Score(req eventdb.DestGenerateRequest, event eventdb.Event) float64 {
+	score := 1.0
+	for _, scorer := range m {
+		score *= scorer.Score(req, event)
+	}
+	return score
+}
+
+// pickScored chooses one of events at random, weighted by scorer. r is a
+// uniform random number in [0, 1). ok is false if no event has a positive
+// score.
+func pickScored(scorer DestScorer, req eventdb.DestGenerateRequest, events []eventdb.Event, r float64) (event eventdb.Event, ok bool) {
+	scores := make([]float64, len(events))
+	var total float64
+	for i, e := range events {
+		score := scorer.Score(req, e)
+		if score > 0 && !math.IsInf(score, 0) && !math.IsNaN(score) {
+			scores[i] = score
+			total += score
+		}
+	}
+	if total == 0 {
+		return event, false
+	}
+
+	target := r * total
+	for i, score := range scores {
+		if score <= 0 {
+			continue
+		}
+		event, ok = events[i], true
+		if target < score {
+			break
+		}
+		target -= score
+	}
+	return event, ok
+}
@@ -0,0 +1,75 @@
+package service
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/findrandomevents/eventdb"
+)
+
+// pickCounts picks from events n times and counts how often each was chosen.
+func pickCounts(scorer DestScorer, req eventdb.DestGenerateRequest, events []eventdb.Event, n int) map[eventdb.EventID]int {
+	rng := rand.New(rand.NewSource(1))
+	counts := make(map[eventdb.EventID]int)
+	for i := 0; i < n; i++ {
+		event, ok := pickScored(scorer, req, events, rng.Float64())
+		if ok {
+			counts[event.ID]++
+		}
+	}
+	return counts
+}
+
+func TestDistanceScorer(t *testing.T) {
+	req := eventdb.DestGenerateRequest{Lat: 45.96, Lng: 15.48}
+	events := []eventdb.Event{
+		{ID: "near", Latitude: 45.96, Longitude: 15.48},
+		// About 2km east
+		{ID: "far", Latitude: 45.96, Longitude: 15.506},
+	}
+	scorer := DistanceScorer{HalfDistanceM: 1000}
+
+	if got := scorer.Score(req, events[0]); got != 1 {
+		t.Fatalf("score at the user's location = %v, want 1", got)
+	}
+	if got := scorer.Score(req, events[1]); got < 0.2 || got > 0.3 {
+		t.Fatalf("score 2km away = %v, want about 0.25", got)
+	}
+
+	counts := pickCounts(scorer, req, events, 1000)
+	if counts["near"] < 700 || counts["near"] > 900 {
+		t.Fatalf("near event picked %d/1000 times, want about 800", counts["near"])
+	}
+}
+
+func TestFeaturedScorer(t *testing.T) {
+	req := eventdb.DestGenerateRequest{}
+	events := []eventdb.Event{
+		{ID: "featured"},
+		{ID: "plain"},
+	}
+	scorer := FeaturedScorer{
+		Featured: map[eventdb.EventID]bool{"featured": true},
+		Weight:   9,
+	}
+
+	counts := pickCounts(scorer, req, events, 1000)
+	if counts["featured"] < 850 || counts["featured"] > 950 {
+		t.Fatalf("featured event picked %d/1000 times, want about 900", counts["featured"])
+	}
+
+	// The uniform scorer picks them evenly
+	counts = pickCounts(UniformScorer{}, req, events, 1000)
+	if counts["featured"] < 450 || counts["featured"] > 550 {
+		t.Fatalf("uniform scorer picked featured event %d/1000 times, want about 500", counts["featured"])
+	}
+}
+
+func TestPickScoredZero(t *testing.T) {
+	events := []eventdb.Event{{ID: "1"}, {ID: "2"}}
+	none := DestScorerFunc(func(eventdb.DestGenerateRequest, eventdb.Event) float64 { return 0 })
+
+	if _, ok := pickScored(none, eventdb.DestGenerateRequest{}, events, 0.5); ok {
+		t.Fatalf("picked an event when every score was zero")
+	}
+}
@@ -3,9 +3,16 @@ package service
 import (
 	"context"
 	"encoding/json"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
+	"github.com/findrandomevents/eventdb"
 	"github.com/findrandomevents/eventdb/auth"
+	"github.com/findrandomevents/eventdb/errors"
 	"github.com/findrandomevents/eventdb/pg"
 )
 
@@ -25,9 +32,284 @@ type Service struct {
 	Time           Time
 
 	Auth auth.Provider
+
+	// Notifier is told about each new dest. If it's nil no notifications are
+	// sent.
+	Notifier Notifier
+
+	// GenerateEnabled turns DestGenerate on. It's off by default because
+	// Facebook no longer gives apps access to the Events API, and without it
+	// there aren't events to pick from.
+	GenerateEnabled bool
+
+	// Scorer weights the candidates DestGenerate picks from. If it's nil every
+	// candidate is equally likely.
+	Scorer DestScorer
+
+	// Rand is the source of randomness for picking dests. If it's nil the
+	// math/rand default source is used. Calls to it are serialized, so a
+	// seeded *rand.Rand is fine, and makes DestGenerate deterministic for
+	// tests.
+	Rand   Rand
+	randMu sync.Mutex
+
+	// MaxDestsPerDay caps how many dests a user can generate each day, counted
+	// from midnight in their time zone. Zero means no limit.
+	MaxDestsPerDay int
+
+	// MaxSubmitIDs caps how many events can be submitted in one call to
+	// EventSubmit. It defaults to 50, Facebook's batch size; bigger
+	// submissions are fetched in several batches.
+	MaxSubmitIDs int
+
+	// MinAreaEvents is how many upcoming events there must be near a user for
+	// DestGenerate to pick one. With fewer it returns GenerateUnderserved, so
+	// users in thin areas aren't sent to the same few places over and over.
+	// Zero means no minimum.
+	MinAreaEvents int
+
+	// DestRepeatAfter is how long after an event is suggested to a user that
+	// DestGenerate may suggest it to them again. Zero means never.
+	DestRepeatAfter time.Duration
+
+	// AttendedStatuses are the Dest statuses, as set by the client, that mean
+	// the user went to the event. DestGenerate never suggests those events to
+	// the user again, even after DestRepeatAfter.
+	AttendedStatuses []string
+
+	// When DestGenerate finds nothing within the requested radius, about 5mi
+	// by default, it widens the search by GenerateRadiusStep meters at a time
+	// until it finds something or passes GenerateMaxRadius meters. If
+	// GenerateMaxRadius is zero the search is never widened. The step
+	// defaults to the usual radius.
+	GenerateMaxRadius  float64
+	GenerateRadiusStep float64
+
+	// DisableBadFilterOnIngest skips IsBadEvent when events are submitted, so
+	// no events are flagged bad. Use it to store everything and filter at
+	// query time.
+	DisableBadFilterOnIngest bool
+
+	// BadFilter decides which events are flagged bad when they're saved. If
+	// it's nil eventdb.DefaultBadEventFilter is used.
+	BadFilter *eventdb.BadEventFilter
+
+	// After FacebookFailureThreshold Graph API calls fail in a row, calls to
+	// Facebook are stopped for FacebookCooldown and event submits fail with
+	// errors.Unavailable. This keeps retries from making Facebook's rate
+	// limiting worse for the shared token pool. They default to 5 failures
+	// and one minute.
+	FacebookFailureThreshold int
+	FacebookCooldown         time.Duration
+	facebookBreaker          circuitBreaker
+
+	// ReportThreshold is how many different users must report an event before
+	// it's marked bad, see EventReport. It defaults to 3.
+	ReportThreshold int
+
+	// These limit how long a single call to DestGenerate, EventSubmit or
+	// EventSearch may run before it's canceled. If they're zero the defaults
+	// below are used.
+	GenerateTimeout time.Duration
+	SubmitTimeout   time.Duration
+	SearchTimeout   time.Duration
+
+	// PublicSearchesPerMinute limits how many anonymous searches each client,
+	// as set by WithClientIP, can make. It defaults to 30.
+	PublicSearchesPerMinute int
+	publicSearchLimiter     clientRateLimiter
+
+	// When each user's activity was last recorded, see UserActive
+	activityMu   sync.Mutex
+	lastActivity map[eventdb.UserID]time.Time
+}
+
+const (
+	defaultGenerateTimeout = 15 * time.Second
+	defaultSubmitTimeout   = 30 * time.Second
+	defaultSearchTimeout   = 60 * time.Second
+
+	defaultReportThreshold = 3
+	defaultMaxSubmitIDs    = 50
+
+	defaultFacebookFailureThreshold = 5
+	defaultFacebookCooldown         = time.Minute
+)
+
+// withTimeout is like context.WithTimeout, but uses def if timeout is unset.
+func withTimeout(ctx context.Context, timeout, def time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		timeout = def
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+func (s *Service) badFilter() eventdb.BadEventFilter {
+	if s.BadFilter != nil {
+		return *s.BadFilter
+	}
+	return eventdb.DefaultBadEventFilter
 }
 
-// FacebookClient mocks out access to the Facebook Graph API.
+// generateRadii returns the radii DestGenerate searches in, in meters, from
+// base out to GenerateMaxRadius.
+func (s *Service) generateRadii(base float64) []float64 {
+	radii := []float64{base}
+
+	step := s.GenerateRadiusStep
+	if step <= 0 {
+		step = generateRadiusM
+	}
+	for r := base + step; r < s.GenerateMaxRadius; r += step {
+		radii = append(radii, r)
+	}
+	if s.GenerateMaxRadius > base {
+		radii = append(radii, s.GenerateMaxRadius)
+	}
+	return radii
+}
+
+func (s *Service) maxSubmitIDs() int {
+	if s.MaxSubmitIDs > 0 {
+		return s.MaxSubmitIDs
+	}
+	return defaultMaxSubmitIDs
+}
+
+// facebookFailed records a failed Graph API call. It reports whether calls to
+// Facebook are now stopped. Calls that failed because ctx was canceled aren't
+// Facebook's fault, so they don't count.
+func (s *Service) facebookFailed(ctx context.Context) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	threshold := s.FacebookFailureThreshold
+	if threshold <= 0 {
+		threshold = defaultFacebookFailureThreshold
+	}
+	cooldown := s.FacebookCooldown
+	if cooldown <= 0 {
+		cooldown = defaultFacebookCooldown
+	}
+	return s.facebookBreaker.failure(s.clock(), threshold, cooldown)
+}
+
+func (s *Service) reportThreshold() int {
+	if s.ReportThreshold > 0 {
+		return s.ReportThreshold
+	}
+	return defaultReportThreshold
+}
+
+// Rand is a source of random numbers, like a *rand.Rand.
+type Rand interface {
+	Float64() float64
+}
+
+// float64 returns a random number in [0, 1) from s.Rand.
+func (s *Service) float64() float64 {
+	if s.Rand == nil {
+		return rand.Float64()
+	}
+	s.randMu.Lock()
+	defer s.randMu.Unlock()
+	return s.Rand.Float64()
+}
+
+// scorer returns the configured DestScorer.
+func (s *Service) scorer() DestScorer {
+	if s.Scorer == nil {
+		return UniformScorer{}
+	}
+	return s.Scorer
+}
+
+// nowKey is the context key for WithNow.
+type nowKey struct{}
+
+// WithNow returns a copy of ctx in which the Service treats t as the current
+// time, so admins can see what it would do at some other time, like what
+// DestGenerate returns at 8pm on Friday. It only applies to admins; for other
+// users it's ignored.
+func WithNow(ctx context.Context, t time.Time) context.Context {
+	return context.WithValue(ctx, nowKey{}, t)
+}
+
+// now returns the current time for the request in ctx. It's the time set by
+// WithNow for admins, or else s.clock().
+func (s *Service) now(ctx context.Context) time.Time {
+	if t, ok := ctx.Value(nowKey{}).(time.Time); ok && auth.User(ctx).IsAdmin {
+		return t
+	}
+	return s.clock()
+}
+
+// clientIPKey is the context key for WithClientIP.
+type clientIPKey struct{}
+
+// WithClientIP returns a copy of ctx that records the IP address the request
+// came from. Anonymous requests are rate limited by it.
+func WithClientIP(ctx context.Context, ip string) context.Context {
+	return context.WithValue(ctx, clientIPKey{}, ip)
+}
+
+// clientIP returns the IP address set by WithClientIP, or "" if there isn't
+// one.
+func clientIP(ctx context.Context) string {
+	ip, _ := ctx.Value(clientIPKey{}).(string)
+	return ip
+}
+
+// clock returns the actual current time from s.Time, or time.Now if it's
+// unset. Unlike now, WithNow doesn't change it, so it's the one to use for
+// state shared between requests.
+func (s *Service) clock() time.Time {
+	if s.Time != nil {
+		return s.Time.Now()
+	}
+	return time.Now()
+}
+
+// checkMask returns an errors.Invalid error listing any fields in an update
+// mask that aren't in known, or if the mask is empty. Either way the update
+// would otherwise silently do nothing.
+func checkMask(mask string, known ...string) error {
+	var unknown []string
+	var n int
+	for _, field := range strings.Split(mask, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		n++
+
+		var ok bool
+		for _, k := range known {
+			if field == k {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			unknown = append(unknown, strconv.Quote(field))
+		}
+	}
+	if len(unknown) > 0 {
+		return errors.E(errors.Invalid, fmt.Sprintf("unknown mask fields: %s", strings.Join(unknown, ", ")))
+	}
+	if n == 0 {
+		return errors.E(errors.Invalid, "nothing to update: mask is empty")
+	}
+
+	return nil
+}
+
+// FacebookClient mocks out access to the Facebook Graph API. It's
+// implemented by facebook.Client.
 type FacebookClient interface {
+	// GetEventInfo fetches up to 50 events by ID.
 	GetEventInfo(ctx context.Context, ids []string) ([]json.RawMessage, error)
+
+	// GetPageEvents lists the IDs of a page's upcoming events.
+	GetPageEvents(ctx context.Context, pageID string) ([]string, error)
 }
@@ -2,6 +2,9 @@ package service
 
 import (
 	"context"
+	"fmt"
+	"strings"
+	"time"
 
 	"github.com/findrandomevents/eventdb"
 	"github.com/findrandomevents/eventdb/auth"
@@ -18,6 +21,10 @@ func (s *Service) UserUpdate(ctx context.Context, id eventdb.UserID, update even
 	}
 	id = eventdb.UserID(currentUser.ID)
 
+	if err := checkMask(update.Mask, "timeZone", "facebookID", "facebookToken", "birthday"); err != nil {
+		return nil, errors.E(op, currentUser.ID, err)
+	}
+
 	updatedUser, err := s.UserStore.Update(ctx, id, update)
 	if err != nil {
 		return nil, errors.E(op, errors.Permission, currentUser.ID, err)
@@ -26,6 +33,51 @@ func (s *Service) UserUpdate(ctx context.Context, id eventdb.UserID, update even
 	return &updatedUser, nil
 }
 
+// activityInterval is how often UserActive records activity for each user.
+// More often would mean a database write on every request.
+const activityInterval = time.Minute
+
+// UserActive records that the current user is active. It's called for each
+// authenticated request, but only writes to the database once per
+// activityInterval for each user.
+func (s *Service) UserActive(ctx context.Context) error {
+	const op errors.Op = "Service.UserActive"
+
+	userID := eventdb.UserID(auth.User(ctx).ID)
+	if userID == "" {
+		return nil
+	}
+
+	now := s.now(ctx)
+	s.activityMu.Lock()
+	if last, ok := s.lastActivity[userID]; ok && now.Sub(last) < activityInterval {
+		s.activityMu.Unlock()
+		return nil
+	}
+	if s.lastActivity == nil || len(s.lastActivity) > 10000 {
+		s.lastActivity = make(map[eventdb.UserID]time.Time)
+	}
+	s.lastActivity[userID] = now
+	s.activityMu.Unlock()
+
+	if err := s.UserStore.TouchActivity(ctx, userID); err != nil {
+		return errors.E(op, userID, err)
+	}
+	return nil
+}
+
+// FacebookTokenCount returns how many users have a Facebook token that
+// EventSubmit can fetch events with.
+func (s *Service) FacebookTokenCount(ctx context.Context) (int, error) {
+	const op errors.Op = "Service.FacebookTokenCount"
+
+	n, err := s.UserStore.CountFBTokens(ctx)
+	if err != nil {
+		return 0, errors.E(op, errors.Internal, err)
+	}
+	return n, nil
+}
+
 // UserGet retrieves User records.
 func (s *Service) UserGet(ctx context.Context, id eventdb.UserID) (eventdb.User, error) {
 	const op errors.Op = "Service.UserGet"
@@ -45,3 +97,137 @@ func (s *Service) UserGet(ctx context.Context, id eventdb.UserID) (eventdb.User,
 
 	return user, nil
 }
+
+const (
+	// defaultUserSearchLimit is the page size for UserSearch if the request
+	// doesn't set one.
+	defaultUserSearchLimit = 100
+	// maxUserSearchLimit is the biggest page UserSearch returns.
+	maxUserSearchLimit = 1000
+)
+
+// UserSearch lists users a page at a time, for admins to look through. Facebook
+// tokens are left out. It's only available to admins.
+func (s *Service) UserSearch(ctx context.Context, req eventdb.UserSearchRequest) (eventdb.UserSearchReply, error) {
+	const op errors.Op = "Service.UserSearch"
+
+	var reply eventdb.UserSearchReply
+
+	if !auth.User(ctx).IsAdmin {
+		return reply, errors.E(op, errors.Permission)
+	}
+
+	if req.Limit < 0 {
+		return reply, errors.E(op, errors.Invalid, "limit must not be negative")
+	}
+	if req.Limit == 0 {
+		req.Limit = defaultUserSearchLimit
+	}
+	if req.Limit > maxUserSearchLimit {
+		req.Limit = maxUserSearchLimit
+	}
+
+	// The store leaves out Facebook tokens
+	users, next, err := s.UserStore.Search(ctx, req)
+	if err != nil {
+		return reply, errors.E(op, err)
+	}
+
+	reply.Users = users
+	reply.Next = next
+	return reply, nil
+}
+
+const (
+	// maxSavedLocations is how many locations each user can save.
+	maxSavedLocations = 20
+	// maxLocationLabel is the longest label a saved location can have, in
+	// bytes.
+	maxLocationLabel = 100
+)
+
+// UserLocationList lists the current user's saved locations. id must be "me".
+func (s *Service) UserLocationList(ctx context.Context, id eventdb.UserID) ([]eventdb.SavedLocation, error) {
+	const op errors.Op = "Service.UserLocationList"
+
+	userID, err := meUser(ctx, id)
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+
+	locs, err := s.UserStore.ListLocations(ctx, userID)
+	if err != nil {
+		return nil, errors.E(op, errors.Internal, userID, err)
+	}
+	return locs, nil
+}
+
+// UserLocationSave saves a location for the current user under its label,
+// replacing any location with the same label. id must be "me".
+func (s *Service) UserLocationSave(ctx context.Context, id eventdb.UserID, loc eventdb.SavedLocation) (eventdb.SavedLocation, error) {
+	const op errors.Op = "Service.UserLocationSave"
+
+	userID, err := meUser(ctx, id)
+	if err != nil {
+		return loc, errors.E(op, err)
+	}
+
+	loc.Label = strings.TrimSpace(loc.Label)
+	if loc.Label == "" {
+		return loc, errors.E(op, errors.Invalid, "label is required")
+	}
+	if len(loc.Label) > maxLocationLabel {
+		return loc, errors.E(op, errors.Invalid, fmt.Sprintf("label is too long, the limit is %d bytes", maxLocationLabel))
+	}
+	if loc.Lat < -90 || loc.Lat > 90 || loc.Lng < -180 || loc.Lng > 180 {
+		return loc, errors.E(op, errors.Invalid, "lat or lng out of range")
+	}
+
+	locs, err := s.UserStore.ListLocations(ctx, userID)
+	if err != nil {
+		return loc, errors.E(op, errors.Internal, userID, err)
+	}
+	replaces := false
+	for _, existing := range locs {
+		if existing.Label == loc.Label {
+			replaces = true
+		}
+	}
+	if !replaces && len(locs) >= maxSavedLocations {
+		return loc, errors.E(op, errors.Invalid, fmt.Sprintf("you can only save %d locations", maxSavedLocations))
+	}
+
+	if err := s.UserStore.SaveLocation(ctx, userID, loc); err != nil {
+		return loc, errors.E(op, errors.Internal, userID, err)
+	}
+	return loc, nil
+}
+
+// UserLocationDelete removes one of the current user's saved locations. id
+// must be "me".
+func (s *Service) UserLocationDelete(ctx context.Context, id eventdb.UserID, label string) error {
+	const op errors.Op = "Service.UserLocationDelete"
+
+	userID, err := meUser(ctx, id)
+	if err != nil {
+		return errors.E(op, err)
+	}
+
+	if err := s.UserStore.DeleteLocation(ctx, userID, label); err != nil {
+		return errors.E(op, userID, err)
+	}
+	return nil
+}
+
+// meUser returns the current user's ID. Like UserGet, the user endpoints
+// only work on "me".
+func meUser(ctx context.Context, id eventdb.UserID) (eventdb.UserID, error) {
+	currentUser := auth.User(ctx)
+	if currentUser.ID == "" {
+		return "", errors.E(errors.NotLoggedIn)
+	}
+	if id != "me" {
+		return "", errors.E(errors.Permission, currentUser.ID)
+	}
+	return eventdb.UserID(currentUser.ID), nil
+}
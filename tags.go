@@ -0,0 +1,40 @@
+package eventdb
+
+import (
+	"regexp"
+)
+
+// Tagging is rough, like price parsing. We look for a few keywords in the
+// event's name and description, which gives finer grained discovery than the
+// single Facebook category.
+
+// tagPatterns maps each tag to the keywords that mark an event with it. The
+// tags are listed in the order ExtractTags returns them.
+var tagPatterns = []struct {
+	tag string
+	re  *regexp.Regexp
+}{
+	{"live music", regexp.MustCompile(`(?i)\b(live (music|band|set|jazz)|concert|gig|jam session|open mic)\b`)},
+	{"free", regexp.MustCompile(`(?i)\b(free (entry|entrance|admission|event|of charge)|admission (is )?free|entry (is )?free|no cover)\b`)},
+	{"outdoor", regexp.MustCompile(`(?i)\b(outdoors?|open[ -]air|in the park|picnic|hike|garden party|rooftop)\b`)},
+	{"food", regexp.MustCompile(`(?i)\b(food|dinner|brunch|tasting|potluck|bbq|barbecue|street food)\b`)},
+	{"dance", regexp.MustCompile(`(?i)\b(dance|dancing|salsa|tango|swing|dj set)\b`)},
+	{"art", regexp.MustCompile(`(?i)\b(exhibition|gallery|art show|vernissage|opening reception)\b`)},
+	{"comedy", regexp.MustCompile(`(?i)\b(comedy|stand[ -]up|improv)\b`)},
+	{"workshop", regexp.MustCompile(`(?i)\b(workshop|class|lesson|seminar)\b`)},
+	{"family", regexp.MustCompile(`(?i)\b(family[ -]friendly|kids|children|all ages)\b`)},
+}
+
+// ExtractTags guesses tags like "live music", "free" or "outdoor" from an
+// event's name and description. It returns nil if none match.
+func ExtractTags(name, description string) []string {
+	text := name + "\n" + description
+
+	var tags []string
+	for _, p := range tagPatterns {
+		if p.re.MatchString(text) {
+			tags = append(tags, p.tag)
+		}
+	}
+	return tags
+}
@@ -0,0 +1,40 @@
+package eventdb
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestExtractTags(t *testing.T) {
+	for _, test := range []struct {
+		Name        string
+		Description string
+		Want        []string
+	}{
+		{
+			Name:        "Jazz in the park",
+			Description: "Live music all afternoon. Free entry, bring a picnic! Family-friendly.",
+			Want:        []string{"live music", "free", "outdoor", "family"},
+		},
+		{
+			Name:        "Stand-up Comedy Night",
+			Description: "Tickets $10 at the door",
+			Want:        []string{"comedy"},
+		},
+		{
+			Name:        "Salsa Workshop",
+			Description: "Beginner class, no partner needed. NO COVER",
+			Want:        []string{"free", "dance", "workshop"},
+		},
+		{
+			Name:        "Board game meetup",
+			Description: "Feel free to bring your own games",
+			Want:        nil,
+		},
+	} {
+		got := ExtractTags(test.Name, test.Description)
+		if !reflect.DeepEqual(got, test.Want) {
+			t.Errorf("ExtractTags(%q, %q) = %q, want %q", test.Name, test.Description, got, test.Want)
+		}
+	}
+}
@@ -14,6 +14,24 @@ type User struct {
 	FacebookID    string    `json:"facebookID"`
 	FacebookToken string    `json:"facebookToken"`
 	Birthday      time.Time `json:"birthday"`
+
+	// LastActiveAt is roughly when the user last made an authenticated
+	// request. It's zero if they never have.
+	LastActiveAt time.Time `json:"lastActiveAt"`
+
+	// LastLat and LastLng are where the user last generated a Dest. DestGenerate
+	// falls back to them when it's called without a location. Both are zero if
+	// the user never has.
+	LastLat float64 `json:"lastLat"`
+	LastLng float64 `json:"lastLng"`
+}
+
+// A SavedLocation is a place a user named so they can generate Dests there
+// without sending coordinates, like "home" or "work".
+type SavedLocation struct {
+	Label string  `json:"label"`
+	Lat   float64 `json:"lat"`
+	Lng   float64 `json:"lng"`
 }
 
 // A UserUpdate is used to update a User object
@@ -30,3 +48,37 @@ type UserUpdate struct {
 	// This is similar to protobuf's FieldMask well known type.
 	Mask string `json:"mask"`
 }
+
+// UserSearchRequest lists users for admins, a page at a time.
+type UserSearchRequest struct {
+	// OrderBy sets the order of the results. The default, UserOrderBySequence,
+	// lists users in the order they signed up.
+	OrderBy UserOrder `json:"orderBy"`
+
+	// Cursor continues a search from where the last page ended. Pass the
+	// previous reply's Next, along with the same OrderBy. Empty starts from
+	// the beginning.
+	Cursor string `json:"cursor"`
+
+	// Limit is the most users to return in one page.
+	Limit int `json:"limit"`
+}
+
+// UserSearchReply is a page of UserSearch results.
+type UserSearchReply struct {
+	Users []User `json:"users"`
+
+	// Next is the Cursor for the following page. It's empty on the last page.
+	Next string `json:"next"`
+}
+
+// UserOrder is a sort order for user search results.
+type UserOrder string
+
+const (
+	// UserOrderBySequence sorts users by when they signed up, oldest first.
+	UserOrderBySequence UserOrder = ""
+	// UserOrderByLastActive sorts users who were active least recently first,
+	// starting with those who never were.
+	UserOrderByLastActive UserOrder = "lastActive"
+)